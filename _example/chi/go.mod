@@ -4,5 +4,11 @@ go 1.16
 
 require (
 	github.com/arl/statsviz v0.4.1
+	github.com/arl/statsviz/statsvizchi v0.0.0
 	github.com/go-chi/chi v1.5.4
 )
+
+replace (
+	github.com/arl/statsviz => ../../
+	github.com/arl/statsviz/statsvizchi => ../../statsvizchi
+)
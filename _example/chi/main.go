@@ -4,29 +4,23 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/arl/statsviz"
 	"github.com/go-chi/chi"
 
 	example "github.com/arl/statsviz/_example"
+	"github.com/arl/statsviz/statsvizchi"
 )
 
 func main() {
-
 	// Force the GC to work to make the plots "move".
 	go example.Work()
 
-	// Create a Chi router and register statsviz handlers.
+	// Create a Chi router and mount statsviz handlers.
 	r := chi.NewRouter()
-	r.Get("/debug/statsviz/ws", statsviz.Ws)
-	r.Get("/debug/statsviz", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "/debug/statsviz/", 301)
-	})
-	r.Handle("/debug/statsviz/*", statsviz.Index)
-
-	mux := http.NewServeMux()
-	mux.Handle("/", r)
+	if err := statsvizchi.Mount(r); err != nil {
+		log.Fatal(err)
+	}
 
-	if err := http.ListenAndServe(":8080", mux); err != nil {
+	if err := http.ListenAndServe(":8080", r); err != nil {
 		log.Fatalf("failed to start server: %s", err)
 	}
 }
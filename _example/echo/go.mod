@@ -4,5 +4,11 @@ go 1.16
 
 require (
 	github.com/arl/statsviz v0.4.1
+	github.com/arl/statsviz/statsvizecho v0.0.0
 	github.com/labstack/echo/v4 v4.6.3
 )
+
+replace (
+	github.com/arl/statsviz => ../../
+	github.com/arl/statsviz/statsvizecho => ../../statsvizecho
+)
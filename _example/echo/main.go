@@ -1,12 +1,10 @@
 package main
 
 import (
-	"net/http"
-
 	"github.com/labstack/echo/v4"
 
-	"github.com/arl/statsviz"
 	example "github.com/arl/statsviz/_example"
+	"github.com/arl/statsviz/statsvizecho"
 )
 
 func main() {
@@ -16,13 +14,9 @@ func main() {
 	// Echo instance
 	e := echo.New()
 
-	mux := http.NewServeMux()
-	_ = statsviz.Register(mux)
-
-	// Use echo WrapHandler to wrap statsviz ServeMux as echo HandleFunc
-	e.GET("/debug/statsviz/", echo.WrapHandler(mux))
-	// Serve static content for statsviz UI
-	e.GET("/debug/statsviz/*", echo.WrapHandler(mux))
+	if err := statsvizecho.Register(e); err != nil {
+		e.Logger.Fatal(err)
+	}
 
 	// Start server
 	e.Logger.Fatal(e.Start(":8080"))
@@ -31,6 +31,7 @@ func TestExamples(t *testing.T) {
 		"middleware": "http://localhost:8080/debug/statsviz/",
 		"mux":        "http://localhost:8080/debug/statsviz/",
 		"options":    "http://localhost:8080/foo/bar/",
+		"userplots":  "http://localhost:8080/debug/statsviz/",
 	}
 
 	ents, err := os.ReadDir(".")
@@ -4,7 +4,11 @@ go 1.16
 
 require (
 	github.com/arl/statsviz v0.4.1
-	github.com/gofiber/adaptor/v2 v2.1.18
+	github.com/arl/statsviz/statsvizfiber v0.0.0
 	github.com/gofiber/fiber/v2 v2.27.0
-	github.com/soheilhy/cmux v0.1.5
+)
+
+replace (
+	github.com/arl/statsviz => ../../
+	github.com/arl/statsviz/statsvizfiber => ../../statsvizfiber
 )
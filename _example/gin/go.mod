@@ -4,5 +4,11 @@ go 1.16
 
 require (
 	github.com/arl/statsviz v0.4.1
+	github.com/arl/statsviz/statsvizgin v0.0.0
 	github.com/gin-gonic/gin v1.7.7
 )
+
+replace (
+	github.com/arl/statsviz => ../../
+	github.com/arl/statsviz/statsvizgin => ../../statsvizgin
+)
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"log"
+
 	"github.com/gin-gonic/gin"
 
-	"github.com/arl/statsviz"
 	example "github.com/arl/statsviz/_example"
+	"github.com/arl/statsviz/statsvizgin"
 )
 
 func main() {
@@ -12,12 +14,8 @@ func main() {
 	go example.Work()
 
 	router := gin.New()
-	router.GET("/debug/statsviz/*filepath", func(context *gin.Context) {
-		if context.Param("filepath") == "/ws" {
-			statsviz.Ws(context.Writer, context.Request)
-			return
-		}
-		statsviz.IndexAtRoot("/debug/statsviz").ServeHTTP(context.Writer, context.Request)
-	})
+	if err := statsvizgin.Register(router); err != nil {
+		log.Fatal(err)
+	}
 	router.Run(":8080")
 }
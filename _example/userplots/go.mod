@@ -0,0 +1,7 @@
+module example/userplots
+
+go 1.16
+
+require github.com/arl/statsviz v0.4.1
+
+replace github.com/arl/statsviz => ../../
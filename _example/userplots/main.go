@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/arl/statsviz"
+	example "github.com/arl/statsviz/_example"
+)
+
+func main() {
+	// Force the GC to work to make the plots "move".
+	go example.Work()
+
+	// Simulate some application values: a queue depth and a cache.
+	var queueDepth, hits, lookups int64
+	go func() {
+		for {
+			atomic.StoreInt64(&queueDepth, rand.Int63n(100))
+			atomic.AddInt64(&lookups, 1)
+			if rand.Intn(4) != 0 {
+				atomic.AddInt64(&hits, 1)
+			}
+		}
+	}()
+
+	queue := statsviz.TimeSeriesPlot{
+		Name:  "queue",
+		Title: "Queue depth",
+		Series: []statsviz.TimeSeries{
+			{
+				Name: "depth",
+				Value: func() float64 {
+					return float64(atomic.LoadInt64(&queueDepth))
+				},
+			},
+		},
+	}
+
+	cache := statsviz.TimeSeriesPlot{
+		Name:  "cache",
+		Title: "Cache hit ratio",
+		Series: []statsviz.TimeSeries{
+			{
+				Name: "hit ratio",
+				Value: func() float64 {
+					return float64(atomic.LoadInt64(&hits)) / float64(atomic.LoadInt64(&lookups))
+				},
+			},
+		},
+	}
+
+	// lookups is a cumulative counter, plot its rate of change instead.
+	lookupRate := statsviz.TimeSeriesPlot{
+		Name:  "lookups",
+		Title: "Cache lookups per second",
+		Series: []statsviz.TimeSeries{
+			{
+				Name: "lookups",
+				Value: func() float64 {
+					return float64(atomic.LoadInt64(&lookups))
+				},
+				Transform: statsviz.Rate,
+			},
+		},
+	}
+
+	// Register statsviz handlers, along with our user-defined plots.
+	mux := http.NewServeMux()
+	if err := statsviz.Register(mux, statsviz.WithPlot(queue), statsviz.WithPlot(cache), statsviz.WithPlot(lookupRate)); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Point your browser to http://localhost:8080/debug/statsviz/")
+	log.Fatal(http.ListenAndServe(":8080", mux))
+}
@@ -0,0 +1,97 @@
+package statsviz
+
+import (
+	"time"
+)
+
+// Thresholds of WithAdaptiveFrequency: the interval doubles once collecting
+// stats took more than 1/adaptiveBusy of it, adaptiveTicks times in a row, and
+// halves once it took less than 1/adaptiveIdle of it as many times.
+const (
+	adaptiveBusy  = 4
+	adaptiveIdle  = 10
+	adaptiveTicks = 3
+)
+
+// adaptiveFrequency holds the bounds of the collection interval, see
+// WithAdaptiveFrequency.
+type adaptiveFrequency struct {
+	min, max time.Duration
+}
+
+// WithAdaptiveFrequency lets the server adapt the interval at which stats are
+// collected and sent, between min and max, to the time it takes: when it
+// consistently takes a significant part of the interval, for example because
+// the process is overloaded, the interval doubles, up to max, so that statsviz
+// backs off. Once collection is fast again, the interval halves, down to min.
+// The send frequency, or the one requested by a client, is the initial
+// interval, within these bounds. Stats carry the effective interval, shown by
+// the user interface.
+func WithAdaptiveFrequency(min, max time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if min < minServerFrequency {
+			return configError("WithAdaptiveFrequency", "adaptive frequency minimum must be at least %v, got %v", minServerFrequency, min)
+		}
+		if max < min {
+			return configError("WithAdaptiveFrequency", "adaptive frequency maximum %v is lower than the minimum %v", max, min)
+		}
+		s.adaptive = &adaptiveFrequency{min: min, max: max}
+		return nil
+	}
+}
+
+// clamp returns d within the adaptive interval bounds.
+func (a *adaptiveFrequency) clamp(d time.Duration) time.Duration {
+	if d < a.min {
+		return a.min
+	}
+	if d > a.max {
+		return a.max
+	}
+	return d
+}
+
+// period returns the current interval between collections.
+func (h *hub) period() time.Duration {
+	if h.interval != 0 {
+		return h.interval
+	}
+	return h.freq
+}
+
+// adapt records that the last collection took busy, and reports whether the
+// collection interval changed as a result. It's a no-op unless the server
+// frequency is adaptive.
+func (h *hub) adapt(busy time.Duration) bool {
+	a := h.s.adaptive
+	if a == nil {
+		return false
+	}
+
+	cur := h.period()
+	switch {
+	case busy > cur/adaptiveBusy:
+		h.busyTicks, h.idleTicks = h.busyTicks+1, 0
+	case busy < cur/adaptiveIdle:
+		h.busyTicks, h.idleTicks = 0, h.idleTicks+1
+	default:
+		h.busyTicks, h.idleTicks = 0, 0
+	}
+
+	next := cur
+	switch {
+	case h.busyTicks >= adaptiveTicks:
+		next = a.clamp(2 * cur)
+	case h.idleTicks >= adaptiveTicks:
+		next = a.clamp(cur / 2)
+	default:
+		return false
+	}
+	h.busyTicks, h.idleTicks = 0, 0
+	if next == cur {
+		return false
+	}
+	h.s.logger.Info("statsviz: collection interval adapted", "interval", next, "previous", cur, "busy", busy)
+	h.interval = next
+	return true
+}
@@ -0,0 +1,116 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveFrequency(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// A slow plot, each collection takes slow nanoseconds.
+	var slow int64
+	plot := TimeSeriesPlot{
+		Name: "slow",
+		Series: []TimeSeries{{
+			Name: "slow",
+			Value: func() float64 {
+				if d := atomic.LoadInt64(&slow); d != 0 {
+					clk.advance(time.Duration(d))
+				}
+				return 0
+			},
+		}},
+	}
+	srv, err := NewServer(
+		withClock(clk),
+		SendFrequency(100*time.Millisecond),
+		WithAdaptiveFrequency(100*time.Millisecond, 800*time.Millisecond),
+		WithPlot(plot),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	frames, unsubscribe := srv.subscribe(srv.freq)
+	defer unsubscribe()
+
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+
+	// wait reads frames until one reports the want interval. grows tells
+	// whether the interval must only grow in the meantime, or only shrink.
+	wait := func(want time.Duration, grows bool) {
+		t.Helper()
+
+		prev := time.Duration(-1)
+		for i := 0; i < 100; i++ {
+			// Advance the clock until the hub ticks, it may be recreating its
+			// ticker after a change of interval.
+			var st stats
+			for deadline := time.Now().Add(5 * time.Second); ; {
+				for len(clk.added) != 0 {
+					<-clk.added
+				}
+				// Long enough for a tick, whatever the interval.
+				clk.advance(time.Second)
+
+				var f *frame
+				select {
+				case f = <-frames:
+				case <-time.After(10 * time.Millisecond):
+					if time.Now().After(deadline) {
+						t.Fatal("timeout waiting for a frame")
+					}
+					continue
+				}
+				err := json.Unmarshal(f.bytes(), &st)
+				f.release()
+				if err != nil {
+					t.Fatal(err)
+				}
+				break
+			}
+
+			got := time.Duration(st.Interval * float64(time.Second))
+			if prev >= 0 && (grows && got < prev || !grows && got > prev) {
+				t.Fatalf("got interval %v after %v, want a monotonic change", got, prev)
+			}
+			if got == want {
+				return
+			}
+			prev = got
+		}
+		t.Fatalf("interval never reached %v", want)
+	}
+
+	// Collecting takes more than a quarter of any interval.
+	atomic.StoreInt64(&slow, int64(300*time.Millisecond))
+	wait(800*time.Millisecond, true)
+
+	atomic.StoreInt64(&slow, 0)
+	wait(100*time.Millisecond, false)
+}
+
+func TestWithAdaptiveFrequencyInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct{ min, max time.Duration }{
+		{0, time.Second},
+		{time.Millisecond, time.Second},
+		{time.Second, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if _, err := NewServer(WithAdaptiveFrequency(tt.min, tt.max)); err == nil {
+			t.Errorf("WithAdaptiveFrequency(%v, %v): got nil error, want non-nil", tt.min, tt.max)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// An Aggregator serves a dashboard overlaying the stats of several remote
+// statsviz instances, for example all the instances of a service. It connects
+// to each of them as a websocket client, and shows one plot per statistic,
+// with one series per instance.
+//
+// The plotted statistics are a fixed set: the heap in use, the allocation
+// rate, the number of goroutines and the GC cycles rate. The other plots of
+// the instances, such as their user plots or their runtime plots, aren't
+// aggregated.
+//
+// Remote instances must use the default JSON encoding. Instances going down
+// have no values, until the aggregator reconnects, with an exponential
+// backoff.
+type Aggregator struct {
+	srv     *Server
+	targets []aggregatorTarget
+
+	mu     sync.RWMutex
+	latest map[string]*stats // last stats received by target, nil if down
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// aggregatorTarget is a remote statsviz instance.
+type aggregatorTarget struct {
+	name string // names the target series
+	url  string // of the websocket endpoint
+}
+
+// aggregatorPlots are the plots of the aggregator dashboard, value reads a
+// statistic from the stats of a target.
+var aggregatorPlots = []struct {
+	name, title, unit string
+	yaxis             Axis
+	transform         Transform
+	value             func(st *stats) float64
+}{
+	{
+		name:  "heap-inuse",
+		title: "Heap in use",
+		unit:  "bytes",
+		yaxis: AxisLog,
+		value: func(st *stats) float64 {
+			if st.Mem == nil {
+				return math.NaN()
+			}
+			return float64(st.Mem.HeapInuse)
+		},
+	},
+	{
+		name:      "heap-allocs",
+		title:     "Heap allocations (bytes per second)",
+		unit:      "bytes/s",
+		yaxis:     AxisLog,
+		transform: Rate,
+		value: func(st *stats) float64 {
+			if st.Mem == nil {
+				return math.NaN()
+			}
+			return float64(st.Mem.TotalAlloc)
+		},
+	},
+	{
+		name:  "goroutines",
+		title: "Goroutines",
+		value: func(st *stats) float64 {
+			if st.NumGoroutine == 0 {
+				return math.NaN()
+			}
+			return float64(st.NumGoroutine)
+		},
+	},
+	{
+		name:      "gc-cycles",
+		title:     "GC cycles (per second)",
+		transform: Rate,
+		value: func(st *stats) float64 {
+			if st.Mem == nil {
+				return math.NaN()
+			}
+			return float64(st.Mem.NumGC)
+		},
+	},
+}
+
+// NewAggregator returns an Aggregator of the statsviz instances at targets,
+// the URLs of their user interfaces, for example
+// http://10.0.0.1:8080/debug/statsviz, or of their websocket endpoints, such
+// as ws://10.0.0.1:8080/debug/statsviz/ws. Targets name the series of the
+// aggregator plots, which show the fixed set of statistics described by
+// Aggregator, whatever the plots of the targets. Targets must send the
+// memory and goroutines stats, which is the case unless their plots are
+// disabled.
+//
+// The aggregator dashboard is served by a Server configured with opts, for
+// example to set its root or send frequency. Built-in plots, which would show
+// the stats of the aggregating process, are disabled, unless opts enable
+// them. The aggregator connects to the targets right away, call Stop to
+// disconnect.
+func NewAggregator(targets []string, opts ...OptionFunc) (*Aggregator, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("aggregator requires at least one target")
+	}
+	return newAggregator(targets, targets, opts)
+}
+
+// NewComparison returns an Aggregator comparing two statsviz instances side
+// by side, for example two builds of a program under A/B testing. Its plots
+// have two series sharing the same axes, named "A" and "B" followed by the
+// targets, see NewAggregator. Like with NewAggregator, only the heap in use,
+// the allocation rate, the goroutines and the GC cycles rate are compared.
+//
+// Since the aggregator samples the last stats received from each target, the
+// values of both series are aligned on its own timestamps, whatever the send
+// frequency of the targets. A target which is down has no values until the
+// aggregator reconnects.
+func NewComparison(targetA, targetB string, opts ...OptionFunc) (*Aggregator, error) {
+	names := []string{"A: " + targetA, "B: " + targetB}
+	return newAggregator([]string{targetA, targetB}, names, opts)
+}
+
+// newAggregator returns an Aggregator of targets, which series are named
+// after names.
+func newAggregator(targets, names []string, opts []OptionFunc) (*Aggregator, error) {
+	a := &Aggregator{
+		latest: make(map[string]*stats, len(targets)),
+		quit:   make(chan struct{}),
+	}
+	for i, t := range targets {
+		u, err := aggregatorURL(t)
+		if err != nil {
+			return nil, err
+		}
+		for _, at := range a.targets {
+			if at.url == u {
+				return nil, fmt.Errorf("duplicate target %q", t)
+			}
+		}
+		a.targets = append(a.targets, aggregatorTarget{name: names[i], url: u})
+	}
+
+	all := []OptionFunc{WithPlots()}
+	for _, p := range aggregatorPlots {
+		plot := TimeSeriesPlot{Name: p.name, Title: p.title, YAxis: p.yaxis}
+		for _, t := range a.targets {
+			plot.Series = append(plot.Series, TimeSeries{
+				Name:      t.name,
+				Value:     a.reader(t.name, p.value),
+				Transform: p.transform,
+				Unit:      p.unit,
+			})
+		}
+		all = append(all, WithPlot(plot))
+	}
+	srv, err := NewServer(append(all, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	a.srv = srv
+
+	for _, t := range a.targets {
+		a.wg.Add(1)
+		go func(t aggregatorTarget) {
+			defer a.wg.Done()
+			a.follow(t)
+		}(t)
+	}
+	return a, nil
+}
+
+// aggregatorURL returns the URL of the websocket endpoint of target.
+func aggregatorURL(target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+		return u.String(), nil
+	default:
+		return "", fmt.Errorf("invalid target %q: unsupported scheme %q", target, u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ws"
+	return u.String(), nil
+}
+
+// reader returns a function reading a statistic from the last stats of the
+// target, or NaN if the target is down.
+func (a *Aggregator) reader(target string, value func(*stats) float64) func() float64 {
+	return func() float64 {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+		st := a.latest[target]
+		if st == nil {
+			return math.NaN()
+		}
+		return value(st)
+	}
+}
+
+// Aggregator reconnection backoff bounds.
+const (
+	minAggregatorBackoff = 250 * time.Millisecond
+	maxAggregatorBackoff = 5 * time.Second
+)
+
+// follow receives the stats of target until the aggregator is stopped,
+// reconnecting with an exponential backoff.
+func (a *Aggregator) follow(t aggregatorTarget) {
+	backoff := minAggregatorBackoff
+	for {
+		if a.receive(t) {
+			// Connected, start over with the shortest backoff.
+			backoff = minAggregatorBackoff
+		}
+		a.set(t.name, nil)
+
+		select {
+		case <-a.quit:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxAggregatorBackoff {
+			backoff = maxAggregatorBackoff
+		}
+	}
+}
+
+// receive connects to target and records the stats it receives, until the
+// connection fails or the aggregator is stopped. It reports whether it could
+// connect.
+func (a *Aggregator) receive(t aggregatorTarget) bool {
+	conn, _, err := websocket.DefaultDialer.Dial(t.url, nil)
+	if err != nil {
+		a.srv.logger.Warn("statsviz: aggregator can't connect", "target", t.name, "error", err)
+		return false
+	}
+	a.srv.logger.Info("statsviz: aggregator connected", "target", t.name)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-a.quit:
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			a.srv.logger.Info("statsviz: aggregator disconnected", "target", t.name, "error", err)
+			return true
+		}
+		if typ != websocket.TextMessage {
+			continue
+		}
+		var st struct {
+			Type *string `json:"type"` // set on control messages
+			stats
+		}
+		if err := json.Unmarshal(msg, &st); err != nil || st.Type != nil {
+			continue
+		}
+		a.set(t.name, &st.stats)
+	}
+}
+
+func (a *Aggregator) set(target string, st *stats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.latest[target] = st
+}
+
+// Register registers the aggregator dashboard HTTP handlers on the provided
+// mux, like Server.Register.
+func (a *Aggregator) Register(mux *http.ServeMux) {
+	a.srv.Register(mux)
+}
+
+// Stop disconnects the aggregator from its targets and stops the server
+// serving its dashboard.
+func (a *Aggregator) Stop() {
+	select {
+	case <-a.quit:
+		return
+	default:
+		close(a.quit)
+	}
+	a.wg.Wait()
+	a.srv.Stop()
+}
@@ -0,0 +1,213 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestInstance returns a test server serving a statsviz instance, sending
+// stats every 10ms, and the statsviz server.
+func newTestInstance(t *testing.T) (*httptest.Server, *Server) {
+	t.Helper()
+
+	srv, err := NewServer(SendFrequency(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts, srv
+}
+
+func TestAggregator(t *testing.T) {
+	t.Parallel()
+
+	one, _ := newTestInstance(t)
+	two, twoSrv := newTestInstance(t)
+	targets := []string{one.URL + "/debug/statsviz", "ws" + strings.TrimPrefix(two.URL, "http") + "/debug/statsviz/ws"}
+	agg, err := NewAggregator(targets, SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agg.Stop()
+
+	mux := http.NewServeMux()
+	agg.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/debug/statsviz/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// Wait for a frame holding the stats of both instances.
+	deadline := time.Now().Add(5 * time.Second)
+	ws.SetReadDeadline(deadline)
+	for {
+		var st struct {
+			UserPlots map[string][]*float64
+		}
+		if err := ws.ReadJSON(&st); err != nil {
+			t.Fatal(err)
+		}
+		heap := st.UserPlots["heap-inuse"]
+		goroutines := st.UserPlots["goroutines"]
+		if len(heap) != 2 || len(goroutines) != 2 {
+			t.Fatalf("got heap %v and goroutines %v, want one series per target", heap, goroutines)
+		}
+		if heap[0] != nil && heap[1] != nil && goroutines[0] != nil && goroutines[1] != nil {
+			if *heap[0] <= 0 || *goroutines[1] <= 0 {
+				t.Errorf("got heap %v and goroutines %v, want positive values", *heap[0], *goroutines[1])
+			}
+			break
+		}
+	}
+
+	// The series are named after the targets.
+	var hs handshake
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/handshake", nil))
+	if err := json.NewDecoder(w.Body).Decode(&hs); err != nil {
+		t.Fatal(err)
+	}
+	if len(hs.Plots) != len(aggregatorPlots) || len(hs.BuiltinPlots) != 0 {
+		t.Fatalf("got %d plots and built-in plots %q, want %d plots and no built-in plots", len(hs.Plots), hs.BuiltinPlots, len(aggregatorPlots))
+	}
+	for i, s := range hs.Plots[0].Series {
+		if s.Name != targets[i] {
+			t.Errorf("got series %q, want %q", s.Name, targets[i])
+		}
+	}
+
+	// A target going down has no values.
+	twoSrv.Stop()
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		if v := agg.reader(targets[1], aggregatorPlots[0].value)(); math.IsNaN(v) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("target still has values after going down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestComparison(t *testing.T) {
+	t.Parallel()
+
+	a, _ := newTestInstance(t)
+	b, bSrv := newTestInstance(t)
+	cmp, err := NewComparison(a.URL+"/debug/statsviz", b.URL+"/debug/statsviz", SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmp.Stop()
+
+	mux := http.NewServeMux()
+	cmp.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	var hs handshake
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/handshake", nil))
+	if err := json.NewDecoder(w.Body).Decode(&hs); err != nil {
+		t.Fatal(err)
+	}
+	wantNames := []string{"A: " + a.URL + "/debug/statsviz", "B: " + b.URL + "/debug/statsviz"}
+	for _, p := range hs.Plots {
+		if len(p.Series) != 2 || p.Series[0].Name != wantNames[0] || p.Series[1].Name != wantNames[1] {
+			t.Errorf("plot %s: got series %+v, want %q", p.Name, p.Series, wantNames)
+		}
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/debug/statsviz/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// Wait for a frame holding both series of every plot.
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for done := false; !done; {
+		var st struct {
+			UserPlots map[string][]*float64
+		}
+		if err := ws.ReadJSON(&st); err != nil {
+			t.Fatal(err)
+		}
+		done = true
+		for _, p := range aggregatorPlots {
+			vals := st.UserPlots[p.name]
+			if len(vals) != 2 {
+				t.Fatalf("plot %s: got %v, want 2 series", p.name, vals)
+			}
+			if p.transform == Raw && (vals[0] == nil || vals[1] == nil) {
+				done = false
+			}
+		}
+	}
+
+	// B going down doesn't affect A.
+	bSrv.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for !math.IsNaN(cmp.reader(wantNames[1], aggregatorPlots[0].value)()) {
+		if time.Now().After(deadline) {
+			t.Fatal("B still has values after going down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v := cmp.reader(wantNames[0], aggregatorPlots[0].value)(); math.IsNaN(v) {
+		t.Errorf("A: got NaN, want a value")
+	}
+}
+
+func TestNewAggregatorInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := [][]string{
+		nil,
+		{"ftp://host/debug/statsviz"},
+		{"http://host/debug/statsviz", "http://host/debug/statsviz"},
+		{"http://host/debug/statsviz", "ws://host/debug/statsviz/ws"},
+	}
+	for _, targets := range tests {
+		if agg, err := NewAggregator(targets); err == nil {
+			agg.Stop()
+			t.Errorf("NewAggregator(%q): got nil error, want non-nil", targets)
+		}
+	}
+	if cmp, err := NewComparison("http://host/debug/statsviz", "http://host/debug/statsviz"); err == nil {
+		cmp.Stop()
+		t.Errorf("NewComparison with the same targets: got nil error, want non-nil")
+	}
+}
+
+func TestAggregatorURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct{ target, want string }{
+		{"http://host:8080/debug/statsviz", "ws://host:8080/debug/statsviz/ws"},
+		{"https://host/debug/statsviz/", "wss://host/debug/statsviz/ws"},
+		{"ws://host/custom/ws", "ws://host/custom/ws"},
+	}
+	for _, tt := range tests {
+		got, err := aggregatorURL(tt.target)
+		if err != nil || got != tt.want {
+			t.Errorf("aggregatorURL(%q) = %q, %v, want %q", tt.target, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package statsviz
+
+import (
+	"net"
+	"net/http"
+)
+
+// WithAllowedCIDRs restricts all statsviz handlers, including the websocket
+// one, to the clients whose IP is in one of the provided CIDR ranges, IPv4 or
+// IPv6, for example "10.0.0.0/8" or "fd00::/8". Requests from other clients
+// are responded with 403 Forbidden.
+//
+// The client IP is the remote address of the connection, or, behind a
+// reverse proxy listed with WithTrustedProxies, the one read from the
+// X-Forwarded-For header.
+func WithAllowedCIDRs(cidrs ...string) OptionFunc {
+	return func(s *Server) error {
+		if len(cidrs) == 0 {
+			return configError("WithAllowedCIDRs", "allowed CIDRs requires at least one range")
+		}
+		nets := make([]*net.IPNet, 0, len(cidrs))
+		for _, c := range cidrs {
+			_, ipnet, err := net.ParseCIDR(c)
+			if err != nil {
+				return configError("WithAllowedCIDRs", "invalid allowed CIDR %q: %v", c, err)
+			}
+			nets = append(nets, ipnet)
+		}
+		s.allowedNets = nets
+		return nil
+	}
+}
+
+// allowedIP reports whether the client that sent r is allowed, see
+// WithAllowedCIDRs.
+func (s *Server) allowedIP(r *http.Request) bool {
+	ip := net.ParseIP(s.clientIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range s.allowedNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowIPs returns h, only called if the client IP is allowed.
+func (s *Server) allowIPs(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedIP(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
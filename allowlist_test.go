@@ -0,0 +1,80 @@
+package statsviz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWithAllowedCIDRs(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	if err := Register(mux, WithAllowedCIDRs("127.0.0.0/8", "10.0.0.0/8", "fd00::/8")); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{"/debug/statsviz/", "/debug/statsviz/app.js", "/debug/statsviz/handshake", "/debug/statsviz/ws"}
+	tests := []struct {
+		remote string
+		want   int
+	}{
+		{"10.1.2.3:1234", http.StatusOK},
+		{"[fd00::1]:1234", http.StatusOK},
+		{"192.168.1.1:1234", http.StatusForbidden},
+		{"[2001:db8::1]:1234", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		for _, path := range paths {
+			if path == "/debug/statsviz/ws" && tt.want == http.StatusOK {
+				// Upgrading requires a real connection, see below.
+				continue
+			}
+			r := httptest.NewRequest("GET", path, nil)
+			r.RemoteAddr = tt.remote
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("GET %s from %s: got status %d, want %d", path, tt.remote, w.Code, tt.want)
+			}
+		}
+	}
+
+	// The test server listens on the loopback address, which is allowed.
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/debug/statsviz/ws", nil)
+	if err != nil {
+		t.Fatalf("websocket upgrade: %v", err)
+	}
+	ws.Close()
+
+	// And is refused once it isn't.
+	mux = http.NewServeMux()
+	if err := Register(mux, WithAllowedCIDRs("10.0.0.0/8")); err != nil {
+		t.Fatal(err)
+	}
+	ts2 := httptest.NewServer(mux)
+	defer ts2.Close()
+	ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts2.URL, "http")+"/debug/statsviz/ws", nil)
+	if err == nil {
+		ws.Close()
+		t.Fatalf("websocket upgrade succeeded, want it to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("websocket upgrade: got response %v, want status %d", resp, http.StatusForbidden)
+	}
+}
+
+func TestWithAllowedCIDRsInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, cidrs := range [][]string{nil, {"10.0.0.0"}, {"10.0.0.0/8", "fd00::/200"}, {"garbage"}} {
+		if err := Register(http.NewServeMux(), WithAllowedCIDRs(cidrs...)); err == nil {
+			t.Errorf("WithAllowedCIDRs(%q): got nil error, want non-nil", cidrs)
+		}
+	}
+}
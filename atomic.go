@@ -0,0 +1,105 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"sync/atomic"
+	"unsafe"
+)
+
+// atomicFloat64 is a float64 that can be safely accessed from multiple
+// goroutines.
+type atomicFloat64 struct {
+	v uint64
+}
+
+// newFloat64 creates a new atomicFloat64 holding val.
+func newFloat64(val float64) *atomicFloat64 {
+	// Always store val, so that the sign of -0 is kept.
+	x := &atomicFloat64{}
+	x.Store(val)
+	return x
+}
+
+// Load atomically loads the wrapped value.
+func (x *atomicFloat64) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&x.v))
+}
+
+// Store atomically stores val.
+func (x *atomicFloat64) Store(val float64) {
+	atomic.StoreUint64(&x.v, math.Float64bits(val))
+}
+
+// Add atomically adds delta to the wrapped value and returns the new value.
+func (x *atomicFloat64) Add(delta float64) float64 {
+	for {
+		oldbits := atomic.LoadUint64(&x.v)
+		new := math.Float64frombits(oldbits) + delta
+		if atomic.CompareAndSwapUint64(&x.v, oldbits, math.Float64bits(new)) {
+			return new
+		}
+	}
+}
+
+// Swap atomically stores val and returns the previous value.
+func (x *atomicFloat64) Swap(val float64) float64 {
+	return math.Float64frombits(atomic.SwapUint64(&x.v, math.Float64bits(val)))
+}
+
+// CompareAndSwap atomically replaces the wrapped value with new if it is equal
+// to old, and reports whether the swap took place.
+//
+// Since NaN is never equal to itself, CompareAndSwap always fails when old is
+// NaN.
+func (x *atomicFloat64) CompareAndSwap(old, new float64) bool {
+	if old != old {
+		return false
+	}
+	return atomic.CompareAndSwapUint64(&x.v, math.Float64bits(old), math.Float64bits(new))
+}
+
+// MarshalJSON encodes the wrapped float64 into JSON. NaN and infinite values,
+// which JSON can't represent, are encoded as null, so that they don't fail
+// the encoding of the whole stats.
+func (x *atomicFloat64) MarshalJSON() ([]byte, error) {
+	v := x.Load()
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(v)
+}
+
+// appendMsgpack encodes the wrapped float64 into MessagePack.
+func (x *atomicFloat64) appendMsgpack(b []byte) []byte {
+	return appendFloat(b, x.Load())
+}
+
+// atomicValue holds a value that can be safely accessed from multiple
+// goroutines. Unlike atomic.Value, it can hold values of different types and
+// swap them, with Go 1.16. Callers assert the type of the loaded values.
+type atomicValue struct {
+	p unsafe.Pointer // *interface{}, nil until the first Store
+}
+
+// Load atomically loads the wrapped value, nil if none was stored.
+func (x *atomicValue) Load() interface{} {
+	if p := atomic.LoadPointer(&x.p); p != nil {
+		return *(*interface{})(p)
+	}
+	return nil
+}
+
+// Store atomically stores val.
+func (x *atomicValue) Store(val interface{}) {
+	atomic.StorePointer(&x.p, unsafe.Pointer(&val))
+}
+
+// Swap atomically stores val and returns the previous value, nil if none was
+// stored.
+func (x *atomicValue) Swap(val interface{}) interface{} {
+	if p := atomic.SwapPointer(&x.p, unsafe.Pointer(&val)); p != nil {
+		return *(*interface{})(p)
+	}
+	return nil
+}
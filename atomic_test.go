@@ -0,0 +1,177 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAtomicFloat64(t *testing.T) {
+	t.Parallel()
+
+	x := newFloat64(1.5)
+	if got := x.Load(); got != 1.5 {
+		t.Errorf("Load() = %v, want %v", got, 1.5)
+	}
+
+	x.Store(2)
+	if got := x.Load(); got != 2 {
+		t.Errorf("Load() = %v, want %v", got, 2)
+	}
+
+	if got := x.Add(0.5); got != 2.5 {
+		t.Errorf("Add(0.5) = %v, want %v", got, 2.5)
+	}
+
+	if got := x.Swap(3); got != 2.5 {
+		t.Errorf("Swap(3) = %v, want %v", got, 2.5)
+	}
+	if got := x.Load(); got != 3 {
+		t.Errorf("Load() = %v, want %v", got, 3)
+	}
+
+	if x.CompareAndSwap(2, 4) {
+		t.Errorf("CompareAndSwap(2, 4) succeeded, want failure")
+	}
+	if !x.CompareAndSwap(3, 4) {
+		t.Errorf("CompareAndSwap(3, 4) failed, want success")
+	}
+	if got := x.Load(); got != 4 {
+		t.Errorf("Load() = %v, want %v", got, 4)
+	}
+
+	buf, err := x.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "4" {
+		t.Errorf("MarshalJSON() = %s, want %s", buf, "4")
+	}
+}
+
+func TestAtomicFloat64CompareAndSwapNaN(t *testing.T) {
+	t.Parallel()
+
+	x := newFloat64(math.NaN())
+	if x.CompareAndSwap(math.NaN(), 1) {
+		t.Errorf("CompareAndSwap(NaN, 1) succeeded, want failure")
+	}
+	if got := x.Load(); !math.IsNaN(got) {
+		t.Errorf("Load() = %v, want NaN", got)
+	}
+}
+
+func TestAtomicFloat64ConcurrentAdd(t *testing.T) {
+	t.Parallel()
+
+	const n = 100
+
+	x := newFloat64(0)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			x.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	if got := x.Load(); got != n {
+		t.Errorf("Load() = %v, want %v", got, float64(n))
+	}
+}
+
+func TestAtomicValue(t *testing.T) {
+	t.Parallel()
+
+	var x atomicValue
+	if got := x.Load(); got != nil {
+		t.Errorf("Load() = %v, want nil", got)
+	}
+	if got := x.Swap(1); got != nil {
+		t.Errorf("Swap(1) = %v, want nil", got)
+	}
+	x.Store("two")
+	if got := x.Load(); got != "two" {
+		t.Errorf("Load() = %v, want %q", got, "two")
+	}
+	if got := x.Swap(nil); got != "two" {
+		t.Errorf("Swap(nil) = %v, want %q", got, "two")
+	}
+	if got := x.Load(); got != nil {
+		t.Errorf("Load() = %v, want nil", got)
+	}
+}
+
+func TestAtomicValueConcurrentSwap(t *testing.T) {
+	t.Parallel()
+
+	const n = 100
+
+	// Each value is swapped out exactly once.
+	var x atomicValue
+	x.Store(-1)
+	seen := make([]int32, n+1)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			_ = x.Load()
+			old := x.Swap(i).(int)
+			atomic.AddInt32(&seen[old+1], 1)
+		}(i)
+	}
+	wg.Wait()
+	last := x.Load().(int)
+	atomic.AddInt32(&seen[last+1], 1)
+
+	for i, c := range seen {
+		if c != 1 {
+			t.Errorf("value %d seen %d times, want once", i-1, c)
+		}
+	}
+}
+
+func TestAtomicFloat64MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		val  float64
+		want string
+	}{
+		{0, "0"},
+		{math.Copysign(0, -1), "-0"},
+		{1.5, "1.5"},
+		{math.NaN(), "null"},
+		{math.Inf(1), "null"},
+		{math.Inf(-1), "null"},
+	}
+	for _, tt := range tests {
+		buf, err := json.Marshal(struct{ V *atomicFloat64 }{newFloat64(tt.val)})
+		if err != nil {
+			t.Errorf("Marshal(%v): %v", tt.val, err)
+			continue
+		}
+		if want := `{"V":` + tt.want + `}`; string(buf) != want {
+			t.Errorf("Marshal(%v) = %s, want %s", tt.val, buf, want)
+		}
+		if !json.Valid(buf) {
+			t.Errorf("Marshal(%v) = %s, invalid JSON", tt.val, buf)
+		}
+	}
+
+	// The zero value and newFloat64(0) are the same.
+	var zero atomicFloat64
+	if a, b := zero.Load(), newFloat64(0).Load(); math.Float64bits(a) != math.Float64bits(b) {
+		t.Errorf("got zero value %v and newFloat64(0) %v, want the same", a, b)
+	}
+	if got := newFloat64(math.Copysign(0, -1)).Load(); !math.Signbit(got) {
+		t.Errorf("newFloat64(-0) = %v, want -0", got)
+	}
+}
@@ -0,0 +1,53 @@
+package statsviz
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// WithBasicAuth protects all statsviz handlers, including the websocket one,
+// with HTTP Basic Authentication. Requests without the correct credentials
+// are responded with 401 Unauthorized.
+func WithBasicAuth(username, password string) OptionFunc {
+	return func(s *Server) error {
+		if username == "" {
+			return configError("WithBasicAuth", "basic auth username can't be empty")
+		}
+		s.auth = &basicAuth{
+			user: sha256.Sum256([]byte(username)),
+			pass: sha256.Sum256([]byte(password)),
+		}
+		return nil
+	}
+}
+
+// basicAuth holds the hashes of the expected credentials. Comparing hashes
+// rather than the credentials themselves ensures the comparison takes the same
+// time, whatever their length.
+type basicAuth struct {
+	user, pass [sha256.Size]byte
+}
+
+func (a *basicAuth) check(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	uh, ph := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(pass))
+	userOK := subtle.ConstantTimeCompare(uh[:], a.user[:]) == 1
+	passOK := subtle.ConstantTimeCompare(ph[:], a.pass[:]) == 1
+	return userOK && passOK
+}
+
+// wrap returns h, only called if the request is authorized.
+func (a *basicAuth) wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.check(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="statsviz", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,91 @@
+package statsviz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWithBasicAuth(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	if err := Register(mux, WithBasicAuth("user", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/debug/statsviz/ws"
+
+	tests := []struct {
+		name       string
+		setAuth    bool
+		user, pass string
+		wantStatus int
+	}{
+		{name: "correct", setAuth: true, user: "user", pass: "secret", wantStatus: http.StatusOK},
+		{name: "wrong password", setAuth: true, user: "user", pass: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "wrong user", setAuth: true, user: "resu", pass: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/debug/statsviz/", "/debug/statsviz/handshake"} {
+				req, err := http.NewRequest("GET", ts.URL+path, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.setAuth {
+					req.SetBasicAuth(tt.user, tt.pass)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("GET %s: got status %d, want %d", path, resp.StatusCode, tt.wantStatus)
+				}
+				if tt.wantStatus == http.StatusUnauthorized && !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Basic ") {
+					t.Errorf("GET %s: got WWW-Authenticate %q, want Basic challenge", path, resp.Header.Get("WWW-Authenticate"))
+				}
+			}
+
+			// The websocket upgrade must also be authorized.
+			hdr := http.Header{}
+			if tt.setAuth {
+				req, _ := http.NewRequest("GET", ts.URL, nil)
+				req.SetBasicAuth(tt.user, tt.pass)
+				hdr.Set("Authorization", req.Header.Get("Authorization"))
+			}
+			ws, resp, err := websocket.DefaultDialer.Dial(wsURL, hdr)
+			if tt.wantStatus == http.StatusOK {
+				if err != nil {
+					t.Fatalf("websocket upgrade: %v", err)
+				}
+				ws.Close()
+				return
+			}
+			if err == nil {
+				ws.Close()
+				t.Fatalf("websocket upgrade succeeded, want it to fail")
+			}
+			if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("websocket upgrade: got response %v, want status %d", resp, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestWithBasicAuthEmptyUser(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithBasicAuth("", "secret")); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+}
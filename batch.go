@@ -0,0 +1,60 @@
+package statsviz
+
+// WithBatchSize sends the stats to websocket clients by batches of n frames,
+// as JSON arrays, in a single websocket message, rather than one message per
+// frame. At high send frequencies, this reduces the framing overhead and the
+// client wakeups, at the cost of a latency of up to n frames. The user
+// interface unpacks the batches.
+//
+// A partial batch is sent before control messages, such as frequency change
+// acknowledgements, once the history has been sent, and when the client
+// disconnects or the server is stopped, so no stats are lost. Batching
+// requires the websocket transport and the JSON encoding, n of 1 disables it.
+func WithBatchSize(n int) OptionFunc {
+	return func(s *Server) error {
+		if n <= 0 {
+			return configError("WithBatchSize", "batch size must be positive, got %d", n)
+		}
+		s.batchSize = n
+		return nil
+	}
+}
+
+// A batcher groups stats messages into JSON arrays of up to size messages,
+// before passing them to send. Binary messages can't be batched, they're sent
+// as is, after the pending batch.
+type batcher struct {
+	send func(msg []byte, binary bool) error
+	size int    // 0 or 1 if messages aren't batched
+	buf  []byte // pending batch, reused
+	n    int    // messages in buf
+}
+
+// add adds msg to the pending batch, which is sent once full.
+func (b *batcher) add(msg []byte, binary bool) error {
+	if b.size <= 1 || binary {
+		if err := b.flush(); err != nil {
+			return err
+		}
+		return b.send(msg, binary)
+	}
+	if b.n == 0 {
+		b.buf = append(b.buf[:0], '[')
+	} else {
+		b.buf = append(b.buf, ',')
+	}
+	b.buf = append(b.buf, msg...)
+	if b.n++; b.n == b.size {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush sends the pending batch, if any.
+func (b *batcher) flush() error {
+	if b.n == 0 {
+		return nil
+	}
+	b.n = 0
+	return b.send(append(b.buf, ']'), false)
+}
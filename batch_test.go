@@ -0,0 +1,138 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBatcher(t *testing.T) {
+	t.Parallel()
+
+	var sent []string
+	b := &batcher{size: 2, send: func(msg []byte, binary bool) error {
+		if binary {
+			msg = append([]byte("binary:"), msg...)
+		}
+		sent = append(sent, string(msg))
+		return nil
+	}}
+	for _, msg := range []string{"1", "2", "3", "4", "5"} {
+		if err := b.add([]byte(msg), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.add([]byte("6"), true)
+	b.add([]byte("7"), false)
+	b.flush()
+	b.flush()
+
+	want := []string{"[1,2]", "[3,4]", "[5]", "binary:6", "[7]"}
+	if strings.Join(sent, " ") != strings.Join(want, " ") {
+		t.Errorf("got messages %q, want %q", sent, want)
+	}
+}
+
+// dialBatches connects to srv websocket endpoint and returns a function
+// reading the next batch of stats.
+func dialBatches(t *testing.T, srv *Server) func() ([]stats, error) {
+	t.Helper()
+
+	ts := httptest.NewServer(srv.Ws())
+	t.Cleanup(ts.Close)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	return func() ([]stats, error) {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		var batch []stats
+		if err := json.Unmarshal(msg, &batch); err != nil {
+			t.Fatalf("got message %.100s, want a batch: %v", msg, err)
+		}
+		return batch, nil
+	}
+}
+
+func TestWsBatches(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(10*time.Millisecond), WithBatchSize(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	read := dialBatches(t, srv)
+
+	var last uint64
+	for i := 0; i < 2; i++ {
+		batch, err := read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) != 3 {
+			t.Fatalf("got a batch of %d frames, want 3", len(batch))
+		}
+		for _, st := range batch {
+			if st.Seq <= last || len(st.Metrics) == 0 {
+				t.Errorf("got frame %d after %d, want increasing frames with stats", st.Seq, last)
+			}
+			last = st.Seq
+		}
+	}
+}
+
+func TestWsBatchesFlushedOnStop(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(time.Hour), WithBatchSize(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := dialBatches(t, srv)
+	waitHubs(t, srv, 1)
+
+	// Only the last frame is collected, the partial batch is sent anyway.
+	srv.Stop()
+	batch, err := read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1 || len(batch[0].Metrics) == 0 {
+		t.Errorf("got batch %+v, want the last frame", batch)
+	}
+	if _, err := read(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("got error %v, want a normal closure", err)
+	}
+}
+
+func TestWithBatchSizeInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, opts := range [][]OptionFunc{
+		{WithBatchSize(0)},
+		{WithBatchSize(2), Transport(TransportSSE)},
+		{WithBatchSize(2), WithEncoding(EncodingMsgpack)},
+	} {
+		if srv, err := NewServer(opts...); err == nil {
+			srv.Stop()
+			t.Errorf("got nil error, want non-nil")
+		}
+	}
+	srv, err := NewServer(WithBatchSize(1), WithEncoding(EncodingMsgpack))
+	if err != nil {
+		t.Errorf("got error %v with a batch size of 1, want nil", err)
+	} else {
+		srv.Stop()
+	}
+}
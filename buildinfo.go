@@ -0,0 +1,43 @@
+package statsviz
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// processStart approximates the process start time by the initialization time
+// of the package. It holds a monotonic clock reading, so that uptimes aren't
+// affected by wall clock changes.
+var processStart = time.Now()
+
+// buildInfo describes the build and runtime of the process, sent once in the
+// handshake so that the user interface can show which program it's looking at.
+type buildInfo struct {
+	GoVersion  string    `json:"goVersion"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	NumCPU     int       `json:"numCPU"`
+	Path       string    `json:"path,omitempty"`     // main module path
+	Version    string    `json:"version,omitempty"`  // main module version
+	Revision   string    `json:"revision,omitempty"` // VCS revision
+	Modified   bool      `json:"modified,omitempty"` // VCS working tree had local changes
+	StartTime  time.Time `json:"startTime"`
+}
+
+// readBuildInfo returns the build and runtime information of the process.
+// Module and VCS information are only available in binaries built with module
+// support, VCS information with Go 1.18 or later.
+func readBuildInfo() buildInfo {
+	bi := buildInfo{
+		GoVersion:  runtime.Version(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		StartTime:  processStart,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		bi.Path = info.Main.Path
+		bi.Version = info.Main.Version
+		bi.Revision, bi.Modified = vcsRevision(info)
+	}
+	return bi
+}
@@ -0,0 +1,12 @@
+//go:build !go1.18
+// +build !go1.18
+
+package statsviz
+
+import "runtime/debug"
+
+// vcsRevision returns no revision since Go versions before 1.18 don't record
+// VCS information in binaries.
+func vcsRevision(*debug.BuildInfo) (rev string, modified bool) {
+	return "", false
+}
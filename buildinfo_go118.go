@@ -0,0 +1,20 @@
+//go:build go1.18
+// +build go1.18
+
+package statsviz
+
+import "runtime/debug"
+
+// vcsRevision returns the VCS revision the binary was built from, and whether
+// the working tree had local changes.
+func vcsRevision(info *debug.BuildInfo) (rev string, modified bool) {
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	return rev, modified
+}
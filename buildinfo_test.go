@@ -0,0 +1,43 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestHandshakeBuildInfo(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/handshake", nil))
+	var hs struct {
+		Build map[string]interface{} `json:"build"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&hs); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := hs.Build["goVersion"], runtime.Version(); got != want {
+		t.Errorf("got Go version %v, want %v", got, want)
+	}
+	if got, want := hs.Build["gomaxprocs"], float64(runtime.GOMAXPROCS(0)); got != want {
+		t.Errorf("got GOMAXPROCS %v, want %v", got, want)
+	}
+	if got, want := hs.Build["numCPU"], float64(runtime.NumCPU()); got != want {
+		t.Errorf("got NumCPU %v, want %v", got, want)
+	}
+	if _, ok := hs.Build["startTime"]; !ok {
+		t.Errorf("handshake has no start time")
+	}
+}
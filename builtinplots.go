@@ -0,0 +1,122 @@
+package statsviz
+
+import "fmt"
+
+// A Plot identifies a built-in plot of the statsviz user interface.
+type Plot string
+
+const (
+	// Plots drawn from runtime.MemStats.
+	PlotHeap        Plot = "heap"
+	PlotMSpanMCache Plot = "mspan-mcache"
+	PlotSizeClasses Plot = "size-classes"
+	PlotObjects     Plot = "objects"
+	PlotGCFraction  Plot = "gcfraction"
+
+	// PlotGoroutines shows the number of goroutines.
+	PlotGoroutines Plot = "goroutines"
+
+	// Plots drawn from runtime/metrics, only shown if the current Go runtime
+	// supports the metrics they rely on.
+	PlotSchedLatencies Plot = "sched-latencies"
+	PlotMutexWait      Plot = "mutex-wait"
+	PlotGCCPU          Plot = "gc-cpu"
+	PlotThreads        Plot = "threads"
+	PlotHeapClasses    Plot = "heap-classes"
+	PlotAllocRate      Plot = "alloc-rate"
+	PlotSizeClassChurn Plot = "size-class-churn"
+	PlotLiveHeap       Plot = "live-heap"
+	PlotGCCPUClasses   Plot = "gc-cpu-classes"
+	PlotStacks         Plot = "stacks"
+	PlotCPUClasses     Plot = "cpu-classes"
+	PlotGoDebug        Plot = "godebug"
+	PlotTracing        Plot = "tracing"
+	PlotGOMAXPROCS     Plot = "gomaxprocs"
+	PlotFinalizers     Plot = "finalizers"
+	PlotScavenger      Plot = "scavenger"
+)
+
+// memStatsPlots holds the built-in plots drawn from runtime.MemStats.
+var memStatsPlots = []Plot{PlotHeap, PlotMSpanMCache, PlotSizeClasses, PlotObjects, PlotGCFraction}
+
+// allPlots holds all built-in plots.
+var allPlots = append(append([]Plot{}, memStatsPlots...), PlotGoroutines, PlotSchedLatencies, PlotMutexWait, PlotGCCPU, PlotThreads, PlotHeapClasses, PlotAllocRate, PlotSizeClassChurn, PlotLiveHeap, PlotGCCPUClasses, PlotStacks, PlotCPUClasses, PlotGoDebug, PlotTracing, PlotGOMAXPROCS, PlotFinalizers, PlotScavenger)
+
+func checkPlots(plots []Plot) error {
+	for _, p := range plots {
+		known := false
+		for _, kp := range allPlots {
+			if p == kp {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown plot %q", p)
+		}
+	}
+	return nil
+}
+
+// WithPlots only enables the provided built-in plots, all the others are
+// disabled. Disabled plots are neither shown nor sampled. By default, all
+// built-in plots are enabled.
+func WithPlots(plots ...Plot) OptionFunc {
+	return func(s *Server) error {
+		if err := checkPlots(plots); err != nil {
+			return &ConfigError{Option: "WithPlots", Err: err}
+		}
+		for _, p := range allPlots {
+			s.disabled[p] = true
+		}
+		for _, p := range plots {
+			delete(s.disabled, p)
+		}
+		return nil
+	}
+}
+
+// WithoutPlots disables the provided built-in plots. Disabled plots are neither
+// shown nor sampled.
+func WithoutPlots(plots ...Plot) OptionFunc {
+	return func(s *Server) error {
+		if err := checkPlots(plots); err != nil {
+			return &ConfigError{Option: "WithoutPlots", Err: err}
+		}
+		for _, p := range plots {
+			s.disabled[p] = true
+		}
+		return nil
+	}
+}
+
+// enabled reports whether the built-in plot p is enabled.
+func (s *Server) enabled(p Plot) bool {
+	return !s.disabled[p]
+}
+
+// needsMemStats reports whether at least one enabled plot is drawn from
+// runtime.MemStats.
+func (s *Server) needsMemStats() bool {
+	for _, p := range memStatsPlots {
+		if s.enabled(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinPlots returns the enabled built-in plots that are not runtime plots,
+// the user interface only draws those. Runtime plots are described separately.
+func (s *Server) builtinPlots() []Plot {
+	plots := []Plot{}
+	for _, p := range memStatsPlots {
+		if s.enabled(p) {
+			plots = append(plots, p)
+		}
+	}
+	if s.enabled(PlotGoroutines) {
+		plots = append(plots, PlotGoroutines)
+	}
+	return plots
+}
@@ -0,0 +1,98 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithPlots(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotGoroutines))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := newStats()
+	s.collect(s.newSampler(), &st)
+
+	if st.NumGoroutine == 0 {
+		t.Errorf("got 0 goroutines, want more")
+	}
+	if st.Mem != nil {
+		t.Errorf("got MemStats, want none since no plot needs them")
+	}
+	if len(st.RuntimePlots) != 0 || len(st.Metrics) != 0 {
+		t.Errorf("got runtime plots %v and metrics %v, want none", st.RuntimePlots, st.Metrics)
+	}
+
+	buf, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), `"Mem"`) {
+		t.Errorf("frame %s contains Mem", buf)
+	}
+
+	if got, want := s.handshake().BuiltinPlots, []Plot{PlotGoroutines}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got handshake builtin plots %v, want %v", got, want)
+	}
+}
+
+func TestWithoutPlots(t *testing.T) {
+	t.Parallel()
+
+	const metric = "/sync/mutex/wait/total:seconds"
+
+	// Check the plot and its metric are in the frame by default.
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := newStats()
+	s.collect(s.newSampler(), &st)
+	if _, ok := st.RuntimePlots[string(PlotMutexWait)]; !ok {
+		t.Skipf("%s not supported by this Go version", metric)
+	}
+	if _, ok := st.Metrics[metric]; !ok {
+		t.Fatalf("frame doesn't contain %s", metric)
+	}
+
+	s, err = NewServer(WithoutPlots(PlotMutexWait, PlotGoroutines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st = newStats()
+	s.collect(s.newSampler(), &st)
+
+	if _, ok := st.RuntimePlots[string(PlotMutexWait)]; ok {
+		t.Errorf("frame contains the disabled %s plot", PlotMutexWait)
+	}
+	if _, ok := st.Metrics[metric]; ok {
+		t.Errorf("frame contains %s, only used by a disabled plot", metric)
+	}
+	if st.NumGoroutine != 0 {
+		t.Errorf("got %d goroutines, want 0 since the plot is disabled", st.NumGoroutine)
+	}
+	if st.Mem == nil {
+		t.Errorf("got no MemStats, want some")
+	}
+	for _, cfg := range s.handshake().RuntimePlots {
+		if cfg.Name == string(PlotMutexWait) {
+			t.Errorf("handshake contains the disabled %s plot", PlotMutexWait)
+		}
+	}
+}
+
+func TestWithPlotsUnknown(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithPlots("unknown")); err == nil {
+		t.Errorf("WithPlots: got nil error, want non-nil")
+	}
+	if _, err := NewServer(WithoutPlots("unknown")); err == nil {
+		t.Errorf("WithoutPlots: got nil error, want non-nil")
+	}
+}
@@ -0,0 +1,47 @@
+package statsviz
+
+import "runtime"
+
+// cgoCallsPlot is the name of the plot added by WithCgoPlot.
+const cgoCallsPlot = "cgo-calls"
+
+// WithCgoPlot, if enabled, adds a plot showing the rate of cgo calls made by
+// the program, so that spikes are visible. Together with the goroutines and OS
+// threads plot, it helps diagnosing OS threads created by blocking cgo calls.
+// The plot is disabled by default.
+func WithCgoPlot(enabled bool) OptionFunc {
+	return func(s *Server) error {
+		for i := range s.runtimePlots {
+			if s.runtimePlots[i].name == cgoCallsPlot {
+				s.runtimePlots = append(s.runtimePlots[:i:i], s.runtimePlots[i+1:]...)
+				break
+			}
+		}
+		if enabled {
+			s.runtimePlots = append(s.runtimePlots, cgoPlot(cgoCalls))
+		}
+		return nil
+	}
+}
+
+// cgoPlot returns the cgo calls plot, reading the cumulative number of cgo
+// calls with calls.
+func cgoPlot(calls func() float64) runtimePlot {
+	return runtimePlot{
+		name:  cgoCallsPlot,
+		title: "Cgo calls (calls per second)",
+		series: []runtimeSeries{
+			{
+				name:      "calls",
+				key:       "cgo:calls",
+				read:      calls,
+				transform: Rate,
+			},
+		},
+	}
+}
+
+// cgoCalls returns the number of cgo calls made by the program.
+func cgoCalls() float64 {
+	return float64(runtime.NumCgoCall())
+}
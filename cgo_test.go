@@ -0,0 +1,66 @@
+package statsviz
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCgoPlotRate(t *testing.T) {
+	t.Parallel()
+
+	counts := []float64{100, 100, 150, 450, 460}
+	i := 0
+	p := cgoPlot(func() float64 { return counts[i] })
+
+	smp := newSamplerOf([]string{})
+	t0 := time.Now()
+	times := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 4500 * time.Millisecond}
+	want := []float64{math.NaN(), 0, 50, 150, 20}
+
+	var vals plotValues
+	for i = range counts {
+		smp.read(t0.Add(times[i]))
+		vals = p.sample(smp, vals)
+		if len(vals) != 1 {
+			t.Fatalf("got %d values, want 1", len(vals))
+		}
+		if got := vals[0]; got != want[i] && !(math.IsNaN(got) && math.IsNaN(want[i])) {
+			t.Errorf("sample %d: got rate %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestWithCgoPlot(t *testing.T) {
+	t.Parallel()
+
+	count := func(s *Server) int {
+		n := 0
+		for _, p := range s.runtimePlots {
+			if p.name == cgoCallsPlot {
+				n++
+			}
+		}
+		return n
+	}
+
+	tests := []struct {
+		opts []OptionFunc
+		want int
+	}{
+		{nil, 0},
+		{[]OptionFunc{WithCgoPlot(true)}, 1},
+		{[]OptionFunc{WithCgoPlot(true), WithCgoPlot(true)}, 1},
+		{[]OptionFunc{WithCgoPlot(true), WithCgoPlot(false)}, 0},
+	}
+	for i, tt := range tests {
+		s, err := NewServer(tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := count(s); got != tt.want {
+			t.Errorf("test %d: got %d cgo plots, want %d", i, got, tt.want)
+		}
+		s.Stop()
+	}
+}
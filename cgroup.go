@@ -0,0 +1,45 @@
+package statsviz
+
+// cgroupMemoryPlot is the name of the plot added by WithCgroupMemory.
+const cgroupMemoryPlot = "cgroup-memory"
+
+// defaultCgroupRoot is where the cgroup filesystem of the process is mounted,
+// in a container in general.
+const defaultCgroupRoot = "/sys/fs/cgroup"
+
+// WithCgroupMemory, if enabled, adds a plot showing the memory usage of the
+// cgroup of the process, as accounted by the kernel, so including the page
+// cache, against the cgroup memory limit, which makes the heap plots more
+// meaningful in containers. Both cgroup v1 and v2 are supported.
+//
+// The plot is only supported on Linux, it's not shown on other platforms.
+// Without memory limit, or outside of a cgroup, the limit, or both series,
+// have no values. The plot is disabled by default.
+func WithCgroupMemory(enable bool) OptionFunc {
+	return func(s *Server) error {
+		for i := range s.runtimePlots {
+			if s.runtimePlots[i].name == cgroupMemoryPlot {
+				s.runtimePlots = append(s.runtimePlots[:i:i], s.runtimePlots[i+1:]...)
+				break
+			}
+		}
+		if enable && cgroupSupported {
+			s.runtimePlots = append(s.runtimePlots, cgroupPlot(defaultCgroupRoot))
+		}
+		return nil
+	}
+}
+
+// cgroupPlot returns the cgroup memory plot, reading the cgroup filesystem
+// mounted at root.
+func cgroupPlot(root string) runtimePlot {
+	cg := cgroupMemory{root: root}
+	return runtimePlot{
+		name:  cgroupMemoryPlot,
+		title: "Cgroup memory",
+		series: []runtimeSeries{
+			{name: "usage", read: cg.usage, readUnit: "bytes"},
+			{name: "limit", read: cg.limit, readUnit: "bytes"},
+		},
+	}
+}
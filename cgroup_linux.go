@@ -0,0 +1,61 @@
+package statsviz
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const cgroupSupported = true
+
+// cgroupMemory reads the memory usage and limit of the cgroup filesystem
+// mounted at root. The v2 unified hierarchy is tried first, then the v1
+// memory controller.
+type cgroupMemory struct {
+	root string
+}
+
+// cgroupUnlimited is the threshold above which a cgroup v1 limit means there's
+// no limit: without limit, v1 reports the largest page aligned int64.
+const cgroupUnlimited = 1 << 62
+
+// usage returns the memory usage of the cgroup, in bytes, or NaN if it can't
+// be read.
+func (cg cgroupMemory) usage() float64 {
+	if v, ok := cg.read("memory.current"); ok {
+		return v
+	}
+	if v, ok := cg.read("memory/memory.usage_in_bytes"); ok {
+		return v
+	}
+	return math.NaN()
+}
+
+// limit returns the memory limit of the cgroup, in bytes, or NaN if there's
+// no limit or it can't be read.
+func (cg cgroupMemory) limit() float64 {
+	v, ok := cg.read("memory.max")
+	if !ok {
+		v, ok = cg.read("memory/memory.limit_in_bytes")
+	}
+	if !ok || v >= cgroupUnlimited {
+		return math.NaN()
+	}
+	return v
+}
+
+// read reads the value of a cgroup file, reporting false if it can't be read
+// or holds "max", meaning there's no limit.
+func (cg cgroupMemory) read(name string) (float64, bool) {
+	b, err := os.ReadFile(filepath.Join(cg.root, name))
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return float64(v), true
+}
@@ -0,0 +1,119 @@
+package statsviz
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCgroupFiles writes files, by name relative to the returned root, like
+// a cgroup filesystem.
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCgroupMemory(t *testing.T) {
+	t.Parallel()
+
+	nan := math.NaN()
+	tests := []struct {
+		name         string
+		files        map[string]string
+		usage, limit float64
+	}{
+		{
+			name:  "v2",
+			files: map[string]string{"memory.current": "1048576\n", "memory.max": "4194304\n"},
+			usage: 1 << 20, limit: 4 << 20,
+		},
+		{
+			name:  "v2 unlimited",
+			files: map[string]string{"memory.current": "1048576\n", "memory.max": "max\n"},
+			usage: 1 << 20, limit: nan,
+		},
+		{
+			name: "v1",
+			files: map[string]string{
+				"memory/memory.usage_in_bytes": "2097152\n",
+				"memory/memory.limit_in_bytes": "8388608\n",
+			},
+			usage: 2 << 20, limit: 8 << 20,
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"memory/memory.usage_in_bytes": "2097152\n",
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+			},
+			usage: 2 << 20, limit: nan,
+		},
+		{
+			name:  "no cgroup",
+			files: map[string]string{},
+			usage: nan, limit: nan,
+		},
+	}
+	for _, tt := range tests {
+		cg := cgroupMemory{root: writeCgroupFiles(t, tt.files)}
+		if got := cg.usage(); !equalFloats([]float64{got}, []float64{tt.usage}) {
+			t.Errorf("%s: got usage %v, want %v", tt.name, got, tt.usage)
+		}
+		if got := cg.limit(); !equalFloats([]float64{got}, []float64{tt.limit}) {
+			t.Errorf("%s: got limit %v, want %v", tt.name, got, tt.limit)
+		}
+	}
+}
+
+func TestWithCgroupMemory(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithCgroupMemory(true), WithCgroupMemory(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	count := 0
+	for i := range srv.runtimePlots {
+		if p := &srv.runtimePlots[i]; p.name == cgroupMemoryPlot {
+			count++
+			if cfg := p.config(); len(cfg.Series) != 2 || cfg.Series[1].Unit != "bytes" {
+				t.Errorf("got series %+v, want usage and limit in bytes", cfg.Series)
+			}
+		}
+	}
+	if count != 1 {
+		t.Errorf("got %d cgroup memory plots, want 1", count)
+	}
+
+	// The plot includes the values read from the cgroup filesystem.
+	root := writeCgroupFiles(t, map[string]string{"memory.current": "1024", "memory.max": "4096"})
+	p := cgroupPlot(root)
+	if vals := p.sample(srv.newSampler(), nil); len(vals) != 2 || vals[0] != 1024 || vals[1] != 4096 {
+		t.Errorf("got values %v, want [1024 4096]", vals)
+	}
+
+	srv, err = NewServer(WithCgroupMemory(true), WithCgroupMemory(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	for i := range srv.runtimePlots {
+		if srv.runtimePlots[i].name == cgroupMemoryPlot {
+			t.Errorf("got cgroup memory plot, want it disabled")
+		}
+	}
+}
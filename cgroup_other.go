@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package statsviz
+
+import "math"
+
+// Cgroups only exist on Linux.
+const cgroupSupported = false
+
+type cgroupMemory struct {
+	root string
+}
+
+func (cgroupMemory) usage() float64 { return math.NaN() }
+func (cgroupMemory) limit() float64 { return math.NaN() }
@@ -0,0 +1,56 @@
+package statsviz
+
+import "time"
+
+// A clock provides the time to the stats collection, so that tests can
+// control it.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+	NewTimer(d time.Duration) timer
+}
+
+// A ticker delivers ticks of a clock, like time.Ticker.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// A timer delivers a single tick of a clock, like time.Timer.
+type timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// realClock is the clock of the time package, used by default.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) NewTimer(d time.Duration) timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time   { return t.t.C }
+func (t realTimer) Reset(d time.Duration) { t.t.Reset(d) }
+func (t realTimer) Stop()                 { t.t.Stop() }
+
+// withClock sets the clock used to timestamp and schedule stats collection.
+func withClock(c clock) OptionFunc {
+	return func(s *Server) error {
+		s.clock = c
+		return nil
+	}
+}
@@ -0,0 +1,156 @@
+package statsviz
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock which time only changes when advanced.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	added   chan struct{} // receives each time a ticker or timer is armed
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, added: make(chan struct{}, 16)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTicker{c: make(chan time.Time, 1), d: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	c.added <- struct{}{}
+	return t
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTicker{c: make(chan time.Time, 1), clock: c, once: true, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	c.added <- struct{}{}
+	return t
+}
+
+// advance moves the clock forward by d, delivering the ticks that happen in
+// the meantime. Like time.Ticker, ticks are dropped for slow receivers.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		t.mu.Lock()
+		for !t.stopped && !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			if t.once {
+				t.stopped = true
+				break
+			}
+			t.next = t.next.Add(t.d)
+		}
+		t.mu.Unlock()
+	}
+}
+
+type fakeTicker struct {
+	c chan time.Time
+	d time.Duration
+
+	// Timers tick once, until reset.
+	clock *fakeClock
+	once  bool
+
+	mu      sync.Mutex
+	next    time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.next = t.clock.now.Add(d)
+	t.stopped = false
+	t.clock.added <- struct{}{}
+}
+
+// deadline returns the instant of the next tick.
+func (t *fakeTicker) deadline() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.next
+}
+
+func (t *fakeTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+}
+
+func TestFakeClockFrames(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newFakeClock(t0)
+
+	srv, err := NewServer(withClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	frames, unsubscribe := srv.subscribe(time.Second)
+	defer unsubscribe()
+
+	// Wait for the hub to start its ticker.
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+
+	for i := 1; i <= 3; i++ {
+		clk.advance(time.Second)
+		select {
+		case f := <-frames:
+			f.release()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tick %d: timeout waiting for a frame", i)
+		}
+	}
+
+	// Without ticks, no other frame is produced.
+	select {
+	case f := <-frames:
+		t.Errorf("got frame %s, want none", f.bytes())
+		f.release()
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Stats are timestamped with the fake clock.
+	st := newStats()
+	srv.collect(srv.newSampler(), &st)
+	if want := t0.Add(3 * time.Second); !st.Time.Equal(want) {
+		t.Errorf("got stats time %v, want %v", st.Time, want)
+	}
+}
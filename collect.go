@@ -0,0 +1,136 @@
+package statsviz
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// A Frame holds the stats collected at one point in time, as sent to the user
+// interface.
+type Frame struct {
+	Time time.Time
+
+	// ID is the id passed to SnapshotWithID, empty otherwise.
+	ID string
+
+	// Uptime is the time elapsed since the process started, at Time.
+	Uptime time.Duration
+
+	// Mem holds the memory statistics of the Go runtime, it's nil if no
+	// enabled plot needs them.
+	Mem *runtime.MemStats
+
+	// NumGoroutine is the number of goroutines, it's 0 if the goroutines plot
+	// is disabled.
+	NumGoroutine int
+
+	// Metrics holds the scalar runtime metrics, by runtime/metrics name.
+	Metrics map[string]float64
+
+	// UserMetrics holds the values of the counters and gauges created with
+	// NewCounter and NewGauge, by name.
+	UserMetrics map[string]float64
+
+	// UserPlots holds the values of the series of the user plots, by plot
+	// name, in the order of the plot series, after their transform.
+	UserPlots map[string][]float64
+
+	// RuntimePlots holds the values of the series of the built-in runtime
+	// plots, by plot name.
+	RuntimePlots map[string][]float64
+}
+
+// collector collects the stats returned by Server.Collect. Its sampler keeps
+// the previous values the series transforms need from one call to the other.
+type collector struct {
+	mu  sync.Mutex
+	smp *sampler
+}
+
+// Collect synchronously collects the stats the server sends to the user
+// interface, with the same code, which is useful to test that the plots and
+// metrics an application defines are fed as expected, without connecting a
+// client.
+//
+// Series transforms, such as Rate, compute their values across the calls to
+// Collect, independently of the stats sent to clients. Their first values are
+// then NaN. Collect returns ErrServerStopped if the server is stopped.
+func (s *Server) Collect() (Frame, error) {
+	untrack, ok := s.track()
+	if !ok {
+		return Frame{}, ErrServerStopped
+	}
+	defer untrack()
+
+	s.collector.mu.Lock()
+	defer s.collector.mu.Unlock()
+	if s.collector.smp == nil {
+		s.collector.smp = s.newSampler()
+	}
+
+	st := newStats()
+	s.collect(s.collector.smp, &st)
+	return frameOf(&st), nil
+}
+
+// frameOf returns the Frame of st, which shares its metrics maps.
+func frameOf(st *stats) Frame {
+	f := Frame{
+		Time:         st.Time,
+		Uptime:       time.Duration(st.Uptime * float64(time.Second)),
+		Mem:          st.Mem,
+		NumGoroutine: st.NumGoroutine,
+		Metrics:      st.Metrics,
+		UserMetrics:  st.UserMetrics,
+	}
+	if st.UserPlots != nil {
+		f.UserPlots = make(map[string][]float64, len(st.UserPlots))
+		for name, vals := range st.UserPlots {
+			f.UserPlots[name] = vals
+		}
+	}
+	if st.RuntimePlots != nil {
+		f.RuntimePlots = make(map[string][]float64, len(st.RuntimePlots))
+		for name, vals := range st.RuntimePlots {
+			f.RuntimePlots[name] = vals
+		}
+	}
+	return f
+}
+
+// SnapshotWithID returns the stats most recently collected at the server send
+// frequency, tagged with id, for example to log them along with the trace of
+// a slow request. It doesn't collect stats, the returned frame has a zero Time
+// if none were collected yet, which is the case on the first call, since stats
+// are only tracked from then on, until the server is stopped.
+func (s *Server) SnapshotWithID(id string) Frame {
+	st := s.latestStats()
+	if st == nil {
+		return Frame{ID: id}
+	}
+	// The latest stats are shared.
+	c := st.clone()
+	f := frameOf(&c)
+	f.ID = id
+	return f
+}
+
+var defaultCollector struct {
+	once sync.Once
+	srv  *Server
+	err  error
+}
+
+// Collect synchronously collects stats like Server.Collect, with a server
+// created with the default options, so without user plots. It's shared by
+// all calls to Collect.
+func Collect() (Frame, error) {
+	defaultCollector.once.Do(func() {
+		defaultCollector.srv, defaultCollector.err = NewServer()
+	})
+	if defaultCollector.err != nil {
+		return Frame{}, defaultCollector.err
+	}
+	return defaultCollector.srv.Collect()
+}
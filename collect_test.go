@@ -0,0 +1,124 @@
+package statsviz
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var collectSink [][]byte
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	c := NewCounter("test-collect-counter")
+	srv, err := NewServer(WithPlot(TimeSeriesPlot{
+		Name: "requests",
+		Series: []TimeSeries{
+			{Name: "total", Value: c.Value},
+			{Name: "rate", Value: c.Value, Transform: Rate},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	c.Add(10)
+	first, err := srv.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Allocate and count, so that monotonic metrics increase.
+	for i := 0; i < 100; i++ {
+		collectSink = append(collectSink, make([]byte, 1024))
+	}
+	c.Add(5)
+	second, err := srv.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !second.Time.After(first.Time) {
+		t.Errorf("got times %v then %v, want increasing times", first.Time, second.Time)
+	}
+	if first.Uptime <= 0 || second.Uptime <= first.Uptime {
+		t.Errorf("got uptimes %v then %v, want positive and increasing uptimes", first.Uptime, second.Uptime)
+	}
+	for _, name := range []string{"/gc/heap/allocs:bytes", "/gc/heap/allocs:objects"} {
+		if first.Metrics[name] >= second.Metrics[name] {
+			t.Errorf("got %s = %v then %v, want an increase", name, first.Metrics[name], second.Metrics[name])
+		}
+	}
+	if got := second.UserMetrics["test-collect-counter"]; got != 15 {
+		t.Errorf("got user metric %v, want 15", got)
+	}
+
+	// The rate has no value the first time.
+	if vals := first.UserPlots["requests"]; len(vals) != 2 || vals[0] != 10 || !math.IsNaN(vals[1]) {
+		t.Errorf("got first user plot values %v, want [10 NaN]", vals)
+	}
+	if vals := second.UserPlots["requests"]; len(vals) != 2 || vals[0] != 15 || !(vals[1] > 0) {
+		t.Errorf("got second user plot values %v, want 15 and a positive rate", vals)
+	}
+
+	srv.Stop()
+	if _, err := srv.Collect(); err == nil {
+		t.Errorf("got nil error collecting from a stopped server, want non-nil")
+	}
+}
+
+func TestCollectDefault(t *testing.T) {
+	t.Parallel()
+
+	f, err := Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Mem == nil || f.NumGoroutine == 0 || len(f.Metrics) == 0 || len(f.RuntimePlots) == 0 {
+		t.Errorf("got frame %+v, want the default stats", f)
+	}
+}
+
+func TestSnapshotWithID(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.SnapshotWithID("").Time.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for stats")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	before := srv.latestStats()
+	f := srv.SnapshotWithID("req-42")
+	after := srv.latestStats()
+	if f.ID != "req-42" {
+		t.Errorf("got id %q, want %q", f.ID, "req-42")
+	}
+	if f.Time.Before(before.Time) || f.Time.After(after.Time) {
+		t.Errorf("got frame at %v, want the latest stats, between %v and %v", f.Time, before.Time, after.Time)
+	}
+	if len(f.Metrics) == 0 || f.Mem == nil {
+		t.Errorf("got frame %+v, want metrics and memory stats", f)
+	}
+
+	// The frame isn't shared with the latest stats.
+	for name := range f.Metrics {
+		delete(f.Metrics, name)
+	}
+	if len(srv.latestStats().Metrics) == 0 {
+		t.Errorf("got latest stats without metrics after modifying the snapshot")
+	}
+	if f := srv.SnapshotWithID(""); f.ID != "" {
+		t.Errorf("got id %q, want none", f.ID)
+	}
+}
@@ -0,0 +1,72 @@
+package statsviz
+
+import (
+	"math"
+	"runtime/metrics"
+	"sort"
+	"strconv"
+)
+
+// WithCompactMetrics enables compact stats messages: instead of repeating the
+// names of the runtime metrics in each message, they're sent once, in the
+// handshake, and messages only hold the metrics values, in the same order. It
+// roughly halves the size of the messages when a lot of runtime metrics are
+// read, for example by user plots or thresholds.
+func WithCompactMetrics(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.compact = enable
+		return nil
+	}
+}
+
+// compactMetricNames returns the sorted names of the scalar runtime metrics
+// read by the server, which index the values of compact stats.
+func (s *Server) compactMetricNames() []string {
+	smp := s.newSampler()
+	var names []string
+	for _, d := range smp.descs {
+		if d.Kind == metrics.KindUint64 || d.Kind == metrics.KindFloat64 {
+			names = append(names, d.Name)
+		}
+	}
+	names = append(names, samplerOverrunMetric)
+	sort.Strings(names)
+	return names
+}
+
+// compactStats returns a copy of st in which Metrics are replaced by
+// MetricValues, holding their values in the order of s.metricNames. The values
+// are appended to vals[:0].
+func (s *Server) compactStats(st *stats, vals compactValues) stats {
+	c := *st
+	c.Metrics = nil
+	c.MetricValues = vals[:0]
+	for _, name := range s.metricNames {
+		v, ok := st.Metrics[name]
+		if !ok {
+			v = math.NaN()
+		}
+		c.MetricValues = append(c.MetricValues, v)
+	}
+	return c
+}
+
+// compactValues are the metrics values of compact stats. Values which couldn't
+// be read are NaN, encoded as null in JSON.
+type compactValues []float64
+
+func (vals compactValues) MarshalJSON() ([]byte, error) {
+	b := make([]byte, 0, 2+len(vals)*8)
+	b = append(b, '[')
+	for i, v := range vals {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			b = append(b, "null"...)
+			continue
+		}
+		b = strconv.AppendFloat(b, v, 'g', -1, 64)
+	}
+	return append(b, ']'), nil
+}
@@ -0,0 +1,83 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWithCompactMetrics(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithCompactMetrics(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/handshake", nil))
+	var hs struct {
+		MetricNames []string `json:"metricNames"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&hs); err != nil {
+		t.Fatal(err)
+	}
+	if len(hs.MetricNames) == 0 || !sort.StringsAreSorted(hs.MetricNames) {
+		t.Fatalf("got handshake metric names %q, want sorted names", hs.MetricNames)
+	}
+
+	st := newStats()
+	srv.collect(srv.newSampler(), &st)
+	st.Metrics[hs.MetricNames[0]] = math.NaN() // couldn't be read
+
+	buf, err := srv.marshalStats(&st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame struct {
+		Metrics      map[string]float64
+		MetricValues []*float64
+	}
+	if err := json.Unmarshal(buf, &frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Metrics != nil {
+		t.Errorf("compact frame holds Metrics %v, want none", frame.Metrics)
+	}
+	if len(frame.MetricValues) != len(hs.MetricNames) {
+		t.Fatalf("got %d metric values, want %d", len(frame.MetricValues), len(hs.MetricNames))
+	}
+
+	got := make(map[string]float64)
+	for i, v := range frame.MetricValues {
+		if v != nil {
+			got[hs.MetricNames[i]] = *v
+		}
+	}
+	want := make(map[string]float64)
+	for name, v := range st.Metrics {
+		if !math.IsNaN(v) {
+			want[name] = v
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got metrics %v, want %v", got, want)
+	}
+
+	// Frames encoded by hubs are compact too.
+	h := &hub{s: srv, stats: st}
+	h.enc = json.NewEncoder(&h.buf)
+	if err := h.encode(); err != nil {
+		t.Fatal(err)
+	}
+	if string(h.out) != string(buf) {
+		t.Errorf("hub frame differs from marshalled stats:\n%s\n%s", h.out, buf)
+	}
+}
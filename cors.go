@@ -0,0 +1,97 @@
+package statsviz
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WithCORS allows the user interface to be served from another origin, such
+// as an internal portal, by allowing cross-origin requests from the provided
+// origins, for example "https://portal.example.com". "*" allows any origin.
+//
+// Responses of all statsviz handlers to allowed origins carry the
+// Access-Control-Allow-Origin header, and preflight OPTIONS requests are
+// answered with 204 No Content. Unless WithCheckOrigin is used, websocket
+// connections from allowed origins are accepted too, in addition to the
+// same-origin ones.
+func WithCORS(origins ...string) OptionFunc {
+	return func(s *Server) error {
+		if len(origins) == 0 {
+			return configError("WithCORS", "CORS requires at least one origin")
+		}
+		c := &cors{origins: make(map[string]bool, len(origins))}
+		for _, o := range origins {
+			if o == "*" {
+				c.any = true
+				continue
+			}
+			c.origins[strings.ToLower(strings.TrimRight(o, "/"))] = true
+		}
+		s.cors = c
+		return nil
+	}
+}
+
+// cors holds the origins allowed to make cross-origin requests.
+type cors struct {
+	origins map[string]bool // lower-cased
+	any     bool
+}
+
+func (c *cors) allowed(origin string) bool {
+	return origin != "" && (c.any || c.origins[strings.ToLower(origin)])
+}
+
+// checkOrigin is the websocket origin check used with CORS, it accepts the
+// same-origin requests, like the default check, and the allowed origins.
+func (c *cors) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || c.allowed(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// wrap returns h, adding the CORS headers to responses to allowed origins
+// and answering preflight requests. Credentials are allowed if credentials
+// is set, in which case the request origin is echoed even if any origin is
+// allowed, since browsers refuse credentials with the '*' wildcard.
+func (c *cors) wrap(h http.HandlerFunc, credentials bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h(w, r)
+			return
+		}
+
+		hdr := w.Header()
+		hdr.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if c.allowed(origin) {
+			if c.any && !credentials {
+				hdr.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				hdr.Set("Access-Control-Allow-Origin", origin)
+			}
+			if credentials {
+				hdr.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if preflight {
+				hdr.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+				if reqHdrs := r.Header.Get("Access-Control-Request-Headers"); reqHdrs != "" {
+					hdr.Set("Access-Control-Allow-Headers", reqHdrs)
+				}
+				hdr.Set("Access-Control-Max-Age", "600")
+			}
+		}
+		if preflight {
+			// Without Access-Control-Allow-Origin, the browser doesn't send
+			// the actual request.
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h(w, r)
+	}
+}
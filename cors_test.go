@@ -0,0 +1,135 @@
+package statsviz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func newCORSServer(t *testing.T, opts ...OptionFunc) *httptest.Server {
+	t.Helper()
+
+	srv, err := NewServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	t.Cleanup(srv.Stop)
+	return ts
+}
+
+func corsRequest(t *testing.T, method, url, origin string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", "GET")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestWithCORS(t *testing.T) {
+	t.Parallel()
+
+	const allowed = "https://portal.example.com"
+	ts := newCORSServer(t, WithCORS(allowed))
+
+	for _, path := range []string{"/debug/statsviz/", "/debug/statsviz/app.js", "/debug/statsviz/handshake"} {
+		resp := corsRequest(t, http.MethodGet, ts.URL+path, allowed)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: got status %d, want %d", path, resp.StatusCode, http.StatusOK)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != allowed {
+			t.Errorf("GET %s: got Access-Control-Allow-Origin %q, want %q", path, got, allowed)
+		}
+
+		resp = corsRequest(t, http.MethodGet, ts.URL+path, "https://evil.example.com")
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("GET %s from a disallowed origin: got Access-Control-Allow-Origin %q, want none", path, got)
+		}
+	}
+
+	resp := corsRequest(t, http.MethodOptions, ts.URL+"/debug/statsviz/handshake", allowed)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("preflight: got status %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != allowed {
+		t.Errorf("preflight: got Access-Control-Allow-Origin %q, want %q", got, allowed)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Methods"); !strings.Contains(got, "GET") {
+		t.Errorf("preflight: got Access-Control-Allow-Methods %q, want GET allowed", got)
+	}
+
+	resp = corsRequest(t, http.MethodOptions, ts.URL+"/debug/statsviz/handshake", "https://evil.example.com")
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("preflight from a disallowed origin: got Access-Control-Allow-Origin %q, want none", got)
+	}
+}
+
+func TestWithCORSWebsocket(t *testing.T) {
+	t.Parallel()
+
+	const allowed = "https://portal.example.com"
+	ts := newCORSServer(t, WithCORS(allowed))
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/debug/statsviz/ws"
+
+	for _, origin := range []string{allowed, ts.URL} {
+		ws, _, err := websocket.DefaultDialer.Dial(URL, http.Header{"Origin": {origin}})
+		if err != nil {
+			t.Errorf("origin %s: %v", origin, err)
+			continue
+		}
+		ws.Close()
+	}
+
+	ws, resp, err := websocket.DefaultDialer.Dial(URL, http.Header{"Origin": {"https://evil.example.com"}})
+	if err == nil {
+		ws.Close()
+		t.Fatalf("disallowed origin: upgrade succeeded, want it refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("disallowed origin: got response %v, want status %d", resp, http.StatusForbidden)
+	}
+}
+
+func TestWithCORSCredentials(t *testing.T) {
+	t.Parallel()
+
+	const origin = "https://portal.example.com"
+	ts := newCORSServer(t, WithCORS("*"), WithBasicAuth("user", "secret"))
+
+	// Preflight requests don't carry credentials.
+	resp := corsRequest(t, http.MethodOptions, ts.URL+"/debug/statsviz/", origin)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("preflight: got status %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != origin {
+		t.Errorf("got Access-Control-Allow-Origin %q, want the request origin %q", got, origin)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("got Access-Control-Allow-Credentials %q, want true", got)
+	}
+}
+
+func TestWithCORSInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithCORS()); err == nil {
+		t.Errorf("got nil error without origins, want non-nil")
+	}
+}
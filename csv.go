@@ -0,0 +1,183 @@
+package statsviz
+
+import (
+	"encoding/csv"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// CSVHandler returns a handler that responds to GET requests with the stats
+// kept in history, as CSV, one row per sample, oldest first. The first column
+// holds the sample time, in RFC 3339 format, followed by one column per scalar
+// runtime metric read by the server. Histogram metrics are summarized by 4
+// columns: the number of values recorded over the sampling interval, their
+// sum and the 50th and 99th percentiles, estimated from the histogram
+// buckets. Empty cells are values that couldn't be read.
+//
+// The 'since' query parameter, an RFC 3339 time, limits the rows to the
+// samples collected at or after it.
+//
+// History must be enabled with WithHistorySize, otherwise only the header row
+// is sent. Register mounts the handler on the history.csv endpoint.
+func (s *Server) CSVHandler() http.Handler {
+	return s.wrap(s.unlessStopped(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "invalid 'since' parameter: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			since = t
+		}
+
+		var all []stats
+		if s.history != nil {
+			all = s.history.snapshot()
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="statsviz.csv"`)
+
+		cols := s.csvColumns()
+		cw := csv.NewWriter(w)
+		cw.Write(cols.header())
+		for i := range all {
+			if all[i].Time.Before(since) {
+				continue
+			}
+			cw.Write(cols.row(&all[i]))
+		}
+		cw.Flush()
+	}))
+}
+
+// csvColumns describes the columns of the CSV export.
+type csvColumns struct {
+	scalars  []string          // scalar metrics names
+	heatmaps []*runtimeHeatmap // summarized histogram metrics
+	plots    []string          // name of the runtime plot of each heatmap
+}
+
+// csvColumns returns the columns for the metrics read by the enabled runtime
+// plots, sorted by metric name.
+func (s *Server) csvColumns() csvColumns {
+	var cols csvColumns
+	seen := make(map[string]bool)
+	for i := range s.runtimePlots {
+		p := &s.runtimePlots[i]
+		if p.heatmap != nil {
+			cols.heatmaps = append(cols.heatmaps, p.heatmap)
+			cols.plots = append(cols.plots, p.name)
+			continue
+		}
+		for _, name := range p.metrics() {
+			if !seen[name] {
+				seen[name] = true
+				cols.scalars = append(cols.scalars, name)
+			}
+		}
+	}
+	sort.Strings(cols.scalars)
+	return cols
+}
+
+func (cols *csvColumns) header() []string {
+	hdr := []string{"timestamp"}
+	hdr = append(hdr, cols.scalars...)
+	for _, hm := range cols.heatmaps {
+		for _, sfx := range []string{"count", "sum", "p50", "p99"} {
+			hdr = append(hdr, hm.metric+":"+sfx)
+		}
+	}
+	return hdr
+}
+
+func (cols *csvColumns) row(st *stats) []string {
+	row := []string{st.Time.Format(time.RFC3339Nano)}
+	for _, name := range cols.scalars {
+		v, ok := st.Metrics[name]
+		if !ok {
+			v = math.NaN()
+		}
+		row = append(row, csvFloat(v))
+	}
+	for i, hm := range cols.heatmaps {
+		count, sum, p50, p99 := hm.summary(st.RuntimePlots[cols.plots[i]])
+		row = append(row, csvFloat(count), csvFloat(sum), csvFloat(p50), csvFloat(p99))
+	}
+	return row
+}
+
+// csvFloat formats v for CSV, NaN and infinite values give an empty cell.
+func csvFloat(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// summary estimates the number of samples, their sum and the 50th and 99th
+// percentiles of the histogram from its merged buckets counts. A sample is
+// considered to be at the middle of its bucket, or at its finite boundary for
+// the first and last buckets if they're unbounded. All values are NaN if
+// counts doesn't match the heatmap buckets.
+func (hm *runtimeHeatmap) summary(counts plotValues) (count, sum, p50, p99 float64) {
+	if len(counts) == 0 || len(counts)+1 != len(hm.bounds) {
+		nan := math.NaN()
+		return nan, nan, nan, nan
+	}
+
+	for i, c := range counts {
+		if math.IsNaN(c) {
+			nan := math.NaN()
+			return nan, nan, nan, nan
+		}
+		if c != 0 {
+			sum += c * hm.value(i)
+		}
+		count += c
+	}
+	return count, sum, hm.quantile(counts, count, 0.5), hm.quantile(counts, count, 0.99)
+}
+
+// value returns the value representing the samples of bucket i.
+func (hm *runtimeHeatmap) value(i int) float64 {
+	lo, hi := hm.bounds[i], hm.bounds[i+1]
+	switch {
+	case math.IsInf(lo, -1):
+		return hi
+	case math.IsInf(hi, 1):
+		return lo
+	}
+	return (lo + hi) / 2
+}
+
+// quantile returns the upper bound of the bucket holding the q-quantile, or
+// its lower bound if the bucket is unbounded. It returns NaN if there are no
+// samples.
+func (hm *runtimeHeatmap) quantile(counts plotValues, total, q float64) float64 {
+	if total == 0 {
+		return math.NaN()
+	}
+	cum := 0.0
+	for i, c := range counts {
+		cum += c
+		if cum >= q*total {
+			if hi := hm.bounds[i+1]; !math.IsInf(hi, 1) {
+				return hi
+			}
+			return hm.bounds[i]
+		}
+	}
+	return hm.bounds[len(counts)]
+}
@@ -0,0 +1,118 @@
+package statsviz
+
+import (
+	"encoding/csv"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func getCSV(t *testing.T, srv *Server, query string) [][]string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	srv.CSVHandler().ServeHTTP(w, httptest.NewRequest("GET", "/history.csv"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("got Content-Type %q, want text/csv", ct)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestCSVHandler(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(time.Hour), WithHistorySize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// Fill the history with samples one minute apart.
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	smp := srv.newSampler()
+	for i := 0; i < 5; i++ {
+		st := newStats()
+		srv.collect(smp, &st)
+		st.Time = t0.Add(time.Duration(i) * time.Minute)
+		srv.history.push(st)
+	}
+
+	st := newStats()
+	srv.collect(srv.newSampler(), &st)
+	want := []string{"timestamp"}
+	for name := range st.Metrics {
+		want = append(want, name)
+	}
+	sort.Strings(want[1:])
+	for i := range srv.runtimePlots {
+		if hm := srv.runtimePlots[i].heatmap; hm != nil {
+			want = append(want, hm.metric+":count", hm.metric+":sum", hm.metric+":p50", hm.metric+":p99")
+		}
+	}
+
+	records := getCSV(t, srv, "")
+	if len(records) == 0 {
+		t.Fatal("got no header row")
+	}
+	if !reflect.DeepEqual(records[0], want) {
+		t.Errorf("got header %q, want %q", records[0], want)
+	}
+	if got := len(records) - 1; got != 5 {
+		t.Errorf("got %d rows, want 5", got)
+	}
+
+	records = getCSV(t, srv, "?since="+t0.Add(3*time.Minute).Format(time.RFC3339))
+	if got := len(records) - 1; got != 2 {
+		t.Fatalf("got %d rows since the 4th sample, want 2", got)
+	}
+	if got, want := records[1][0], t0.Add(3*time.Minute).Format(time.RFC3339Nano); got != want {
+		t.Errorf("got first row time %q, want %q", got, want)
+	}
+}
+
+func TestCSVHandlerBadSince(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	w := httptest.NewRecorder()
+	srv.CSVHandler().ServeHTTP(w, httptest.NewRequest("GET", "/history.csv?since=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHeatmapSummary(t *testing.T) {
+	t.Parallel()
+
+	hm := &runtimeHeatmap{bounds: []float64{0, 1, 2, math.Inf(1)}}
+
+	count, sum, p50, p99 := hm.summary(plotValues{2, 1, 1})
+	if count != 4 || sum != 2*0.5+1*1.5+1*2 || p50 != 1 || p99 != 2 {
+		t.Errorf("got count=%v sum=%v p50=%v p99=%v, want 4, 4.5, 1, 2", count, sum, p50, p99)
+	}
+
+	count, _, p50, _ = hm.summary(plotValues{0, 0, 0})
+	if count != 0 || !math.IsNaN(p50) {
+		t.Errorf("got count=%v p50=%v for an empty histogram, want 0 and NaN", count, p50)
+	}
+
+	if count, _, _, _ = hm.summary(nil); !math.IsNaN(count) {
+		t.Errorf("got count=%v for missing counts, want NaN", count)
+	}
+}
@@ -0,0 +1,180 @@
+package statsviz
+
+import (
+	"math"
+	"strconv"
+)
+
+// WithDecimation limits to maxPoints the number of historical samples sent to
+// newly connected clients, which is useful with a large history, see
+// WithHistorySize. Samples are selected with the Largest Triangle Three
+// Buckets algorithm, which preserves the shape of the plotted series, and the
+// oldest and newest samples are always sent. Stats collected after the client
+// connected are not decimated.
+//
+// maxPoints must be at least 3. By default, the whole history is sent.
+func WithDecimation(maxPoints int) OptionFunc {
+	return func(s *Server) error {
+		if maxPoints < 3 {
+			return configError("WithDecimation", "decimation must keep at least 3 points")
+		}
+		s.maxPoints = maxPoints
+		return nil
+	}
+}
+
+// decimate returns at most n of the stats in all, selected by lttb over all
+// their scalar series.
+func decimate(all []stats, n int) []stats {
+	if len(all) <= n {
+		return all
+	}
+	xs := make([]float64, len(all))
+	for i := range all {
+		xs[i] = all[i].Time.Sub(all[0].Time).Seconds()
+	}
+	idx := lttb(xs, historySeries(all), n)
+	kept := make([]stats, len(idx))
+	for i, j := range idx {
+		kept[i] = all[j]
+	}
+	return kept
+}
+
+// historySeries returns the scalar series of the values plotted from all
+// stats, one slice per series, with NaN where stats don't have the value.
+func historySeries(all []stats) [][]float64 {
+	var ys [][]float64
+	idx := make(map[string]int)
+	add := func(key string, i int, v float64) {
+		k, ok := idx[key]
+		if !ok {
+			k = len(ys)
+			idx[key] = k
+			y := make([]float64, len(all))
+			for j := range y {
+				y[j] = math.NaN()
+			}
+			ys = append(ys, y)
+		}
+		ys[k][i] = v
+	}
+	addPlots := func(prefix string, i int, plots map[string]plotValues) {
+		for name, vals := range plots {
+			for j, v := range vals {
+				add(prefix+name+":"+strconv.Itoa(j), i, v)
+			}
+		}
+	}
+
+	for i := range all {
+		st := &all[i]
+		add("goroutines", i, float64(st.NumGoroutine))
+		if m := st.Mem; m != nil {
+			add("mem:heap-alloc", i, float64(m.HeapAlloc))
+			add("mem:heap-sys", i, float64(m.HeapSys))
+			add("mem:heap-idle", i, float64(m.HeapIdle))
+			add("mem:heap-inuse", i, float64(m.HeapInuse))
+			add("mem:next-gc", i, float64(m.NextGC))
+			add("mem:objects", i, float64(m.Mallocs-m.Frees))
+			add("mem:gc-cpu-fraction", i, m.GCCPUFraction)
+		}
+		for name, v := range st.Metrics {
+			add(name, i, v)
+		}
+		for name, v := range st.UserMetrics {
+			add("user:"+name, i, v)
+		}
+		addPlots("plot:", i, st.UserPlots)
+		addPlots("runtime:", i, st.RuntimePlots)
+	}
+	return ys
+}
+
+// lttb implements the Largest Triangle Three Buckets downsampling algorithm,
+// generalized to multiple series sharing the same x values. It returns the
+// indices, in increasing order, of the n selected points, which include the
+// first and last ones.
+//
+// Points, apart from the first and last, are split into n-2 buckets. From each
+// bucket, the point forming the largest triangle with the previously selected
+// point and the average of the next bucket is selected. With multiple series,
+// the areas of the triangles of all series are summed, once series are
+// normalized by their range, so that they all have the same weight. NaN values
+// are ignored.
+func lttb(xs []float64, ys [][]float64, n int) []int {
+	if n >= len(xs) || n < 3 {
+		idx := make([]int, len(xs))
+		for i := range idx {
+			idx[i] = i
+		}
+		return idx
+	}
+
+	// Normalization factors, 0 for constant series which are ignored.
+	norms := make([]float64, len(ys))
+	for k, y := range ys {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, v := range y {
+			if !math.IsNaN(v) {
+				lo, hi = math.Min(lo, v), math.Max(hi, v)
+			}
+		}
+		if hi > lo && !math.IsInf(hi-lo, 0) {
+			norms[k] = 1 / (hi - lo)
+		}
+	}
+
+	every := float64(len(xs)-2) / float64(n-2)
+	idx := make([]int, 0, n)
+	idx = append(idx, 0)
+	avg := make([]float64, len(ys))
+	a := 0
+	for i := 0; i < n-2; i++ {
+		// Average point of the next bucket.
+		start := int(float64(i+1)*every) + 1
+		end := int(float64(i+2)*every) + 1
+		if end > len(xs) {
+			end = len(xs)
+		}
+		var avgX float64
+		for j := start; j < end; j++ {
+			avgX += xs[j]
+		}
+		avgX /= float64(end - start)
+		for k, y := range ys {
+			sum, cnt := 0.0, 0
+			for j := start; j < end; j++ {
+				if !math.IsNaN(y[j]) {
+					sum += y[j]
+					cnt++
+				}
+			}
+			avg[k] = math.NaN()
+			if cnt > 0 {
+				avg[k] = sum / float64(cnt)
+			}
+		}
+
+		// Point of the current bucket forming the largest triangle.
+		best, maxArea := -1, -1.0
+		for j := int(float64(i)*every) + 1; j < start; j++ {
+			area := 0.0
+			for k, y := range ys {
+				if norms[k] == 0 {
+					continue
+				}
+				tri := math.Abs((xs[a]-avgX)*(y[j]-y[a]) - (xs[a]-xs[j])*(avg[k]-y[a]))
+				if !math.IsNaN(tri) {
+					area += tri * norms[k]
+				}
+			}
+			if area > maxArea {
+				best, maxArea = j, area
+			}
+		}
+		idx = append(idx, best)
+		a = best
+	}
+	return append(idx, len(xs)-1)
+}
@@ -0,0 +1,116 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+// sawtooth returns n points of a sawtooth of the given period, ranging from 0
+// to period-1.
+func sawtooth(n, period int) []float64 {
+	y := make([]float64, n)
+	for i := range y {
+		y[i] = float64(i % period)
+	}
+	return y
+}
+
+func TestLTTB(t *testing.T) {
+	t.Parallel()
+
+	const n, period, maxPoints = 1000, 100, 50
+	xs := make([]float64, n)
+	for i := range xs {
+		xs[i] = float64(i)
+	}
+	y := sawtooth(n, period)
+	// A second series with NaN values, which are ignored.
+	nans := make([]float64, n)
+	for i := range nans {
+		nans[i] = math.NaN()
+	}
+
+	idx := lttb(xs, [][]float64{y, nans}, maxPoints)
+	if len(idx) != maxPoints {
+		t.Fatalf("got %d points, want %d", len(idx), maxPoints)
+	}
+	if idx[0] != 0 || idx[len(idx)-1] != n-1 {
+		t.Errorf("got first and last indices %d and %d, want 0 and %d", idx[0], idx[len(idx)-1], n-1)
+	}
+	for i := 1; i < len(idx); i++ {
+		if idx[i] <= idx[i-1] {
+			t.Fatalf("indices not increasing: %v", idx)
+		}
+	}
+
+	// The shape is preserved: each tooth keeps a point close to its peak.
+	for tooth := 0; tooth < n/period; tooth++ {
+		top := 0.0
+		for _, i := range idx {
+			if i/period == tooth {
+				top = math.Max(top, y[i])
+			}
+		}
+		if top < 0.8*period {
+			t.Errorf("tooth %d: got a highest point of %v, want at least %v", tooth, top, 0.8*period)
+		}
+	}
+
+	// Nothing is dropped if there are less points than requested.
+	if idx := lttb(xs[:10], [][]float64{y[:10]}, maxPoints); len(idx) != 10 {
+		t.Errorf("got %d points, want 10", len(idx))
+	}
+}
+
+func TestDecimatedHistory(t *testing.T) {
+	t.Parallel()
+
+	const maxPoints = 20
+	srv, err := NewServer(WithDecimation(maxPoints), WithPlots(PlotGoroutines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// Without WithHistorySize, collected stats aren't recorded, only the
+	// synthetic ones are.
+	srv.history = newHistory(100)
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range sawtooth(100, 10) {
+		srv.history.push(stats{Time: t0.Add(time.Duration(i) * time.Second), NumGoroutine: int(v)})
+	}
+
+	done := make(chan struct{})
+	close(done)
+	var got []stats
+	err = srv.sendStats(done, srv.freq, 1, nil, nil, func(msg []byte, _ bool) error {
+		var st stats
+		if err := json.Unmarshal(msg, &st); err != nil {
+			return err
+		}
+		if st.Historical {
+			got = append(got, st)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != maxPoints {
+		t.Fatalf("got %d historical stats, want %d", len(got), maxPoints)
+	}
+	if !got[0].Time.Equal(t0) || !got[maxPoints-1].Time.Equal(t0.Add(99*time.Second)) {
+		t.Errorf("got first and last stats at %v and %v, want the oldest and newest ones", got[0].Time, got[maxPoints-1].Time)
+	}
+}
+
+func TestWithDecimationInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithDecimation(2)); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+}
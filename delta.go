@@ -0,0 +1,57 @@
+package statsviz
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// WithDeltaFrames enables delta frames: after a first full frame, the stats
+// sent to clients only hold the runtime metrics which value changed since the
+// previous frame, the user interface carries forward the others. Plots and
+// histograms are always sent in full. It's not compatible with
+// WithCompactMetrics, which sends all metric values by position.
+//
+// A full frame is sent again whenever a client connects, or misses frames,
+// since a slow client can miss changes.
+func WithDeltaFrames(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.delta = enable
+		return nil
+	}
+}
+
+// A scalarDelta tracks the values of scalar metrics from one frame to the
+// next, to only send the changed ones.
+type scalarDelta struct {
+	prev    map[string]float64 // values of the previous frame
+	changed map[string]float64 // reused by diff
+}
+
+// diff returns the metrics of cur which value changed since the previous call,
+// or all of them if full is set. The returned map is reused by the next call.
+func (d *scalarDelta) diff(cur map[string]float64, full bool) map[string]float64 {
+	if d.prev == nil {
+		d.prev = make(map[string]float64, len(cur))
+		d.changed = make(map[string]float64, len(cur))
+	}
+	for name := range d.changed {
+		delete(d.changed, name)
+	}
+	for name, v := range cur {
+		if prev, ok := d.prev[name]; full || !ok || !sameValue(prev, v) {
+			d.changed[name] = v
+		}
+		d.prev[name] = v
+	}
+	return d.changed
+}
+
+func sameValue(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+// sendFull arranges for the next frame of the hub to be a full one, with
+// delta frames.
+func (h *hub) sendFull() {
+	atomic.StoreInt32(&h.full, 1)
+}
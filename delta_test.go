@@ -0,0 +1,79 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var deltaSink [][]byte
+
+func TestWithDeltaFrames(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithDeltaFrames(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	if !srv.handshake().DeltaFrames {
+		t.Errorf("got handshake without delta frames, want delta frames")
+	}
+
+	h := &hub{s: srv, freq: time.Second, stats: newStats()}
+	h.enc = json.NewEncoder(&h.buf)
+	smp := srv.newSampler()
+	metrics := func() map[string]float64 {
+		t.Helper()
+		h.tick(smp)
+		var st struct{ Metrics map[string]float64 }
+		if err := json.Unmarshal(h.out, &st); err != nil {
+			t.Fatal(err)
+		}
+		return st.Metrics
+	}
+
+	// Ticks are on time, the overrun stays at 0.
+	const (
+		unchanged = samplerOverrunMetric
+		changed   = "/gc/heap/allocs:bytes"
+	)
+	first := metrics()
+	for _, name := range []string{unchanged, changed} {
+		if _, ok := first[name]; !ok {
+			t.Fatalf("first frame has no %s, want a full frame", name)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		deltaSink = append(deltaSink, make([]byte, 1024))
+	}
+	second := metrics()
+	if v, ok := second[unchanged]; ok {
+		t.Errorf("second frame has %s = %v, want it omitted", unchanged, v)
+	}
+	if second[changed] <= first[changed] {
+		t.Errorf("got %s = %v then %v, want an increase", changed, first[changed], second[changed])
+	}
+	if len(second) >= len(first) {
+		t.Errorf("got %d metrics then %d, want fewer metrics in the delta frame", len(first), len(second))
+	}
+
+	// Hub subscribers still get all metrics.
+	if _, ok := h.stats.Metrics[unchanged]; !ok {
+		t.Errorf("hub stats have no %s, want all metrics", unchanged)
+	}
+
+	h.sendFull()
+	if third := metrics(); len(third) != len(first) {
+		t.Errorf("got %d metrics after requesting a full frame, want %d", len(third), len(first))
+	}
+}
+
+func TestDeltaFramesCompact(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithDeltaFrames(true), WithCompactMetrics(true)); err == nil {
+		t.Errorf("got nil error for delta frames with compact metrics, want non-nil")
+	}
+}
@@ -0,0 +1,57 @@
+package statsviz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrServerStopped is returned by the methods of a stopped Server, such as
+// Collect and StreamFrames.
+var ErrServerStopped = errors.New("statsviz: server stopped")
+
+// ErrInvalidFrequency is returned, wrapped, by StreamFrames for a frequency
+// out of range.
+var ErrInvalidFrequency = errors.New("statsviz: invalid frequency")
+
+// A ConfigError reports an invalid configuration, for example an invalid
+// option value, conflicting options or, for Register, statsviz handlers
+// already registered on the mux. It's returned by all functions configuring a
+// Server, such as NewServer and Register.
+type ConfigError struct {
+	// Option is the name of the offending option, such as "SendFrequency",
+	// or of the first of conflicting options. It's "Root" if the handlers
+	// are already registered, and "option" for the options of other
+	// packages which don't return a ConfigError.
+	Option string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("statsviz: %s: %v", e.Option, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// configError returns a ConfigError of option, which error is formatted
+// as with fmt.Errorf.
+func configError(option, format string, args ...interface{}) *ConfigError {
+	return &ConfigError{Option: option, Err: fmt.Errorf(format, args...)}
+}
+
+// checkRegistered returns a ConfigError if one of the handlers of s is
+// already registered on mux, which would make mux panic.
+func (s *Server) checkRegistered(mux *http.ServeMux) error {
+	for _, e := range s.endpoints() {
+		r, err := http.NewRequest("GET", e.pattern, nil)
+		if err != nil {
+			continue
+		}
+		if _, registered := mux.Handler(r); registered == e.pattern {
+			return configError("Root", "a handler is already registered for %s", e.pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package statsviz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestConfigError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		opts   []OptionFunc
+		option string
+	}{
+		{"invalid frequency", []OptionFunc{SendFrequency(0)}, "SendFrequency"},
+		{"invalid root", []OptionFunc{Root("/debug statsviz")}, "Root"},
+		{"nil origin check", []OptionFunc{WithCheckOrigin(nil)}, "WithCheckOrigin"},
+		{"nil option", []OptionFunc{Root("/stats"), nil}, "option"},
+		{"binary encoding over SSE", []OptionFunc{WithEncoding(EncodingProtobuf), Transport(TransportSSE)}, "WithEncoding"},
+		{"delta and compact", []OptionFunc{WithDeltaFrames(true), WithCompactMetrics(true)}, "WithDeltaFrames"},
+		{"varint deltas without protobuf", []OptionFunc{WithVarintDeltas(10)}, "WithVarintDeltas"},
+		{"history size and bytes", []OptionFunc{WithHistorySize(10), WithHistoryBytes(1 << 20)}, "WithHistoryBytes"},
+		{"websocket path", []OptionFunc{WithWebSocketPath("/debug/statsviz/handshake")}, "WithWebSocketPath"},
+		{"unnamed metric func", []OptionFunc{WithMetricFunc("", "", func() float64 { return 0 })}, "WithMetricFunc"},
+		{"duplicate plot", []OptionFunc{WithPlot(TimeSeriesPlot{Name: "p", Series: []TimeSeries{{Name: "s", Value: func() float64 { return 0 }}}}), WithMetricFunc("p", "", func() float64 { return 0 })}, "WithMetricFunc"},
+		{"other package option", []OptionFunc{func(*Server) error { return errors.New("invalid") }}, "option"},
+	}
+	for _, tt := range tests {
+		srv, err := NewServer(tt.opts...)
+		if err == nil {
+			srv.Stop()
+			t.Errorf("%s: got nil error, want non-nil", tt.name)
+			continue
+		}
+		var cerr *ConfigError
+		if !errors.As(err, &cerr) {
+			t.Errorf("%s: got error %T %v, want *ConfigError", tt.name, err, err)
+			continue
+		}
+		if cerr.Option != tt.option || cerr.Err == nil {
+			t.Errorf("%s: got option %q and error %v, want %q", tt.name, cerr.Option, cerr.Err, tt.option)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mux := http.NewServeMux()
+	if err := RegisterContext(ctx, mux); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range [][]OptionFunc{nil, {Root("debug/statsviz/")}} {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("Register panicked: %v", r)
+				}
+			}()
+			err := RegisterContext(ctx, mux, opts...)
+			var cerr *ConfigError
+			if !errors.As(err, &cerr) || cerr.Option != "Root" {
+				t.Errorf("got error %v, want a *ConfigError of Root", err)
+			}
+		}()
+	}
+
+	// Another root can be registered on the same mux.
+	if err := RegisterContext(ctx, mux, Root("/other")); err != nil {
+		t.Errorf("got error %v registering another root, want nil", err)
+	}
+}
+
+func TestRegisterConflict(t *testing.T) {
+	t.Parallel()
+
+	paths := []string{
+		"/debug/statsviz/",
+		"/debug/statsviz/handshake",
+		"/debug/statsviz/ws",
+		"/debug/statsviz/history.csv",
+		"/debug/statsviz/stream.ndjson",
+		"/debug/statsviz/plots.json",
+		"/debug/statsviz/grafana/",
+		"/debug/statsviz/goroutines.txt",
+		"/debug/statsviz/metrics.json",
+		"/debug/statsviz/pprof/",
+	}
+	for _, path := range paths {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s: Register panicked: %v", path, r)
+				}
+			}()
+			mux := http.NewServeMux()
+			mux.Handle(path, http.NotFoundHandler())
+			err := Register(mux, WithPprof(true))
+			var cerr *ConfigError
+			if !errors.As(err, &cerr) || cerr.Option != "Root" {
+				t.Errorf("%s: got error %v, want a *ConfigError of Root", path, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,93 @@
+package statsviz
+
+import (
+	"expvar"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// expvarName is the name under which PublishExpvar publishes stats.
+const expvarName = "statsviz"
+
+// PublishExpvar publishes the scalar runtime metrics last collected by the
+// server as the "statsviz" expvar variable, one entry per metric, keyed by
+// runtime/metrics name, so that they appear in /debug/vars. With
+// WithMetricPrefix, entries are rather keyed by MetricName, with '_' as
+// separator. Values that can't be represented in JSON, such as NaN, are
+// omitted.
+//
+// The published values are the ones collected at the server send frequency,
+// also sent to clients, so publishing them doesn't read runtime metrics more
+// often. They're updated until the server is stopped.
+//
+// Like expvar.Publish, PublishExpvar panics if the "statsviz" variable is
+// already published, so it must be called once per process.
+func (s *Server) PublishExpvar() {
+	// Start tracking the latest stats right away.
+	s.latestStats()
+
+	expvar.Publish(expvarName, expvar.Func(func() interface{} {
+		vals := make(map[string]float64)
+		st := s.latestStats()
+		if st == nil {
+			return vals
+		}
+		for name, v := range st.Metrics {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			if s.metricPrefix != "" {
+				name = MetricName(s.metricPrefix, name, '_')
+			}
+			vals[name] = v
+		}
+		return vals
+	}))
+}
+
+// WithExpvar samples the numeric expvar variables with the given names at
+// each tick, and shows them in the 'User metrics' plot, alongside counters
+// and gauges, under their own name. This brings the counters already
+// published with expvar in the dashboard, without instrumenting them again.
+//
+// Variables are read through their JSON representation, so expvar.Int,
+// expvar.Float and expvar.Func returning a number are supported. Other
+// variables, such as expvar.String or expvar.Map, are skipped, with a warning
+// on the first sample. Variables not published yet are skipped until they
+// are.
+func WithExpvar(names ...string) OptionFunc {
+	return func(s *Server) error {
+		for _, name := range names {
+			if name == "" {
+				return configError("WithExpvar", "empty expvar name")
+			}
+		}
+		s.expvars = append(s.expvars, names...)
+		return nil
+	}
+}
+
+// readExpvars stores the current values of the expvars of WithExpvar into
+// vals, which is allocated if nil, and returns it.
+func (s *Server) readExpvars(vals map[string]float64) map[string]float64 {
+	if vals == nil {
+		vals = make(map[string]float64, len(s.expvars))
+	}
+	for _, name := range s.expvars {
+		v := expvar.Get(name)
+		if v == nil {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.String()), 64)
+		if err != nil {
+			delete(vals, name)
+			if _, warned := s.expvarSkipped.LoadOrStore(name, true); !warned {
+				s.logger.Warn("statsviz: skipping non-numeric expvar", "name", name)
+			}
+			continue
+		}
+		vals[name] = f
+	}
+	return vals
+}
@@ -0,0 +1,95 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Now())
+	srv, err := NewServer(withClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	srv.PublishExpvar()
+
+	v := expvar.Get(expvarName)
+	if v == nil {
+		t.Fatalf("expvar %q is not published", expvarName)
+	}
+	read := func() map[string]float64 {
+		var vals map[string]float64
+		if err := json.Unmarshal([]byte(v.String()), &vals); err != nil {
+			t.Fatalf("invalid expvar value %s: %v", v.String(), err)
+		}
+		return vals
+	}
+	if vals := read(); len(vals) != 0 {
+		t.Errorf("got %v before any sample, want no values", vals)
+	}
+
+	// Trigger a sample.
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+	clk.advance(srv.freq)
+
+	const metric = "/sched/goroutines:goroutines"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		vals := read()
+		if len(vals) != 0 {
+			if !(vals[metric] > 0) {
+				t.Errorf("got %s = %v, want a positive value", metric, vals[metric])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the expvar to be updated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWithExpvar(t *testing.T) {
+	t.Parallel()
+
+	requests := expvar.NewInt("test_expvar_requests")
+	expvar.NewString("test_expvar_version").Set("v1")
+
+	srv, err := NewServer(WithExpvar("test_expvar_requests", "test_expvar_version", "test_expvar_unpublished"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	smp := srv.newSampler()
+	st := newStats()
+	for i := int64(1); i <= 3; i++ {
+		requests.Add(1)
+		srv.collect(smp, &st)
+		if got := st.UserMetrics["test_expvar_requests"]; got != float64(i) {
+			t.Errorf("frame %d: got %v requests, want %d", i, got, i)
+		}
+		for _, name := range []string{"test_expvar_version", "test_expvar_unpublished"} {
+			if v, ok := st.UserMetrics[name]; ok {
+				t.Errorf("frame %d: got %s = %v, want it skipped", i, name, v)
+			}
+		}
+	}
+	if _, warned := srv.expvarSkipped.Load("test_expvar_version"); !warned {
+		t.Errorf("non-numeric expvar hasn't been warned about")
+	}
+
+	if _, err := NewServer(WithExpvar("")); err == nil {
+		t.Errorf("got nil error for an empty expvar name, want non-nil")
+	}
+}
@@ -0,0 +1,32 @@
+package statsviz
+
+// fdPlot is the name of the plot added by WithFDPlot.
+const fdPlot = "file-descriptors"
+
+// WithFDPlot adds a plot showing the number of open file descriptors of the
+// process against their limit, which helps spotting descriptor leaks. It's
+// only supported on Linux, where descriptors are counted by listing
+// /proc/self/fd at each tick, which is why it's disabled by default. On other
+// platforms, the plot is not shown.
+func WithFDPlot(enable bool) OptionFunc {
+	return func(s *Server) error {
+		for i := range s.runtimePlots {
+			if s.runtimePlots[i].name == fdPlot {
+				s.runtimePlots = append(s.runtimePlots[:i:i], s.runtimePlots[i+1:]...)
+				break
+			}
+		}
+		if !enable || !fdSupported {
+			return nil
+		}
+		s.runtimePlots = append(s.runtimePlots, runtimePlot{
+			name:  fdPlot,
+			title: "File descriptors",
+			series: []runtimeSeries{
+				{name: "open", read: openFDs},
+				{name: "limit", read: fdLimit},
+			},
+		})
+		return nil
+	}
+}
@@ -0,0 +1,36 @@
+package statsviz
+
+import (
+	"math"
+	"os"
+	"syscall"
+)
+
+const fdSupported = true
+
+// openFDs returns the number of file descriptors open by the process, or NaN
+// if they can't be counted.
+func openFDs() float64 {
+	f, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return math.NaN()
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return math.NaN()
+	}
+	// Don't count the descriptor used to list the others.
+	return float64(len(names) - 1)
+}
+
+// fdLimit returns the soft limit of the number of file descriptors the
+// process can open, or NaN if it can't be read or there's no limit.
+func fdLimit() float64 {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil || lim.Cur == math.MaxUint64 {
+		return math.NaN()
+	}
+	return float64(lim.Cur)
+}
@@ -0,0 +1,63 @@
+package statsviz
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenFDs(t *testing.T) {
+	before := openFDs()
+
+	const n = 10
+	dir := t.TempDir()
+	for i := 0; i < n; i++ {
+		f, err := os.Create(filepath.Join(dir, "f"+string(rune('0'+i))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+	}
+
+	if after := openFDs(); after < before+n {
+		t.Errorf("got %v open file descriptors after opening %d files, want at least %v", after, n, before+n)
+	}
+	if lim := fdLimit(); !(lim >= before+n) {
+		t.Errorf("got file descriptors limit %v, want at least %v", lim, before+n)
+	}
+}
+
+func TestWithFDPlot(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithFDPlot(true), WithFDPlot(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	count := 0
+	for i := range srv.runtimePlots {
+		if p := &srv.runtimePlots[i]; p.name == fdPlot {
+			count++
+			vals := p.sample(srv.newSampler(), nil)
+			if len(vals) != 2 || !(vals[0] > 0) {
+				t.Errorf("got values %v, want open descriptors and limit", vals)
+			}
+		}
+	}
+	if count != 1 {
+		t.Errorf("got %d file descriptors plots, want 1", count)
+	}
+
+	srv, err = NewServer(WithFDPlot(true), WithFDPlot(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	for _, p := range srv.runtimePlots {
+		if p.name == fdPlot {
+			t.Errorf("got a file descriptors plot after WithFDPlot(false), want none")
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package statsviz
+
+import "math"
+
+// File descriptors are only counted on Linux.
+const fdSupported = false
+
+func openFDs() float64 { return math.NaN() }
+func fdLimit() float64 { return math.NaN() }
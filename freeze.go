@@ -0,0 +1,40 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// WithFreezeOnThreshold, if enabled, freezes the plots of the connected user
+// interfaces when a metric crosses a threshold set with WithThreshold, so that
+// the state around the event can be inspected rather than scrolling away. It's
+// disabled by default.
+//
+// Frozen plots keep receiving stats, and the server keeps recording them in
+// history, so nothing is lost: the user resumes the live view from the user
+// interface.
+func WithFreezeOnThreshold(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.freeze = enable
+		return nil
+	}
+}
+
+// freezeClients tells the clients of all hubs to freeze their plots since the
+// named metric crossed its threshold with value v. Like other control
+// messages, the freeze message replaces the frames pending for each client,
+// so that it's neither dropped nor a reason to disconnect slow clients. Must
+// be called with s.hubsMu held.
+func (s *Server) freezeClients(t *threshold, v float64) {
+	msg, err := json.Marshal(controlMsg{
+		Type:   "freeze",
+		Reason: fmt.Sprintf("%s is %v, above %v", t.metric, v, t.above),
+	})
+	if err != nil {
+		s.logger.Error("statsviz: can't encode freeze message", "error", err)
+		return
+	}
+	for _, h := range s.hubs {
+		h.sendControl(msg)
+	}
+}
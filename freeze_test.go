@@ -0,0 +1,208 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitThresholdTicker waits for the hub ticker to be created, and for the
+// thresholds watcher to subscribe, along with the test subscriber.
+func waitThresholdTicker(t *testing.T, srv *Server, clk *fakeClock) {
+	t.Helper()
+
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		srv.hubsMu.Lock()
+		n := len(srv.hubs[srv.freq].subs)
+		srv.hubsMu.Unlock()
+		if n == 2 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the thresholds watcher")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFreezeOnThreshold(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, err := NewServer(
+		withClock(clk),
+		WithFreezeOnThreshold(true),
+		WithThreshold("/sched/goroutines:goroutines", 0, func(string, float64) {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	frames, unsubscribe := srv.subscribe(srv.freq)
+	defer unsubscribe()
+
+	waitThresholdTicker(t, srv, clk)
+	clk.advance(time.Second)
+
+	// The freeze message is sent along with the stats which crossed the
+	// threshold, in no particular order.
+	var stats, freezes int
+	for i := 0; i < 2; i++ {
+		select {
+		case f := <-frames:
+			var msg controlMsg
+			if err := json.Unmarshal(f.bytes(), &msg); err != nil {
+				t.Fatal(err)
+			}
+			f.release()
+			switch msg.Type {
+			case "":
+				stats++
+			case "freeze":
+				freezes++
+				if !strings.Contains(msg.Reason, "/sched/goroutines:goroutines") {
+					t.Errorf("got freeze reason %q, want it to name the metric", msg.Reason)
+				}
+			default:
+				t.Errorf("got control message %+v, want a freeze", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for a frame")
+		}
+	}
+	if stats != 1 || freezes != 1 {
+		t.Errorf("got %d stats and %d freeze messages, want 1 and 1", stats, freezes)
+	}
+
+	// The threshold isn't crossed again, no other freeze message is sent.
+	clk.advance(time.Second)
+	select {
+	case f := <-frames:
+		var msg controlMsg
+		if err := json.Unmarshal(f.bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		f.release()
+		if msg.Type != "" {
+			t.Errorf("got control message %+v, want stats", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a frame")
+	}
+}
+
+func TestNoFreezeByDefault(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, err := NewServer(
+		withClock(clk),
+		WithThreshold("/sched/goroutines:goroutines", 0, func(string, float64) {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	frames, unsubscribe := srv.subscribe(srv.freq)
+	defer unsubscribe()
+
+	waitThresholdTicker(t, srv, clk)
+	clk.advance(time.Second)
+
+	select {
+	case f := <-frames:
+		f.release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a frame")
+	}
+	select {
+	case f := <-frames:
+		t.Errorf("got unexpected frame %s", f.bytes())
+		f.release()
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFreezeSlowClient(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []SlowClientPolicy{DropNewest, DropOldest, Disconnect} {
+		clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+		crossed := make(chan struct{}, 1)
+		srv, err := NewServer(
+			withClock(clk),
+			WithClientBuffer(2),
+			WithSlowClientPolicy(policy),
+			WithFreezeOnThreshold(true),
+			WithThreshold("/sched/goroutines:goroutines", 0, func(string, float64) {
+				crossed <- struct{}{}
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Stop()
+
+		frames, unsubscribe := srv.subscribe(srv.freq)
+		defer unsubscribe()
+		waitThresholdTicker(t, srv, clk)
+
+		// Fill the client buffer with stale stats, leaving room for the
+		// stats crossing the threshold only.
+		srv.hubsMu.Lock()
+		for sub := range srv.hubs[srv.freq].subs {
+			if (<-chan *frame)(sub.ch) != frames {
+				continue
+			}
+			for len(sub.ch) < cap(sub.ch)-1 {
+				f := framePool.Get().(*frame)
+				f.buf = append(f.buf[:0], "{}"...)
+				f.binary = false
+				f.refs = 1
+				sub.ch <- f
+			}
+		}
+		srv.hubsMu.Unlock()
+
+		// The callback is called once the freeze message is sent.
+		clk.advance(time.Second)
+		select {
+		case <-crossed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the threshold")
+		}
+
+		var freezes int
+	read:
+		for {
+			select {
+			case f, ok := <-frames:
+				if !ok {
+					t.Fatalf("%s: client disconnected", policy)
+				}
+				var msg controlMsg
+				if err := json.Unmarshal(f.bytes(), &msg); err != nil {
+					t.Fatal(err)
+				}
+				f.release()
+				if msg.Type == "freeze" {
+					freezes++
+				}
+			case <-time.After(100 * time.Millisecond):
+				break read
+			}
+		}
+		if freezes != 1 {
+			t.Errorf("%s: got %d freeze messages, want 1", policy, freezes)
+		}
+	}
+}
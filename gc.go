@@ -0,0 +1,93 @@
+package statsviz
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+// WithForceGC allows users to run a garbage collection from the user
+// interface, which shows how much memory actually is live. It's disabled by
+// default since a forced garbage collection blocks the calling goroutine, and
+// uses CPU, while it runs.
+func WithForceGC(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.forceGC = enable
+		return nil
+	}
+}
+
+// runGC runs a garbage collection, if allowed, in response to a forceGC
+// control message. The reply carries the number of heap bytes freed.
+func (s *Server) runGC() controlMsg {
+	reply := controlMsg{Type: "forceGC"}
+	if !s.forceGC {
+		reply.Error = "forcing garbage collection is disabled"
+		return reply
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if before.HeapAlloc > after.HeapAlloc {
+		reply.Freed = before.HeapAlloc - after.HeapAlloc
+	}
+	return reply
+}
+
+// sinceLastGC returns the time elapsed between the last garbage collection
+// and now, in seconds, or 0 if there has been none yet.
+func (smp *sampler) sinceLastGC(now time.Time) float64 {
+	debug.ReadGCStats(&smp.gc)
+	if smp.gc.NumGC == 0 {
+		return 0
+	}
+	return now.Sub(smp.gc.LastGC).Seconds()
+}
+
+// gcCyclesMetric counts the completed garbage collection cycles, see gcEvent.
+const gcCyclesMetric = "/gc/cycles/total:gc-cycles"
+
+// A gcEvent reports the garbage collections completed over a sampling
+// interval, which the user interface draws as vertical lines on time plots.
+type gcEvent struct {
+	Count int       `json:"count"` // several collections can complete in an interval
+	Time  time.Time `json:"time"`  // of the last collection, possibly approximated
+
+	// Gap is how late, in seconds, the stats have been collected, if they
+	// were, which is then attributed to the collections stopping the world.
+	Gap float64 `json:"gap,omitempty"`
+}
+
+// gcCycles reads the number of completed garbage collection cycles, or NaN if
+// the metric isn't supported.
+func (smp *sampler) gcCycles() float64 {
+	smp.gcSample[0].Name = gcCyclesMetric
+	metrics.Read(smp.gcSample[:])
+	return scalar(smp.gcSample[0].Value)
+}
+
+// gcEvent returns the garbage collections completed since the previous call,
+// given the cumulative number of cycles, or nil if there were none, or if it's
+// the first call. The event time is the one of the last collection read by
+// sinceLastGC if it's within the interval, the middle of the interval
+// otherwise.
+func (smp *sampler) gcEvent(now time.Time, cycles float64) *gcEvent {
+	if math.IsNaN(cycles) {
+		return nil
+	}
+	prev, prevTime := smp.prevCycles, smp.prevTime
+	smp.prevCycles, smp.prevTime = cycles, now
+	if prevTime.IsZero() || cycles <= prev {
+		return nil
+	}
+
+	t := smp.gc.LastGC
+	if t.Before(prevTime) || t.After(now) {
+		t = prevTime.Add(now.Sub(prevTime) / 2)
+	}
+	return &gcEvent{Count: int(cycles - prev), Time: t}
+}
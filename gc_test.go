@@ -0,0 +1,87 @@
+package statsviz
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWithForceGC(t *testing.T) {
+	t.Parallel()
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	reply := requestControl(t, controlMsg{Type: "forceGC"}, WithForceGC(true))
+	if reply.Type != "forceGC" || reply.Error != "" {
+		t.Fatalf("got reply %+v, want a forceGC reply without error", reply)
+	}
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	if after.NumForcedGC <= before.NumForcedGC {
+		t.Errorf("got %d forced GC cycles, want more than %d", after.NumForcedGC, before.NumForcedGC)
+	}
+}
+
+func TestForceGCDisabled(t *testing.T) {
+	t.Parallel()
+
+	reply := requestControl(t, controlMsg{Type: "forceGC"})
+	if reply.Type != "forceGC" || reply.Error == "" {
+		t.Errorf("got reply %+v, want a forceGC reply with an error", reply)
+	}
+}
+
+func TestSinceLastGC(t *testing.T) {
+	t.Parallel()
+
+	runtime.GC()
+	smp := newSampler()
+	since := smp.sinceLastGC(time.Now())
+	if since <= 0 || since > 60 {
+		t.Errorf("got %vs since the last GC, want a positive duration, shorter than a minute", since)
+	}
+}
+
+func TestGCEvent(t *testing.T) {
+	t.Parallel()
+
+	smp := newSamplerOf([]string{})
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if ev := smp.gcEvent(t0, 10); ev != nil {
+		t.Errorf("first sample: got %+v, want no event", ev)
+	}
+	if ev := smp.gcEvent(t0.Add(time.Second), 10); ev != nil {
+		t.Errorf("no GC: got %+v, want no event", ev)
+	}
+
+	// 2 cycles over the tick, at an unknown time.
+	ev := smp.gcEvent(t0.Add(2*time.Second), 12)
+	if ev == nil || ev.Count != 2 || !ev.Time.Equal(t0.Add(1500*time.Millisecond)) {
+		t.Errorf("got %+v, want 2 GCs in the middle of the interval", ev)
+	}
+
+	// The time of the last GC is used if known.
+	smp.gc.LastGC = t0.Add(2200 * time.Millisecond)
+	ev = smp.gcEvent(t0.Add(3*time.Second), 13)
+	if ev == nil || ev.Count != 1 || !ev.Time.Equal(smp.gc.LastGC) {
+		t.Errorf("got %+v, want 1 GC at %v", ev, smp.gc.LastGC)
+	}
+
+	// Collected stats report actual collections.
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	st := newStats()
+	smp = srv.newSampler()
+	srv.collect(smp, &st)
+	before := st.Time
+	runtime.GC()
+	srv.collect(smp, &st)
+	if st.GC == nil || st.GC.Count < 1 || st.GC.Time.Before(before) || st.GC.Time.After(st.Time) {
+		t.Errorf("got GC event %+v, want at least 1 GC between %v and %v", st.GC, before, st.Time)
+	}
+}
@@ -2,4 +2,7 @@ module github.com/arl/statsviz
 
 go 1.16
 
-require github.com/gorilla/websocket v1.4.2
+require (
+	github.com/gorilla/websocket v1.4.2
+	github.com/prometheus/common v0.32.1
+)
@@ -0,0 +1,140 @@
+package statsviz
+
+import (
+	"bytes"
+	"math"
+	"runtime"
+	"time"
+)
+
+// goroutineStatesPlot is the name of the plot added by WithGoroutineBreakdown.
+const goroutineStatesPlot = "goroutine-states"
+
+// goroutineStates are the states goroutines are bucketed into. Goroutines in
+// the other states, such as "chan receive", "select" or "sleep", are
+// waiting.
+var goroutineStates = [...]string{"running", "runnable", "syscall", "waiting"}
+
+const waitingState = len(goroutineStates) - 1
+
+// WithGoroutineBreakdown adds a plot showing the number of goroutines running,
+// runnable, in a system call or waiting, as stacked areas. The breakdown is
+// computed every interval, which is independent of the send frequency, by
+// parsing a dump of all goroutine stacks. Since dumping stacks stops the world
+// and its cost grows with the number of goroutines, interval should be longer
+// than the send frequency, for example 5 seconds. Until the breakdown is first
+// computed, the plot is empty.
+func WithGoroutineBreakdown(interval time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return configError("WithGoroutineBreakdown", "goroutine breakdown interval must be positive")
+		}
+		if s.goroutines != nil {
+			return configError("WithGoroutineBreakdown", "goroutine breakdown already enabled")
+		}
+
+		gs := &goroutineBreakdown{interval: interval}
+		p := runtimePlot{
+			name:    goroutineStatesPlot,
+			title:   "Goroutines by state",
+			stacked: true,
+		}
+		for i, state := range goroutineStates {
+			count := newFloat64(math.NaN())
+			gs.counts[i] = count
+			p.series = append(p.series, runtimeSeries{name: state, read: count.Load})
+		}
+		s.goroutines = gs
+		s.runtimePlots = append(s.runtimePlots, p)
+		return nil
+	}
+}
+
+// A goroutineBreakdown periodically counts goroutines by state.
+type goroutineBreakdown struct {
+	interval time.Duration
+	counts   [len(goroutineStates)]*atomicFloat64 // last counts, read by the plot series
+	buf      []byte                               // reused stacks dump buffer
+}
+
+// run updates the breakdown every interval until done is closed.
+func (gs *goroutineBreakdown) run(clk clock, done <-chan struct{}) {
+	gs.update()
+	tick := clk.NewTicker(gs.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C():
+		}
+		gs.update()
+	}
+}
+
+// update dumps the goroutine stacks and stores the number of goroutines in
+// each state.
+func (gs *goroutineBreakdown) update() {
+	gs.buf = goroutineDump(gs.buf)
+	counts := countGoroutineStates(gs.buf)
+	for i, n := range counts {
+		gs.counts[i].Store(float64(n))
+	}
+}
+
+// goroutineDump returns the stacks of all goroutines, as formatted by
+// runtime.Stack, in buf which is grown until the whole dump fits.
+func goroutineDump(buf []byte) []byte {
+	if cap(buf) == 0 {
+		buf = make([]byte, 64<<10)
+	}
+	buf = buf[:cap(buf)]
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// countGoroutineStates counts goroutines by state in a goroutines dump, in
+// which each goroutine starts with a header such as:
+//
+//	goroutine 18 [chan receive, 2 minutes]:
+func countGoroutineStates(dump []byte) [len(goroutineStates)]int {
+	var counts [len(goroutineStates)]int
+	prefix := []byte("goroutine ")
+	for len(dump) > 0 {
+		line := dump
+		if i := bytes.IndexByte(dump, '\n'); i >= 0 {
+			line, dump = dump[:i], dump[i+1:]
+		} else {
+			dump = nil
+		}
+		if !bytes.HasPrefix(line, prefix) {
+			continue
+		}
+		start := bytes.IndexByte(line, '[')
+		if start < 0 {
+			continue
+		}
+		line = line[start+1:]
+		end := bytes.IndexAny(line, ",]")
+		if end < 0 {
+			continue
+		}
+		state := string(line[:end])
+
+		i := waitingState
+		for j, s := range goroutineStates[:waitingState] {
+			if state == s {
+				i = j
+				break
+			}
+		}
+		counts[i]++
+	}
+	return counts
+}
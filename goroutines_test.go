@@ -0,0 +1,126 @@
+package statsviz
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCountGoroutineStates(t *testing.T) {
+	t.Parallel()
+
+	dump := []byte(`goroutine 1 [running]:
+main.main()
+	/tmp/main.go:10 +0x1d
+
+goroutine 18 [chan receive, 2 minutes]:
+main.worker()
+	/tmp/main.go:20 +0x25
+
+goroutine 19 [runnable]:
+main.spin()
+
+goroutine 20 [syscall, locked to thread]:
+syscall.Syscall()
+
+goroutine 21 [select]:
+goroutine 22 [sleep]:
+goroutine 23 [runnable]:
+created by main.main`)
+
+	got := countGoroutineStates(dump)
+	want := [len(goroutineStates)]int{1, 2, 1, 3}
+	if got != want {
+		t.Errorf("got counts %v, want %v", got, want)
+	}
+}
+
+func TestGoroutineBreakdown(t *testing.T) {
+	t.Parallel()
+
+	// Block goroutines on a channel, they must be counted as waiting.
+	const n = 20
+	var wg sync.WaitGroup
+	block := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-block
+		}()
+	}
+	defer func() {
+		close(block)
+		wg.Wait()
+	}()
+
+	clk := newFakeClock(time.Now())
+	srv, err := NewServer(withClock(clk), WithGoroutineBreakdown(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// The breakdown is first computed before the ticker is created.
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the breakdown ticker")
+	}
+
+	var p *runtimePlot
+	for i := range srv.runtimePlots {
+		if srv.runtimePlots[i].name == goroutineStatesPlot {
+			p = &srv.runtimePlots[i]
+		}
+	}
+	if p == nil {
+		t.Fatalf("plot %q not found", goroutineStatesPlot)
+	}
+	if cfg := p.config(); !cfg.Stacked || len(cfg.Series) != len(goroutineStates) {
+		t.Errorf("got plot config %+v, want %d stacked series", cfg, len(goroutineStates))
+	}
+
+	// The blocking goroutines may not all be blocked yet, recompute the
+	// breakdown until they are.
+	smp := srv.newSampler()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		vals := p.sample(smp, nil)
+		for i, v := range vals {
+			if math.IsNaN(v) || v < 0 {
+				t.Fatalf("got %s goroutines = %v, want a positive count", goroutineStates[i], v)
+			}
+		}
+		// The sampling goroutine itself is running.
+		if vals[0] >= 1 && vals[waitingState] >= n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got breakdown %v, want at least 1 running and %d waiting goroutines", vals, n)
+		}
+		clk.advance(5 * time.Second)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWithGoroutineBreakdownInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithGoroutineBreakdown(0)); err == nil {
+		t.Errorf("got nil error for a zero interval, want non-nil")
+	}
+	if _, err := NewServer(WithGoroutineBreakdown(time.Second), WithGoroutineBreakdown(time.Second)); err == nil {
+		t.Errorf("got nil error when enabled twice, want non-nil")
+	}
+}
+
+func TestGoroutineDumpGrows(t *testing.T) {
+	t.Parallel()
+
+	buf := goroutineDump(make([]byte, 16))
+	if len(buf) <= 16 || buf[len(buf)-1] == 0 {
+		t.Errorf("got a %d bytes dump, want the full dump", len(buf))
+	}
+}
@@ -0,0 +1,181 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GrafanaHandler returns a handler implementing the Grafana SimpleJSON data
+// source protocol, serving the stats kept in history, so that they can be
+// shown on Grafana dashboards. It responds to:
+//
+//   - / with 200 OK, for the data source health check,
+//   - /search with the names of the available targets: the scalar runtime
+//     metrics read by the server, such as /gc/heap/allocs:bytes, and the user
+//     metrics,
+//   - /query with the datapoints of the requested targets, over the requested
+//     time range.
+//
+// Datapoints are averaged over each interval of the query, so that there's at
+// most one datapoint per interval.
+//
+// Paths are relative to the handler, which must be mounted with
+// http.StripPrefix. History must be enabled with WithHistorySize, otherwise
+// there's no data. Register mounts the handler on the grafana endpoint, the
+// URL of the data source is then http://host:port/debug/statsviz/grafana.
+func (s *Server) GrafanaHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/search", s.grafanaSearch)
+	mux.HandleFunc("/query", s.grafanaQuery)
+	return s.wrap(s.unlessStopped(mux.ServeHTTP))
+}
+
+// grafanaHistory returns the stats kept in history, oldest first.
+func (s *Server) grafanaHistory() []stats {
+	if s.history == nil {
+		return nil
+	}
+	return s.history.snapshot()
+}
+
+// grafanaSearch responds with the sorted names of the targets found in the
+// most recent stats, containing the requested target, if any.
+func (s *Server) grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid search request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	targets := []string{}
+	if all := s.grafanaHistory(); len(all) != 0 {
+		last := &all[len(all)-1]
+		for _, m := range []map[string]float64{last.Metrics, last.UserMetrics} {
+			for name := range m {
+				if strings.Contains(name, req.Target) {
+					targets = append(targets, name)
+				}
+			}
+		}
+	}
+	sort.Strings(targets)
+	writeJSON(w, targets)
+}
+
+// A grafanaQuery is the body of a SimpleJSON query request.
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64 `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// A grafanaSeries is a SimpleJSON time series. Each datapoint is a value and
+// a Unix time in milliseconds.
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// grafanaQuery responds with the datapoints of the requested targets.
+func (s *Server) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var q grafanaQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Range.To.Before(q.Range.From) {
+		http.Error(w, "invalid query: range ends before it starts", http.StatusBadRequest)
+		return
+	}
+
+	all := s.grafanaHistory()
+	series := make([]grafanaSeries, 0, len(q.Targets))
+	for _, t := range q.Targets {
+		series = append(series, grafanaSeries{
+			Target:     t.Target,
+			Datapoints: datapoints(all, t.Target, q.Range.From, q.Range.To, time.Duration(q.IntervalMs)*time.Millisecond),
+		})
+	}
+	writeJSON(w, series)
+}
+
+// datapoints returns the values of the named metric, runtime or user metric,
+// of the stats in all collected between from and to, both included. If
+// interval is positive, values are averaged over consecutive intervals,
+// starting at from, each datapoint then has the time of the start of its
+// interval. Missing values are skipped.
+func datapoints(all []stats, name string, from, to time.Time, interval time.Duration) [][2]float64 {
+	points := [][2]float64{}
+	var (
+		start    time.Time // of the current interval
+		sum      float64
+		n        int
+		hasStart bool
+	)
+	flush := func() {
+		if n != 0 {
+			points = append(points, [2]float64{sum / float64(n), float64(start.UnixNano() / 1e6)})
+		}
+		sum, n = 0, 0
+	}
+
+	for i := range all {
+		st := &all[i]
+		if st.Time.Before(from) || st.Time.After(to) {
+			continue
+		}
+		v, ok := st.Metrics[name]
+		if !ok {
+			v, ok = st.UserMetrics[name]
+		}
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		if interval <= 0 {
+			points = append(points, [2]float64{v, float64(st.Time.UnixNano() / 1e6)})
+			continue
+		}
+
+		if bucket := from.Add(st.Time.Sub(from) / interval * interval); !hasStart || !bucket.Equal(start) {
+			flush()
+			start, hasStart = bucket, true
+		}
+		sum += v
+		n++
+	}
+	flush()
+	return points
+}
+
+// writeJSON responds with the JSON encoding of v.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,145 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafanaHandler(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(time.Hour), WithHistorySize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// Fill the history with samples 10 seconds apart.
+	const metric = "/sched/goroutines:goroutines"
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		st := newStats()
+		st.Time = t0.Add(time.Duration(i) * 10 * time.Second)
+		st.Metrics = map[string]float64{metric: float64(i)}
+		st.UserMetrics = map[string]float64{"queue": float64(10 * i)}
+		srv.history.push(st)
+	}
+
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/debug/statsviz/grafana"+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			t.Fatalf("%s: got status %d, want %d", path, resp.StatusCode, http.StatusOK)
+		}
+		return resp
+	}
+
+	// Health check.
+	resp, err := http.Get(ts.URL + "/debug/statsviz/grafana/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("health check: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var targets []string
+	resp = post("/search", `{"target":""}`)
+	err = json.NewDecoder(resp.Body).Decode(&targets)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{metric, "queue"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("got targets %q, want %q", targets, want)
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(t0.Add(d).UnixNano() / 1e6)
+	}
+	tests := []struct {
+		name     string
+		interval time.Duration
+		want     [][2]float64
+	}{
+		{
+			name: "all",
+			want: [][2]float64{{1, ms(10 * time.Second)}, {2, ms(20 * time.Second)}, {3, ms(30 * time.Second)}, {4, ms(40 * time.Second)}},
+		},
+		{
+			name:     "averaged",
+			interval: 20 * time.Second,
+			want:     [][2]float64{{1.5, ms(10 * time.Second)}, {3.5, ms(30 * time.Second)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := `{
+				"range": {"from": "` + t0.Add(10*time.Second).Format(time.RFC3339) + `", "to": "` + t0.Add(40*time.Second).Format(time.RFC3339) + `"},
+				"intervalMs": ` + strconv.FormatInt(tt.interval.Milliseconds(), 10) + `,
+				"targets": [{"target": "` + metric + `", "refId": "A", "type": "timeserie"}, {"target": "queue", "refId": "B"}]
+			}`
+			var series []struct {
+				Target     string       `json:"target"`
+				Datapoints [][2]float64 `json:"datapoints"`
+			}
+			resp := post("/query", q)
+			err := json.NewDecoder(resp.Body).Decode(&series)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(series) != 2 {
+				t.Fatalf("got %d series, want 2", len(series))
+			}
+			if series[0].Target != metric || !reflect.DeepEqual(series[0].Datapoints, tt.want) {
+				t.Errorf("got series %s %v, want %s %v", series[0].Target, series[0].Datapoints, metric, tt.want)
+			}
+			if got := len(series[1].Datapoints); series[1].Target != "queue" || got != len(tt.want) {
+				t.Errorf("got series %s with %d datapoints, want queue with %d", series[1].Target, got, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestGrafanaQueryInvalid(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithHistorySize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	h := http.StripPrefix("/grafana", srv.GrafanaHandler())
+
+	tests := []struct {
+		method, body string
+		want         int
+	}{
+		{"GET", "", http.StatusMethodNotAllowed},
+		{"POST", "not json", http.StatusBadRequest},
+		{"POST", `{"range": {"from": "2022-01-02T00:00:00Z", "to": "2022-01-01T00:00:00Z"}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, "/grafana/query", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s %q: got status %d, want %d", tt.method, tt.body, w.Code, tt.want)
+		}
+	}
+}
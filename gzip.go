@@ -0,0 +1,118 @@
+package statsviz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WithGzipAssets sets whether the index page and the embedded assets of the
+// user interface are served gzip compressed to the clients accepting it,
+// which is the default. Assets are compressed once, on first request, then
+// kept in memory. Already compressed assets, such as fonts, and the assets of
+// WithAssetsDir, which may change, are always served as is. This is unrelated
+// to the compression of websocket messages, see WithCompression.
+func WithGzipAssets(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.gzipAssets = enable
+		return nil
+	}
+}
+
+// compressedExts holds the extensions of the files which are already
+// compressed, and can't be compressed much further.
+var compressedExts = map[string]bool{
+	".gz": true, ".zip": true, ".png": true, ".jpg": true, ".jpeg": true,
+	".gif": true, ".webp": true, ".woff": true, ".woff2": true,
+}
+
+// acceptsGzip reports whether the client accepts gzip encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, h := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(h, ",") {
+			name, params := enc, ""
+			if i := strings.IndexByte(enc, ';'); i >= 0 {
+				name, params = enc[:i], enc[i+1:]
+			}
+			name = strings.TrimSpace(name)
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			params = strings.ReplaceAll(params, " ", "")
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil && q == 0 {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// gzipBytes returns b gzip compressed, or nil if compressing b isn't worth it.
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	zw.Write(b)
+	zw.Close()
+	if buf.Len() >= len(b) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// gzipContent holds the compressed content of a file, or nil if the file is
+// sent uncompressed.
+type gzipContent struct {
+	once sync.Once
+	data []byte
+}
+
+// gzipCache caches the compressed embedded assets by path, they're shared by
+// all servers.
+var gzipCache sync.Map
+
+// serveGzip writes data, the gzip compressed content of the file name.
+func serveGzip(w http.ResponseWriter, r *http.Request, name string, data []byte) {
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+// gzipAssets returns a handler serving the files of assets gzip compressed
+// to the clients accepting it, and calling next otherwise, or for
+// directories, missing or already compressed files.
+func gzipAssets(assets fs.FS, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if !acceptsGzip(r) || r.Header.Get("Range") != "" || compressedExts[path.Ext(name)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v, _ := gzipCache.LoadOrStore(name, &gzipContent{})
+		c := v.(*gzipContent)
+		c.once.Do(func() {
+			if b, err := fs.ReadFile(assets, name); err == nil {
+				c.data = gzipBytes(b)
+			}
+		})
+		if c.data == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		serveGzip(w, r, name, c.data)
+	})
+}
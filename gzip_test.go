@@ -0,0 +1,126 @@
+package statsviz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arl/statsviz/internal/static"
+)
+
+func TestGzipAssets(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+
+	get := func(path string, gzipped bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if gzipped {
+			req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: got status %d, want %d", path, w.Code, http.StatusOK)
+		}
+		return w
+	}
+
+	for _, asset := range []string{"", "app.js", "statsviz.css"} {
+		path := "/debug/statsviz/" + asset
+		raw := get(path, false)
+		if enc := raw.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("GET %s: got Content-Encoding %q without Accept-Encoding, want none", path, enc)
+		}
+		if asset != "" {
+			want, err := fs.ReadFile(static.Assets, asset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(raw.Body.Bytes(), want) {
+				t.Errorf("GET %s: body differs from the embedded asset", path)
+			}
+		}
+
+		w := get(path, true)
+		if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+			t.Fatalf("GET %s: got Content-Encoding %q, want gzip", path, enc)
+		}
+		if ct, want := w.Header().Get("Content-Type"), raw.Header().Get("Content-Type"); ct != want {
+			t.Errorf("GET %s: got Content-Type %q, want %q", path, ct, want)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("GET %s: got Vary %q, want Accept-Encoding", path, w.Header().Get("Vary"))
+		}
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, raw.Body.Bytes()) {
+			t.Errorf("GET %s: decompressed body differs from the uncompressed one", path)
+		}
+	}
+
+	// Fonts are already compressed.
+	font := "/debug/statsviz/semantic/themes/default/assets/fonts/icons.woff2"
+	if enc := get(font, true).Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("GET %s: got Content-Encoding %q, want none", font, enc)
+	}
+}
+
+func TestWithGzipAssetsDisabled(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithGzipAssets(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+
+	for _, path := range []string{"/debug/statsviz/", "/debug/statsviz/app.js"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if enc := w.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("GET %s: got Content-Encoding %q, want none", path, enc)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"deflate, gzip":        true,
+		"br;q=1.0, gzip;q=0.5": true,
+		"gzip;q=0":             false,
+		"*":                    true,
+		"identity":             false,
+	}
+	for header, want := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if got := acceptsGzip(r); got != want {
+			t.Errorf("acceptsGzip(%q) = %t, want %t", header, got, want)
+		}
+	}
+}
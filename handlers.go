@@ -1,8 +1,18 @@
 package statsviz
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
 	"net/http"
+	"net/url"
+	"runtime/metrics"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/arl/statsviz/internal/static"
@@ -18,9 +28,95 @@ var Index = IndexAtRoot(defaultRoot)
 // IndexAtRoot returns an index statsviz handler rooted at root. It's useful if
 // you desire your server to responds with the statsviz HTML page at a
 // path that is different than /debug/statsviz.
+//
+// The HTML page connects to the websocket handler at root/ws.
 func IndexAtRoot(root string) http.HandlerFunc {
+	return indexAtRoot(root, page{Title: defaultPageTitle}, nil, true)
+}
+
+// indexAtRoot returns an index statsviz handler rooted at root, rendering the
+// index page with the customizations of p. The page and its assets are read
+// from dir if not nil, each time they're requested, or embedded otherwise. If
+// gzipped is set, the embedded page and assets are served gzip compressed to
+// the clients accepting it, see WithGzipAssets.
+func indexAtRoot(root string, p page, dir fs.FS, gzipped bool) http.HandlerFunc {
 	prefix := strings.TrimRight(root, "/") + "/"
-	return http.StripPrefix(prefix, http.FileServer(http.FS(static.Assets))).ServeHTTP
+
+	var assets http.Handler
+	var index func() ([]byte, error)
+	var gzipIndex []byte // compressed index page, nil if not compressed
+	if dir == nil {
+		assets = http.FileServer(http.FS(static.Assets))
+		page := indexPage(prefix, p)
+		index = func() ([]byte, error) { return page, nil }
+		if gzipped {
+			assets = gzipAssets(static.Assets, assets)
+			gzipIndex = gzipBytes(page)
+		}
+	} else {
+		assets = http.FileServer(http.FS(dir))
+		index = func() ([]byte, error) {
+			tmpl, err := template.ParseFS(dir, "index.html")
+			if err != nil {
+				return nil, err
+			}
+			return executeIndex(tmpl, prefix, p)
+		}
+	}
+
+	return http.StripPrefix(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" || r.URL.Path == "/" {
+			page, err := index()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("statsviz: can't render index page: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if gzipIndex != nil {
+				w.Header().Add("Vary", "Accept-Encoding")
+				if acceptsGzip(r) {
+					serveGzip(w, r, "index.html", gzipIndex)
+					return
+				}
+			}
+			w.Write(page)
+			return
+		}
+		assets.ServeHTTP(w, r)
+	})).ServeHTTP
+}
+
+var indexTmpl = template.Must(template.ParseFS(static.Assets, "index.html"))
+
+// indexPage renders the embedded statsviz HTML page, so that it refers to the
+// endpoints rooted at prefix. The template escapes the customizations of p.
+func indexPage(prefix string, p page) []byte {
+	page, err := executeIndex(indexTmpl, prefix, p)
+	if err != nil {
+		panic(fmt.Sprintf("statsviz: can't render index page: %v", err))
+	}
+	return page
+}
+
+// executeIndex renders the statsviz HTML page with tmpl, see indexPage.
+func executeIndex(tmpl *template.Template, prefix string, p page) ([]byte, error) {
+	ws := p.ws
+	if ws == "" {
+		ws = prefix + "ws"
+	}
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, struct {
+		Ws, Handshake string
+		Page          page
+	}{
+		Ws:        ws,
+		Handshake: prefix + "handshake",
+		Page:      p,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // Ws is a default Websocket handler, created with NewWsHandler, sending statistics
@@ -32,20 +128,349 @@ var Ws = NewWsHandler(defaultSendFrequency)
 //
 // If the upgrade fails, an HTTP error response is sent to the client.
 func NewWsHandler(frequency time.Duration) http.HandlerFunc {
+	s := newServer()
+	s.freq = frequency
+	return s.ws()
+}
+
+func (s *Server) ws() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var upgrader = websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
+		untrack, ok := s.track()
+		if !ok {
+			http.Error(w, "statsviz server stopped", http.StatusServiceUnavailable)
+			return
+		}
+		defer untrack()
+
+		if s.rateLimit != nil && !s.rateLimit.allow(s.clientIP(r), s.clock.Now()) {
+			http.Error(w, "statsviz: too many connection attempts", http.StatusTooManyRequests)
+			return
+		}
+
+		// Count the client before upgrading, so that concurrent
+		// connections can't exceed the limit.
+		n := atomic.AddInt32(&s.clients, 1)
+		defer atomic.AddInt32(&s.clients, -1)
+		if s.maxClients > 0 && n > s.maxClients {
+			http.Error(w, "statsviz: too many clients", http.StatusServiceUnavailable)
+			return
 		}
 
+		freq, err := s.queryFrequency(r.URL.Query())
+		if err != nil {
+			http.Error(w, "statsviz: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Clients requesting subprotocols expect the frames of one of these
+		// versions, which must be the server one.
+		if requested := websocket.Subprotocols(r); len(requested) != 0 && !supportedSubprotocol(requested) {
+			s.upgradeError(w, r, http.StatusBadRequest, fmt.Errorf("websocket: unsupported subprotocols %q, this server speaks %q", requested, wsSubprotocol))
+			return
+		}
+
+		upgrader := s.upgrader()
 		ws, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
+			// Handshake errors have been replied to, and logged, by
+			// upgradeError. Others happen once the connection has been
+			// hijacked, there's nothing to reply.
+			if _, ok := err.(websocket.HandshakeError); !ok {
+				s.logger.Warn("statsviz: websocket upgrade failed", "remote_addr", r.RemoteAddr, "root", s.root, "error", err)
+			}
 			return
 		}
 		defer ws.Close()
 
-		// Explicitly ignore this error. We don't want to spam standard output
-		// each time the other end of the websocket connection closes.
-		_ = sendStats(ws, frequency)
+		// Compression is only effective if the client supports it, otherwise
+		// this is a no-op.
+		ws.EnableWriteCompression(s.compression)
+
+		s.logger.Info("statsviz: websocket client connected", "remote_addr", r.RemoteAddr, "root", s.root)
+		// Reconnecting clients ask to resume where they left.
+		_, resume := r.URL.Query()["resume"]
+		if err := s.sendStatsWs(ws, freq, resume); err != nil {
+			s.logger.Warn("statsviz: websocket write failed", "remote_addr", r.RemoteAddr, "root", s.root, "error", err)
+		}
+		s.logger.Info("statsviz: websocket client disconnected", "remote_addr", r.RemoteAddr, "root", s.root)
+	}
+}
+
+// Bounds of the frequencies requested by websocket clients, see
+// queryFrequency.
+const (
+	maxQueryInterval = time.Hour
+	maxQueryHz       = 1000
+)
+
+// queryFrequency returns the send frequency requested by a websocket client
+// with the hz query parameter, a number of frames per second, or interval, a
+// duration between frames such as 500ms, or the server frequency if there's
+// none. Intervals can't be longer than maxQueryInterval, and hz can't exceed
+// maxQueryHz. Intervals shorter than minSendFrequency are raised to it.
+func (s *Server) queryFrequency(q url.Values) (time.Duration, error) {
+	var freq time.Duration
+	switch {
+	case q.Get("interval") != "":
+		d, err := time.ParseDuration(q.Get("interval"))
+		if err != nil || d <= 0 || d > maxQueryInterval {
+			return 0, fmt.Errorf("invalid interval %q, must be in (0, %v]", q.Get("interval"), maxQueryInterval)
+		}
+		freq = d
+	case q.Get("hz") != "":
+		// Checked before the conversion, which overflows for tiny hz.
+		minHz := float64(time.Second) / float64(maxQueryInterval)
+		hz, err := strconv.ParseFloat(q.Get("hz"), 64)
+		if err != nil || !(hz >= minHz && hz <= maxQueryHz) {
+			return 0, fmt.Errorf("invalid hz %q, must be in [%v, %v]", q.Get("hz"), minHz, maxQueryHz)
+		}
+		freq = time.Duration(float64(time.Second) / hz)
+	default:
+		return s.freq, nil
+	}
+	if freq < minSendFrequency {
+		freq = minSendFrequency
+	}
+	return freq, nil
+}
+
+// wsSubprotocol is the websocket subprotocol naming the version of the frames
+// schema. It changes whenever a change to the frames would break the clients
+// of the previous version. Clients which don't request any subprotocol are
+// served the current version.
+const wsSubprotocol = "statsviz.v1"
+
+// supportedSubprotocol reports whether the server supports one of the
+// requested subprotocols.
+func supportedSubprotocol(requested []string) bool {
+	for _, p := range requested {
+		if p == wsSubprotocol {
+			return true
+		}
+	}
+	return false
+}
+
+// upgrader returns the websocket upgrader of the server connections.
+func (s *Server) upgrader() websocket.Upgrader {
+	return websocket.Upgrader{
+		Subprotocols:      []string{wsSubprotocol},
+		ReadBufferSize:    s.readBufferSize,
+		WriteBufferSize:   s.writeBufferSize,
+		WriteBufferPool:   s.writeBuffers,
+		EnableCompression: s.compression,
+		CheckOrigin:       s.checkOrigin,
+		Error:             s.upgradeError,
+	}
+}
+
+// upgradeError replies to a request which couldn't be upgraded to a websocket
+// connection with status, for example 400 if it isn't a websocket handshake or
+// 403 if its origin is rejected. The upgrader calls it before hijacking the
+// connection, it's the only reply to the request.
+func (s *Server) upgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	s.logger.Warn("statsviz: websocket upgrade failed", "remote_addr", r.RemoteAddr, "root", s.root, "status", status, "error", reason)
+	w.Header().Set("Sec-Websocket-Version", "13")
+	http.Error(w, "statsviz: "+reason.Error(), status)
+}
+
+// NewSSEHandler returns a handler that streams application statistics at the
+// given frequency, as Server-Sent Events. It's an alternative to the
+// websocket handler for networks where websocket connections can't be
+// established.
+//
+// Events are sent until the client disconnects.
+func NewSSEHandler(frequency time.Duration) http.HandlerFunc {
+	s := newServer()
+	s.freq = frequency
+	return s.sse()
+}
+
+func (s *Server) sse() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		untrack, ok := s.track()
+		if !ok {
+			http.Error(w, "statsviz server stopped", http.StatusServiceUnavailable)
+			return
+		}
+		defer untrack()
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// As for websockets, ignore the error, the client is gone anyway.
+		_ = s.sendStatsSSE(r.Context().Done(), w, flusher)
+	}
+}
+
+// SnapshotHandler returns a handler that responds to GET requests with the
+// current runtime statistics, in the same JSON format as the one used by the
+// websocket handler. Pass the 'pretty=true' query parameter to get an indented
+// output.
+func SnapshotHandler() http.Handler {
+	var mu sync.Mutex
+	s := newServer()
+	smp := newSampler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		stats := newStats()
+		mu.Lock()
+		s.collect(smp, &stats)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			enc.SetIndent("", "  ")
+		}
+		enc.Encode(stats)
+	})
+}
+
+// GoroutineDumpHandler returns a handler that responds with the stacks of all
+// goroutines, as formatted by runtime.Stack, as a goroutines.txt file to
+// download. Dumping the stacks stops the world for a time that grows with the
+// number of goroutines.
+func GoroutineDumpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dump := goroutineDump(nil)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=goroutines.txt")
+		w.Write(dump)
+	})
+}
+
+// catalogMetric describes a runtime metric in the MetricsCatalogHandler
+// response.
+type catalogMetric struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Cumulative  bool   `json:"cumulative"`
+	Description string `json:"description"`
+}
+
+// metricKinds names the kinds of runtime metrics.
+var metricKinds = map[metrics.ValueKind]string{
+	metrics.KindUint64:           "Uint64",
+	metrics.KindFloat64:          "Float64",
+	metrics.KindFloat64Histogram: "Float64Histogram",
+	metrics.KindBad:              "Bad",
+}
+
+// MetricsCatalogHandler returns a handler that responds with the JSON list of
+// all the metrics supported by the runtime/metrics package of the running
+// binary, as reported by metrics.All, with their name, kind, cumulative flag
+// and description. Whatever the plots shown, it helps finding the metrics
+// available for custom plots.
+func MetricsCatalogHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		all := metrics.All()
+		catalog := make([]catalogMetric, len(all))
+		for i, d := range all {
+			kind, ok := metricKinds[d.Kind]
+			if !ok {
+				kind = metricKinds[metrics.KindBad]
+			}
+			catalog[i] = catalogMetric{
+				Name:        d.Name,
+				Kind:        kind,
+				Cumulative:  d.Cumulative,
+				Description: d.Description,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			enc.SetIndent("", "  ")
+		}
+		enc.Encode(catalog)
+	})
+}
+
+// handshake holds the metadata the user interface needs to connect to the
+// data endpoint.
+type handshake struct {
+	Transport TransportKind    `json:"transport"`
+	Millis    int64            `json:"millis"` // send frequency
+	Plots     []TimeSeriesPlot `json:"plots"`  // user-defined plots
+
+	// BuiltinPlots lists the enabled built-in plots, other than runtime plots.
+	BuiltinPlots []Plot `json:"builtinPlots"`
+
+	// MemoryLimit is the Go runtime soft memory limit, shown on the heap
+	// plot, or 0 if there's no limit. It's also sent with each stats since
+	// the application may change it.
+	MemoryLimit int64 `json:"memoryLimit,omitempty"`
+
+	// RuntimePlots holds the built-in plots backed by runtime metrics, only
+	// those supported by the current Go runtime are listed.
+	RuntimePlots []PlotConfig `json:"runtimePlots"`
+
+	// Links holds the links to other statsviz endpoints, shown in the menu.
+	Links []link `json:"links,omitempty"`
+
+	// ForceGC indicates the user interface can run a garbage collection.
+	ForceGC bool `json:"forceGC,omitempty"`
+
+	// ProfileControls indicates the user interface can set the profile
+	// rates.
+	ProfileControls bool `json:"profileControls,omitempty"`
+
+	// Encoding is the encoding of stats messages. Control messages are always
+	// JSON encoded.
+	Encoding EncodingKind `json:"encoding"`
+
+	// Build describes the build and runtime of the process.
+	Build buildInfo `json:"build"`
+
+	// MetricNames, with compact stats, holds the names of the runtime
+	// metrics which values are sent in the MetricValues of stats, in the same
+	// order. See WithCompactMetrics. With varint deltas, it's the order of
+	// the metrics values of protobuf frames.
+	MetricNames []string `json:"metricNames,omitempty"`
+
+	// Keyframes, with varint deltas, is the number of frames between
+	// keyframes. See WithVarintDeltas.
+	Keyframes int `json:"keyframes,omitempty"`
+
+	// DeltaFrames indicates that stats only hold the runtime metrics which
+	// changed since the previous stats. See WithDeltaFrames.
+	DeltaFrames bool `json:"deltaFrames,omitempty"`
+
+	// Metrics describes the sampled runtime metrics, by name.
+	Metrics map[string]metricInfo `json:"metrics,omitempty"`
+
+	// Labels holds the static labels of the process, see WithLabels.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// A link is a menu entry of the user interface, pointing to url.
+type link struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Icon  string `json:"icon,omitempty"` // Semantic UI icon name
+}
+
+// handshakeHandler serves the handshake returned by hs, called on each request
+// so that it reflects the plots added or removed since registration.
+func handshakeHandler(hs func() handshake) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hs())
 	}
 }
@@ -1,11 +1,17 @@
 package statsviz
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"runtime"
+	"runtime/metrics"
 	"strings"
 	"testing"
 	"time"
@@ -97,6 +103,196 @@ func TestWs(t *testing.T) {
 	testWs(t, http.HandlerFunc(Ws), "http://example.com/debug/statsviz/ws")
 }
 
+// readControl reads messages on ws, ignoring stats, until it gets a control
+// message.
+func readControl(t *testing.T, ws *websocket.Conn) controlMsg {
+	t.Helper()
+
+	for {
+		_, p, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var msg controlMsg
+		if err := json.Unmarshal(p, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != "" {
+			return msg
+		}
+	}
+}
+
+// requestControl connects a websocket client to a server created with opts and
+// sends it msg. It returns the server reply.
+func requestControl(t *testing.T, msg controlMsg, opts ...OptionFunc) controlMsg {
+	t.Helper()
+
+	srv, err := NewServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return readControl(t, ws)
+}
+
+func TestWsSetFrequency(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(NewWsHandler(time.Hour))
+	defer s.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(controlMsg{Type: "setFrequency", Millis: 100}); err != nil {
+		t.Fatal(err)
+	}
+	ack := readControl(t, ws)
+	if ack.Millis != 100 || ack.Error != "" {
+		t.Fatalf("got ack %+v, want accepted frequency of 100ms", ack)
+	}
+
+	// Stats should now be sent every 100ms rather than every hour.
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 2; i++ {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Too high frequencies are refused.
+	if err := ws.WriteJSON(controlMsg{Type: "setFrequency", Millis: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ack = readControl(t, ws)
+	if ack.Millis != 100 || ack.Error == "" {
+		t.Fatalf("got ack %+v, want refused frequency and 100ms still in use", ack)
+	}
+}
+
+func TestWsQueryFrequency(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newFakeClock(t0)
+	srv, err := NewServer(withClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?interval=200ms", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// The client is told the frequency in use.
+	if ack := readControl(t, ws); ack.Type != "setFrequency" || ack.Millis != 200 {
+		t.Fatalf("got %+v, want a setFrequency ack of 200ms", ack)
+	}
+
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+
+	// Frames are collected every 200ms, rather than every second, and not
+	// in between.
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 1; i <= 3; i++ {
+		clk.advance(100 * time.Millisecond)
+		clk.advance(100 * time.Millisecond)
+		var st stats
+		if err := ws.ReadJSON(&st); err != nil {
+			t.Fatal(err)
+		}
+		if want := t0.Add(time.Duration(i) * 200 * time.Millisecond); !st.Time.Equal(want) {
+			t.Errorf("frame %d: got time %v, want %v", i, st.Time, want)
+		}
+	}
+}
+
+func TestQueryFrequency(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(2 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	tests := []struct {
+		query string
+		want  time.Duration // 0 if invalid
+	}{
+		{"", 2 * time.Second},
+		{"resume", 2 * time.Second},
+		{"interval=500ms", 500 * time.Millisecond},
+		{"hz=2", 500 * time.Millisecond},
+		{"hz=0.5", 2 * time.Second},
+		// Too high frequencies are clamped.
+		{"interval=1ms", minSendFrequency},
+		{"hz=1000", minSendFrequency},
+		{"interval=1", 0},
+		{"interval=-1s", 0},
+		{"hz=0", 0},
+		{"hz=-1", 0},
+		{"hz=NaN", 0},
+		{"hz=fast", 0},
+		// Out of range frequencies are refused.
+		{"interval=1h", time.Hour},
+		{"interval=2h", 0},
+		{"hz=0.001", 1000 * time.Second},
+		{"hz=0.0002", 0},
+		{"hz=1e-300", 0},
+		{"hz=1001", 0},
+		{"hz=1e300", 0},
+		{"hz=Inf", 0},
+		{"hz=-Inf", 0},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := srv.queryFrequency(q)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", tt.query, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %v, %v, want %v", tt.query, got, err, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	srv.Ws()(w, httptest.NewRequest("GET", "/debug/statsviz/ws?hz=fast", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
 func TestWsCantUpgrade(t *testing.T) {
 	url := "http://example.com/debug/statsviz/ws"
 
@@ -109,10 +305,366 @@ func TestWsCantUpgrade(t *testing.T) {
 	}
 }
 
+func TestWithCheckOrigin(t *testing.T) {
+	t.Parallel()
+
+	allowed := "http://dashboard.example.com"
+	srv, err := NewServer(WithCheckOrigin(func(r *http.Request) bool {
+		return r.Header.Get("Origin") == allowed
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	// Allowed origin.
+	ws, _, err := websocket.DefaultDialer.Dial(URL, http.Header{"Origin": {allowed}})
+	if err != nil {
+		t.Fatalf("allowed origin: %v", err)
+	}
+	ws.Close()
+
+	// Disallowed origin.
+	ws, resp, err := websocket.DefaultDialer.Dial(URL, http.Header{"Origin": {"http://evil.example.com"}})
+	if err == nil {
+		ws.Close()
+		t.Fatalf("disallowed origin: upgrade succeeded, want it refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("disallowed origin: got response %v, want status %d", resp, http.StatusForbidden)
+	}
+}
+
+func TestWithMaxClients(t *testing.T) {
+	t.Parallel()
+
+	const max = 3
+	srv, err := NewServer(WithMaxClients(max))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http")
+	var clients []*websocket.Conn
+	for i := 0; i < max; i++ {
+		ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+		if err != nil {
+			t.Fatalf("client %d: %v", i, err)
+		}
+		defer ws.Close()
+		clients = append(clients, ws)
+	}
+
+	ws, resp, err := websocket.DefaultDialer.Dial(URL, nil)
+	if err == nil {
+		ws.Close()
+		t.Fatalf("upgrade succeeded with %d clients connected, want it refused", max)
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got response %v, want status %d", resp, http.StatusServiceUnavailable)
+	}
+
+	// Abruptly close a connection, without a close frame. Then a new client
+	// can connect, once the server noticed.
+	clients[0].UnderlyingConn().Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+		if err == nil {
+			ws.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("can't connect after a client disconnected: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWithMaxClientsInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithMaxClients(-1)); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+}
+
+func TestWithBufferSizes(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	if u := srv.upgrader(); u.ReadBufferSize != defaultReadBufferSize || u.WriteBufferSize != defaultWriteBufferSize || u.WriteBufferPool == nil {
+		t.Errorf("got buffer sizes %d and %d, pool %v, want the default sizes and a pool", u.ReadBufferSize, u.WriteBufferSize, u.WriteBufferPool)
+	}
+
+	srv, err = NewServer(WithReadBufferSize(512), WithWriteBufferSize(16<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	if u := srv.upgrader(); u.ReadBufferSize != 512 || u.WriteBufferSize != 16<<10 {
+		t.Errorf("got buffer sizes %d and %d, want 512 and %d", u.ReadBufferSize, u.WriteBufferSize, 16<<10)
+	}
+
+	// Connections work with the configured buffers.
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opt := range []OptionFunc{WithReadBufferSize(0), WithWriteBufferSize(-1)} {
+		if _, err := NewServer(opt); err == nil {
+			t.Errorf("got nil error for an invalid buffer size, want non-nil")
+		}
+	}
+}
+
+func TestWsSameOriginDefault(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(Ws))
+	defer ts.Close()
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http")
+	ws, resp, err := websocket.DefaultDialer.Dial(URL, http.Header{"Origin": {"http://evil.example.com"}})
+	if err == nil {
+		ws.Close()
+		t.Fatalf("cross-origin upgrade succeeded, want it refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got response %v, want status %d", resp, http.StatusForbidden)
+	}
+}
+
+func testSSE(t *testing.T, f http.Handler, URL string) {
+	t.Helper()
+
+	s := httptest.NewServer(f)
+	defer s.Close()
+
+	u, err := url.Parse(URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(s.URL + u.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("header[Content-Type] %s, want %s", ct, "text/event-stream")
+	}
+
+	// Wait for 2 events and check that the payload is what we expect.
+	r := bufio.NewReader(resp.Body)
+	for i := 0; i < 2; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			t.Fatalf("event line = %q, want 'data: ' prefix", line)
+		}
+
+		var stats stats
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &stats); err != nil {
+			t.Fatal(err)
+		}
+
+		// Events are terminated by a blank line.
+		if line, err = r.ReadString('\n'); err != nil || line != "\n" {
+			t.Fatalf("got %q, %v, want blank line", line, err)
+		}
+	}
+}
+
+func TestSSE(t *testing.T) {
+	t.Parallel()
+
+	testSSE(t, NewSSEHandler(100*time.Millisecond), "http://example.com/debug/statsviz/ws")
+}
+
+func TestSSEClientDisconnect(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "http://example.com/debug/statsviz/ws", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		NewSSEHandler(10*time.Millisecond)(w, req)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SSE handler still running after client disconnection")
+	}
+}
+
+func testHandshake(t *testing.T, f http.Handler, URL string, transport TransportKind) {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", URL, nil)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, req)
+
+	var hs handshake
+	if err := json.NewDecoder(w.Body).Decode(&hs); err != nil {
+		t.Fatal(err)
+	}
+	if hs.Transport != transport {
+		t.Errorf("handshake transport = %q, want %q", hs.Transport, transport)
+	}
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	t.Parallel()
+
+	for _, pretty := range []bool{false, true} {
+		url := "http://example.com/debug/statsviz/snapshot"
+		if pretty {
+			url += "?pretty=true"
+		}
+
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		SnapshotHandler().ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("http status %v, want %v", resp.StatusCode, http.StatusOK)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("header[Content-Type] %s, want %s", ct, "application/json")
+		}
+
+		body := w.Body.Bytes()
+		if indented := bytes.Contains(body, []byte("\n  ")); indented != pretty {
+			t.Errorf("pretty=%t: indented output = %t", pretty, indented)
+		}
+
+		var stats stats
+		if err := json.Unmarshal(body, &stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.NumGoroutine == 0 || stats.Mem.Sys == 0 || len(stats.Metrics) == 0 {
+			t.Errorf("snapshot %s looks empty", body)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "http://example.com/debug/statsviz/snapshot", nil)
+	w := httptest.NewRecorder()
+	SnapshotHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: http status %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestMetricsCatalogHandler(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	MetricsCatalogHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics.json", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", ct)
+	}
+	var catalog []catalogMetric
+	if err := json.NewDecoder(w.Body).Decode(&catalog); err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog) != len(metrics.All()) {
+		t.Errorf("got %d metrics, want %d", len(catalog), len(metrics.All()))
+	}
+
+	var found bool
+	for _, m := range catalog {
+		if m.Name != "/gc/heap/allocs:bytes" {
+			continue
+		}
+		found = true
+		if m.Kind != "Uint64" || !m.Cumulative || m.Description == "" {
+			t.Errorf("got %+v, want a cumulative Uint64 metric with a description", m)
+		}
+	}
+	if !found {
+		t.Errorf("/gc/heap/allocs:bytes is not in the catalog")
+	}
+
+	// The catalog is also served under the root.
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/metrics.json", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestGoroutineDumpHandler(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+
+	req := httptest.NewRequest("GET", "http://example.com/debug/statsviz/goroutines.txt", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("http status %v, want %v", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("got Content-Type %q, want text/plain", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != "attachment; filename=goroutines.txt" {
+		t.Errorf("got Content-Disposition %q, want an attachment", cd)
+	}
+	if !strings.HasPrefix(w.Body.String(), "goroutine ") {
+		t.Errorf("got body %q, want it to start with a goroutine header", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "TestGoroutineDumpHandler") {
+		t.Errorf("dump doesn't contain the stack of the test goroutine")
+	}
+}
+
 func testRegister(t *testing.T, f http.Handler, baseURL string) {
 	testIndex(t, f, baseURL)
 	ws := strings.TrimRight(baseURL, "/") + "/ws"
 	testWs(t, f, ws)
+	testHandshake(t, f, strings.TrimRight(baseURL, "/")+"/handshake", TransportWebSocket)
 }
 
 func TestRegister(t *testing.T) {
@@ -161,6 +713,29 @@ func TestRegister(t *testing.T) {
 		testRegister(t, mux, "http://example.com/root/to/statsviz/")
 	})
 
+	t.Run("sse", func(t *testing.T) {
+		t.Parallel()
+
+		mux := http.NewServeMux()
+		if err := Register(mux, Transport(TransportSSE)); err != nil {
+			t.Fatal(err)
+		}
+
+		const baseURL = "http://example.com/debug/statsviz/"
+		testIndex(t, mux, baseURL)
+		testSSE(t, mux, baseURL+"ws")
+		testHandshake(t, mux, baseURL+"handshake", TransportSSE)
+	})
+
+	t.Run("unknown transport", func(t *testing.T) {
+		t.Parallel()
+
+		mux := http.NewServeMux()
+		if err := Register(mux, Transport("carrier-pigeon")); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
 	t.Run("non-positive frequency", func(t *testing.T) {
 		t.Parallel()
 
@@ -179,3 +754,240 @@ func TestRegisterDefault(t *testing.T) {
 
 	testRegister(t, http.DefaultServeMux, "http://example.com/debug/statsviz/")
 }
+
+func TestWsCompression(t *testing.T) {
+	t.Parallel()
+
+	for _, enable := range []bool{false, true} {
+		mux := http.NewServeMux()
+		if err := Register(mux, WithCompression(enable), SendFrequency(10*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+
+		s := httptest.NewServer(mux)
+		defer s.Close()
+
+		dialer := websocket.Dialer{EnableCompression: true}
+		ws, resp, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/debug/statsviz/ws", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+
+		ext := resp.Header.Get("Sec-WebSocket-Extensions")
+		if negotiated := strings.Contains(ext, "permessage-deflate"); negotiated != enable {
+			t.Errorf("compression=%t: Sec-WebSocket-Extensions = %q", enable, ext)
+		}
+
+		var st stats
+		if err := ws.ReadJSON(&st); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWsWrite(b *testing.B) {
+	for _, enable := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compression=%t", enable), func(b *testing.B) {
+			srv := newServer()
+			smp := newSampler()
+			st := newStats()
+			srv.collect(smp, &st)
+
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upgrader := websocket.Upgrader{EnableCompression: enable}
+				ws, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer ws.Close()
+				ws.EnableWriteCompression(enable)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := ws.WriteJSON(&st); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			}))
+			defer s.Close()
+
+			dialer := websocket.Dialer{EnableCompression: true}
+			ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer ws.Close()
+
+			for i := 0; i < b.N; i++ {
+				if _, _, err := ws.ReadMessage(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWsUpgradeFailure(t *testing.T) {
+	// Not parallel since we're counting goroutines.
+
+	srv, err := NewServer(WithCheckOrigin(func(r *http.Request) bool {
+		return r.Header.Get("Origin") == ""
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	log := &warnLogger{}
+	srv.logger = log
+
+	handshake := http.Header{
+		"Connection":            {"Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-Websocket-Version": {"13"},
+		"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+	}
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"plain GET", nil, http.StatusBadRequest},
+		{"rejected origin", handshake, http.StatusForbidden},
+	}
+
+	before := runtime.NumGoroutine()
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/debug/statsviz/ws", nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		if tt.header != nil {
+			req.Header.Set("Origin", "http://evil.example.com")
+		}
+		w := httptest.NewRecorder()
+		srv.Ws().ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if body := w.Body.String(); strings.Count(body, "statsviz: websocket") != 1 {
+			t.Errorf("%s: got body %q, want a single error message", tt.name, body)
+		}
+	}
+
+	log.mu.Lock()
+	if len(log.warns) != len(tests) {
+		t.Errorf("got warnings %q, want %d", log.warns, len(tests))
+	}
+	log.mu.Unlock()
+
+	// No stats are streamed.
+	srv.hubsMu.Lock()
+	if len(srv.hubs) != 0 {
+		t.Errorf("got %d hubs, want none", len(srv.hubs))
+	}
+	srv.hubsMu.Unlock()
+	if got := waitGoroutines(before); got > before {
+		t.Errorf("got %d goroutines, want at most %d", got, before)
+	}
+}
+
+func TestWsSubprotocol(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	tests := []struct {
+		name      string
+		requested []string
+		want      string // negotiated subprotocol
+		wantErr   bool
+	}{
+		{"none", nil, "", false},
+		{"supported", []string{"statsviz.v0", wsSubprotocol}, wsSubprotocol, false},
+		{"unsupported", []string{"statsviz.v0"}, "", true},
+	}
+	for _, tt := range tests {
+		dialer := websocket.Dialer{Subprotocols: tt.requested}
+		ws, resp, err := dialer.Dial(url, nil)
+		if tt.wantErr {
+			if err == nil {
+				ws.Close()
+				t.Errorf("%s: got nil error, want non-nil", tt.name)
+			} else if resp == nil || resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s: got response %v, want status %d", tt.name, resp, http.StatusBadRequest)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := ws.Subprotocol(); got != tt.want {
+			t.Errorf("%s: got subprotocol %q, want %q", tt.name, got, tt.want)
+		}
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := ws.ReadMessage(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		ws.Close()
+	}
+}
+
+func TestWithWebSocketPath(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	if err := Register(mux, WithWebSocketPath("/proxied/statsviz-data/"), SendFrequency(10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/debug/statsviz/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<meta name="statsviz-ws" content="/proxied/statsviz-data" />`; !strings.Contains(string(body), want) {
+		t.Errorf("index page doesn't refer to the websocket path with %s", want)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/proxied/statsviz-data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var st stats
+	if err := ws.ReadJSON(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Seq == 0 {
+		t.Errorf("got stats %+v, want a sequence number", st)
+	}
+
+	// The default path isn't served anymore, the index is.
+	if _, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/debug/statsviz/ws", nil); err == nil {
+		t.Errorf("connected to the default websocket path, want an error")
+	}
+
+	for _, path := range []string{"", "/", "with space", "/debug/statsviz/handshake", "/debug/statsviz/goroutines.txt", "/debug/statsviz/metrics.json"} {
+		if _, err := NewServer(WithWebSocketPath(path)); err == nil {
+			t.Errorf("WithWebSocketPath(%q): got nil error, want non-nil", path)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package statsviz
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// healthWindow is the number of collection intervals after which the stats
+// are stale, see HealthHandler.
+const healthWindow = 3
+
+// HealthHandler returns a handler checking that the server collects stats,
+// for example for a liveness probe. It responds with 200 OK if stats were
+// collected within the last 3 intervals, or with 503 Service Unavailable,
+// explaining for how long they haven't been, otherwise. Stats are only
+// collected while clients are connected, the server is healthy when there's
+// none.
+//
+// Register doesn't mount the handler, and it isn't authenticated, so that it
+// can be mounted where probes expect it.
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		stopped := s.stopped
+		s.mu.Unlock()
+		if stopped {
+			http.Error(w, "statsviz server stopped", http.StatusServiceUnavailable)
+			return
+		}
+
+		if stale, since := s.staleness(); stale {
+			http.Error(w, fmt.Sprintf("statsviz: no stats collected for %v", since), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// staleness reports whether stats haven't been collected for more than
+// healthWindow intervals of the fastest hub, and since how long.
+func (s *Server) staleness() (stale bool, since time.Duration) {
+	now := s.clock.Now()
+	last := time.Unix(0, atomic.LoadInt64(&s.counters.lastSample))
+
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	var freq time.Duration
+	for _, h := range s.hubs {
+		// Hubs haven't collected stats before they start.
+		if h.started.After(last) {
+			last = h.started
+		}
+		if freq == 0 || h.freq < freq {
+			freq = h.freq
+		}
+	}
+	if freq == 0 {
+		return false, 0
+	}
+	since = now.Sub(last)
+	return since > healthWindow*freq, since
+}
@@ -0,0 +1,60 @@
+package statsviz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, err := NewServer(withClock(clk), SendFrequency(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	check := func(want int, body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		srv.HealthHandler().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		if w.Code != want || !strings.Contains(w.Body.String(), body) {
+			t.Errorf("got %d %q, want %d and a body containing %q", w.Code, w.Body.String(), want, body)
+		}
+	}
+
+	// No clients, no stats to collect.
+	check(http.StatusOK, "ok")
+
+	frames, unsubscribe := srv.subscribe(srv.freq)
+	defer unsubscribe()
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+	clk.advance(time.Second)
+	select {
+	case f := <-frames:
+		f.release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a frame")
+	}
+	check(http.StatusOK, "ok")
+
+	// The sampler is stuck.
+	clk.mu.Lock()
+	clk.tickers[0].Stop()
+	clk.mu.Unlock()
+	clk.advance(3 * time.Second)
+	check(http.StatusOK, "ok")
+	clk.advance(time.Millisecond)
+	check(http.StatusServiceUnavailable, "no stats collected for 3.001s")
+
+	srv.Stop()
+	check(http.StatusServiceUnavailable, "stopped")
+}
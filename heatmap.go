@@ -0,0 +1,94 @@
+package statsviz
+
+import (
+	"math"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+// A HeatmapPlot is a user-defined heatmap, showing the distribution over time
+// of the values recorded by a runtime histogram metric, such as GC pauses or
+// scheduling latencies. Each column of the heatmap holds the numbers of values
+// recorded in each bucket over a sampling interval. It's added to the user
+// interface with WithHeatmapPlot.
+type HeatmapPlot struct {
+	// Name identifies the plot, it must be unique.
+	Name string
+
+	// Title is shown on top of the plot.
+	Title string
+
+	// Metric is the name of a runtime/metrics metric of kind
+	// Float64Histogram, for example /gc/pauses:seconds.
+	Metric string
+
+	// MaxBuckets is the maximum number of buckets shown on the heatmap.
+	// Runtime histograms have a lot of buckets, adjacent buckets are merged
+	// so that there are at most MaxBuckets. By default, 30.
+	MaxBuckets int
+
+	// BucketLabel formats the upper bound of a bucket, as shown on the
+	// heatmap. The bound of the last bucket may be +Inf. By default, bounds
+	// of metrics in seconds are shown as durations, others as numbers.
+	BucketLabel func(upper float64) string
+}
+
+// WithHeatmapPlot adds a user-defined heatmap plot to the user interface. The
+// heatmap is shown, and sent, along with built-in runtime plots.
+func WithHeatmapPlot(p HeatmapPlot) OptionFunc {
+	return func(s *Server) error {
+		if p.Name == "" {
+			return configError("WithHeatmapPlot", "plot name can't be empty")
+		}
+		for i := range s.runtimePlots {
+			if s.runtimePlots[i].name == p.Name {
+				return configError("WithHeatmapPlot", "duplicate plot name %q", p.Name)
+			}
+		}
+		if err := checkPlots([]Plot{Plot(p.Name)}); err == nil {
+			return configError("WithHeatmapPlot", "plot name %q is reserved for a built-in plot", p.Name)
+		}
+		if p.MaxBuckets < 0 {
+			return configError("WithHeatmapPlot", "plot %q: max buckets must be positive", p.Name)
+		}
+		if !isHistogram(p.Metric) {
+			return configError("WithHeatmapPlot", "plot %q: %q is not a histogram runtime metric supported by this Go version", p.Name, p.Metric)
+		}
+
+		label := p.BucketLabel
+		if label == nil {
+			label = numberLabel
+			if strings.HasSuffix(p.Metric, ":seconds") {
+				label = secondsLabel
+			}
+		}
+		hm := &runtimeHeatmap{metric: p.Metric, maxBuckets: p.MaxBuckets, label: label}
+		hm.init()
+
+		s.runtimePlots = append(s.runtimePlots, runtimePlot{
+			name:    p.Name,
+			title:   p.Title,
+			heatmap: hm,
+		})
+		return nil
+	}
+}
+
+// isHistogram reports whether name is a Float64Histogram runtime metric.
+func isHistogram(name string) bool {
+	for _, d := range metrics.All() {
+		if d.Name == name {
+			return d.Kind == metrics.KindFloat64Histogram
+		}
+	}
+	return false
+}
+
+// numberLabel formats a bucket boundary as a number.
+func numberLabel(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
@@ -0,0 +1,106 @@
+package statsviz
+
+import (
+	"math"
+	"runtime"
+	"testing"
+)
+
+func TestWithHeatmapPlot(t *testing.T) {
+	t.Parallel()
+
+	const metric = "/gc/pauses:seconds"
+	if !isHistogram(metric) {
+		t.Skipf("%s not supported by this Go version", metric)
+	}
+
+	s, err := NewServer(WithHeatmapPlot(HeatmapPlot{
+		Name:       "gc-pauses",
+		Title:      "GC pauses",
+		Metric:     metric,
+		MaxBuckets: 10,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	var cfg *PlotConfig
+	cfgs := s.handshake().RuntimePlots
+	for i := range cfgs {
+		if cfgs[i].Name == "gc-pauses" {
+			cfg = &cfgs[i]
+		}
+	}
+	if cfg == nil {
+		t.Fatal("heatmap plot is not in the handshake")
+	}
+	if cfg.Type != "heatmap" {
+		t.Errorf("got plot type %q, want heatmap", cfg.Type)
+	}
+	nbuckets := len(cfg.Series)
+	if nbuckets == 0 || nbuckets > 10 {
+		t.Fatalf("got %d buckets, want between 1 and 10", nbuckets)
+	}
+	// The histogram last bucket is unbounded.
+	if last := cfg.Series[nbuckets-1].Name; last != "+Inf" {
+		t.Errorf("got last bucket %q, want +Inf", last)
+	}
+
+	st := newStats()
+	smp := s.newSampler()
+	s.collect(smp, &st)
+	runtime.GC()
+	s.collect(smp, &st)
+
+	vals := st.RuntimePlots["gc-pauses"]
+	if len(vals) != nbuckets {
+		t.Fatalf("got %d bucket counts, want %d", len(vals), nbuckets)
+	}
+	total := 0.0
+	for _, v := range vals {
+		if math.IsNaN(v) || v < 0 {
+			t.Fatalf("got bucket counts %v, want positive counts", vals)
+		}
+		total += v
+	}
+	// runtime.GC stops the world at least once.
+	if total < 1 {
+		t.Errorf("got %v GC pauses since runtime.GC(), want some", total)
+	}
+}
+
+func TestWithHeatmapPlotErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		plot HeatmapPlot
+	}{
+		{"empty name", HeatmapPlot{Metric: "/gc/pauses:seconds"}},
+		{"built-in name", HeatmapPlot{Name: string(PlotHeap), Metric: "/gc/pauses:seconds"}},
+		{"unknown metric", HeatmapPlot{Name: "plot", Metric: "/does/not/exist:seconds"}},
+		{"scalar metric", HeatmapPlot{Name: "plot", Metric: "/sched/goroutines:goroutines"}},
+		{"negative max buckets", HeatmapPlot{Name: "plot", Metric: "/gc/pauses:seconds", MaxBuckets: -1}},
+	}
+	for _, tt := range tests {
+		if _, err := NewServer(WithHeatmapPlot(tt.plot)); err == nil {
+			t.Errorf("%s: got nil error, want non-nil", tt.name)
+		}
+	}
+
+	p := HeatmapPlot{Name: "plot", Metric: "/gc/pauses:seconds"}
+	if _, err := NewServer(WithHeatmapPlot(p), WithHeatmapPlot(p)); err == nil {
+		t.Errorf("duplicate name: got nil error, want non-nil")
+	}
+}
+
+func TestNumberLabel(t *testing.T) {
+	t.Parallel()
+
+	for v, want := range map[float64]string{1: "1", 1024: "1024", 1e6: "1e+06", math.Inf(1): "+Inf"} {
+		if got := numberLabel(v); got != want {
+			t.Errorf("numberLabel(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package statsviz
+
+import (
+	"time"
+)
+
+// WithHistogramInterval sets the interval at which histograms, shown by
+// heatmap plots, are sampled and sent, while the other stats are at the send
+// frequency. Histograms being the largest part of a frame, sending them less
+// often saves bandwidth and encoding time. Frames without fresh histograms
+// omit them, the user interface keeps showing the last ones. Each histogram
+// then holds the values recorded over the whole interval.
+//
+// Histograms are sampled once per interval, on the first frame collected in
+// each interval, intervals being aligned on the wall clock. 0, the default,
+// samples histograms with every frame.
+func WithHistogramInterval(d time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if d < 0 {
+			return configError("WithHistogramInterval", "histogram interval must be positive or zero, got %v", d)
+		}
+		s.histInterval = d
+		return nil
+	}
+}
+
+// sampleHistograms reports whether histograms must be sampled at now, by
+// smp, see WithHistogramInterval.
+func (s *Server) sampleHistograms(smp *sampler, now time.Time) bool {
+	if s.histInterval == 0 {
+		return true
+	}
+	if !smp.histTime.IsZero() && now.Truncate(s.histInterval).Equal(smp.histTime.Truncate(s.histInterval)) {
+		return false
+	}
+	smp.histTime = now
+	return true
+}
@@ -0,0 +1,42 @@
+package statsviz
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithHistogramInterval(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Unix(1000, 0))
+	s, err := NewServer(withClock(clk), WithHistogramInterval(5*time.Second), WithPlots(PlotSchedLatencies, PlotMutexWait))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) != 2 {
+		t.Skip("runtime metrics not supported by this Go version")
+	}
+
+	smp := s.newSampler()
+	st := newStats()
+	for i := 0; i <= 12; i++ {
+		s.collect(smp, &st)
+		_, hist := st.RuntimePlots[string(PlotSchedLatencies)]
+		if want := i%5 == 0; hist != want {
+			t.Errorf("tick %d: got histogram %t, want %t", i, hist, want)
+		}
+		if _, ok := st.RuntimePlots[string(PlotMutexWait)]; !ok || len(st.Metrics) == 0 {
+			t.Errorf("tick %d: got runtime plots %v and %d metrics, want scalars", i, st.RuntimePlots, len(st.Metrics))
+		}
+		clk.advance(time.Second)
+	}
+}
+
+func TestWithHistogramIntervalInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithHistogramInterval(-time.Second)); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+}
@@ -0,0 +1,277 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// history is a fixed-size ring buffer holding the most recently collected
+// stats. It's safe for concurrent use.
+type history struct {
+	mu    sync.Mutex
+	buf   []stats
+	start int // index of the oldest stats
+	len   int // number of stats in the buffer
+
+	// With a byte budget, maxBytes is the maximum estimated size of the
+	// stats in the buffer, bytes their current estimated size and sizes the
+	// estimated size of each of them. The buffer is allocated by the first
+	// push, see newHistoryBytes.
+	maxBytes, bytes int
+	sizes           []int
+
+	// windows holds the summaries of the scalar metrics of the stats in the
+	// buffer, indexed by metric name.
+	windows map[string]*window
+
+	// dropped is the highest sequence number of the stats evicted from the
+	// buffer, or discarded by a reset.
+	dropped uint64
+}
+
+func newHistory(size int) *history {
+	return &history{buf: make([]stats, size), windows: make(map[string]*window)}
+}
+
+// newHistoryBytes returns a history holding the most recent stats which
+// estimated size is at most maxBytes, see statsSize. Since the buffer can't
+// grow, it's sized by the first push for as many stats of the same size as fit
+// in maxBytes. The most recent stats are always kept, even if larger.
+func newHistoryBytes(maxBytes int) *history {
+	return &history{maxBytes: maxBytes, windows: make(map[string]*window)}
+}
+
+// push adds st to the history, evicting the oldest stats if the buffer is
+// full, or if the stats exceed the byte budget.
+func (h *history) push(st stats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var size int
+	if h.maxBytes > 0 {
+		size = statsSize(&st)
+		if h.buf == nil {
+			n := h.maxBytes / size
+			if n < 1 {
+				n = 1
+			}
+			h.buf, h.sizes = make([]stats, n), make([]int, n)
+		}
+	}
+
+	h.pushWindows(st.Metrics, st.UserMetrics)
+
+	if h.len == len(h.buf) {
+		h.evict()
+	}
+	i := (h.start + h.len) % len(h.buf)
+	h.buf[i] = st
+	h.len++
+	if h.maxBytes > 0 {
+		h.sizes[i] = size
+		h.bytes += size
+		for h.bytes > h.maxBytes && h.len > 1 {
+			h.evict()
+		}
+	}
+}
+
+// evict evicts the oldest stats from the buffer, which mustn't be empty.
+func (h *history) evict() {
+	h.dropped = h.buf[h.start].Seq
+	h.buf[h.start] = stats{}
+	if h.maxBytes > 0 {
+		h.bytes -= h.sizes[h.start]
+		h.sizes[h.start] = 0
+	}
+	h.start = (h.start + 1) % len(h.buf)
+	h.len--
+}
+
+// Estimated sizes, in bytes, of the parts of stats held in history. A map
+// entry takes its key and value, and, including the map buckets overhead,
+// about as much again.
+const (
+	statsStructSize = int(unsafe.Sizeof(stats{}))
+	memStatsSize    = int(unsafe.Sizeof(runtime.MemStats{}))
+	gcEventSize     = int(unsafe.Sizeof(gcEvent{}))
+	floatEntrySize  = 2 * (int(unsafe.Sizeof("")) + 8)
+	plotEntrySize   = 2 * (int(unsafe.Sizeof("")) + int(unsafe.Sizeof(plotValues(nil))))
+)
+
+// statsSize returns the estimated memory used by st, as held in history.
+func statsSize(st *stats) int {
+	size := statsStructSize
+	if st.Mem != nil {
+		size += memStatsSize
+	}
+	if st.GC != nil {
+		size += gcEventSize
+	}
+	for _, m := range []map[string]float64{st.Metrics, st.UserMetrics} {
+		for name := range m {
+			size += floatEntrySize + len(name)
+		}
+	}
+	for _, m := range []map[string]plotValues{st.UserPlots, st.RuntimePlots} {
+		for name, vals := range m {
+			size += plotEntrySize + len(name) + 8*cap(vals)
+		}
+	}
+	return size
+}
+
+// reset empties the history.
+func (h *history) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.len > 0 {
+		h.dropped = h.buf[(h.start+h.len-1)%len(h.buf)].Seq
+	}
+	for i := range h.buf {
+		h.buf[i] = stats{}
+	}
+	for i := range h.sizes {
+		h.sizes[i] = 0
+	}
+	h.start, h.len, h.bytes = 0, 0, 0
+	h.windows = make(map[string]*window)
+}
+
+// pushWindows pushes the values of the runtime and user metrics into their
+// window. Metrics missing from both maps get a NaN value, and their window is
+// removed once it only holds NaN values.
+func (h *history) pushWindows(runtime, user map[string]float64) {
+	for _, m := range []map[string]float64{runtime, user} {
+		for name, v := range m {
+			w, ok := h.windows[name]
+			if !ok {
+				w = newWindow(len(h.buf))
+				h.windows[name] = w
+			}
+			w.push(v)
+		}
+	}
+	for name, w := range h.windows {
+		_, inRuntime := runtime[name]
+		_, inUser := user[name]
+		if inRuntime || inUser {
+			continue
+		}
+		w.push(math.NaN())
+		if w.count == 0 {
+			delete(h.windows, name)
+		}
+	}
+}
+
+// summaries stores the summaries of the metrics in the history into sums,
+// which is allocated if nil, and returns it. It returns nil if the history is
+// empty.
+func (h *history) summaries(sums map[string]summary) map[string]summary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.windows) == 0 {
+		return nil
+	}
+	if sums == nil {
+		sums = make(map[string]summary, len(h.windows))
+	}
+	for name := range sums {
+		if _, ok := h.windows[name]; !ok {
+			delete(sums, name)
+		}
+	}
+	for name, w := range h.windows {
+		if s, ok := w.summary(); ok {
+			sums[name] = s
+		}
+	}
+	return sums
+}
+
+// after returns a copy of the stats in history collected after the stats with
+// sequence number seq, from oldest to newest. ok is false if some of them have
+// been evicted, or discarded by a reset.
+func (h *history) after(seq uint64) (all []stats, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if seq < h.dropped {
+		return nil, false
+	}
+	for i := 0; i < h.len; i++ {
+		if st := h.buf[(h.start+i)%len(h.buf)]; st.Seq > seq {
+			all = append(all, st)
+		}
+	}
+	return all, true
+}
+
+// snapshot returns a copy of the stats currently in the history, from oldest
+// to newest.
+func (h *history) snapshot() []stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	all := make([]stats, h.len)
+	for i := range all {
+		all[i] = h.buf[(h.start+i)%len(h.buf)]
+	}
+	return all
+}
+
+// recordHistory pushes the stats collected at the server send frequency, or
+// at the idle history interval if no client is connected, into the server
+// history, until the server is stopped.
+func (s *Server) recordHistory() {
+	record := func(st *stats) {
+		c := st.clone()
+		// Only needed by live stats.
+		c.Summary = nil
+		c.TopAllocators = nil
+		s.history.push(c)
+	}
+	unsubscribe := func() {}
+	for {
+		// Subscribe at the new frequency before unsubscribing, so that
+		// there's no gap in the history.
+		freq := s.historyFrequency()
+		next := s.subscribeFunc(freq, record)
+		unsubscribe()
+		unsubscribe = next
+		for freq == s.historyFrequency() {
+			select {
+			case <-s.done:
+				unsubscribe()
+				return
+			case <-s.clientsChanged:
+			}
+		}
+	}
+}
+
+// ResetHistory empties the server history and tells connected clients to
+// clear their plots, which is useful to get a clean baseline, for example at
+// the start of a load test. Clients can also request a reset from the user
+// interface.
+func (s *Server) ResetHistory() {
+	resetMsg, _ := json.Marshal(controlMsg{Type: "reset"})
+
+	// Holding hubsMu, hubs can't broadcast stats, so stats broadcast before
+	// the reset are neither kept in history nor sent to clients after it.
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	if s.history != nil {
+		s.history.reset()
+	}
+	for _, h := range s.hubs {
+		h.sendControl(resetMsg)
+	}
+}
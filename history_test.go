@@ -0,0 +1,440 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	h := newHistory(3)
+	if got := len(h.snapshot()); got != 0 {
+		t.Fatalf("len(snapshot()) = %d, want 0", got)
+	}
+
+	for i := 1; i <= 5; i++ {
+		h.push(stats{NumGoroutine: i})
+	}
+
+	all := h.snapshot()
+	if len(all) != 3 {
+		t.Fatalf("len(snapshot()) = %d, want 3", len(all))
+	}
+	for i, st := range all {
+		if want := i + 3; st.NumGoroutine != want {
+			t.Errorf("snapshot()[%d] = %d, want %d", i, st.NumGoroutine, want)
+		}
+	}
+}
+
+func TestHistoryBackfill(t *testing.T) {
+	t.Parallel()
+
+	const histSize = 5
+
+	mux := http.NewServeMux()
+	err := Register(mux, SendFrequency(10*time.Millisecond), WithHistorySize(histSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Give the history the time to fill up.
+	time.Sleep(20 * histSize * time.Millisecond)
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/debug/statsviz/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	var prev time.Time
+	for i := 0; i <= histSize; i++ {
+		_, p, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var st stats
+		if err := json.Unmarshal(p, &st); err != nil {
+			t.Fatal(err)
+		}
+
+		// The first histSize messages are historical, then live ones follow.
+		if want := i < histSize; st.Historical != want {
+			t.Errorf("message %d: Historical = %t, want %t", i, st.Historical, want)
+		}
+		if !st.Time.After(prev) {
+			t.Errorf("message %d: time %v should be after %v", i, st.Time, prev)
+		}
+		prev = st.Time
+	}
+}
+
+func TestWithHistorySizeNegative(t *testing.T) {
+	t.Parallel()
+
+	if err := Register(http.NewServeMux(), WithHistorySize(-1)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestHistoryBytes(t *testing.T) {
+	t.Parallel()
+
+	// Stats with a plot which series grow from the 50th stats on.
+	sample := func(seq int) stats {
+		st := stats{Seq: uint64(seq), Metrics: make(map[string]float64)}
+		for i := 0; i < 20; i++ {
+			st.Metrics["/metric/"+strconv.Itoa(i)+":bytes"] = float64(seq)
+		}
+		n := 4
+		if seq >= 50 {
+			n = 40
+		}
+		st.RuntimePlots = map[string]plotValues{"heatmap": make(plotValues, n)}
+		return st
+	}
+
+	const budget = 10000
+	h := newHistoryBytes(budget)
+	for seq := 1; seq <= 100; seq++ {
+		h.push(sample(seq))
+
+		all := h.snapshot()
+		size := 0
+		for i := range all {
+			size += statsSize(&all[i])
+		}
+		if size > budget || size != h.bytes {
+			t.Fatalf("seq %d: got %d bytes (%d tracked) for %d stats, want at most %d", seq, size, h.bytes, len(all), budget)
+		}
+		// The oldest stats are evicted.
+		if last := all[len(all)-1].Seq; last != uint64(seq) || all[0].Seq != last-uint64(len(all)-1) {
+			t.Fatalf("seq %d: got stats %d to %d, want the most recent ones", seq, all[0].Seq, last)
+		}
+	}
+
+	first, last := sample(1), sample(50)
+	small, large := statsSize(&first), statsSize(&last)
+	if got, want := len(h.buf), budget/small; got != want {
+		t.Errorf("got a buffer of %d stats, want %d", got, want)
+	}
+	if got, want := len(h.snapshot()), budget/large; got != want {
+		t.Errorf("got %d large stats, want %d", got, want)
+	}
+
+	h.reset()
+	if h.bytes != 0 || len(h.snapshot()) != 0 {
+		t.Errorf("got %d bytes and %d stats after reset, want none", h.bytes, len(h.snapshot()))
+	}
+}
+
+func TestWithHistoryBytesInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, opts := range [][]OptionFunc{
+		{WithHistoryBytes(0)},
+		{WithHistoryBytes(1 << 20), WithHistorySize(10)},
+	} {
+		if err := Register(http.NewServeMux(), opts...); err == nil {
+			t.Errorf("got nil error, want non-nil")
+		}
+	}
+}
+
+func TestResetHistory(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(time.Millisecond), WithHistorySize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// Wait for the history to fill up while stats keep being recorded.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.history.snapshot()) < 10 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the history to fill up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Reset while stats are being recorded, for the race detector.
+	for i := 0; i < 10; i++ {
+		srv.ResetHistory()
+		time.Sleep(time.Millisecond)
+	}
+
+	// Stop the recording to check the reset itself.
+	srv.Stop()
+	srv.ResetHistory()
+	if got := len(srv.history.snapshot()); got != 0 {
+		t.Errorf("got %d stats in history after reset, want 0", got)
+	}
+}
+
+func TestWsReset(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(10*time.Millisecond), WithHistorySize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// Wait for live stats, the connection is then subscribed to reset
+	// messages.
+	for {
+		var st stats
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := ws.ReadJSON(&st); err != nil {
+			t.Fatal(err)
+		}
+		if !st.Historical {
+			break
+		}
+	}
+
+	if err := ws.WriteJSON(controlMsg{Type: "reset"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client is told to reset, then stats keep coming.
+	reset := readControl(t, ws)
+	if reset.Type != "reset" {
+		t.Fatalf("got control message %+v, want a reset", reset)
+	}
+	for i := 0; i < 3; i++ {
+		var st stats
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := ws.ReadJSON(&st); err != nil {
+			t.Fatal(err)
+		}
+		if st.Historical {
+			t.Errorf("got historical stats after reset")
+		}
+	}
+}
+
+func TestHistorySummaries(t *testing.T) {
+	t.Parallel()
+
+	h := newHistory(3)
+	if sums := h.summaries(nil); sums != nil {
+		t.Fatalf("got summaries %v of an empty history, want nil", sums)
+	}
+
+	push := func(goroutines, queue float64) {
+		st := stats{Metrics: map[string]float64{"/sched/goroutines:goroutines": goroutines}}
+		if !math.IsNaN(queue) {
+			st.UserMetrics = map[string]float64{"queue": queue}
+		}
+		h.push(st)
+	}
+	push(10, 1)
+	push(20, 2)
+	push(30, math.NaN()) // the queue metric is missing
+	push(60, math.NaN())
+
+	sums := h.summaries(nil)
+	if got, want := sums["/sched/goroutines:goroutines"], (summary{Min: 20, Max: 60, Avg: 110.0 / 3}); got != want {
+		t.Errorf("got goroutines summary %+v, want %+v", got, want)
+	}
+	if got, want := sums["queue"], (summary{Min: 2, Max: 2, Avg: 2}); got != want {
+		t.Errorf("got queue summary %+v, want %+v", got, want)
+	}
+
+	// Once the queue metric has aged out of the history, it has no summary.
+	push(60, math.NaN())
+	if sums = h.summaries(sums); len(sums) != 1 {
+		t.Errorf("got summaries %v, want only the goroutines one", sums)
+	}
+
+	h.reset()
+	if sums := h.summaries(nil); sums != nil {
+		t.Errorf("got summaries %v after a reset, want nil", sums)
+	}
+}
+
+func TestHubSummaries(t *testing.T) {
+	t.Parallel()
+
+	h, smp := newTestHub(t, WithHistorySize(10))
+	h.s.history.push(stats{Metrics: map[string]float64{"/sched/goroutines:goroutines": 1}})
+
+	h.tick(smp)
+	if _, ok := h.stats.Summary["/sched/goroutines:goroutines"]; !ok {
+		t.Errorf("got summaries %v, want the goroutines one", h.stats.Summary)
+	}
+}
+
+// resumeServer returns a server keeping a history of histSize stats,
+// collected at each tick of clk, and its websocket URL.
+func resumeServer(t *testing.T, clk *fakeClock, histSize int) (*Server, string) {
+	t.Helper()
+
+	srv, err := NewServer(withClock(clk), WithHistorySize(histSize), WithPlots(PlotGoroutines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+	ts := httptest.NewServer(srv.Ws())
+	t.Cleanup(ts.Close)
+
+	// Wait for the history hub to start its ticker.
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+	return srv, "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+// collectHistory advances clk n times by a second, waiting for each collected
+// stats to be recorded in the server history.
+func collectHistory(t *testing.T, srv *Server, clk *fakeClock, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		want := srv.history.snapshot()
+		clk.advance(time.Second)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got := srv.history.snapshot()
+			if len(got) > 0 && (len(want) == 0 || got[len(got)-1].Seq != want[len(want)-1].Seq) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timeout waiting for stats to be recorded")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// readStats reads stats from ws, failing the test on control messages.
+func readStats(t *testing.T, ws *websocket.Conn) stats {
+	t.Helper()
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st stats
+	if err := json.Unmarshal(msg, &st); err != nil || st.GoVersion == "" {
+		t.Fatalf("got message %s, want stats", msg)
+	}
+	return st
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, url := resumeServer(t, clk, 10)
+	collectHistory(t, srv, clk, 2)
+
+	// The first connection gets the whole history.
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readStats(t, ws)
+	last := readStats(t, ws)
+	if !last.Historical || last.Seq == 0 {
+		t.Fatalf("got stats %+v, want historical stats with a sequence number", last)
+	}
+	ws.Close()
+
+	// Stats collected while the client is disconnected.
+	collectHistory(t, srv, clk, 3)
+
+	ws, _, err = websocket.DefaultDialer.Dial(url+"?resume", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := ws.WriteJSON(controlMsg{Type: "resume", Since: last.Seq}); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := last.Seq
+	for i := 0; i < 3; i++ {
+		st := readStats(t, ws)
+		if !st.Historical || st.Seq <= prev {
+			t.Fatalf("missed stats #%d: got seq %d (historical=%t), want historical stats after %d", i, st.Seq, st.Historical, prev)
+		}
+		prev = st.Seq
+	}
+
+	// Then live stats follow, once the client has subscribed, along with the
+	// history recorder.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		srv.hubsMu.Lock()
+		n := len(srv.hubs[time.Second].subs)
+		srv.hubsMu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the client to subscribe")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clk.advance(time.Second)
+	if st := readStats(t, ws); st.Historical || st.Seq <= prev {
+		t.Errorf("got seq %d (historical=%t), want live stats after %d", st.Seq, st.Historical, prev)
+	}
+}
+
+func TestResumeAgedOut(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, url := resumeServer(t, clk, 3)
+	collectHistory(t, srv, clk, 5)
+
+	ws, _, err := websocket.DefaultDialer.Dial(url+"?resume", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := ws.WriteJSON(controlMsg{Type: "resume", Since: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client is told to reset, then gets the whole history.
+	if msg := readControl(t, ws); msg.Type != "reset" {
+		t.Fatalf("got control message %+v, want a reset", msg)
+	}
+	for i := 0; i < 3; i++ {
+		if st := readStats(t, ws); !st.Historical {
+			t.Fatalf("got stats #%d %+v, want historical stats", i, st)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package statsviz
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// subscriberBufferSize is the default number of frames buffered for each
+// subscriber of a hub. Once its buffer is full, the subscriber slow client
+// policy applies.
+const subscriberBufferSize = 4
+
+// A frame holds encoded stats, shared between all subscribers of a hub.
+// Frames are pooled: a subscriber must call release once it's done with a
+// frame, after which the frame must not be used anymore.
+type frame struct {
+	buf    []byte
+	binary bool // binary encoded stats, see EncodingKind.binary
+	refs   int32
+}
+
+var framePool = sync.Pool{
+	New: func() interface{} { return new(frame) },
+}
+
+// bytes returns the encoded stats, or control message.
+func (f *frame) bytes() []byte {
+	return f.buf
+}
+
+// release gives up the caller reference to the frame, which returns to the
+// pool once all references have been released.
+func (f *frame) release() {
+	if atomic.AddInt32(&f.refs, -1) == 0 {
+		framePool.Put(f)
+	}
+}
+
+// A subscriber receives the frames collected by a hub, either on ch, or
+// synchronously, via fn, if ch is nil. With the Disconnect policy, ch is
+// closed if it's full when a frame is collected.
+type subscriber struct {
+	ch     chan *frame
+	fn     func(*stats)
+	policy SlowClientPolicy
+}
+
+// A hub collects stats at a given frequency and sends them to all its
+// subscribers, so that stats are only collected and encoded once, whatever
+// the number of clients. A server has one hub per send frequency in use, so
+// one in general since clients use the server frequency unless they request
+// another one.
+type hub struct {
+	s    *Server
+	freq time.Duration
+	subs map[*subscriber]struct{} // guarded by s.hubsMu
+	quit chan struct{}            // closed when the last subscriber leaves
+
+	started time.Time // see staleness
+
+	// Only used by the hub goroutine, reused from one tick to the other to
+	// limit allocations.
+	stats stats
+	buf   bytes.Buffer
+	enc   *json.Encoder
+	mbuf  []byte        // binary encoded stats
+	out   []byte        // last encoded stats, either in buf or mbuf
+	vals  compactValues // compact metrics values, see WithCompactMetrics
+	last  time.Time     // time of the last collection, see overrun
+
+	// With an adaptive frequency, interval is the current interval between
+	// collections, and busyTicks and idleTicks count the consecutive slow
+	// and fast collections. See adapt.
+	interval             time.Duration
+	busyTicks, idleTicks int
+
+	// With delta frames, delta tracks the metrics values sent to clients.
+	// full is set, atomically, when the next frame must hold all of them.
+	delta   scalarDelta
+	changed map[string]float64 // metrics of the current frame
+	full    int32
+
+	// With varint deltas, varint tracks the metrics values sent to clients
+	// and vm holds those of the current frame.
+	varint varintDelta
+	vm     *varintMetrics
+
+	// With a float precision, the rounded metrics values of the current
+	// frame.
+	rounded, roundedUser map[string]float64
+}
+
+// subscribe returns a channel receiving the frames collected at the given
+// frequency, and the function to call to unsubscribe, after which the
+// channel doesn't receive frames anymore. The channel is buffered and subject
+// to the server slow client policy, it's closed if the policy disconnects the
+// subscriber.
+func (s *Server) subscribe(freq time.Duration) (frames <-chan *frame, unsubscribe func()) {
+	sub := &subscriber{ch: make(chan *frame, s.clientBuffer), policy: s.slowClients}
+	return sub.ch, s.addSubscriber(freq, sub)
+}
+
+// subscribeFunc arranges for fn to be called with the stats collected at the
+// given frequency. fn is called from the hub goroutine, it must not retain
+// stats. subscribeFunc returns the function to call to unsubscribe.
+func (s *Server) subscribeFunc(freq time.Duration, fn func(*stats)) (unsubscribe func()) {
+	return s.addSubscriber(freq, &subscriber{fn: fn})
+}
+
+func (s *Server) addSubscriber(freq time.Duration, sub *subscriber) (unsubscribe func()) {
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	h, ok := s.hubs[freq]
+	if !ok {
+		h = &hub{
+			s:     s,
+			freq:  freq,
+			subs:  make(map[*subscriber]struct{}),
+			quit:  make(chan struct{}),
+			stats: newStats(),
+
+			started: s.clock.Now(),
+		}
+		if s.adaptive != nil {
+			h.interval = s.adaptive.clamp(freq)
+		}
+		h.enc = json.NewEncoder(&h.buf)
+		s.hubs[freq] = h
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			h.run()
+		}()
+	}
+	h.subs[sub] = struct{}{}
+	if sub.ch != nil {
+		// The new client needs all metrics values.
+		h.sendFull()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.hubsMu.Lock()
+			defer s.hubsMu.Unlock()
+
+			h.remove(sub)
+		})
+	}
+}
+
+// remove removes sub from the hub subscribers, if it's still subscribed, the
+// hub stops once it has no subscribers anymore. Must be called with s.hubsMu
+// held.
+func (h *hub) remove(sub *subscriber) {
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	if len(h.subs) == 0 {
+		delete(h.s.hubs, h.freq)
+		close(h.quit)
+	}
+}
+
+// run collects stats at the hub frequency until the hub has no subscribers
+// anymore or the server is stopped.
+func (h *hub) run() {
+	if h.s.pinnedSampler {
+		h.runPinned()
+		return
+	}
+
+	tick := h.s.clock.NewTicker(h.period())
+	defer func() { tick.Stop() }()
+
+	smp := h.s.newSampler()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-h.s.done:
+			h.stop(smp)
+			return
+		case <-tick.C():
+		}
+
+		start := h.s.clock.Now()
+		h.tick(smp)
+		if h.adapt(h.s.clock.Now().Sub(start)) {
+			tick.Stop()
+			tick = h.s.clock.NewTicker(h.period())
+		}
+	}
+}
+
+// tick collects and encodes stats once and broadcasts them.
+func (h *hub) tick(smp *sampler) {
+	h.collect(smp)
+	overrun := h.overrun(h.stats.Time)
+	h.stats.Metrics[samplerOverrunMetric] = overrun
+	if overrun > 0 && h.stats.GC != nil {
+		// A pause of the collections likely delayed the sampler.
+		h.stats.GC.Gap = overrun
+	}
+	h.stats.Seq = atomic.AddUint64(&h.s.seq, 1)
+	atomic.StoreInt64(&h.s.counters.lastSample, h.stats.Time.UnixNano())
+	if h.s.history != nil {
+		h.stats.Summary = h.s.history.summaries(h.stats.Summary)
+	}
+	if h.s.topAllocs != nil {
+		h.stats.TopAllocators = h.s.topAllocs.load()
+	}
+	h.stats.Truncated = false
+	if h.interval != 0 {
+		h.stats.Interval = h.interval.Seconds()
+	}
+	if h.s.delta {
+		h.changed = h.delta.diff(h.stats.Metrics, atomic.SwapInt32(&h.full, 0) != 0)
+	}
+	if h.s.keyframes > 0 {
+		c := h.s.compactStats(&h.stats, h.vals)
+		h.vals = c.MetricValues
+		h.vm = h.varint.next(h.vals, h.s.keyframes, atomic.SwapInt32(&h.full, 0) != 0)
+	}
+	if err := h.tracedEncode(); err != nil {
+		h.s.logger.Error("statsviz: can't encode stats", "error", err)
+		return
+	}
+	atomic.StoreInt64(&h.s.frameBytes, int64(len(h.out)))
+
+	if max := h.s.maxFrameBytes; max > 0 {
+		// Drop user plots, last added first, until the frame fits.
+		plots := h.s.plots()
+		for i := len(plots) - 1; i >= 0 && len(h.out) > max; i-- {
+			name := plots[i].Name
+			if _, ok := h.stats.UserPlots[name]; !ok {
+				continue
+			}
+			delete(h.stats.UserPlots, name)
+			h.stats.Truncated = true
+			if err := h.tracedEncode(); err != nil {
+				h.s.logger.Error("statsviz: can't encode stats", "error", err)
+				return
+			}
+		}
+	}
+	h.broadcast()
+}
+
+// encode encodes h.stats into h.out, with the server encoding.
+func (h *hub) encode() error {
+	st := &h.stats
+	if h.s.delta {
+		c := *st
+		c.Metrics = h.changed
+		st = &c
+	}
+
+	if h.s.encoding == EncodingProtobuf {
+		h.mbuf = h.s.appendProtobuf(h.mbuf[:0], st, h.vm)
+		h.out = h.mbuf
+		return nil
+	}
+
+	if h.s.precision > 0 && h.s.encoding == EncodingJSON {
+		c := h.s.roundStats(st, h.rounded, h.roundedUser)
+		h.rounded, h.roundedUser = c.Metrics, c.UserMetrics
+		st = &c
+	}
+
+	if h.s.compact {
+		c := h.s.compactStats(st, h.vals)
+		h.vals = c.MetricValues
+		st = &c
+	}
+
+	if h.s.encoding == EncodingMsgpack {
+		var err error
+		h.mbuf, err = appendMsgpack(h.mbuf[:0], st)
+		h.out = h.mbuf
+		return err
+	}
+
+	h.buf.Reset()
+	if err := h.enc.Encode(st); err != nil {
+		return err
+	}
+	// Strip the newline added by the encoder.
+	h.out = h.buf.Bytes()[:h.buf.Len()-1]
+	return nil
+}
+
+// sendControl sends msg to all connected clients.
+func (s *Server) sendControl(msg controlMsg) {
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+	s.sendControlLocked(msg)
+}
+
+// sendControlLocked is like sendControl, s.hubsMu must be held.
+func (s *Server) sendControlLocked(msg controlMsg) {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	for _, h := range s.hubs {
+		h.sendControl(buf)
+	}
+}
+
+// FrameBytes returns the size, in bytes, of the last stats frame collected
+// by the server, before any truncation by WithMaxFrameBytes. It's 0 until
+// stats are first collected.
+func (s *Server) FrameBytes() int {
+	return int(atomic.LoadInt64(&s.frameBytes))
+}
+
+// broadcast sends the last collected stats to all subscribers. Slow
+// subscribers, which buffer is full, miss them, so that they don't block the
+// others.
+func (h *hub) broadcast() {
+	f := framePool.Get().(*frame)
+	f.buf = append(f.buf[:0], h.out...)
+	f.binary = h.s.encoding.binary()
+	f.refs = 1 // our own reference, released below
+
+	h.s.hubsMu.Lock()
+	defer h.s.hubsMu.Unlock()
+
+	for sub := range h.subs {
+		if sub.ch == nil {
+			sub.fn(&h.stats)
+			continue
+		}
+		h.offer(sub, f)
+	}
+	f.release()
+}
+
+// stop collects and broadcasts a last frame, once the server is stopped, then
+// unsubscribes all subscribers, closing their channels so that they know no
+// frame follows.
+func (h *hub) stop(smp *sampler) {
+	h.sendFull()
+	h.tick(smp)
+
+	h.s.hubsMu.Lock()
+	defer h.s.hubsMu.Unlock()
+	for sub := range h.subs {
+		h.remove(sub)
+		if sub.ch != nil {
+			close(sub.ch)
+		}
+	}
+}
+
+// sendControl discards the frames pending for each subscriber, which have
+// been collected before a reset or a change of plots, and sends them msg
+// instead. Must be called with s.hubsMu held, since frames are only sent on
+// subscribers channels with s.hubsMu held, there's then room for msg once the
+// channel is drained.
+func (h *hub) sendControl(msg []byte) {
+	for sub := range h.subs {
+		if sub.ch == nil {
+			continue
+		}
+	drain:
+		for {
+			select {
+			case f := <-sub.ch:
+				f.release()
+			default:
+				break drain
+			}
+		}
+		f := framePool.Get().(*frame)
+		f.buf = append(f.buf[:0], msg...)
+		f.binary = false
+		f.refs = 1
+		sub.ch <- f
+	}
+	// Discarded frames may have held changed metrics values.
+	h.sendFull()
+}
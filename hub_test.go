@@ -0,0 +1,257 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"runtime/metrics"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHubSharedBetweenClients(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(20 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http")
+	var clients [2]*websocket.Conn
+	for i := range clients {
+		ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		clients[i] = ws
+	}
+
+	// Read frames until both clients received the same one, meaning they have
+	// been collected once, for both clients.
+	seen := make(map[time.Time]int)
+	deadline := time.Now().Add(2 * time.Second)
+	for shared := false; !shared; {
+		for _, ws := range clients {
+			ws.SetReadDeadline(deadline)
+			var st stats
+			if err := ws.ReadJSON(&st); err != nil {
+				t.Fatal(err)
+			}
+			seen[st.Time]++
+			if seen[st.Time] == 2 {
+				shared = true
+			}
+		}
+	}
+
+	srv.hubsMu.Lock()
+	nhubs := len(srv.hubs)
+	srv.hubsMu.Unlock()
+	if nhubs != 1 {
+		t.Errorf("got %d hubs, want 1", nhubs)
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// slow never reads its frames.
+	_, unsubscribeSlow := srv.subscribe(srv.freq)
+	defer unsubscribeSlow()
+
+	frames, unsubscribe := srv.subscribe(srv.freq)
+	defer unsubscribe()
+
+	// Once slow buffer is full, the other subscriber must still receive frames.
+	for i := 0; i < 3*subscriberBufferSize; i++ {
+		select {
+		case f := <-frames:
+			f.release()
+		case <-time.After(2 * time.Second):
+			t.Fatalf("frame %d: timeout, the hub is blocked by the slow subscriber", i)
+		}
+	}
+}
+
+func TestHubMaxFrameBytes(t *testing.T) {
+	t.Parallel()
+
+	one := func() float64 { return 1 }
+	small := TimeSeriesPlot{Name: "small", Series: []TimeSeries{{Name: "s", Value: one}}}
+	big := TimeSeriesPlot{Name: "big"}
+	for i := 0; i < 500; i++ {
+		big.Series = append(big.Series, TimeSeries{Name: fmt.Sprint(i), Value: one})
+	}
+
+	// tick collects a few frames with the given budget and returns the last
+	// one, decoded, and its server.
+	tick := func(max int) (stats, *Server) {
+		t.Helper()
+
+		h, smp := newTestHub(t, WithPlot(small), WithPlot(big), WithMaxFrameBytes(max))
+		frames := make(chan *frame, 1)
+		h.subs[&subscriber{ch: frames}] = struct{}{}
+
+		var f *frame
+		for i := 0; i < 3; i++ {
+			h.tick(smp)
+			f = <-frames
+			if i < 2 {
+				f.release()
+			}
+		}
+		defer f.release()
+
+		var st stats
+		if err := json.Unmarshal(f.bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		return st, h.s
+	}
+
+	// Without limit, nothing is dropped.
+	st, srv := tick(0)
+	if st.Truncated || len(st.UserPlots) != 2 {
+		t.Fatalf("without limit, got truncated=%t and %d user plots, want false and 2", st.Truncated, len(st.UserPlots))
+	}
+	full := srv.FrameBytes()
+
+	// Just below the full size, only the last added plot is dropped.
+	st, _ = tick(full - 500)
+	if !st.Truncated {
+		t.Errorf("got truncated=false, want true")
+	}
+	if _, ok := st.UserPlots["big"]; ok {
+		t.Errorf("plot big was not dropped")
+	}
+	if _, ok := st.UserPlots["small"]; !ok {
+		t.Errorf("plot small was dropped, want only big dropped")
+	}
+
+	// With a tiny limit, all user plots are dropped, not the built-in ones.
+	st, srv = tick(1)
+	if !st.Truncated || len(st.UserPlots) != 0 {
+		t.Errorf("got truncated=%t and %d user plots, want true and 0", st.Truncated, len(st.UserPlots))
+	}
+	if len(st.Metrics) == 0 || len(st.RuntimePlots) == 0 {
+		t.Errorf("built-in plots were dropped")
+	}
+	if srv.FrameBytes() < full-500 {
+		t.Errorf("got FrameBytes() = %d, want the size before truncation, about %d", srv.FrameBytes(), full)
+	}
+}
+
+func TestWithMaxFrameBytesInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithMaxFrameBytes(-1)); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+}
+
+func TestHubQuitsWithoutSubscribers(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	_, unsubscribe := srv.subscribe(time.Hour)
+	_, unsubscribe2 := srv.subscribe(time.Hour)
+
+	nhubs := func() int {
+		srv.hubsMu.Lock()
+		defer srv.hubsMu.Unlock()
+		return len(srv.hubs)
+	}
+
+	if got := nhubs(); got != 1 {
+		t.Errorf("got %d hubs with 2 subscribers, want 1", got)
+	}
+	unsubscribe()
+	unsubscribe() // no-op
+	if got := nhubs(); got != 1 {
+		t.Errorf("got %d hubs with 1 subscriber, want 1", got)
+	}
+	unsubscribe2()
+	if got := nhubs(); got != 0 {
+		t.Errorf("got %d hubs without subscribers, want 0", got)
+	}
+}
+
+// raceEnabled is set if the race detector is enabled.
+var raceEnabled bool
+
+// newTestHub returns a hub that isn't running, to exercise the collection path
+// directly.
+func newTestHub(tb testing.TB, opts ...OptionFunc) (*hub, *sampler) {
+	tb.Helper()
+
+	srv, err := NewServer(opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(srv.Stop)
+
+	h := &hub{s: srv, freq: srv.freq, subs: make(map[*subscriber]struct{}), stats: newStats()}
+	h.enc = json.NewEncoder(&h.buf)
+	return h, srv.newSampler()
+}
+
+func TestHubCollectAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+
+	h, smp := newTestHub(t)
+	frames := make(chan *frame, 1)
+	h.subs[&subscriber{ch: frames}] = struct{}{}
+
+	// Warm up, so that maps, slices and buffers are allocated.
+	for i := 0; i < 3; i++ {
+		h.tick(smp)
+		(<-frames).release()
+	}
+
+	// The remaining allocations are made by encoding/json, mostly when
+	// encoding maps of plot values, a few per plot, and one per metric of the
+	// map of metrics, which the GODEBUG metrics make much larger.
+	maxAllocs := float64(4 + 4*len(h.s.runtimePlots) + len(godebugSeries(metrics.All())))
+	allocs := testing.AllocsPerRun(100, func() {
+		h.tick(smp)
+		(<-frames).release()
+	})
+	if allocs > maxAllocs {
+		t.Errorf("got %v allocations per tick, want at most %v", allocs, maxAllocs)
+	}
+}
+
+func BenchmarkCollect(b *testing.B) {
+	h, smp := newTestHub(b)
+	frames := make(chan *frame, 1)
+	h.subs[&subscriber{ch: frames}] = struct{}{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.tick(smp)
+		(<-frames).release()
+	}
+}
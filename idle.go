@@ -0,0 +1,61 @@
+package statsviz
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WithIdleHistoryInterval sets the interval at which stats are recorded in the
+// history, see WithHistorySize, while no client is connected, rather than at
+// the send frequency, which is the default. Sinks, see WithSink, don't count
+// as clients. Once a client connects, stats are recorded at the send frequency
+// again, so the history keeps a coarser backfill of the times nobody watched,
+// at a lower cost.
+//
+// Note that, without history, thresholds, sinks, or PublishExpvar, stats
+// aren't collected at all while no client is connected: runtime metrics are
+// only read again on the next connection.
+func WithIdleHistoryInterval(interval time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if interval < 0 {
+			return configError("WithIdleHistoryInterval", "idle history interval can't be negative, got %v", interval)
+		}
+		s.idleHistory = interval
+		return nil
+	}
+}
+
+// connected counts a client connection, and signals it to the history
+// recorder.
+func (s *Server) connected() {
+	s.counters.connect()
+	s.notifyClients()
+}
+
+// disconnected counts a client disconnection, and signals it to the history
+// recorder.
+func (s *Server) disconnected() {
+	s.counters.disconnect()
+	s.notifyClients()
+}
+
+func (s *Server) notifyClients() {
+	if s.clientsChanged == nil {
+		return
+	}
+	select {
+	case s.clientsChanged <- struct{}{}:
+	default:
+		// The recorder hasn't handled the previous change yet, it reads the
+		// number of clients anyway.
+	}
+}
+
+// historyFrequency returns the frequency at which the history is recorded,
+// depending on the connected clients.
+func (s *Server) historyFrequency() time.Duration {
+	if s.idleHistory > 0 && atomic.LoadInt32(&s.counters.clients) == 0 {
+		return s.idleHistory
+	}
+	return s.freq
+}
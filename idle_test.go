@@ -0,0 +1,155 @@
+package statsviz
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// hubFrequencies returns the frequencies of the hubs of srv.
+func hubFrequencies(srv *Server) map[time.Duration]bool {
+	srv.hubsMu.Lock()
+	defer srv.hubsMu.Unlock()
+
+	freqs := make(map[time.Duration]bool, len(srv.hubs))
+	for freq := range srv.hubs {
+		freqs[freq] = true
+	}
+	return freqs
+}
+
+// waitHubFrequency waits for srv to only have a hub at freq.
+func waitHubFrequency(t *testing.T, srv *Server, freq time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		freqs := hubFrequencies(srv)
+		if len(freqs) == 1 && freqs[freq] {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got hubs at %v, want a hub at %v", freqs, freq)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIdlePause(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, err := NewServer(withClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	sampled := func() int64 { return atomic.LoadInt64(&srv.counters.lastSample) }
+	connect := func() *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-clk.added:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the hub ticker")
+		}
+		clk.advance(time.Second)
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := ws.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+
+	ws := connect()
+	ws.Close()
+	waitHubs(t, srv, 0)
+
+	// Without clients, metrics aren't read anymore.
+	last := sampled()
+	clk.advance(time.Second)
+	time.Sleep(20 * time.Millisecond)
+	if got := sampled(); got != last {
+		t.Errorf("stats sampled at %v without clients, want no sampling", time.Unix(0, got))
+	}
+
+	// Sampling resumes on the next connection.
+	ws = connect()
+	defer ws.Close()
+	if got := sampled(); got == last {
+		t.Errorf("stats not sampled after reconnection")
+	}
+}
+
+func TestIdleHistoryInterval(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithHistorySize(10), WithIdleHistoryInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	// Without clients, history is recorded at the idle interval.
+	waitHubFrequency(t, srv, time.Minute)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The client and the history share the send frequency hub.
+	waitHubFrequency(t, srv, time.Second)
+	srv.hubsMu.Lock()
+	n := len(srv.hubs[time.Second].subs)
+	srv.hubsMu.Unlock()
+	if n != 2 {
+		t.Errorf("got %d subscribers, want the client and the history", n)
+	}
+
+	ws.Close()
+	waitHubFrequency(t, srv, time.Minute)
+
+	if _, err := NewServer(WithIdleHistoryInterval(-time.Second)); err == nil {
+		t.Errorf("got nil error for a negative interval, want non-nil")
+	}
+}
+
+func TestIdleHistoryIntervalSink(t *testing.T) {
+	t.Parallel()
+
+	w := &lineWriter{lines: make(chan struct{}, 10)}
+	srv, err := NewServer(WithHistorySize(10), WithIdleHistoryInterval(time.Minute), WithSink(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// Sinks aren't clients, the history is recorded at the idle interval
+	// while the sink gets stats at the send frequency.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		freqs := hubFrequencies(srv)
+		if len(freqs) == 2 && freqs[time.Minute] && freqs[time.Second] {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got hubs at %v, want hubs at %v and %v", freqs, time.Minute, time.Second)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := srv.Stats().Clients; got != 0 {
+		t.Errorf("got %d clients, want 0", got)
+	}
+}
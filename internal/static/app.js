@@ -1,3 +1,4 @@
+import * as msgpack from './msgpack.js';
 import * as stats from './stats.js';
 import * as ui from './ui.js';
 
@@ -5,13 +6,28 @@ const $ = id => {
     return document.getElementById(id);
 }
 
+// endpoint returns the path of the named statsviz endpoint, as written by the
+// server in the index page, which allows for multiple statsviz instances on
+// the same server. It defaults to a path relative to the current page.
+const endpoint = name => {
+    const meta = document.querySelector('meta[name="statsviz-' + name + '"]');
+    if (meta && meta.content) {
+        return meta.content;
+    }
+    return window.location.pathname + name;
+}
+
+// The version of the frames schema this user interface understands, the server
+// rejects the connection if it doesn't speak it.
+const wsSubprotocol = "statsviz.v1";
+
 const buildWebsocketURI = () => {
     var loc = window.location,
         ws_prot = "ws:";
     if (loc.protocol === "https:") {
         ws_prot = "wss:";
     }
-    return ws_prot + "//" + loc.host + loc.pathname + "ws"
+    return ws_prot + "//" + loc.host + endpoint("ws");
 }
 
 const dataRetentionSeconds = 60;
@@ -23,20 +39,143 @@ const clamp = (val, min, max) => {
     return val;
 }
 
-/* WebSocket connection handling */
+/* Connection handling */
+
+const buildDataURI = () => {
+    return endpoint("ws");
+}
+
+// fetchHandshake retrieves the metadata advertised by the server, falling
+// back to defaults if the server doesn't provide them (i.e. when statsviz
+// handlers have been registered manually).
+const fetchHandshake = async () => {
+    try {
+        const resp = await fetch(endpoint("handshake"));
+        if (resp.ok) {
+            return await resp.json();
+        }
+    } catch (error) {
+        console.warn("Can't retrieve handshake: ", error);
+    }
+    return { transport: "websocket" };
+}
 
-const connect = () => {
-    let ws = new WebSocket(buildWebsocketURI());
+const reconnect = () => {
+    setTimeout(connect, clamp(timeout += timeout, 250, 5000));
+}
+
+var initDone = false;
+var lastTime = null;
+var lastSeq = null; // sequence number of the last received stats
+var lastMetrics = {}; // metrics of the last received stats, see carryMetrics
+var handshake = null;
+
+// expandMetrics rebuilds the Metrics of compact stats, from their values and
+// the metric names sent in the handshake.
+const expandMetrics = allStats => {
+    if (!allStats.MetricValues || !handshake || !handshake.metricNames) {
+        return;
+    }
+    const metrics = {};
+    handshake.metricNames.forEach((name, i) => {
+        const v = allStats.MetricValues[i];
+        if (v !== null && v !== undefined && !Number.isNaN(v)) {
+            metrics[name] = v;
+        }
+    });
+    allStats.Metrics = metrics;
+    delete allStats.MetricValues;
+}
+
+// carryMetrics completes the Metrics of delta stats, which only hold the
+// changed metrics, with the values of the previous stats.
+const carryMetrics = allStats => {
+    if (!handshake || !handshake.deltaFrames) {
+        return;
+    }
+    allStats.Metrics = Object.assign({}, lastMetrics, allStats.Metrics);
+    lastMetrics = allStats.Metrics;
+}
+
+const onStats = allStats => {
+    expandMetrics(allStats);
+    // Stats carry the time at which they've been collected, which allows to
+    // place the historical stats sent upon connection at the right time.
+    const ts = allStats.Time ? new Date(allStats.Time) : new Date();
+    if (lastTime !== null && ts <= lastTime) {
+        // Already known, this happens with historical stats after a reconnection.
+        return;
+    }
+    lastTime = ts;
+    carryMetrics(allStats);
+    if (allStats.Seq) {
+        lastSeq = allStats.Seq;
+    }
+
+    if (!initDone) {
+        stats.init(dataRetentionSeconds, allStats, handshake.plots || [], handshake.runtimePlots || []);
+        stats.pushData(ts, allStats);
+        initDone = true;
+        let data = stats.slice(dataRetentionSeconds);
+        ui.createPlots(data, handshake.plots || [], handshake.runtimePlots || [], handshake.builtinPlots, handshake.metrics || {});
+        return;
+    }
+
+    stats.pushData(ts, allStats);
+    if (!allStats.Historical) {
+        updateLastGC(allStats.SinceLastGC);
+        updateUptime(allStats.Uptime);
+        updateInterval(allStats.Interval);
+        updateTopAllocators(allStats.TopAllocators);
+    }
+    if (ui.isPaused() || allStats.Historical) {
+        // Don't redraw plots for each historical stats, the first live one
+        // will do that.
+        return
+    }
+    let data = stats.slice(dataRetentionSeconds);
+    ui.updatePlots(data);
+}
+
+const connectWebsocket = () => {
+    // When reconnecting, only ask for the stats missed while disconnected.
+    const resume = lastSeq !== null;
+    // The send frequency can be set per page, with the hz or interval query
+    // parameters, which are passed on.
+    const params = new URLSearchParams();
+    const page = new URLSearchParams(window.location.search);
+    for (const name of ["hz", "interval"]) {
+        if (page.has(name)) {
+            params.set(name, page.get(name));
+        }
+    }
+    if (resume) {
+        params.set("resume", "");
+    }
+    const query = params.toString();
+    let ws = new WebSocket(buildWebsocketURI() + (query ? "?" + query : ""), wsSubprotocol);
+    // MessagePack encoded stats are sent as binary messages.
+    ws.binaryType = "arraybuffer";
     console.info("Attempting websocket connection to statsviz server...");
 
     ws.onopen = () => {
         console.info("Successfully connected");
+        $("disconnected-item").style.display = "none";
         timeout = 250; // reset connection timeout for next time
+        if (resume) {
+            ws.send(JSON.stringify({ type: "resume", since: lastSeq }));
+        }
     };
 
     ws.onclose = event => {
         console.info("Closed websocket connection: ", event);
-        setTimeout(connect, clamp(timeout += timeout, 250, 5000));
+        if (event.code === 1000 && event.reason) {
+            // The server closed the connection on purpose, for example
+            // since it's shutting down, say so while reconnecting.
+            $("disconnected-reason").textContent = "Disconnected: " + event.reason;
+            $("disconnected-item").style.display = "";
+        }
+        reconnect();
     };
 
     ws.onerror = error => {
@@ -44,24 +183,308 @@ const connect = () => {
         ws.close();
     };
 
-    var initDone = false;
     ws.onmessage = event => {
-        let allStats = JSON.parse(event.data)
-        if (!initDone) {
-            stats.init(dataRetentionSeconds, allStats);
-            stats.pushData(new Date(), allStats);
-            initDone = true;
-            let data = stats.slice(dataRetentionSeconds);
-            ui.createPlots(data);
+        if (event.data instanceof ArrayBuffer) {
+            if (handshake.encoding === "protobuf") {
+                // Meant for other clients, see statsviz.proto.
+                return;
+            }
+            onStats(msgpack.decode(new Uint8Array(event.data)));
             return;
         }
+        const msg = JSON.parse(event.data);
+        // Arrays hold batches of messages, see WithBatchSize.
+        for (const m of Array.isArray(msg) ? msg : [msg]) {
+            if (m.type !== undefined) {
+                onControl(m);
+                continue;
+            }
+            onStats(m);
+        }
+    }
+
+    frequencySelect.disabled = false;
+    frequencySelect.onchange = () => {
+        ws.send(JSON.stringify({ type: "setFrequency", millis: parseInt(frequencySelect.value) }));
+    };
+
+    resetButton.disabled = false;
+    resetButton.onclick = () => {
+        ws.send(JSON.stringify({ type: "reset" }));
+    };
+
+    $("force-gc-item").style.display = handshake.forceGC ? "" : "none";
+    forceGCButton.disabled = !handshake.forceGC;
+    forceGCButton.onclick = () => {
+        forceGCButton.disabled = true;
+        ws.send(JSON.stringify({ type: "forceGC" }));
+    };
+
+    $("profile-rates-item").style.display = handshake.profileControls ? "" : "none";
+    profileRatesSelect.disabled = !handshake.profileControls;
+    profileRatesSelect.onchange = () => {
+        profileRatesSelect.disabled = true;
+        ws.send(JSON.stringify({ type: "setProfileRates", rates: profileRates[profileRatesSelect.value] }));
+    };
+}
+
+// profileRates are the profile rates that can be set from the menu.
+const profileRates = {
+    default: { memProfileRate: 512 * 1024, blockProfileRate: 0, mutexProfileFraction: 0 },
+    // Sample an allocation every 4KiB, blocking events every 10µs and 1% of
+    // mutex contention events.
+    detailed: { memProfileRate: 4096, blockProfileRate: 10000, mutexProfileFraction: 100 },
+    all: { memProfileRate: 1, blockProfileRate: 1, mutexProfileFraction: 1 },
+};
 
-        stats.pushData(new Date(), allStats);
-        if (ui.isPaused()) {
-            return
+const frequencySelect = $("frequency");
+const resetButton = $("reset");
+const forceGCButton = $("force-gc");
+const profileRatesSelect = $("profile-rates");
+const frozenButton = $("frozen");
+
+// updateLastGC shows the time elapsed since the last garbage collection.
+const updateLastGC = secs => {
+    const el = $("last-gc");
+    if (!secs) {
+        el.textContent = "";
+        return;
+    }
+    el.textContent = "Last GC: " + (secs < 1 ? "<1" : Math.floor(secs)) + "s ago";
+}
+
+// updateUptime shows the time elapsed since the process started.
+const updateUptime = secs => {
+    const el = $("uptime");
+    if (!secs) {
+        el.textContent = "";
+        return;
+    }
+    secs = Math.floor(secs);
+    const d = Math.floor(secs / 86400), h = Math.floor(secs % 86400 / 3600), m = Math.floor(secs % 3600 / 60);
+    let up = d ? d + "d" + h + "h" : h ? h + "h" + m + "m" : m ? m + "m" + secs % 60 + "s" : secs + "s";
+    el.textContent = "Up " + up;
+}
+
+// updateInterval shows the effective collection interval, when the server
+// adapts it.
+const updateInterval = secs => {
+    frequencySelect.title = secs ? "Send frequency, adapted by the server to " + Math.round(secs * 1000) + "ms" : "Send frequency";
+}
+
+// updateTopAllocators fills the top allocators table with the last snapshot,
+// the section is shown once the server sends one.
+const updateTopAllocators = sites => {
+    if (!sites) {
+        return;
+    }
+    document.querySelectorAll(".top-allocators").forEach(el => el.style.display = "");
+    const rows = sites.map(site => {
+        const tr = document.createElement("tr");
+        const fn = document.createElement("td");
+        fn.textContent = site.function;
+        fn.title = site.file + ":" + site.line;
+        const cells = [ui.formatBytes(site.bytes), site.objects, ui.formatBytes(site.inuseBytes)].map(v => {
+            const td = document.createElement("td");
+            td.className = "right aligned";
+            td.textContent = v;
+            return td;
+        });
+        tr.append(fn, ...cells);
+        return tr;
+    });
+    $("top-allocators").replaceChildren(...rows);
+}
+
+// onReset clears the plots data, the server sends a reset message to all
+// clients once it has cleared its history.
+const onReset = () => {
+    if (!initDone) {
+        return;
+    }
+    stats.clear();
+    // Stats sent after a reset may be older than the last ones received, when
+    // a reconnecting client can't resume and gets the whole history.
+    lastTime = null;
+    ui.updatePlots(stats.slice(dataRetentionSeconds));
+}
+
+// onFreeze stops redrawing the plots, as the server asks when a threshold is
+// crossed, until the user resumes the live view. Stats are still received in
+// the meantime.
+const onFreeze = reason => {
+    if (!ui.isPaused()) {
+        ui.togglePause();
+    }
+    $("frozen-reason").textContent = "Frozen: " + reason;
+    $("frozen-item").style.display = "";
+}
+
+frozenButton.onclick = () => {
+    $("frozen-item").style.display = "none";
+    if (ui.isPaused()) {
+        ui.togglePause();
+    }
+    if (initDone) {
+        ui.updatePlots(stats.slice(dataRetentionSeconds));
+    }
+}
+
+// onAddPlot shows a user plot added on the server. Before the plots are
+// created, it's just added to those of the handshake.
+const onAddPlot = plot => {
+    if (!initDone) {
+        handshake.plots = (handshake.plots || []).filter(p => p.name !== plot.name).concat([plot]);
+        return;
+    }
+    stats.addUserPlot(plot);
+    ui.addUserPlot(stats.slice(dataRetentionSeconds), plot);
+}
+
+// onRemovePlot removes a user plot removed on the server.
+const onRemovePlot = name => {
+    if (!initDone) {
+        handshake.plots = (handshake.plots || []).filter(p => p.name !== name);
+        return;
+    }
+    ui.removeUserPlot(name);
+    stats.removeUserPlot(name);
+}
+
+const onControl = msg => {
+    switch (msg.type) {
+        case "setFrequency":
+            if (msg.error) {
+                console.warn("Frequency change refused: ", msg.error);
+            }
+            frequencySelect.value = msg.millis;
+            break;
+        case "reset":
+            onReset();
+            break;
+        case "plotsChanged":
+            (msg.removed || []).forEach(onRemovePlot);
+            (msg.added || []).forEach(onAddPlot);
+            break;
+        case "freeze":
+            onFreeze(msg.reason);
+            break;
+        case "forceGC":
+            forceGCButton.disabled = false;
+            if (msg.error) {
+                console.warn("Garbage collection refused: ", msg.error);
+                break;
+            }
+            forceGCButton.title = "Last forced GC freed " + ui.formatBytes(msg.freed || 0);
+            break;
+        case "setProfileRates":
+            profileRatesSelect.disabled = false;
+            if (msg.error) {
+                console.warn("Profile rates change refused: ", msg.error);
+                break;
+            }
+            const prev = msg.rates || {};
+            profileRatesSelect.title = "Previous rates: memory " + (prev.memProfileRate || 0) +
+                ", block " + (prev.blockProfileRate || 0) + ", mutex " + (prev.mutexProfileFraction || 0) +
+                ". Heap profiles reflect a new memory rate after a GC";
+            break;
+    }
+}
+
+const connectSSE = () => {
+    let es = new EventSource(buildDataURI());
+    console.info("Attempting Server-Sent Events connection to statsviz server...");
+
+    es.onopen = () => {
+        console.info("Successfully connected");
+        timeout = 250; // reset connection timeout for next time
+    };
+
+    es.onerror = error => {
+        // Disable EventSource automatic reconnection, we handle it ourselves.
+        console.error("Server-Sent Events error: ", error);
+        es.close();
+        reconnect();
+    };
+
+    es.onmessage = event => {
+        const msg = JSON.parse(event.data);
+        if (msg.type !== undefined) {
+            onControl(msg);
+            return;
         }
-        let data = stats.slice(dataRetentionSeconds);
-        ui.updatePlots(data);
+        onStats(msg);
+    }
+
+    // Server-Sent Events are one-way only.
+    frequencySelect.disabled = true;
+    resetButton.disabled = true;
+    forceGCButton.disabled = true;
+    profileRatesSelect.disabled = true;
+}
+
+// renderLinks shows the links to other endpoints advertised by the server, in
+// the menu.
+const renderLinks = links => {
+    const menu = $("links");
+    menu.replaceChildren();
+    for (const link of links) {
+        const a = document.createElement("a");
+        a.className = "item";
+        a.href = link.url;
+        if (link.icon) {
+            const icon = document.createElement("i");
+            icon.className = link.icon + " icon";
+            a.append(icon, " ");
+        }
+        a.append(link.title);
+        menu.append(a);
+    }
+}
+
+// renderBuildInfo shows the Go and main module versions of the server in the
+// menu, with the other build and runtime information in its tooltip.
+const renderBuildInfo = build => {
+    const el = $("build-info");
+    if (!build) {
+        el.style.display = "none";
+        return;
+    }
+    let text = build.goVersion;
+    if (build.version && build.version !== "(devel)") {
+        text += " · " + build.version;
+    }
+    if (build.revision) {
+        text += " (" + build.revision.slice(0, 7) + (build.modified ? "+" : "") + ")";
+    }
+    el.textContent = text;
+
+    const lines = [];
+    if (build.path) {
+        lines.push("Module: " + build.path);
+    }
+    lines.push("GOMAXPROCS: " + build.gomaxprocs, "CPUs: " + build.numCPU);
+    if (build.revision) {
+        lines.push("Revision: " + build.revision + (build.modified ? " (modified)" : ""));
+    }
+    lines.push("Started: " + new Date(build.startTime).toLocaleString());
+    el.title = lines.join("\n");
+}
+
+const connect = async () => {
+    handshake = await fetchHandshake();
+    renderLinks(handshake.links || []);
+    renderBuildInfo(handshake.build);
+    if (handshake.encoding === "protobuf") {
+        console.error("Protobuf encoded stats can't be plotted, only control messages are handled");
+    }
+    if (handshake.millis) {
+        frequencySelect.value = handshake.millis;
+    }
+    if (handshake.transport === "sse") {
+        connectSSE();
+    } else {
+        connectWebsocket();
     }
 }
 connect();
\ No newline at end of file
@@ -26,6 +26,10 @@ export default class Buffer {
         this._buf[this._pos] = pt;
         this._pos++;
     }
+    // clear discards all datapoints.
+    clear() {
+        this._pos = 0;
+    }
     length() {
         if (this._pos > this._len) {
             return this._len;
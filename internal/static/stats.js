@@ -26,9 +26,17 @@ var data = {
     objects: new Array(numSeriesObjects),
     goroutines: null,
     gcfraction: null,
-    // Array of the last relevant GC times
+    // Array of the last relevant GCs, with their time and count
     lastGCs: new Array(),
+    // Last known soft memory limit, 0 if there's no limit
+    memoryLimit: 0,
     bySize: null,
+    // User metrics buffers, indexed by metric name
+    userMetrics: {},
+    // User plots buffers (one per series), indexed by plot name
+    userPlots: {},
+    // Runtime plots buffers (one per series), indexed by plot name
+    runtimePlots: {},
 };
 
 // Contain indexed class sizes, this is initialized after reception of the first message.
@@ -36,9 +44,12 @@ var classSizes = new Array();
 const lastGCs = data.lastGCs;
 
 
-const init = (buflen, allStats) => {
+var buflen, bufcap;
+
+const init = (len, allStats, plots, runtimePlots) => {
     const extraBufferCapacity = 20; // 20% of extra (preallocated) buffer datapoints
-    const bufcap = buflen + (buflen * extraBufferCapacity) / 100; // number of actual datapoints
+    buflen = len;
+    bufcap = buflen + (buflen * extraBufferCapacity) / 100; // number of actual datapoints
 
     const memStats = allStats.Mem;
 
@@ -58,34 +69,38 @@ const init = (buflen, allStats) => {
         data.objects[i] = new Buffer(buflen, bufcap);
     }
 
-    // size classes heatmap
-    for (let i = 0; i < memStats.BySize.length; i++) {
-        classSizes.push(memStats.BySize[i].Size);
+    // size classes heatmap, MemStats are only sent if a plot needs them
+    const bySize = memStats ? memStats.BySize : [];
+    for (let i = 0; i < bySize.length; i++) {
+        classSizes.push(bySize[i].Size);
     }
 
     data.bySize = new Array(classSizes.length);
     for (let i = 0; i < data.bySize.length; i++) {
         data.bySize[i] = new Buffer(buflen, bufcap);
     }
+
+    // user and runtime plots
+    for (const plot of plots) {
+        data.userPlots[plot.name] = plot.series.map(() => new Buffer(buflen, bufcap));
+    }
+    for (const plot of runtimePlots) {
+        data.runtimePlots[plot.name] = plot.series.map(() => new Buffer(buflen, bufcap));
+    }
 };
 
-const updateLastGC = memStats => {
-    const nanoToSeconds = 1000 * 1000 * 1000;
-    let t = Math.floor(memStats.LastGC / nanoToSeconds);
-    let lastGC = new Date(t * 1000);
-    if (data.lastGCs.length == 0) {
-        data.lastGCs.push(lastGC);
+// pushGC records the garbage collections reported by the server since the
+// previous stats, if any.
+const pushGC = gc => {
+    if (!gc) {
         return;
     }
-    if (lastGC.getTime() != data.lastGCs[data.lastGCs.length - 1].getTime()) {
-        data.lastGCs.push(lastGC);
-        // We've added a GC timestamp, check if we can cut the front. We
-        // don't need to keep track data.lastGCs[0] if it happened before
-        // the oldest timestamp we're showing. 
-        let mints = data.times._buf[0];
-        if (data.lastGCs[0] < mints) {
-            data.lastGCs.splice(0, 1);
-        }
+    data.lastGCs.push({ time: new Date(gc.time), count: gc.count, gap: gc.gap || 0 });
+    // Forget the GCs which happened before the oldest timestamp we're
+    // showing.
+    const mints = data.times._buf[0];
+    while (data.lastGCs.length && data.lastGCs[0].time < mints) {
+        data.lastGCs.splice(0, 1);
     }
 }
 
@@ -94,8 +109,23 @@ const pushData = (ts, allStats) => {
 
     const memStats = allStats.Mem;
 
-    data.gcfraction.push(memStats.GCCPUFraction);
+    data.memoryLimit = allStats.MemoryLimit || 0;
     data.goroutines.push(allStats.NumGoroutine);
+    if (memStats) {
+        pushMemStats(memStats);
+    }
+
+    pushGC(allStats.GC);
+    pushUserMetrics(allStats.UserMetrics || {});
+    pushPlots(data.userPlots, allStats.UserPlots || {});
+    // Histograms may be sent less often than the other stats, see
+    // WithHistogramInterval, heatmaps then keep their last values.
+    pushPlots(data.runtimePlots, allStats.RuntimePlots || {}, true);
+}
+
+// pushMemStats pushes the data of the plots drawn from runtime.MemStats.
+const pushMemStats = memStats => {
+    data.gcfraction.push(memStats.GCCPUFraction);
 
     data.heap[idxHeapAlloc].push(memStats.HeapAlloc);
     data.heap[idxHeapSys].push(memStats.HeapSys);
@@ -116,8 +146,73 @@ const pushData = (ts, allStats) => {
         const size = memStats.BySize[i];
         data.bySize[i].push(size.Mallocs - size.Frees);
     }
+}
+
+// pushPlots pushes the series values of each plot into their buffers. If
+// carry is set, the last values of the plots missing from plots are pushed
+// again.
+const pushPlots = (bufs, plots, carry) => {
+    for (const name in bufs) {
+        if (carry && !(name in plots)) {
+            bufs[name].forEach(buf => {
+                buf.push(buf.length() ? buf.slice(1)[0] : null);
+            });
+            continue;
+        }
+        const vals = plots[name] || [];
+        bufs[name].forEach((buf, i) => {
+            buf.push(i < vals.length ? vals[i] : null);
+        });
+    }
+}
+
+const pushUserMetrics = userMetrics => {
+    for (const name in userMetrics) {
+        if (!(name in data.userMetrics)) {
+            // User metric seen for the first time, align it with the other
+            // series by filling its past with empty datapoints.
+            const buf = new Buffer(buflen, bufcap);
+            for (let i = 1; i < data.times.length(); i++) {
+                buf.push(null);
+            }
+            data.userMetrics[name] = buf;
+        }
+        data.userMetrics[name].push(userMetrics[name]);
+    }
+}
 
-    updateLastGC(memStats);
+// addUserPlot creates the buffers of a user plot added while running, aligned
+// with the other series by filling their past with empty datapoints.
+const addUserPlot = plot => {
+    data.userPlots[plot.name] = plot.series.map(() => {
+        const buf = new Buffer(buflen, bufcap);
+        for (let i = 0; i < data.times.length(); i++) {
+            buf.push(null);
+        }
+        return buf;
+    });
+}
+
+// removeUserPlot discards the buffers of a removed user plot.
+const removeUserPlot = name => {
+    delete data.userPlots[name];
+}
+
+// clear discards all data, plots then start empty.
+const clear = () => {
+    const bufs = [data.times, data.goroutines, data.gcfraction, ...data.heap, ...data.mspanMCache, ...data.objects, ...data.bySize];
+    for (const name in data.userPlots) {
+        bufs.push(...data.userPlots[name]);
+    }
+    for (const name in data.runtimePlots) {
+        bufs.push(...data.runtimePlots[name]);
+    }
+    for (const buf of bufs) {
+        buf.clear();
+    }
+    // User metrics are created again once seen.
+    data.userMetrics = {};
+    data.lastGCs.length = 0;
 }
 
 const length = () => {
@@ -154,15 +249,35 @@ const slice = nitems => {
         bySizes[i] = data.bySize[i].slice(nitems);
     }
 
+    // User metrics plot data
+    let userMetrics = {};
+    for (const name in data.userMetrics) {
+        userMetrics[name] = data.userMetrics[name].slice(nitems);
+    }
+
+    // User and runtime plots data
+    let userPlots = {};
+    for (const name in data.userPlots) {
+        userPlots[name] = data.userPlots[name].map(buf => buf.slice(nitems));
+    }
+    let runtimePlots = {};
+    for (const name in data.runtimePlots) {
+        runtimePlots[name] = data.runtimePlots[name].map(buf => buf.slice(nitems));
+    }
+
     return {
         times: times,
         gcfraction: gcfraction,
         goroutines: goroutines,
         heap: heap,
+        memoryLimit: data.memoryLimit,
         mspanMCache: mspanMCache,
         objects: objects,
         bySizes: bySizes,
+        userMetrics: userMetrics,
+        userPlots: userPlots,
+        runtimePlots: runtimePlots,
     }
 }
 
-export { init, lastGCs, classSizes, pushData, length, slice };
\ No newline at end of file
+export { init, lastGCs, classSizes, pushData, clear, length, slice, addUserPlot, removeUserPlot };
\ No newline at end of file
@@ -9,7 +9,7 @@ const GCLines = data => {
     const shapes = [];
 
     for (let i = 0, n = gcs.length; i < n; i++) {
-        let d = gcs[i];
+        let d = gcs[i].time;
         // Clamp GC times which are out of bounds
         if (d < mints || d > maxts) {
             continue;
@@ -23,8 +23,10 @@ const GCLines = data => {
             y0: 0,
             y1: 1,
             line: {
-                color: 'rgb(55, 128, 191)',
-                width: 1,
+                // Red if the GCs delayed the collection of the stats.
+                color: gcs[i].gap ? 'rgb(219, 64, 82)' : 'rgb(55, 128, 191)',
+                // Thicker if several GCs happened within the interval.
+                width: Math.min(gcs[i].count, 4),
                 dash: 'longdashdot',
             }
         })
@@ -72,6 +74,27 @@ const heapData = data => {
 }
 
 // https://plotly.com/javascript/reference/layout
+// memoryLimitLine returns the horizontal line showing the soft memory limit on
+// the heap plot, if there's one.
+const memoryLimitLine = data => {
+    if (!data.memoryLimit) {
+        return [];
+    }
+    return [{
+        type: 'line',
+        xref: 'paper',
+        x0: 0,
+        x1: 1,
+        y0: data.memoryLimit,
+        y1: data.memoryLimit,
+        line: {
+            color: 'rgb(219, 64, 82)',
+            width: 1,
+            dash: 'dash',
+        }
+    }];
+}
+
 const heapLayout = {
     title: 'Heap',
     xaxis: {
@@ -80,6 +103,8 @@ const heapLayout = {
     },
     yaxis: {
         title: 'bytes',
+        // Heap sizes span several orders of magnitude.
+        type: 'log',
         ticksuffix: 'B',
         // tickformat: ' ',
         exponentformat: 'SI',
@@ -245,9 +270,192 @@ const gcFractionLayout = {
     }
 };
 
+const userMetricsData = data => {
+    const ret = [];
+    for (const name in data.userMetrics) {
+        ret.push({
+            x: data.times,
+            y: data.userMetrics[name],
+            type: 'scatter',
+            name: name,
+            hovertemplate: '<b>' + name + '</b>: %{y}',
+        });
+    }
+    return ret;
+}
+
+const userMetricsLayout = {
+    title: 'User metrics',
+    xaxis: {
+        title: 'time',
+        tickformat: '%H:%M:%S',
+    },
+    yaxis: {
+        title: 'value',
+    }
+};
+
+// userPlots and runtimePlots hold the configs of the user plots and of the
+// runtime plots, as received in the handshake. Both kinds of plots are drawn
+// the same way, as time series.
+var userPlots = [];
+var runtimePlots = [];
+
+const seriesPlotElt = (prefix, plot) => {
+    return document.getElementById(prefix + plot.name);
+}
+
+// formatBytes formats a number of bytes with a binary unit.
+const formatBytes = n => {
+    const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+    let i = 0;
+    while (n >= 1024 && i < units.length - 1) {
+        n /= 1024;
+        i++;
+    }
+    return (i == 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+// scaleValues multiplies vals by the scale of series, if any.
+const scaleValues = (vals, series) => {
+    if (!series.scale) {
+        return vals;
+    }
+    return vals.map(v => v == null ? v : v * series.scale);
+}
+
+// unitSuffix returns the suffix shown after the values of series.
+const unitSuffix = series => {
+    switch (series.unit) {
+        case undefined:
+        case '':
+            return '';
+        case 'percent':
+            return '%';
+        case 'bytes':
+            return 'B';
+    }
+    return ' ' + series.unit;
+}
+
+const seriesPlotData = (times, vals, plot) => {
+    if (plot.type === 'heatmap') {
+        // Same as the size classes heatmap, with one series per bucket.
+        return [{
+            x: times,
+            y: plot.series.map(series => series.name),
+            z: vals,
+            type: 'heatmap',
+            hovertemplate: '<br><b>bucket</b>: ≤ %{y}' +
+                '<br><b>count</b>: %{z}<br>',
+            showlegend: false,
+            colorscale: colorscale,
+        }];
+    }
+    return plot.series.map((series, i) => {
+        const y = scaleValues(vals[i], series);
+        const trace = {
+            x: times,
+            y: y,
+            type: 'scatter',
+            name: series.name,
+            hovertemplate: '<b>' + series.name + '</b>: %{y}' + unitSuffix(series),
+        };
+        if (series.color) {
+            trace.line = { color: series.color };
+            trace.marker = { color: series.color };
+        }
+        if (series.unit === 'bytes') {
+            // Show bytes with binary prefixes, rather than SI ones.
+            trace.text = y.map(v => v == null ? '' : formatBytes(v));
+            trace.hovertemplate = '<b>' + series.name + '</b>: %{text}';
+        }
+        if ((plot.rightAxis || []).includes(series.name)) {
+            trace.yaxis = 'y2';
+        }
+        if (plot.stepped) {
+            // The value holds until the next sample.
+            trace.line = Object.assign(trace.line || {}, { shape: 'hv' });
+        }
+        if (plot.stacked) {
+            // Stacked areas, each series is filled up to the previous one.
+            trace.stackgroup = 'stack';
+            trace.line = Object.assign(trace.line || {}, { width: 0.5 });
+        }
+        return trace;
+    });
+}
+
+// unitAxis returns the layout of the y axis of series, showing their unit if
+// they all share the same, or undefined.
+const unitAxis = series => {
+    if (series.length === 0 || !series[0].unit || !series.every(s => s.unit === series[0].unit)) {
+        return undefined;
+    }
+    return {
+        title: series[0].unit,
+        ticksuffix: unitSuffix(series[0]),
+        exponentformat: 'SI',
+    };
+}
+
+const seriesPlotLayout = plot => {
+    const layout = {
+        title: plot.title,
+        xaxis: {
+            title: 'time',
+            tickformat: '%H:%M:%S',
+        },
+    };
+    if (plot.type === 'heatmap') {
+        layout.yaxis = {
+            title: 'buckets',
+            type: 'category',
+        };
+    } else {
+        const right = plot.rightAxis || [];
+        const left = plot.series.filter(series => !right.includes(series.name));
+        layout.yaxis = unitAxis(left);
+        if (right.length > 0) {
+            // Series not comparable with the others, see PlotConfig.RightAxis.
+            const axis = unitAxis(plot.series.filter(series => right.includes(series.name)));
+            layout.yaxis2 = Object.assign(axis || {}, { overlaying: 'y', side: 'right', showgrid: false });
+        }
+    }
+    if (plot.yaxis === 'log') {
+        // Plotly leaves values lower than or equal to zero out.
+        layout.yaxis = Object.assign(layout.yaxis || {}, { type: 'log' });
+    }
+    return layout;
+}
+
+// createSeriesPlotsElts adds the accordion items holding plots to container.
+// The title of a plot drawn from runtime metrics describes them, in a tooltip.
+const createSeriesPlotsElts = (container, prefix, plots, metrics = {}) => {
+    for (const plot of plots) {
+        const title = $('<div class="title"><i class="dropdown icon"></i></div>');
+        title.append(document.createTextNode(' ' + (plot.title || plot.name)));
+        if (plot.metrics) {
+            title.attr('title', plot.metrics.map(name => {
+                const info = metrics[name];
+                return info ? name + (info.cumulative ? ' (cumulative)' : '') + ': ' + info.description : name;
+            }).join('\n'));
+        }
+
+        const content = $('<div class="content"></div>');
+        const elt = $('<div class="transition hidden plot"></div>');
+        elt.attr('id', prefix + plot.name);
+        content.append(elt);
+
+        container.append(title, content);
+    }
+    if (plots.length != 0) {
+        container.show();
+    }
+}
 
 const configs = () => {
-    const plots = ['heap', 'mspan-mcache', 'size-classes', 'objects', 'gcfraction', 'goroutines'];
+    const plots = ['heap', 'mspan-mcache', 'size-classes', 'objects', 'gcfraction', 'goroutines', 'user-metrics'];
     const cfgs = {};
 
     plots.forEach(plotName => {
@@ -273,8 +481,50 @@ const sizeClassesElt = $('#size-classes')[0];
 const objectsElt = $('#objects')[0];
 const gcfractionElt = $('#gcfraction')[0];
 const goroutinesElt = $('#goroutines')[0];
+const userMetricsElt = $('#user-metrics')[0];
+
+// showUserMetrics only shows the user metrics plot section once there is at
+// least one user metric to display.
+const showUserMetrics = data => {
+    if (Object.keys(data.userMetrics).length != 0) {
+        $('.user-metrics').css('display', '');
+    }
+}
+
+// builtinPlots holds the built-in plots drawn from runtime.MemStats and the
+// number of goroutines.
+const builtinPlots = [
+    { name: 'heap', elt: heapElt, data: heapData, layout: heapLayout },
+    { name: 'mspan-mcache', elt: mspanMCacheElt, data: mspanMCacheData, layout: mspanMCacheLayout },
+    { name: 'size-classes', elt: sizeClassesElt, data: sizeClassesData, layout: sizeClassesLayout },
+    { name: 'objects', elt: objectsElt, data: objectsData, layout: objectsLayout },
+    { name: 'gcfraction', elt: gcfractionElt, data: gcFractionData, layout: gcFractionLayout },
+    { name: 'goroutines', elt: goroutinesElt, data: goroutinesData, layout: goroutinesLayout },
+];
+
+// enabledPlots holds the built-in plots enabled on the server.
+var enabledPlots = builtinPlots;
+
+// removePlotElt removes the accordion item holding a plot.
+const removePlotElt = elt => {
+    const content = $(elt).parent();
+    content.prev('.title').remove();
+    content.remove();
+}
+
+// createPlots creates the plots. builtin lists the names of the enabled
+// built-in plots, they're all enabled if it's undefined. metrics describes the
+// runtime metrics, by name.
+const createPlots = (data, plots, rtplots, builtin, metrics) => {
+    userPlots = plots;
+    runtimePlots = rtplots;
+    if (builtin) {
+        enabledPlots = builtinPlots.filter(p => builtin.includes(p.name));
+        builtinPlots.filter(p => !builtin.includes(p.name)).forEach(p => removePlotElt(p.elt));
+    }
+    createSeriesPlotsElts($('#user-plots'), 'user-plot-', userPlots);
+    createSeriesPlotsElts($('#runtime-plots'), 'runtime-plot-', runtimePlots, metrics);
 
-const createPlots = (data) => {
     $('.ui.accordion').accordion({
         exclusive: false,
         onOpen: function() {
@@ -285,12 +535,50 @@ const createPlots = (data) => {
         }
     });
 
-    Plotly.newPlot(heapElt, heapData(data), heapLayout, configs['heap']);
-    Plotly.newPlot(mspanMCacheElt, mspanMCacheData(data), mspanMCacheLayout, configs['mspan-mcache']);
-    Plotly.newPlot(sizeClassesElt, sizeClassesData(data), sizeClassesLayout, configs['size-classes']);
-    Plotly.newPlot(objectsElt, objectsData(data), objectsLayout, configs['objects']);
-    Plotly.newPlot(gcfractionElt, gcFractionData(data), gcFractionLayout, configs['gcfraction']);
-    Plotly.newPlot(goroutinesElt, goroutinesData(data), goroutinesLayout, configs['goroutines']);
+    for (const plot of enabledPlots) {
+        Plotly.newPlot(plot.elt, plot.data(data), plot.layout, configs[plot.name]);
+    }
+    Plotly.newPlot(userMetricsElt, userMetricsData(data), userMetricsLayout, configs['user-metrics']);
+    showUserMetrics(data);
+
+    for (const plot of runtimePlots) {
+        Plotly.newPlot(seriesPlotElt('runtime-plot-', plot), seriesPlotData(data.times, data.runtimePlots[plot.name], plot), seriesPlotLayout(plot), configs[plot.name]);
+    }
+    for (const plot of userPlots) {
+        Plotly.newPlot(seriesPlotElt('user-plot-', plot), seriesPlotData(data.times, data.userPlots[plot.name], plot), seriesPlotLayout(plot), configs[plot.name]);
+    }
+}
+
+// addUserPlot shows a user plot added while running, or replaces the one with
+// the same name.
+const addUserPlot = (data, plot) => {
+    removeUserPlot(plot.name);
+    userPlots.push(plot);
+    createSeriesPlotsElts($('#user-plots'), 'user-plot-', [plot]);
+    Plotly.newPlot(seriesPlotElt('user-plot-', plot), seriesPlotData(data.times, data.userPlots[plot.name], plot), seriesPlotLayout(plot), configs[plot.name]);
+}
+
+// removeUserPlot removes a user plot removed while running.
+const removeUserPlot = name => {
+    const elt = document.getElementById('user-plot-' + name);
+    if (elt) {
+        Plotly.purge(elt);
+        removePlotElt(elt);
+    }
+    userPlots = userPlots.filter(plot => plot.name !== name);
+    if (userPlots.length == 0) {
+        $('#user-plots').hide();
+    }
+}
+
+// seriesPlotLayoutWithGC returns the layout of a time series plot, showing
+// the GC lines, or of a heatmap.
+const seriesPlotLayoutWithGC = (plot, gcLines) => {
+    const layout = seriesPlotLayout(plot);
+    if (plot.type !== 'heatmap') {
+        layout.shapes = gcLines;
+    }
+    return layout;
 }
 
 var updateIdx = 0;
@@ -298,32 +586,41 @@ var updateIdx = 0;
 const updatePlots = data => {
     let gcLines = GCLines(data);
 
-    heapLayout.shapes = gcLines;
-    if (!heapElt.hidden) {
-        Plotly.react(heapElt, heapData(data), heapLayout, configs['heap']);
-    }
-
+    heapLayout.shapes = gcLines.concat(memoryLimitLine(data));
     mspanMCacheLayout.shapes = gcLines;
-    if (!mspanMCacheElt.hidden) {
-        Plotly.react(mspanMCacheElt, mspanMCacheData(data), mspanMCacheLayout, configs['mspan-mcache']);
-    }
-
     objectsLayout.shapes = gcLines;
-    if (!objectsElt.hidden) {
-        Plotly.react(objectsElt, objectsData(data), objectsLayout, configs['objects']);
-    }
 
-    if (!gcfractionElt.hidden) {
-        Plotly.react(gcfractionElt, gcFractionData(data), gcFractionLayout, configs['gcfraction']);
+    for (const plot of enabledPlots) {
+        if (plot.elt.hidden) {
+            continue;
+        }
+        if (plot.name === 'size-classes' && updateIdx % 5 != 0) {
+            // Update the size class heatmap 5 times less often since it's expensive.
+            continue;
+        }
+        Plotly.react(plot.elt, plot.data(data), plot.layout, configs[plot.name]);
     }
 
-    if (!goroutinesElt.hidden) {
-        Plotly.react(goroutinesElt, goroutinesData(data), goroutinesLayout, configs['goroutines']);
+    showUserMetrics(data);
+    if (!userMetricsElt.hidden) {
+        Plotly.react(userMetricsElt, userMetricsData(data), userMetricsLayout, configs['user-metrics']);
     }
 
-    if (!sizeClassesElt.hidden && updateIdx % 5 == 0) {
-        // Update the size class heatmap 5 times less often since it's expensive. 
-        Plotly.react(sizeClassesElt, sizeClassesData(data), sizeClassesLayout, configs['size-classes']);
+    for (const plot of runtimePlots) {
+        const elt = seriesPlotElt('runtime-plot-', plot);
+        if (plot.type === 'heatmap' && updateIdx % 5 != 0) {
+            // Like the size class heatmap, update less often.
+            continue;
+        }
+        if (!elt.hidden) {
+            Plotly.react(elt, seriesPlotData(data.times, data.runtimePlots[plot.name], plot), seriesPlotLayoutWithGC(plot, gcLines), configs[plot.name]);
+        }
+    }
+    for (const plot of userPlots) {
+        const elt = seriesPlotElt('user-plot-', plot);
+        if (!elt.hidden) {
+            Plotly.react(elt, seriesPlotData(data.times, data.userPlots[plot.name], plot), seriesPlotLayoutWithGC(plot, gcLines), configs[plot.name]);
+        }
     }
 
     updateIdx++;
@@ -333,4 +630,4 @@ let paused = false;
 const isPaused = () => { return paused; }
 const togglePause = () => { paused = !paused; }
 
-export { isPaused, togglePause, createPlots, updatePlots };
\ No newline at end of file
+export { isPaused, togglePause, createPlots, updatePlots, addUserPlot, removeUserPlot, formatBytes };
\ No newline at end of file
@@ -0,0 +1,83 @@
+package statsviz
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WithLabels sets static labels describing the process, such as its hostname,
+// region or instance id, so that the stats of several instances can be told
+// apart. The labels are sent in the handshake, and attached to the metrics
+// exported by the Prometheus handler of the server and by the statsvizotel
+// package.
+//
+// Label names must be valid Prometheus label names: ASCII letters, digits and
+// '_', not starting with a digit nor with "__", which is reserved, and
+// different from "le", used by histogram buckets. WithLabels can be set more
+// than once, the labels are merged.
+func WithLabels(labels map[string]string) OptionFunc {
+	return func(s *Server) error {
+		for name := range labels {
+			if err := checkLabelName(name); err != nil {
+				return &ConfigError{Option: "WithLabels", Err: err}
+			}
+		}
+		if s.labels == nil {
+			s.labels = make(map[string]string, len(labels))
+		}
+		for name, v := range labels {
+			s.labels[name] = v
+		}
+		return nil
+	}
+}
+
+func checkLabelName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("empty label name")
+	case name[0] >= '0' && name[0] <= '9':
+		return fmt.Errorf("label name %q can't start with a digit", name)
+	case strings.HasPrefix(name, "__"):
+		return fmt.Errorf("label name %q can't start with \"__\"", name)
+	case name == "le":
+		return fmt.Errorf("label name %q is reserved", name)
+	case sanitizeName(name, 0) != name:
+		return fmt.Errorf("label name %q must only hold ASCII letters, digits and '_'", name)
+	}
+	return nil
+}
+
+// Labels returns a copy of the labels set by WithLabels, nil if there's none.
+func (s *Server) Labels() map[string]string {
+	if len(s.labels) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(s.labels))
+	for name, v := range s.labels {
+		labels[name] = v
+	}
+	return labels
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// promLabels returns the Prometheus text format of labels, sorted by name and
+// separated by commas, without braces.
+func promLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name + `="` + promLabelEscaper.Replace(labels[name]) + `"`)
+	}
+	return sb.String()
+}
@@ -0,0 +1,66 @@
+package statsviz
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestWithLabels(t *testing.T) {
+	t.Parallel()
+
+	labels := map[string]string{"hostname": "web-1", "region": `eu "west"`}
+	srv, err := NewServer(WithLabels(labels), WithLabels(map[string]string{"instance_id": "i-42"}), SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	want := map[string]string{"hostname": "web-1", "region": `eu "west"`, "instance_id": "i-42"}
+	hs := srv.handshake()
+	if len(hs.Labels) != len(want) {
+		t.Fatalf("got handshake labels %v, want %v", hs.Labels, want)
+	}
+	for k, v := range want {
+		if hs.Labels[k] != v {
+			t.Errorf("handshake label %s: got %q, want %q", k, hs.Labels[k], v)
+		}
+	}
+
+	body := scrapeServer(t, srv)
+	var p expfmt.TextParser
+	families, err := p.TextToMetricFamilies(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("can't parse prometheus output: %v\n%s", err, body)
+	}
+	for _, name := range []string{"go_gc_heap_allocs_bytes_total", "go_gc_heap_goal_bytes"} {
+		f, ok := families[name]
+		if !ok {
+			t.Fatalf("missing metric family %q", name)
+		}
+		got := make(map[string]string)
+		for _, lp := range f.Metric[0].Label {
+			got[lp.GetName()] = lp.GetValue()
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got labels %v, want %v", name, got, want)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("%s: label %s: got %q, want %q", name, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestWithLabelsInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"", "1host", "__name", "le", "host-name", "région"} {
+		if _, err := NewServer(WithLabels(map[string]string{name: "v"})); err == nil {
+			t.Errorf("label name %q: got nil error, want non-nil", name)
+		}
+	}
+}
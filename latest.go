@@ -0,0 +1,42 @@
+package statsviz
+
+// latestFrame holds the most recently collected stats, so that read-only
+// endpoints can load them without locks, racing the hubs or reading runtime
+// metrics again.
+type latestFrame struct {
+	v atomicValue // *stats, never modified once stored
+}
+
+// store stores a copy of st, so that st can be reused by the caller.
+func (l *latestFrame) store(st *stats) {
+	c := st.clone()
+	l.v.Store(&c)
+}
+
+// load returns the last stored stats, or nil if none were stored yet. The
+// returned stats are shared, they must not be modified.
+func (l *latestFrame) load() *stats {
+	st, _ := l.v.Load().(*stats)
+	return st
+}
+
+// latestStats returns the stats most recently collected at the server send
+// frequency, or nil if none were collected yet. The returned stats are shared,
+// they must not be modified. They're read by PublishExpvar, SnapshotWithID and
+// Server.PrometheusHandler, while the Grafana and CSV endpoints read the
+// history, which they need past stats of.
+//
+// Stats are only tracked from the first call, until the server is stopped, so
+// a server has no extra hub if nothing reads them.
+func (s *Server) latestStats() *stats {
+	s.latestOnce.Do(func() {
+		unsubscribe := s.subscribeFunc(s.freq, s.latest.store)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			<-s.done
+			unsubscribe()
+		}()
+	})
+	return s.latest.load()
+}
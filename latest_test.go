@@ -0,0 +1,98 @@
+package statsviz
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLatestFrame(t *testing.T) {
+	t.Parallel()
+
+	var l latestFrame
+	if st := l.load(); st != nil {
+		t.Fatalf("got %+v before any store, want nil", st)
+	}
+
+	// One writer reusing its stats, like a hub, and many readers.
+	const (
+		ticks   = 1000
+		readers = 8
+	)
+	var wg sync.WaitGroup
+	quit := make(chan struct{})
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				st := l.load()
+				if st == nil {
+					continue
+				}
+				// Values written within a tick are consistent.
+				seq := float64(st.Seq)
+				if st.Metrics["seq"] != seq || st.UserPlots["plot"][0] != seq {
+					t.Errorf("got inconsistent stats %d, %v and %v", st.Seq, st.Metrics["seq"], st.UserPlots["plot"])
+					return
+				}
+			}
+		}()
+	}
+
+	st := stats{
+		Metrics:   make(map[string]float64),
+		UserPlots: map[string]plotValues{"plot": {0}},
+	}
+	for i := 1; i <= ticks; i++ {
+		st.Seq = uint64(i)
+		st.Metrics["seq"] = float64(i)
+		st.UserPlots["plot"][0] = float64(i)
+		l.store(&st)
+	}
+	close(quit)
+	wg.Wait()
+
+	if got := l.load(); got.Seq != ticks {
+		t.Errorf("got seq %d, want %d", got.Seq, ticks)
+	}
+}
+
+func TestLatestStats(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var prev uint64
+	for prev == 0 {
+		if st := srv.latestStats(); st != nil {
+			prev = st.Seq
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the latest stats")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for {
+		if st := srv.latestStats(); st.Seq > prev {
+			if len(st.Metrics) == 0 {
+				t.Errorf("got stats %+v, want metrics", st)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the latest stats to be updated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,123 @@
+package statsviz
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ListenAndServe starts an HTTP server listening on the TCP network address
+// addr and serving the statsviz handlers, configured with the provided
+// options, under the root path (/debug/statsviz by default). It's meant for
+// programs that don't have an HTTP server already, such as scripts or command
+// line tools:
+//
+//	go statsviz.ListenAndServe("localhost:8080")
+//
+// ListenAndServe always returns a non-nil error.
+func ListenAndServe(addr string, opts ...OptionFunc) error {
+	return listenAndServe(context.Background(), addr, "", "", nil, opts)
+}
+
+// ListenAndServeTLS is like ListenAndServe but serves HTTPS, and thus secure
+// websockets, using the certificate and matching private key files. See
+// http.Server.ServeTLS.
+func ListenAndServeTLS(addr, certFile, keyFile string, opts ...OptionFunc) error {
+	return listenAndServe(context.Background(), addr, certFile, keyFile, nil, opts)
+}
+
+// Start starts serving statsviz, configured with the provided options, on a
+// random free port of the loopback interface, and returns the URL of the user
+// interface, such as http://127.0.0.1:43567/debug/statsviz/. It's meant for
+// ephemeral debugging, in tests or development tools:
+//
+//	url, stop, err := statsviz.Start()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer stop()
+//	log.Println("statsviz at", url)
+//
+// stop shuts the HTTP server down, closing all connections, and stops the
+// statsviz server. It can be called more than once.
+func Start(opts ...OptionFunc) (url string, stop func(), err error) {
+	s, err := NewServer(opts...)
+	if err != nil {
+		return "", nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		s.Stop()
+		return "", nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer s.Stop()
+		serve(ctx, s, l, "", "")
+	}()
+	stop = func() {
+		cancel()
+		// Stopping s right away ends the streaming responses, which the HTTP
+		// server shutdown would wait for otherwise.
+		s.Stop()
+		<-done
+	}
+	return "http://" + l.Addr().String() + s.root + "/", stop, nil
+}
+
+// listenAndServe listens on addr and serves statsviz until ctx is cancelled.
+// If not nil, listening is called with the address the server listens on.
+func listenAndServe(ctx context.Context, addr, certFile, keyFile string, listening func(net.Addr), opts []OptionFunc) error {
+	s, err := NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	defer s.Stop()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if listening != nil {
+		listening(l.Addr())
+	}
+	return serve(ctx, s, l, certFile, keyFile)
+}
+
+// serve serves the statsviz handlers of s over l, using TLS if certFile is not
+// empty, until ctx is cancelled. It then shuts down the HTTP server, closes l
+// and returns ctx.Err().
+func serve(ctx context.Context, s *Server, l net.Listener, certFile, keyFile string) error {
+	defer l.Close()
+
+	mux := http.NewServeMux()
+	s.Register(mux)
+	srv := &http.Server{Handler: mux}
+
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errc <- srv.ServeTLS(l, certFile, keyFile)
+			return
+		}
+		errc <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Websocket connections are hijacked, Shutdown doesn't wait for them,
+	// s.Stop closes them.
+	srv.Shutdown(shutdownCtx)
+	<-errc
+	return ctx.Err()
+}
@@ -0,0 +1,170 @@
+package statsviz
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startListenAndServe runs listenAndServe on a random localhost port and
+// returns its address. The server is stopped at the end of the test.
+func startListenAndServe(t *testing.T, certFile, keyFile string, opts ...OptionFunc) string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addrc := make(chan net.Addr, 1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- listenAndServe(ctx, "127.0.0.1:0", certFile, keyFile, func(a net.Addr) { addrc <- a }, opts)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errc; err != context.Canceled {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	})
+
+	select {
+	case a := <-addrc:
+		return a.String()
+	case err := <-errc:
+		t.Fatal(err)
+	}
+	return ""
+}
+
+func checkIndexPage(t *testing.T, client *http.Client, url string) {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "<title>Statsviz</title>") {
+		t.Errorf("GET %s: got status %d and body %q, want the index page", url, resp.StatusCode, body)
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	t.Parallel()
+
+	addr := startListenAndServe(t, "", "", Root("/foo/bar"))
+	checkIndexPage(t, http.DefaultClient, "http://"+addr+"/foo/bar/")
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, pool := writeTestCert(t)
+	addr := startListenAndServe(t, certFile, keyFile)
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	checkIndexPage(t, client, "https://"+addr+"/debug/statsviz/")
+}
+
+func TestListenAndServeError(t *testing.T) {
+	t.Parallel()
+
+	if err := ListenAndServe("127.0.0.1:-1"); err == nil {
+		t.Errorf("got nil error for an invalid address, want non-nil")
+	}
+	if err := ListenAndServe("127.0.0.1:0", SendFrequency(0)); err == nil {
+		t.Errorf("got nil error for an invalid option, want non-nil")
+	}
+}
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+
+	url, stop, err := Start(Root("/stats"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if !strings.HasPrefix(url, "http://127.0.0.1:") || !strings.HasSuffix(url, "/stats/") {
+		t.Fatalf("got URL %q, want http://127.0.0.1:<port>/stats/", url)
+	}
+	checkIndexPage(t, http.DefaultClient, url)
+
+	stop()
+	stop()
+	addr := strings.TrimSuffix(strings.TrimPrefix(url, "http://"), "/stats/")
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Errorf("listener still accepts connections after stop")
+	}
+
+	if _, _, err := Start(SendFrequency(0)); err == nil {
+		t.Errorf("got nil error for an invalid option, want non-nil")
+	}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key in
+// a temporary directory. It returns their paths and a pool with the
+// certificate.
+func writeTestCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"statsviz test"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
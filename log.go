@@ -0,0 +1,17 @@
+package statsviz
+
+// A logger logs server events, such as websocket clients connecting and
+// disconnecting. Its methods take a message followed by alternating keys and
+// values, it's satisfied by *slog.Logger, see WithLogger.
+type logger interface {
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger is the default logger, it discards all events.
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,20 @@
+//go:build go1.19
+// +build go1.19
+
+package statsviz
+
+import (
+	"math"
+	"runtime/debug"
+)
+
+// memoryLimit returns the current soft memory limit of the Go runtime, as set
+// by GOMEMLIMIT or debug.SetMemoryLimit, or 0 if there's no limit.
+func memoryLimit() int64 {
+	// A negative input doesn't change the limit, only returns it.
+	limit := debug.SetMemoryLimit(-1)
+	if limit == math.MaxInt64 {
+		return 0
+	}
+	return limit
+}
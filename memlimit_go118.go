@@ -0,0 +1,10 @@
+//go:build !go1.19
+// +build !go1.19
+
+package statsviz
+
+// memoryLimit returns 0 since Go versions before 1.19 have no soft memory
+// limit.
+func memoryLimit() int64 {
+	return 0
+}
@@ -0,0 +1,50 @@
+//go:build go1.19
+// +build go1.19
+
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	// Not parallel since we're changing the memory limit.
+	defer debug.SetMemoryLimit(debug.SetMemoryLimit(math.MaxInt64))
+
+	s := newServer()
+
+	buf, err := json.Marshal(s.handshake())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "memoryLimit") {
+		t.Errorf("handshake %s contains memoryLimit, want it omitted when there's no limit", buf)
+	}
+
+	const limit = 1 << 30
+	debug.SetMemoryLimit(limit)
+
+	buf, err = json.Marshal(s.handshake())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hs handshake
+	if err := json.Unmarshal(buf, &hs); err != nil {
+		t.Fatal(err)
+	}
+	if hs.MemoryLimit != limit {
+		t.Errorf("handshake %s: got memoryLimit %d, want %d", buf, hs.MemoryLimit, limit)
+	}
+
+	// The limit is re-read with each stats.
+	debug.SetMemoryLimit(2 * limit)
+	st := newStats()
+	s.collect(newSampler(), &st)
+	if st.MemoryLimit != 2*limit {
+		t.Errorf("stats: got MemoryLimit %d, want %d", st.MemoryLimit, 2*limit)
+	}
+}
@@ -0,0 +1,77 @@
+package statsviz
+
+import (
+	"strings"
+)
+
+// WithMetricPrefix sets the prefix of the metric names exported by the
+// Prometheus handler of the server, by PublishExpvar and by the
+// statsvizotel package, so that they don't collide with the names of other
+// exporters. For example, with the "statsviz" prefix, /gc/heap/allocs:bytes
+// is exported to Prometheus as statsviz_go_gc_heap_allocs_bytes_total. See
+// MetricName.
+//
+// The prefix can't start with a digit. By default, there's no prefix.
+func WithMetricPrefix(prefix string) OptionFunc {
+	return func(s *Server) error {
+		if prefix != "" && prefix[0] >= '0' && prefix[0] <= '9' {
+			return configError("WithMetricPrefix", "metric prefix %q can't start with a digit", prefix)
+		}
+		s.metricPrefix = prefix
+		return nil
+	}
+}
+
+// MetricPrefix returns the prefix of the exported metric names, set by
+// WithMetricPrefix.
+func (s *Server) MetricPrefix() string {
+	return s.metricPrefix
+}
+
+// MetricName converts the runtime/metrics name into the name of an exported
+// metric: prefix, if not empty, then "go", the segments of the metric path and
+// its unit, separated by sep. Other characters than ASCII letters, digits and
+// '_' are replaced by '_', except sep in prefix. For example, with the
+// "statsviz" prefix and '_' as separator, /gc/heap/allocs-by-size:bytes
+// becomes statsviz_go_gc_heap_allocs_by_size_bytes.
+//
+// All statsviz exporters name metrics with MetricName, so that names are
+// consistent from one to the other.
+func MetricName(prefix, name string, sep byte) string {
+	unit := ""
+	if i := strings.IndexByte(name, ':'); i != -1 {
+		name, unit = name[:i], name[i+1:]
+	}
+
+	var sb strings.Builder
+	if prefix != "" {
+		sb.WriteString(sanitizeName(prefix, sep))
+		sb.WriteByte(sep)
+	}
+	sb.WriteString("go")
+	for _, s := range strings.Split(name, "/") {
+		if s == "" {
+			continue
+		}
+		sb.WriteByte(sep)
+		sb.WriteString(sanitizeName(s, 0))
+	}
+	if unit != "" {
+		// Keep the unit in the name since a runtime metric can exist with
+		// different units, e.g. /gc/heap/allocs:bytes and :objects.
+		sb.WriteByte(sep)
+		sb.WriteString(sanitizeName(unit, 0))
+	}
+	return sb.String()
+}
+
+// sanitizeName replaces the characters of s other than ASCII letters, digits,
+// '_' and keep by '_'.
+func sanitizeName(s string, keep byte) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || (keep != 0 && r == rune(keep)) {
+			return r
+		}
+		return '_'
+	}, s)
+}
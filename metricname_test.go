@@ -0,0 +1,57 @@
+package statsviz
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		prefix, name string
+		sep          byte
+		want         string
+	}{
+		{"", "/gc/heap/allocs:bytes", '_', "go_gc_heap_allocs_bytes"},
+		{"statsviz", "/gc/heap/allocs:bytes", '_', "statsviz_go_gc_heap_allocs_bytes"},
+		{"statsviz", "/gc/heap/allocs-by-size:bytes", '_', "statsviz_go_gc_heap_allocs_by_size_bytes"},
+		{"statsviz", "/cpu/classes/gc/mark/assist:cpu-seconds", '_', "statsviz_go_cpu_classes_gc_mark_assist_cpu_seconds"},
+		{"my-app", "/sched/goroutines:goroutines", '_', "my_app_go_sched_goroutines_goroutines"},
+		{"my.app", "/sched/goroutines:goroutines", '.', "my.app.go.sched.goroutines.goroutines"},
+		{"", "/weird name*:units", '.', "go.weird_name_.units"},
+	}
+	for _, tt := range tests {
+		if got := MetricName(tt.prefix, tt.name, tt.sep); got != tt.want {
+			t.Errorf("MetricName(%q, %q, %q) = %q, want %q", tt.prefix, tt.name, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestWithMetricPrefix(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithMetricPrefix("statsviz"), SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	if got := srv.MetricPrefix(); got != "statsviz" {
+		t.Errorf("got prefix %q, want %q", got, "statsviz")
+	}
+
+	body := scrapeServer(t, srv)
+	for _, want := range []string{"\nstatsviz_go_gc_heap_allocs_bytes_total ", "\nstatsviz_go_gc_heap_goal_bytes "} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Prometheus metrics don't contain %q", strings.TrimSpace(want))
+		}
+	}
+	if strings.Contains(body, "\ngo_") {
+		t.Errorf("Prometheus metrics contain unprefixed names")
+	}
+
+	if _, err := NewServer(WithMetricPrefix("1app")); err == nil {
+		t.Errorf("got nil error for a prefix starting with a digit, want non-nil")
+	}
+}
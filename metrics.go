@@ -0,0 +1,212 @@
+package statsviz
+
+import (
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+// sampler reads the runtime metrics supported by the current Go runtime.
+type sampler struct {
+	descs   []metrics.Description
+	samples []metrics.Sample
+	idx     map[string]int // sample index by metric name
+
+	t          time.Time                   // time of the last read
+	transforms map[string]*transformState  // per-series transform state
+	ratios     map[[2]string]*counterRatio // per metrics pair ratio state
+	hists      map[string][]uint64         // per heatmap previous counts
+	averages   map[string]*movingAverage   // per-series smoothing state
+	histTime   time.Time                   // last sampling of histograms
+
+	gc debug.GCStats // reused by sinceLastGC
+
+	// gcSample reads gcCyclesMetric, which is read even if no plot needs it,
+	// but not part of the stats metrics. prevCycles and prevTime are the
+	// number of cycles and the time of the previous call to gcEvent.
+	gcSample   [1]metrics.Sample
+	prevCycles float64
+	prevTime   time.Time
+
+	logger logger          // reports unsupported metrics
+	bad    map[string]bool // metrics already reported unsupported
+}
+
+// newSampler returns a sampler reading all supported runtime metrics.
+func newSampler() *sampler {
+	return newSamplerOf(nil)
+}
+
+// newSamplerOf returns a sampler only reading the named metrics, among the
+// supported ones. If names is nil, all supported metrics are read.
+func newSamplerOf(names []string) *sampler {
+	var keep map[string]bool
+	if names != nil {
+		keep = make(map[string]bool, len(names))
+		for _, name := range names {
+			keep[name] = true
+		}
+	}
+
+	s := sampler{
+		idx:        make(map[string]int),
+		transforms: make(map[string]*transformState),
+		ratios:     make(map[[2]string]*counterRatio),
+		hists:      make(map[string][]uint64),
+		logger:     nopLogger{},
+		bad:        make(map[string]bool),
+	}
+	for _, d := range metrics.All() {
+		if d.Kind == metrics.KindBad || (keep != nil && !keep[d.Name]) {
+			continue
+		}
+		s.idx[d.Name] = len(s.samples)
+		s.descs = append(s.descs, d)
+		s.samples = append(s.samples, metrics.Sample{Name: d.Name})
+	}
+	return &s
+}
+
+// read reads all runtime metrics, at time now.
+//
+// A metric can be reported unsupported, as metrics.KindBad, when the set of
+// metrics differs from one Go version to the other, in which case its value is
+// left out of stats. Each unsupported metric is logged once.
+func (s *sampler) read(now time.Time) {
+	metrics.Read(s.samples)
+	s.t = now
+
+	for i := range s.samples {
+		if name := s.samples[i].Name; s.samples[i].Value.Kind() == metrics.KindBad && !s.bad[name] {
+			s.bad[name] = true
+			s.logger.Warn("statsviz: unsupported runtime metric, left out of stats", "metric", name)
+		}
+	}
+}
+
+// transform applies tr to v, the last read value of the time series
+// identified by key, a runtime metric name or a user series key. The previous
+// value of the series, needed by Rate and Delta, is kept in the sampler.
+func (s *sampler) transform(key string, tr Transform, v float64) float64 {
+	if tr == Raw {
+		return v
+	}
+	st, ok := s.transforms[key]
+	if !ok {
+		st = &transformState{}
+		s.transforms[key] = st
+	}
+	return st.apply(tr, v, s.t)
+}
+
+// percent returns, as a percentage, the increase of the named cumulative
+// metric, which last read value is v, divided by the increase of the of
+// cumulative metric, which last read value is ofv, since the previous call to
+// percent for the same metrics. It returns NaN the first time, or if of didn't
+// increase.
+func (s *sampler) percent(name string, v float64, of string, ofv float64) float64 {
+	key := [2]string{name, of}
+	r, ok := s.ratios[key]
+	if !ok {
+		r = &counterRatio{}
+		s.ratios[key] = r
+	}
+	return 100 * r.update(v, ofv)
+}
+
+// A counterRatio computes the ratio of the increases of two cumulative
+// counters.
+type counterRatio struct {
+	num, den counterDelta
+}
+
+// update records the values of both counters and returns the ratio of their
+// increases since the previous update, or NaN if there's no previous update or
+// the denominator didn't increase.
+func (r *counterRatio) update(num, den float64) float64 {
+	dnum, dden := r.num.update(num), r.den.update(den)
+	if math.IsNaN(dnum) || math.IsNaN(dden) || dden <= 0 {
+		return math.NaN()
+	}
+	return dnum / dden
+}
+
+// A counterDelta computes the increase of a cumulative counter.
+type counterDelta struct {
+	prev float64
+	ok   bool // false until the first update
+}
+
+// update records the value v of the counter and returns its increase since
+// the previous update, or NaN if there's no previous update. The increase is
+// zero if the counter has been reset.
+func (d *counterDelta) update(v float64) float64 {
+	prev, ok := d.prev, d.ok
+	d.prev, d.ok = v, true
+	if !ok {
+		return math.NaN()
+	}
+	return math.Max(v-prev, 0)
+}
+
+// A counterRate computes the rate of change of a cumulative counter.
+type counterRate struct {
+	prev  float64
+	prevT time.Time // zero until the first update
+}
+
+// update records the value v of the counter at time t and returns the
+// per-second rate of change since the previous update, or NaN if there's no
+// previous update. The rate is zero if the counter has been reset.
+func (r *counterRate) update(v float64, t time.Time) float64 {
+	prev, prevT := r.prev, r.prevT
+	r.prev, r.prevT = v, t
+
+	dt := t.Sub(prevT).Seconds()
+	if prevT.IsZero() || dt <= 0 {
+		return math.NaN()
+	}
+	return math.Max(v-prev, 0) / dt
+}
+
+// value returns the last read value of the named metric. The returned value
+// is of kind metrics.KindBad if the metric is not supported.
+func (s *sampler) value(name string) metrics.Value {
+	i, ok := s.idx[name]
+	if !ok {
+		return metrics.Value{}
+	}
+	return s.samples[i].Value
+}
+
+// scalar returns the value of a scalar metric, or NaN if v is not a scalar.
+func scalar(v metrics.Value) float64 {
+	switch v.Kind() {
+	case metrics.KindUint64:
+		return float64(v.Uint64())
+	case metrics.KindFloat64:
+		return v.Float64()
+	}
+	return math.NaN()
+}
+
+// scalars stores the last read values of all scalar runtime metrics, indexed
+// by name, into vals, which is allocated if nil, and returns it. Histograms are
+// not part of the returned map.
+func (s *sampler) scalars(vals map[string]float64) map[string]float64 {
+	if vals == nil {
+		vals = make(map[string]float64, len(s.samples))
+	}
+	for _, sample := range s.samples {
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			vals[sample.Name] = float64(sample.Value.Uint64())
+		case metrics.KindFloat64:
+			vals[sample.Name] = sample.Value.Float64()
+		case metrics.KindBad:
+			delete(vals, sample.Name)
+		}
+	}
+	return vals
+}
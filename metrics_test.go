@@ -0,0 +1,118 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+func TestCounterRate(t *testing.T) {
+	t.Parallel()
+
+	var r counterRate
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// No previous value.
+	if got := r.update(1, t0); !math.IsNaN(got) {
+		t.Errorf("first update: got %v, want NaN", got)
+	}
+
+	// 3 seconds of mutex wait in 2 seconds.
+	if got, want := r.update(4, t0.Add(2*time.Second)), 1.5; got != want {
+		t.Errorf("second update: got %v, want %v", got, want)
+	}
+
+	// Same time, can't compute a rate.
+	if got := r.update(5, t0.Add(2*time.Second)); !math.IsNaN(got) {
+		t.Errorf("update at same time: got %v, want NaN", got)
+	}
+}
+
+func TestSamplerRate(t *testing.T) {
+	t.Parallel()
+
+	smp := newSampler()
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	smp.t = t0
+	if got := smp.transform("/metric:seconds", Rate, 10); !math.IsNaN(got) {
+		t.Errorf("first sample: got %v, want NaN", got)
+	}
+
+	smp.t = t0.Add(500 * time.Millisecond)
+	if got, want := smp.transform("/metric:seconds", Rate, 11), 2.0; got != want {
+		t.Errorf("second sample: got %v, want %v", got, want)
+	}
+
+	// Another metric has its own state.
+	if got := smp.transform("/other:seconds", Rate, 11); !math.IsNaN(got) {
+		t.Errorf("first sample of other metric: got %v, want NaN", got)
+	}
+}
+
+func TestSamplerPercent(t *testing.T) {
+	t.Parallel()
+
+	const (
+		gc    = "/cpu/classes/gc/total:cpu-seconds"
+		total = "/cpu/classes/total:cpu-seconds"
+	)
+
+	smp := newSampler()
+
+	// First tick, no previous values.
+	if got := smp.percent(gc, 1, total, 10); !math.IsNaN(got) {
+		t.Errorf("first tick: got %v, want NaN", got)
+	}
+
+	// Second tick: 0.5 GC cpu-seconds over 10 cpu-seconds.
+	if got, want := smp.percent(gc, 1.5, total, 20), 5.0; got != want {
+		t.Errorf("second tick: got %v, want %v", got, want)
+	}
+
+	// Third tick: no CPU time spent in GC.
+	if got, want := smp.percent(gc, 1.5, total, 25), 0.0; got != want {
+		t.Errorf("third tick: got %v, want %v", got, want)
+	}
+
+	// Fourth tick: total didn't increase.
+	if got := smp.percent(gc, 1.5, total, 25); !math.IsNaN(got) {
+		t.Errorf("fourth tick: got %v, want NaN", got)
+	}
+}
+
+func TestSamplerBadMetric(t *testing.T) {
+	t.Parallel()
+
+	const bogus = "/bogus/metric:units"
+	h, smp := newTestHub(t)
+	log := &warnLogger{}
+	smp.logger = log
+	smp.idx[bogus] = len(smp.samples)
+	smp.samples = append(smp.samples, metrics.Sample{Name: bogus})
+
+	frames := make(chan *frame, 1)
+	h.subs[&subscriber{ch: frames}] = struct{}{}
+	h.stats.Metrics = map[string]float64{bogus: 1} // stale value
+	for i := 0; i < 3; i++ {
+		h.tick(smp)
+		f := <-frames
+		var st struct{ Metrics map[string]float64 }
+		if err := json.Unmarshal(f.bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		f.release()
+		if v, ok := st.Metrics[bogus]; ok {
+			t.Errorf("got %s = %v, want it left out", bogus, v)
+		}
+		if len(st.Metrics) == 0 {
+			t.Errorf("got no metrics, want the supported ones")
+		}
+	}
+
+	if len(log.warns) != 1 {
+		t.Errorf("got warnings %q, want 1", log.warns)
+	}
+}
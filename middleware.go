@@ -0,0 +1,29 @@
+package statsviz
+
+import "net/http"
+
+// Middleware returns a middleware serving statsviz, configured with opts,
+// for applications building a single http.Handler rather than using a
+// http.ServeMux. See Server.Middleware.
+func Middleware(opts ...OptionFunc) (func(http.Handler) http.Handler, error) {
+	s, err := NewServer(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s.Middleware, nil
+}
+
+// Middleware returns a handler serving the requests to the statsviz
+// handlers, those under Root and to the websocket endpoint, as Register
+// does, and forwarding all other requests to next.
+func (s *Server) Middleware(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	s.Register(mux)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
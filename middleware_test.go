@@ -0,0 +1,64 @@
+package statsviz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	mw, err := Middleware(WithWebSocketPath("/live"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-App", "yes")
+		w.WriteHeader(http.StatusTeapot)
+	})
+	ts := httptest.NewServer(mw(app))
+	defer ts.Close()
+
+	tests := []struct {
+		path    string
+		wantApp bool
+	}{
+		{"/", true},
+		{"/api/users", true},
+		{"/debug", true},
+		{"/debug/statsvizz", true},
+		{"/debug/statsviz/", false},
+		{"/debug/statsviz/handshake", false},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if gotApp := resp.Header.Get("X-App") == "yes"; gotApp != tt.wantApp {
+			t.Errorf("GET %s: got status %d, reached the wrapped handler: %t, want %t", tt.path, resp.StatusCode, gotApp, tt.wantApp)
+		}
+		if !tt.wantApp && resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: got status %d, want %d", tt.path, resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/live", nil)
+	if err != nil {
+		t.Fatalf("websocket upgrade: %v", err)
+	}
+	ws.Close()
+}
+
+func TestMiddlewareInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Middleware(Root("/debug/%zz")); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+}
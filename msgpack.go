@@ -0,0 +1,307 @@
+package statsviz
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An EncodingKind is an encoding of the stats sent to the user interface.
+type EncodingKind string
+
+const (
+	// EncodingJSON encodes stats as JSON text messages. This is the default.
+	EncodingJSON EncodingKind = "json"
+
+	// EncodingMsgpack encodes stats as MessagePack binary websocket messages,
+	// which are smaller and cheaper to encode than JSON. Control messages are
+	// still JSON encoded.
+	EncodingMsgpack EncodingKind = "msgpack"
+
+	// EncodingProtobuf encodes stats as Protocol Buffers binary websocket
+	// messages, following the Frame message of statsviz.proto, so that other
+	// programs can consume them with generated types. The user interface can't
+	// decode them. Control messages are still JSON encoded.
+	EncodingProtobuf EncodingKind = "protobuf"
+)
+
+// binary reports whether stats are encoded as binary websocket messages.
+func (e EncodingKind) binary() bool {
+	return e != EncodingJSON
+}
+
+// WithEncoding sets the encoding of the stats sent to the user interface.
+// MessagePack and Protocol Buffers are only supported with the websocket
+// transport.
+func WithEncoding(e EncodingKind) OptionFunc {
+	return func(s *Server) error {
+		switch e {
+		case EncodingJSON, EncodingMsgpack, EncodingProtobuf:
+		default:
+			return configError("WithEncoding", "unknown encoding %q", e)
+		}
+		s.encoding = e
+		return nil
+	}
+}
+
+// A msgpackAppender appends its MessagePack encoding to a buffer. It's the
+// counterpart of json.Marshaler for appendMsgpack.
+type msgpackAppender interface {
+	appendMsgpack(b []byte) []byte
+}
+
+var (
+	appenderType = reflect.TypeOf((*msgpackAppender)(nil)).Elem()
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// appendMsgpack appends the MessagePack encoding of v to b. The encoded value
+// has the same shape as the JSON encoding of v: structs are encoded as maps,
+// keyed by field name, following the json struct tags, times are encoded as
+// RFC 3339 strings. Only the types found in stats are supported, and maps must
+// have string keys.
+func appendMsgpack(b []byte, v interface{}) ([]byte, error) {
+	return appendValue(b, reflect.ValueOf(v))
+}
+
+func appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, 0xc0), nil
+	}
+	if v.Type().Implements(appenderType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		return v.Interface().(msgpackAppender).appendMsgpack(b), nil
+	}
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		return appendString(b, t.Format(time.RFC3339Nano)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint(b, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return appendFloat(b, v.Float()), nil
+	case reflect.String:
+		return appendString(b, v.String()), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		return appendValue(b, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		fallthrough
+	case reflect.Array:
+		var err error
+		b = appendArrayLen(b, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendValue(b, v.Index(i)); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return b, fmt.Errorf("msgpack: unsupported map key type %s", v.Type().Key())
+		}
+		var err error
+		b = appendMapLen(b, v.Len())
+		for it := v.MapRange(); it.Next(); {
+			b = appendString(b, it.Key().String())
+			if b, err = appendValue(b, it.Value()); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		return appendStruct(b, v)
+	}
+	return b, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+}
+
+// A structField is a struct field encoded by appendStruct.
+type structField struct {
+	index     int
+	name      string
+	omitEmpty bool
+}
+
+var structFields sync.Map // reflect.Type -> []structField
+
+// fieldsOf returns the fields of struct type t that are encoded, like
+// encoding/json does, ignoring embedded structs, which stats don't have.
+func fieldsOf(t reflect.Type) []structField {
+	if f, ok := structFields.Load(t); ok {
+		return f.([]structField)
+	}
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" { // unexported
+			continue
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		f := structField{index: i, name: sf.Name}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		if name != "" {
+			f.name = name
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			if opt == "omitempty" {
+				f.omitEmpty = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	structFields.Store(t, fields)
+	return fields
+}
+
+func appendStruct(b []byte, v reflect.Value) ([]byte, error) {
+	fields := fieldsOf(v.Type())
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !isEmpty(v.Field(f.index)) {
+			n++
+		}
+	}
+
+	var err error
+	b = appendMapLen(b, n)
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if f.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		b = appendString(b, f.name)
+		if b, err = appendValue(b, fv); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// isEmpty reports whether v is empty, as defined by the omitempty option of
+// encoding/json.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func appendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i)) // negative fixint
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return appendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return appendUint32(append(b, 0xd2), uint32(i))
+	}
+	return appendUint64(append(b, 0xd3), uint64(i))
+}
+
+func appendUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= 0x7f:
+		return append(b, byte(u)) // positive fixint
+	case u <= math.MaxUint8:
+		return append(b, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return appendUint16(append(b, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		return appendUint32(append(b, 0xce), uint32(u))
+	}
+	return appendUint64(append(b, 0xcf), u)
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	return appendUint64(append(b, 0xcb), math.Float64bits(f))
+}
+
+func appendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = appendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = appendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendArrayLen(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, 0xdc), uint16(n))
+	}
+	return appendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendMapLen(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, 0xde), uint16(n))
+	}
+	return appendUint32(append(b, 0xdf), uint32(n))
+}
+
+func appendUint16(b []byte, u uint16) []byte {
+	return append(b, byte(u>>8), byte(u))
+}
+
+func appendUint32(b []byte, u uint32) []byte {
+	return append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func appendUint64(b []byte, u uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(u>>32)), uint32(u))
+}
@@ -0,0 +1,320 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// decodeMsgpack decodes the MessagePack value at the start of b, like
+// json.Unmarshal into an interface{} would: maps are decoded as
+// map[string]interface{} and numbers as float64. It returns the rest of b.
+func decodeMsgpack(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("unexpected end of input")
+	}
+	t, b := b[0], b[1:]
+
+	// n returns the next big-endian unsigned integer of size bytes.
+	n := func(size int) (uint64, error) {
+		if len(b) < size {
+			return 0, fmt.Errorf("unexpected end of input")
+		}
+		var u uint64
+		for _, c := range b[:size] {
+			u = u<<8 | uint64(c)
+		}
+		b = b[size:]
+		return u, nil
+	}
+	str := func(l uint64, err error) (interface{}, []byte, error) {
+		if err != nil || uint64(len(b)) < l {
+			return nil, nil, fmt.Errorf("invalid string")
+		}
+		return string(b[:l]), b[l:], nil
+	}
+	arr := func(l uint64, err error) (interface{}, []byte, error) {
+		if err != nil {
+			return nil, nil, err
+		}
+		a := make([]interface{}, l)
+		for i := range a {
+			if a[i], b, err = decodeMsgpack(b); err != nil {
+				return nil, nil, err
+			}
+		}
+		return a, b, nil
+	}
+	obj := func(l uint64, err error) (interface{}, []byte, error) {
+		if err != nil {
+			return nil, nil, err
+		}
+		m := make(map[string]interface{}, l)
+		for i := uint64(0); i < l; i++ {
+			var k, v interface{}
+			if k, b, err = decodeMsgpack(b); err != nil {
+				return nil, nil, err
+			}
+			if v, b, err = decodeMsgpack(b); err != nil {
+				return nil, nil, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("map key %v isn't a string", k)
+			}
+			m[ks] = v
+		}
+		return m, b, nil
+	}
+
+	switch {
+	case t < 0x80:
+		return float64(t), b, nil
+	case t < 0x90:
+		return obj(uint64(t&0x0f), nil)
+	case t < 0xa0:
+		return arr(uint64(t&0x0f), nil)
+	case t < 0xc0:
+		return str(uint64(t&0x1f), nil)
+	case t >= 0xe0:
+		return float64(int8(t)), b, nil
+	}
+	switch t {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	case 0xcb:
+		u, err := n(8)
+		return math.Float64frombits(u), b, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := n(1 << (t - 0xcc))
+		return float64(u), b, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (t - 0xd0)
+		u, err := n(size)
+		shift := 64 - 8*size
+		return float64(int64(u<<shift) >> shift), b, err
+	case 0xd9, 0xda, 0xdb:
+		return str(n(1 << (t - 0xd9)))
+	case 0xdc, 0xdd:
+		return arr(n(2 << (t - 0xdc)))
+	case 0xde, 0xdf:
+		return obj(n(2 << (t - 0xde)))
+	}
+	return nil, nil, fmt.Errorf("unsupported type 0x%x", t)
+}
+
+func TestAppendMsgpackScalars(t *testing.T) {
+	t.Parallel()
+
+	tests := []interface{}{
+		nil, true, false, 0, 1, 127, 128, 255, 256, 65535, 65536, uint64(math.MaxUint64),
+		-1, -32, -33, -128, -129, -32768, -32769, int64(math.MinInt64),
+		1.5, -0.25, math.MaxFloat64,
+		"", "short", strings.Repeat("a", 31), strings.Repeat("b", 32), strings.Repeat("c", 256), strings.Repeat("d", 70000),
+		[]int{}, []int{1, 2, 3}, make([]string, 16), make([]bool, 70000),
+		map[string]int{}, map[string]int{"a": 1},
+		struct {
+			A int `json:"a"`
+			B int `json:",omitempty"`
+			C int `json:"-"`
+			D string
+			e int
+		}{A: 1, C: 3, D: "d"},
+	}
+	for _, v := range tests {
+		b, err := appendMsgpack(nil, v)
+		if err != nil {
+			t.Errorf("%T %.20v: %v", v, v, err)
+			continue
+		}
+		got, rest, err := decodeMsgpack(b)
+		if err != nil || len(rest) != 0 {
+			t.Errorf("%T %.20v: decode error %v, %d bytes left", v, v, err, len(rest))
+			continue
+		}
+		want := jsonRoundTrip(t, v)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%T %.20v: got %.50v, want %.50v", v, v, got, want)
+		}
+	}
+
+	if _, err := appendMsgpack(nil, map[int]int{1: 1}); err == nil {
+		t.Errorf("got nil error encoding a map with int keys, want non-nil")
+	}
+}
+
+// jsonRoundTrip encodes v into JSON and decodes it into an interface{}.
+func jsonRoundTrip(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ret interface{}
+	if err := json.Unmarshal(buf, &ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+// collectTestStats collects stats with all plots, and a user plot with a NaN
+// value, with the server that collected them, stopped.
+func collectTestStats(t testing.TB) (stats, *Server) {
+	srv, err := NewServer(
+		WithPlot(TimeSeriesPlot{
+			Name: "user",
+			Series: []TimeSeries{
+				{Name: "one", Value: func() float64 { return 1 }},
+				{Name: "nan", Value: math.NaN},
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	st := newStats()
+	smp := srv.newSampler()
+	srv.collect(smp, &st)
+	srv.collect(smp, &st)
+	st.Historical = true
+	return st, srv
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	st, _ := collectTestStats(t)
+	b, err := appendMsgpack(nil, &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, rest, err := decodeMsgpack(b)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("decode error %v, %d bytes left", err, len(rest))
+	}
+
+	// The MessagePack encoded stats decode into the same value as the JSON
+	// encoded ones.
+	if want := jsonRoundTrip(t, &st); !reflect.DeepEqual(got, want) {
+		t.Fatalf("MessagePack and JSON encodings differ")
+	}
+
+	// And back to stats.
+	buf, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded stats
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Time.Equal(st.Time) || !decoded.Historical || decoded.GoVersion != st.GoVersion {
+		t.Errorf("got stats %+v, want %+v", decoded, st)
+	}
+	if vals := decoded.UserPlots["user"]; len(vals) != 2 || vals[0] != 1 || !math.IsNaN(vals[1]) {
+		t.Errorf("got user plot values %v, want [1 NaN]", vals)
+	}
+	if decoded.Mem == nil || decoded.Mem.NumGC != st.Mem.NumGC || len(decoded.Mem.BySize) != len(st.Mem.BySize) {
+		t.Errorf("got MemStats %+v, want %+v", decoded.Mem, st.Mem)
+	}
+}
+
+func TestWsMsgpack(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithEncoding(EncodingMsgpack), SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	typ, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != websocket.BinaryMessage {
+		t.Fatalf("got message type %d, want binary", typ)
+	}
+	v, _, err := decodeMsgpack(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := v.(map[string]interface{}); !ok || m["Metrics"] == nil {
+		t.Errorf("got %.100v, want stats", v)
+	}
+
+	// Control messages are still JSON.
+	if err := ws.WriteJSON(controlMsg{Type: "setFrequency", Millis: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if ack := readControl(t, ws); ack.Type != "setFrequency" {
+		t.Errorf("got control message %+v, want a setFrequency ack", ack)
+	}
+
+	if hs := srv.handshake(); hs.Encoding != EncodingMsgpack {
+		t.Errorf("got handshake encoding %q, want %q", hs.Encoding, EncodingMsgpack)
+	}
+}
+
+func TestWithEncodingInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithEncoding("xml")); err == nil {
+		t.Errorf("got nil error for an unknown encoding, want non-nil")
+	}
+	if _, err := NewServer(WithEncoding(EncodingMsgpack), Transport(TransportSSE)); err == nil {
+		t.Errorf("got nil error for msgpack over SSE, want non-nil")
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	st, srv := collectTestStats(b)
+
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf, _ = json.Marshal(&st)
+		}
+		b.ReportMetric(float64(len(buf)), "bytes/frame")
+	})
+	b.Run("msgpack", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf, _ = appendMsgpack(buf[:0], &st)
+		}
+		b.ReportMetric(float64(len(buf)), "bytes/frame")
+	})
+	b.Run("protobuf", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf = srv.appendProtobuf(buf[:0], &st, nil)
+		}
+		b.ReportMetric(float64(len(buf)), "bytes/frame")
+	})
+}
@@ -0,0 +1,70 @@
+package statsviz
+
+import "net/http"
+
+// NDJSONHandler returns a handler that streams the stats, sent at the default
+// frequency of 1 second, as newline-delimited JSON: one JSON encoded stats per
+// line, in the format of the websocket handler, over a plain HTTP response. It
+// allows to record the stats to a file, for example with:
+//
+//	curl -N http://localhost:8080/debug/statsviz/stream.ndjson > stats.ndjson
+//
+// Stats are sent until the client disconnects.
+func NDJSONHandler() http.Handler {
+	return newServer().ndjson()
+}
+
+// NDJSONHandler is like the NDJSONHandler function, stats are sent at the
+// server frequency. Its encoding must be JSON. Register mounts the handler on
+// the stream.ndjson endpoint.
+func (s *Server) NDJSONHandler() http.Handler {
+	return s.wrap(s.ndjson())
+}
+
+func (s *Server) ndjson() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		untrack, ok := s.track()
+		if !ok {
+			http.Error(w, "statsviz server stopped", http.StatusServiceUnavailable)
+			return
+		}
+		defer untrack()
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.encoding.binary() {
+			http.Error(w, "statsviz: JSON lines require the JSON encoding", http.StatusNotAcceptable)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		s.connected()
+		defer s.disconnected()
+
+		// As for websockets, ignore the error, the client is gone anyway.
+		_ = s.sendStats(r.Context().Done(), s.freq, 1, nil, nil, func(msg []byte, _ bool) error {
+			if _, err := w.Write(msg); err != nil {
+				return err
+			}
+			if len(msg) == 0 || msg[len(msg)-1] != '\n' {
+				if _, err := w.Write([]byte{'\n'}); err != nil {
+					return err
+				}
+			}
+			flusher.Flush()
+			return nil
+		})
+	}
+}
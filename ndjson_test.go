@@ -0,0 +1,92 @@
+package statsviz
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNDJSONHandler(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/debug/statsviz/stream.ndjson", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("got content type %q, want application/x-ndjson", ct)
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 1<<20)
+	var prev stats
+	for i := 0; i < 3; i++ {
+		if !sc.Scan() {
+			t.Fatalf("line %d: %v", i, sc.Err())
+		}
+		var st stats
+		if err := json.Unmarshal(sc.Bytes(), &st); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if st.Seq == 0 || len(st.Metrics) == 0 {
+			t.Errorf("line %d: got %s, want stats", i, sc.Bytes())
+		}
+		if i > 0 && !st.Time.After(prev.Time) {
+			t.Errorf("line %d: got time %v, want after %v", i, st.Time, prev.Time)
+		}
+		prev = st
+	}
+
+	// The stream ends once the client is gone.
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		srv.hubsMu.Lock()
+		n := len(srv.hubs)
+		srv.hubsMu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stats still sent after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNDJSONHandlerBinary(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithEncoding(EncodingMsgpack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	w := httptest.NewRecorder()
+	srv.NDJSONHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/stream.ndjson", nil))
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNotAcceptable)
+	}
+}
@@ -0,0 +1,32 @@
+package statsviz
+
+import "time"
+
+// samplerOverrunMetric is the name of the series of Metrics holding how late,
+// in seconds, stats have been collected compared to the send frequency, which
+// shows whether statsviz itself is starved, for example because the process
+// is overloaded.
+const samplerOverrunMetric = "statsviz/sampler_overrun_seconds"
+
+// overrunTolerance is the fraction of the send frequency below which delays
+// are considered as jitter, and not reported.
+const overrunTolerance = 10
+
+// overrun records that stats are collected at now, and returns how late, in
+// seconds, they're collected, given the previous collection time and the hub
+// interval. Overruns are logged.
+func (h *hub) overrun(now time.Time) float64 {
+	last := h.last
+	h.last = now
+	if last.IsZero() {
+		return 0
+	}
+	elapsed := now.Sub(last)
+	period := h.period()
+	late := elapsed - period
+	if late <= period/overrunTolerance {
+		return 0
+	}
+	h.s.logger.Warn("statsviz: stats collection overrun", "interval", period, "elapsed", elapsed, "overrun", late)
+	return late.Seconds()
+}
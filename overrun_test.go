@@ -0,0 +1,166 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// warnLogger records the messages of warnings.
+type warnLogger struct {
+	nopLogger
+	mu    sync.Mutex
+	warns []string
+}
+
+func (l *warnLogger) Warn(msg string, _ ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warns = append(l.warns, msg)
+}
+
+func TestSamplerOverrun(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newFakeClock(t0)
+
+	srv, err := NewServer(withClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	log := &warnLogger{}
+	srv.logger = log
+
+	frames, unsubscribe := srv.subscribe(time.Second)
+	defer unsubscribe()
+
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+
+	overrun := func() float64 {
+		t.Helper()
+		select {
+		case f := <-frames:
+			defer f.release()
+			var st struct{ Metrics map[string]float64 }
+			if err := json.Unmarshal(f.bytes(), &st); err != nil {
+				t.Fatal(err)
+			}
+			v, ok := st.Metrics[samplerOverrunMetric]
+			if !ok {
+				t.Fatalf("frame has no %s series", samplerOverrunMetric)
+			}
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for a frame")
+		}
+		return 0
+	}
+
+	// On time.
+	clk.advance(time.Second)
+	if got := overrun(); got != 0 {
+		t.Errorf("first tick: got overrun %v, want 0", got)
+	}
+	clk.advance(time.Second)
+	if got := overrun(); got != 0 {
+		t.Errorf("on time tick: got overrun %v, want 0", got)
+	}
+
+	// A slow tick: the ticker drops the ticks that can't be delivered.
+	clk.advance(3 * time.Second)
+	if got := overrun(); got != 2 {
+		t.Errorf("slow tick: got overrun %v, want 2", got)
+	}
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if len(log.warns) != 1 {
+		t.Errorf("got warnings %q, want 1 overrun warning", log.warns)
+	}
+}
+
+func TestOverrunJitter(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	h := &hub{s: srv, freq: time.Second}
+
+	t0 := time.Now()
+	tests := []struct {
+		after time.Duration
+		want  float64
+	}{
+		{0, 0},
+		{time.Second, 0},
+		{2050 * time.Millisecond, 0}, // jitter
+		{3500 * time.Millisecond, 0.45},
+	}
+	for _, tt := range tests {
+		if got := h.overrun(t0.Add(tt.after)); got < tt.want-1e-9 || got > tt.want+1e-9 {
+			t.Errorf("after %v: got overrun %v, want %v", tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestGCSamplingGap(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, err := NewServer(withClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	frames, unsubscribe := srv.subscribe(time.Second)
+	defer unsubscribe()
+
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+
+	gc := func(after time.Duration) *gcEvent {
+		t.Helper()
+		runtime.GC()
+		clk.advance(after)
+		select {
+		case f := <-frames:
+			defer f.release()
+			var st struct{ GC *gcEvent }
+			if err := json.Unmarshal(f.bytes(), &st); err != nil {
+				t.Fatal(err)
+			}
+			return st.GC
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for a frame")
+		}
+		return nil
+	}
+
+	// The first stats have no previous GC count.
+	gc(time.Second)
+
+	// On time, despite a GC.
+	if ev := gc(time.Second); ev == nil || ev.Gap != 0 {
+		t.Errorf("on time tick: got GC event %+v, want a GC without gap", ev)
+	}
+
+	// A delayed tick, coinciding with a GC.
+	if ev := gc(3 * time.Second); ev == nil || ev.Gap != 2 {
+		t.Errorf("delayed tick: got GC event %+v, want a GC with a 2s gap", ev)
+	}
+}
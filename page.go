@@ -0,0 +1,72 @@
+package statsviz
+
+import (
+	"os"
+)
+
+// defaultPageTitle is the title of the user interface page, unless set with
+// WithPageTitle.
+const defaultPageTitle = "Statsviz"
+
+// page holds the customizations of the user interface page.
+type page struct {
+	Title string
+	Logo  string // logo image URL, shown in the menu if set
+	Home  string // URL the menu header links to, if set
+
+	ws string // websocket endpoint path, <root>/ws if empty
+}
+
+// WithPageTitle sets the title of the user interface page, also shown in the
+// menu, "Statsviz" by default. It's useful to tell apart the dashboards of
+// several applications.
+func WithPageTitle(title string) OptionFunc {
+	return func(s *Server) error {
+		if title == "" {
+			return configError("WithPageTitle", "page title can't be empty")
+		}
+		s.page.Title = title
+		return nil
+	}
+}
+
+// WithPageLogo shows the image at logoURL in the menu of the user interface,
+// before the page title. If homeURL is set, the logo and title link to it, for
+// example to go back to an internal portal. Either URL may be empty.
+func WithPageLogo(logoURL, homeURL string) OptionFunc {
+	return func(s *Server) error {
+		s.page.Logo = logoURL
+		s.page.Home = homeURL
+		return nil
+	}
+}
+
+// WithAssetsDir serves the user interface page, index.html, and its assets
+// from dir rather than from the files embedded in statsviz. Files are read
+// each time they're requested, which allows to work on a customized user
+// interface without rebuilding the program. index.html is a template, rendered
+// like the embedded one.
+func WithAssetsDir(dir string) OptionFunc {
+	return func(s *Server) error {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return configError("WithAssetsDir", "invalid assets directory: %v", err)
+		}
+		if !fi.IsDir() {
+			return configError("WithAssetsDir", "invalid assets directory: %s is not a directory", dir)
+		}
+		s.assetsDir = os.DirFS(dir)
+		return nil
+	}
+}
+
+// WithDataOnly, if enable is true, makes the server a data source only, for
+// a user interface of your own: Register doesn't register the user interface
+// page, nor its assets, and Index responds with 404 Not Found. The websocket,
+// handshake and other data endpoints are registered as usual.
+func WithDataOnly(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.dataOnly = enable
+		return nil
+	}
+}
@@ -0,0 +1,145 @@
+package statsviz
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// renderIndex returns the index page served by a server created with opts.
+func renderIndex(t *testing.T, opts ...OptionFunc) string {
+	t.Helper()
+
+	srv, err := NewServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	w := httptest.NewRecorder()
+	srv.Index().ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/", nil))
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestPageCustomization(t *testing.T) {
+	t.Parallel()
+
+	def := renderIndex(t)
+	if !strings.Contains(def, "<title>Statsviz</title>") || strings.Contains(def, `class="logo"`) {
+		t.Errorf("default index page doesn't have the default title, or has a logo")
+	}
+
+	page := renderIndex(t,
+		WithPageTitle(`Acme <script>alert("x")</script>`),
+		WithPageLogo("https://acme.example/logo.png", "javascript:alert(1)"),
+	)
+	if strings.Contains(page, "<script>alert") {
+		t.Errorf("index page has the unescaped title")
+	}
+	if want := `<title>Acme &lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</title>`; !strings.Contains(page, want) {
+		t.Errorf("index page doesn't have the escaped title %s", want)
+	}
+	if want := `<img class="logo" src="https://acme.example/logo.png"`; !strings.Contains(page, want) {
+		t.Errorf("index page doesn't have the logo %s", want)
+	}
+	if strings.Contains(page, "javascript:alert") {
+		t.Errorf("index page links to an unsafe URL")
+	}
+
+	if _, err := NewServer(WithPageTitle("")); err == nil {
+		t.Errorf("got nil error for an empty page title, want non-nil")
+	}
+}
+
+func TestWithAssetsDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	index := `<html><head><title>{{.Page.Title}}</title></head><body>stub</body></html>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(index), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.js"), []byte("// stub"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	page := renderIndex(t, WithAssetsDir(dir))
+	if want := "<title>Statsviz</title></head><body>stub</body>"; !strings.Contains(page, want) {
+		t.Errorf("got index page %q, want the stub page", page)
+	}
+
+	srv, err := NewServer(WithAssetsDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	w := httptest.NewRecorder()
+	srv.Index().ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/app.js", nil))
+	if got := w.Body.String(); got != "// stub" {
+		t.Errorf("got asset %q, want the stub asset", got)
+	}
+
+	for _, dir := range []string{filepath.Join(dir, "missing"), filepath.Join(dir, "app.js")} {
+		if _, err := NewServer(WithAssetsDir(dir)); err == nil {
+			t.Errorf("WithAssetsDir(%q): got nil error, want non-nil", dir)
+		}
+	}
+}
+
+func TestWithDataOnly(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithDataOnly(true), SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, path := range []string{"/debug/statsviz/", "/debug/statsviz/app.js"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: got status %d, want %d", path, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/debug/statsviz/handshake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET handshake: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/debug/statsviz/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var st struct{ Seq uint64 }
+	if err := ws.ReadJSON(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Seq == 0 {
+		t.Errorf("got stats %+v, want a sequence number", st)
+	}
+}
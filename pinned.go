@@ -0,0 +1,80 @@
+package statsviz
+
+import (
+	"runtime"
+	"time"
+)
+
+// WithPinnedSampler runs the collection of stats on a goroutine locked to its
+// own OS thread, so that application goroutines don't delay it under load, on
+// a schedule fixed when it starts: each collection is timed against the
+// original cadence, whatever the delay of the previous one, and the instants
+// missed altogether are skipped. This costs an OS thread per send frequency in
+// use, which is why it's disabled by default.
+func WithPinnedSampler(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.pinnedSampler = enable
+		return nil
+	}
+}
+
+// runPinned is run, rather than the hub ticker loop, with WithPinnedSampler.
+func (h *hub) runPinned() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	sched := newSchedule(h.s.clock, h.period())
+	defer sched.stop()
+
+	smp := h.s.newSampler()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-h.s.done:
+			h.stop(smp)
+			return
+		case <-sched.timer.C():
+		}
+
+		start := h.s.clock.Now()
+		h.tick(smp)
+		if h.adapt(h.s.clock.Now().Sub(start)) {
+			sched.d = h.period()
+		}
+		sched.rearm()
+	}
+}
+
+// A schedule delivers ticks at fixed instants, every d since it started. Its
+// timer is armed for the next instant once a tick has been handled.
+type schedule struct {
+	clock clock
+	d     time.Duration
+	next  time.Time // instant of the tick the timer is armed for
+	timer timer
+}
+
+func newSchedule(c clock, d time.Duration) *schedule {
+	return &schedule{
+		clock: c,
+		d:     d,
+		next:  c.Now().Add(d),
+		timer: c.NewTimer(d),
+	}
+}
+
+// rearm arms the timer for the tick following the last one, or the first
+// instant still to come if the last tick has been handled that late.
+func (s *schedule) rearm() {
+	now := s.clock.Now()
+	s.next = s.next.Add(s.d)
+	if late := now.Sub(s.next); late >= 0 {
+		s.next = s.next.Add((late/s.d + 1) * s.d)
+	}
+	s.timer.Reset(s.next.Sub(now))
+}
+
+func (s *schedule) stop() {
+	s.timer.Stop()
+}
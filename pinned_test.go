@@ -0,0 +1,64 @@
+package statsviz
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPinnedSamplerSchedule(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newFakeClock(t0)
+	srv, err := NewServer(withClock(clk), WithPinnedSampler(true), SendFrequency(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	frames, unsubscribe := srv.subscribe(srv.freq)
+	defer unsubscribe()
+
+	// armed waits for the hub timer to be armed and returns its deadline.
+	armed := func() time.Time {
+		t.Helper()
+		select {
+		case <-clk.added:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the hub timer")
+		}
+		clk.mu.Lock()
+		defer clk.mu.Unlock()
+		return clk.tickers[len(clk.tickers)-1].deadline()
+	}
+
+	if got, want := armed(), t0.Add(time.Second); !got.Equal(want) {
+		t.Fatalf("got first tick at %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		advance time.Duration
+		want    time.Duration // next tick, since t0
+	}{
+		// An on time tick.
+		{time.Second, 2 * time.Second},
+		// A tick 500ms late doesn't delay the next one.
+		{1500 * time.Millisecond, 3 * time.Second},
+		// A tick 1.3s late, the following one is missed and skipped.
+		{1800 * time.Millisecond, 5 * time.Second},
+	}
+	elapsed := time.Duration(0)
+	for _, tt := range tests {
+		elapsed += tt.advance
+		clk.advance(tt.advance)
+		select {
+		case f := <-frames:
+			f.release()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("at %v: timeout waiting for a frame", elapsed)
+		}
+		if got, want := armed(), t0.Add(tt.want); !got.Equal(want) {
+			t.Errorf("at %v: got next tick at %v, want %v", elapsed, got, want)
+		}
+	}
+}
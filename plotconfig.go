@@ -0,0 +1,96 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// builtinPlotConfigs holds the configuration of the built-in plots drawn from
+// runtime.MemStats and of the goroutines plot, which the user interface
+// defines itself.
+var builtinPlotConfigs = map[Plot]PlotConfig{
+	PlotHeap: {
+		Name: "heap", Title: "Heap", Type: "scatter", YAxis: AxisLog,
+		Series: []TimeSeries{
+			{Name: "heap alloc", Unit: "bytes"},
+			{Name: "heap sys", Unit: "bytes"},
+			{Name: "heap idle", Unit: "bytes"},
+			{Name: "heap in-use", Unit: "bytes"},
+			{Name: "next gc", Unit: "bytes"},
+		},
+	},
+	PlotMSpanMCache: {
+		Name: "mspan-mcache", Title: "MSpan/MCache", Type: "scatter",
+		Series: []TimeSeries{
+			{Name: "mspan in-use", Unit: "bytes"},
+			{Name: "mspan sys", Unit: "bytes"},
+			{Name: "mcache in-use", Unit: "bytes"},
+			{Name: "mcache sys", Unit: "bytes"},
+		},
+	},
+	PlotSizeClasses: {
+		// One series per size class.
+		Name: "size-classes", Title: "Size Classes", Type: "heatmap",
+	},
+	PlotObjects: {
+		Name: "objects", Title: "Objects", Type: "scatter",
+		Series: []TimeSeries{
+			{Name: "live"},
+			{Name: "lookups"},
+			{Name: "heap"},
+		},
+	},
+	PlotGCFraction: {
+		Name: "gcfraction", Title: "GC CPU fraction", Type: "scatter",
+		Series: []TimeSeries{{Name: "gc/cpu", Unit: "percent", Scale: 100}},
+	},
+	PlotGoroutines: {
+		Name: "goroutines", Title: "Goroutines", Type: "scatter",
+		Series: []TimeSeries{{Name: "goroutines"}},
+	},
+}
+
+// PlotConfigs returns the configuration of the plots drawn by the user
+// interface: the enabled built-in plots, the runtime plots supported by the
+// current Go runtime and the user plots, in that order. Runtime plots and user
+// plots are configured as in the handshake of the user interface.
+func (s *Server) PlotConfigs() []PlotConfig {
+	var cfgs []PlotConfig
+	for _, p := range s.builtinPlots() {
+		cfgs = append(cfgs, builtinPlotConfigs[p])
+	}
+	for i := range s.runtimePlots {
+		cfgs = append(cfgs, s.runtimePlots[i].config())
+	}
+	for _, p := range s.plots() {
+		cfgs = append(cfgs, PlotConfig{
+			Name:   p.Name,
+			Title:  p.Title,
+			Type:   "scatter",
+			YAxis:  p.YAxis,
+			Series: append([]TimeSeries(nil), p.Series...),
+		})
+	}
+	return cfgs
+}
+
+// PlotConfigHandler returns a handler that responds with the configuration
+// of the plots of the default server, as a JSON array, so that external
+// tools can draw them without connecting to the websocket.
+func PlotConfigHandler() http.Handler {
+	return newServer().plotConfigs()
+}
+
+// PlotConfigHandler is like the PlotConfigHandler function, it responds with
+// the server plot configurations, see PlotConfigs. Register mounts the
+// handler on the plots.json endpoint.
+func (s *Server) PlotConfigHandler() http.Handler {
+	return s.wrap(s.unlessStopped(s.plotConfigs()))
+}
+
+func (s *Server) plotConfigs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.PlotConfigs())
+	}
+}
@@ -0,0 +1,64 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPlotConfigHandler(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithPlot(TimeSeriesPlot{
+		Name:   "user",
+		Series: []TimeSeries{{Name: "one", Unit: "seconds", Value: func() float64 { return 1 }}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/plots.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var cfgs []PlotConfig
+	if err := json.NewDecoder(w.Body).Decode(&cfgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != len(srv.PlotConfigs()) {
+		t.Errorf("got %d plots, want %d", len(cfgs), len(srv.PlotConfigs()))
+	}
+
+	byName := make(map[string]PlotConfig)
+	for _, cfg := range cfgs {
+		byName[cfg.Name] = cfg
+	}
+	heap, ok := byName[string(PlotHeap)]
+	if !ok {
+		t.Fatalf("got plots %v, want the heap plot", byName)
+	}
+	if heap.Type != "scatter" || heap.YAxis != AxisLog || len(heap.Series) != 5 {
+		t.Errorf("got heap plot %+v, want a log scatter plot with 5 series", heap)
+	}
+	for _, ts := range heap.Series {
+		if ts.Unit != "bytes" {
+			t.Errorf("heap series %q: got unit %q, want bytes", ts.Name, ts.Unit)
+		}
+	}
+	if user := byName["user"]; len(user.Series) != 1 || user.Series[0].Unit != "seconds" {
+		t.Errorf("got user plot %+v, want one series in seconds", user)
+	}
+
+	// Runtime plots are configured as in the handshake.
+	hs := srv.handshake()
+	for _, want := range hs.RuntimePlots {
+		if got := byName[want.Name]; got.Type != want.Type || len(got.Series) != len(want.Series) {
+			t.Errorf("runtime plot %s: got %+v, want %+v", want.Name, got, want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// A TimeSeriesPlot is a user-defined plot, showing one or more time series of
+// application values. It's added to the user interface with WithPlot.
+type TimeSeriesPlot struct {
+	// Name identifies the plot, it must be unique.
+	Name string `json:"name"`
+
+	// Title is shown on top of the plot.
+	Title string `json:"title"`
+
+	// Series holds the time series shown on the plot.
+	Series []TimeSeries `json:"series"`
+
+	// YAxis is the type of the y axis, linear by default.
+	YAxis Axis `json:"yaxis,omitempty"`
+}
+
+// An Axis is the type of a plot axis.
+type Axis string
+
+const (
+	// AxisLinear is a linear axis. This is the default.
+	AxisLinear Axis = "linear"
+
+	// AxisLog is a logarithmic axis, for values spanning several orders of
+	// magnitude. Values lower than or equal to zero can't be shown on a
+	// logarithmic axis, they're left out of the plot, as NaN values are.
+	AxisLog Axis = "log"
+)
+
+// A TimeSeries is a single time series of a TimeSeriesPlot.
+type TimeSeries struct {
+	// Name of the time series, as shown in the plot legend. It must be unique
+	// within the plot.
+	Name string `json:"name"`
+
+	// Value is called each time statistics are collected and returns the
+	// current value of the time series. It must be safe to call Value from
+	// multiple goroutines.
+	Value func() float64 `json:"-"`
+
+	// Transform is applied to the values returned by Value before they're
+	// plotted. Use Rate or Delta for cumulative counters. By default, values
+	// are plotted as is.
+	Transform Transform `json:"-"`
+
+	// Smoothing, if set, smooths the plotted values, once transformed. See
+	// MovingAverage.
+	Smoothing Smoothing `json:"-"`
+
+	// Unit is the unit of the plotted values, for example "seconds". Values
+	// in "bytes" are shown with binary prefixes, such as KiB or MiB.
+	Unit string `json:"unit,omitempty"`
+
+	// Scale, if not zero, multiplies the plotted values before they're shown,
+	// in Unit. For example, a series of values in KiB is shown in bytes with a
+	// Scale of 1024. Values are sent as is, scaling is done by the user
+	// interface.
+	Scale float64 `json:"scale,omitempty"`
+
+	// Color, if set, is the CSS color of the series, for example "#1f77b4"
+	// or "steelblue". By default, the user interface picks a color.
+	Color string `json:"color,omitempty"`
+}
+
+// WithPlot adds a user-defined plot to the user interface.
+func WithPlot(p TimeSeriesPlot) OptionFunc {
+	return func(s *Server) error {
+		if err := checkUserPlot(p, s.userPlots); err != nil {
+			return &ConfigError{Option: "WithPlot", Err: err}
+		}
+		s.userPlots = append(s.userPlots, newUserPlot(p))
+		return nil
+	}
+}
+
+// WithMetricFunc adds a user plot named after name, showing the values
+// returned by fn, in unit, which may be empty. fn is called each time stats are
+// collected, it must be safe to call from multiple goroutines. It's a shortcut
+// for WithPlot, with a single series, for example to plot the length of a
+// channel. As for all user plots, a panic in fn is logged, once, and its value
+// left out of the plot.
+func WithMetricFunc(name, unit string, fn func() float64) OptionFunc {
+	p := TimeSeriesPlot{
+		Name:   name,
+		Title:  name,
+		Series: []TimeSeries{{Name: name, Value: fn, Unit: unit}},
+	}
+	return func(s *Server) error {
+		if err := checkUserPlot(p, s.userPlots); err != nil {
+			return &ConfigError{Option: "WithMetricFunc", Err: err}
+		}
+		s.userPlots = append(s.userPlots, newUserPlot(p))
+		return nil
+	}
+}
+
+// checkUserPlot returns an error if p is not a valid user plot, or if one of
+// plots has the same name.
+func checkUserPlot(p TimeSeriesPlot, plots []*userPlot) error {
+	if p.Name == "" {
+		return fmt.Errorf("plot name can't be empty")
+	}
+	for _, up := range plots {
+		if up.Name == p.Name {
+			return fmt.Errorf("duplicate plot name %q", p.Name)
+		}
+	}
+	if len(p.Series) == 0 {
+		return fmt.Errorf("plot %q has no series", p.Name)
+	}
+	switch p.YAxis {
+	case "", AxisLinear, AxisLog:
+	default:
+		return fmt.Errorf("plot %q: unknown axis type %q", p.Name, p.YAxis)
+	}
+	names := make(map[string]bool, len(p.Series))
+	for _, ts := range p.Series {
+		if names[ts.Name] {
+			return fmt.Errorf("plot %q: duplicate series name %q", p.Name, ts.Name)
+		}
+		names[ts.Name] = true
+		if ts.Value == nil {
+			return fmt.Errorf("plot %q: series %q has a nil Value", p.Name, ts.Name)
+		}
+		if err := ts.Transform.check(); err != nil {
+			return fmt.Errorf("plot %q: series %q: %v", p.Name, ts.Name, err)
+		}
+		if err := ts.Smoothing.check(); err != nil {
+			return fmt.Errorf("plot %q: series %q: %v", p.Name, ts.Name, err)
+		}
+		if ts.Scale < 0 || math.IsNaN(ts.Scale) || math.IsInf(ts.Scale, 0) {
+			return fmt.Errorf("plot %q: series %q: invalid scale %v", p.Name, ts.Name, ts.Scale)
+		}
+	}
+	return nil
+}
+
+// AddPlot adds a user-defined plot to the user interface of a running server,
+// like WithPlot does at creation. Connected clients are told to show it, and
+// the stats collected from then on hold its values. It's safe to call AddPlot
+// concurrently, while clients are connected.
+func (s *Server) AddPlot(p TimeSeriesPlot) error {
+	return s.changePlots(func(cur []*userPlot) ([]*userPlot, controlMsg, error) {
+		if err := checkUserPlot(p, cur); err != nil {
+			return nil, controlMsg{}, err
+		}
+		up := newUserPlot(p)
+		up.logger = s.logger
+		plots := make([]*userPlot, len(cur), len(cur)+1)
+		copy(plots, cur)
+		return append(plots, up), controlMsg{Type: "plotsChanged", Added: []TimeSeriesPlot{p}}, nil
+	})
+}
+
+// RemovePlot removes the named user-defined plot, added with WithPlot or
+// AddPlot, from the user interface of a running server. Connected clients are
+// told to remove it, and the stats collected from then on don't hold its
+// values anymore. It's safe to call RemovePlot concurrently, while clients are
+// connected.
+func (s *Server) RemovePlot(name string) error {
+	return s.changePlots(func(cur []*userPlot) ([]*userPlot, controlMsg, error) {
+		plots := make([]*userPlot, 0, len(cur))
+		for _, up := range cur {
+			if up.Name != name {
+				plots = append(plots, up)
+			}
+		}
+		if len(plots) == len(cur) {
+			return nil, controlMsg{}, fmt.Errorf("no plot named %q", name)
+		}
+		return plots, controlMsg{Type: "plotsChanged", Removed: []string{name}}, nil
+	})
+}
+
+// changePlots replaces the user plots with those returned by change, called
+// with the current ones, unless it returns an error, and sends the returned
+// plotsChanged message to the connected clients. Clients are told before any
+// stats collected with the new plots is sent to them, since frames are only
+// broadcast with s.hubsMu held.
+func (s *Server) changePlots(change func(cur []*userPlot) ([]*userPlot, controlMsg, error)) error {
+	s.hubsMu.Lock()
+	defer s.hubsMu.Unlock()
+
+	s.plotsMu.Lock()
+	plots, msg, err := change(s.userPlots)
+	if err != nil {
+		s.plotsMu.Unlock()
+		return err
+	}
+	// Plots are copied on write, so that readers of s.userPlots don't have
+	// to hold plotsMu while they iterate.
+	s.userPlots = plots
+	s.plotsMu.Unlock()
+
+	s.sendControlLocked(msg)
+	return nil
+}
+
+// plots returns the current user plots, which must not be modified.
+func (s *Server) plots() []*userPlot {
+	s.plotsMu.RLock()
+	defer s.plotsMu.RUnlock()
+	return s.userPlots
+}
+
+// userPlot wraps a TimeSeriesPlot with the state required to sample it.
+type userPlot struct {
+	TimeSeriesPlot
+	panicked []sync.Once // one per series, to only log panics once
+	keys     []string    // per series key of the sampler transform state
+	logger   logger
+}
+
+func newUserPlot(p TimeSeriesPlot) *userPlot {
+	up := &userPlot{
+		TimeSeriesPlot: p,
+		panicked:       make([]sync.Once, len(p.Series)),
+		keys:           make([]string, len(p.Series)),
+		logger:         nopLogger{},
+	}
+	for i, ts := range p.Series {
+		// Runtime metrics names start with a '/', so user keys can't collide.
+		up.keys[i] = "user:" + p.Name + ":" + ts.Name
+	}
+	return up
+}
+
+// sample calls the Value function of all series and stores their values, once
+// transformed and smoothed with smp, into vals, which is allocated if it doesn't have the
+// right length, and returns it.
+func (p *userPlot) sample(smp *sampler, vals plotValues) plotValues {
+	if len(vals) != len(p.Series) {
+		vals = make(plotValues, len(p.Series))
+	}
+	for i, ts := range p.Series {
+		v := smp.transform(p.keys[i], ts.Transform, p.value(i))
+		vals[i] = smp.smooth(p.keys[i], ts.Smoothing, v)
+	}
+	return vals
+}
+
+// value returns the value of the ith series, or NaN if its Value function
+// panicked.
+func (p *userPlot) value(i int) (v float64) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.panicked[i].Do(func() {
+				p.logger.Error("statsviz: user plot series panicked", "plot", p.Name, "series", p.Series[i].Name, "error", r)
+			})
+			v = math.NaN()
+		}
+	}()
+
+	return p.Series[i].Value()
+}
+
+// plotValues holds the values of the series of a plot, at a given time.
+type plotValues []float64
+
+// MarshalJSON encodes the values into a JSON array, NaN and infinite values,
+// which have no JSON representation, are encoded as null.
+func (vals plotValues) MarshalJSON() ([]byte, error) {
+	// Size the buffer for the longest floats, such as rates, so that it never
+	// grows.
+	buf := make([]byte, 1, 2+25*len(vals))
+	buf[0] = '['
+	for i, v := range vals {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			buf = append(buf, "null"...)
+			continue
+		}
+		buf = strconv.AppendFloat(buf, v, 'g', -1, 64)
+	}
+	return append(buf, ']'), nil
+}
+
+// appendMsgpack encodes the values into a MessagePack array, NaN and infinite
+// values being encoded as nil, as in JSON.
+func (vals plotValues) appendMsgpack(b []byte) []byte {
+	b = appendArrayLen(b, len(vals))
+	for _, v := range vals {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			b = append(b, 0xc0)
+			continue
+		}
+		b = appendFloat(b, v)
+	}
+	return b
+}
+
+// UnmarshalJSON decodes a JSON array into vals, null values being decoded as
+// NaN.
+func (vals *plotValues) UnmarshalJSON(buf []byte) error {
+	var ptrs []*float64
+	if err := json.Unmarshal(buf, &ptrs); err != nil {
+		return err
+	}
+	*vals = make(plotValues, len(ptrs))
+	for i, p := range ptrs {
+		if p == nil {
+			(*vals)[i] = math.NaN()
+			continue
+		}
+		(*vals)[i] = *p
+	}
+	return nil
+}
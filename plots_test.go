@@ -0,0 +1,371 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWithPlot(t *testing.T) {
+	t.Parallel()
+
+	value := func() float64 { return 0 }
+
+	tests := []struct {
+		name  string
+		plots []TimeSeriesPlot
+	}{
+		{
+			name:  "empty name",
+			plots: []TimeSeriesPlot{{Series: []TimeSeries{{Name: "a", Value: value}}}},
+		},
+		{
+			name:  "no series",
+			plots: []TimeSeriesPlot{{Name: "plot"}},
+		},
+		{
+			name:  "nil value",
+			plots: []TimeSeriesPlot{{Name: "plot", Series: []TimeSeries{{Name: "a"}}}},
+		},
+		{
+			name:  "unknown transform",
+			plots: []TimeSeriesPlot{{Name: "plot", Series: []TimeSeries{{Name: "a", Value: value, Transform: 42}}}},
+		},
+		{
+			name:  "negative scale",
+			plots: []TimeSeriesPlot{{Name: "plot", Series: []TimeSeries{{Name: "a", Value: value, Scale: -1}}}},
+		},
+		{
+			name:  "unknown axis",
+			plots: []TimeSeriesPlot{{Name: "plot", Series: []TimeSeries{{Name: "a", Value: value}}, YAxis: "sqrt"}},
+		},
+		{
+			name: "duplicate series name",
+			plots: []TimeSeriesPlot{{Name: "plot", Series: []TimeSeries{
+				{Name: "a", Value: value},
+				{Name: "a", Value: value},
+			}}},
+		},
+		{
+			name: "duplicate name",
+			plots: []TimeSeriesPlot{
+				{Name: "plot", Series: []TimeSeries{{Name: "a", Value: value}}},
+				{Name: "plot", Series: []TimeSeries{{Name: "b", Value: value}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		var opts []OptionFunc
+		for _, p := range tt.plots {
+			opts = append(opts, WithPlot(p))
+		}
+		err := Register(http.NewServeMux(), opts...)
+		var cerr *ConfigError
+		if !errors.As(err, &cerr) || cerr.Option != "WithPlot" {
+			t.Errorf("%s: got error %v, want a WithPlot ConfigError", tt.name, err)
+		}
+	}
+}
+
+func TestUserPlot(t *testing.T) {
+	t.Parallel()
+
+	var counter int64
+	plot := TimeSeriesPlot{
+		Name:  "counter",
+		Title: "Counter",
+		YAxis: AxisLog,
+		Series: []TimeSeries{
+			{
+				Name:  "counter",
+				Value: func() float64 { return float64(atomic.AddInt64(&counter, 1)) },
+				Unit:  "bytes",
+				Scale: 1024,
+			},
+			{
+				Name:  "panicking",
+				Value: func() float64 { panic("boom") },
+			},
+		},
+	}
+
+	mux := http.NewServeMux()
+	if err := Register(mux, SendFrequency(10*time.Millisecond), WithPlot(plot)); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// The plot config is advertised in the handshake.
+	resp, err := http.Get(srv.URL + "/debug/statsviz/handshake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var hs handshake
+	if err := json.NewDecoder(resp.Body).Decode(&hs); err != nil {
+		t.Fatal(err)
+	}
+	if len(hs.Plots) != 1 || hs.Plots[0].Name != "counter" || len(hs.Plots[0].Series) != 2 {
+		t.Fatalf("handshake plots = %+v, want the counter plot", hs.Plots)
+	}
+	if hs.Plots[0].YAxis != AxisLog {
+		t.Errorf("got counter plot y axis %q, want %q", hs.Plots[0].YAxis, AxisLog)
+	}
+	if ts := hs.Plots[0].Series[0]; ts.Unit != "bytes" || ts.Scale != 1024 {
+		t.Errorf("got counter series unit %q and scale %v, want bytes and 1024", ts.Unit, ts.Scale)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/debug/statsviz/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// Check that the counter increments across stats, and that the panicking
+	// series doesn't prevent stats to be sent.
+	var prev float64
+	for i := 0; i < 3; i++ {
+		var st stats
+		if err := ws.ReadJSON(&st); err != nil {
+			t.Fatal(err)
+		}
+
+		vals := st.UserPlots["counter"]
+		if len(vals) != 2 {
+			t.Fatalf("got %d values for the counter plot, want 2", len(vals))
+		}
+		if vals[0] <= prev {
+			t.Errorf("counter value = %v, want > %v", vals[0], prev)
+		}
+		prev = vals[0]
+		if !math.IsNaN(vals[1]) {
+			t.Errorf("panicking series value = %v, want NaN", vals[1])
+		}
+	}
+}
+
+func TestPlotValuesJSON(t *testing.T) {
+	t.Parallel()
+
+	vals := plotValues{1.5, math.NaN(), math.Inf(1), 2}
+	buf, err := json.Marshal(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[1.5,null,null,2]"; string(buf) != want {
+		t.Errorf("json.Marshal(%v) = %s, want %s", vals, buf, want)
+	}
+
+	var got plotValues
+	if err := json.Unmarshal(buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || got[0] != 1.5 || !math.IsNaN(got[1]) || !math.IsNaN(got[2]) || got[3] != 2 {
+		t.Errorf("json.Unmarshal(%s) = %v", buf, got)
+	}
+}
+
+func TestAddRemovePlot(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// next returns the next stats, and the control message received before
+	// them, if any.
+	next := func() (st stats, ctrl controlMsg) {
+		t.Helper()
+		for {
+			ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m controlMsg
+			if json.Unmarshal(msg, &m) == nil && m.Type != "" {
+				ctrl = m
+				continue
+			}
+			if err := json.Unmarshal(msg, &st); err != nil {
+				t.Fatal(err)
+			}
+			return st, ctrl
+		}
+	}
+
+	if st, _ := next(); len(st.UserPlots) != 0 {
+		t.Fatalf("got user plots %v, want none", st.UserPlots)
+	}
+
+	plot := TimeSeriesPlot{Name: "dyn", Series: []TimeSeries{{Name: "one", Value: func() float64 { return 1 }}}}
+	if err := srv.AddPlot(plot); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.AddPlot(plot); err == nil {
+		t.Errorf("got nil error adding a plot twice, want non-nil")
+	}
+	if hs := srv.handshake(); len(hs.Plots) != 1 || hs.Plots[0].Name != "dyn" {
+		t.Errorf("got handshake plots %+v, want the added one", hs.Plots)
+	}
+
+	// Stats collected before the plot was added may be sent after the
+	// notification.
+	st, ctrl := next()
+	if ctrl.Type != "plotsChanged" || len(ctrl.Added) != 1 || ctrl.Added[0].Name != "dyn" || len(ctrl.Added[0].Series) != 1 || len(ctrl.Removed) != 0 {
+		t.Fatalf("got control message %+v, want the added plot", ctrl)
+	}
+	if _, ok := st.UserPlots["dyn"]; !ok {
+		st, _ = next()
+	}
+	if vals := st.UserPlots["dyn"]; len(vals) != 1 || vals[0] != 1 {
+		t.Fatalf("got user plots %v, want the added plot", st.UserPlots)
+	}
+
+	if err := srv.RemovePlot("dyn"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RemovePlot("dyn"); err == nil {
+		t.Errorf("got nil error removing an unknown plot, want non-nil")
+	}
+
+	st, ctrl = next()
+	if ctrl.Type != "plotsChanged" || len(ctrl.Removed) != 1 || ctrl.Removed[0] != "dyn" || len(ctrl.Added) != 0 {
+		t.Fatalf("got control message %+v, want the removed plot", ctrl)
+	}
+	if _, ok := st.UserPlots["dyn"]; ok {
+		st, _ = next()
+	}
+	if _, ok := st.UserPlots["dyn"]; ok {
+		t.Errorf("got user plots %v, want the plot removed", st.UserPlots)
+	}
+}
+
+func TestHandshakeAfterAddRemovePlot(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	mux := http.NewServeMux()
+	srv.Register(mux)
+
+	// plotNames returns the names of the plots of the served handshake.
+	plotNames := func() map[string]bool {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/statsviz/handshake", nil))
+		var hs struct {
+			Plots []struct{ Name string }
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &hs); err != nil {
+			t.Fatal(err)
+		}
+		names := make(map[string]bool)
+		for _, p := range hs.Plots {
+			names[p.Name] = true
+		}
+		return names
+	}
+
+	plot := TimeSeriesPlot{Name: "dyn", Series: []TimeSeries{{Name: "one", Value: func() float64 { return 1 }}}}
+	if err := srv.AddPlot(plot); err != nil {
+		t.Fatal(err)
+	}
+	if names := plotNames(); !names["dyn"] {
+		t.Errorf("got handshake plots %v after AddPlot, want dyn", names)
+	}
+
+	if err := srv.RemovePlot("dyn"); err != nil {
+		t.Fatal(err)
+	}
+	if names := plotNames(); names["dyn"] {
+		t.Errorf("got handshake plots %v after RemovePlot, want dyn removed", names)
+	}
+}
+
+func TestSeriesColor(t *testing.T) {
+	t.Parallel()
+
+	plot := TimeSeriesPlot{
+		Name: "plot",
+		Series: []TimeSeries{
+			{Name: "colored", Value: func() float64 { return 1 }, Color: "#1f77b4"},
+			{Name: "default", Value: func() float64 { return 2 }},
+		},
+	}
+	buf, err := json.Marshal(plot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Series []map[string]interface{} `json:"series"`
+	}
+	if err := json.Unmarshal(buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if c := got.Series[0]["color"]; c != "#1f77b4" {
+		t.Errorf("got color %v, want #1f77b4", c)
+	}
+	if c, ok := got.Series[1]["color"]; ok {
+		t.Errorf("got color %v for a series without color, want none", c)
+	}
+}
+
+func TestWithMetricFunc(t *testing.T) {
+	t.Parallel()
+
+	var n int64
+	srv, err := NewServer(
+		WithMetricFunc("queue", "items", func() float64 { return float64(atomic.AddInt64(&n, 1)) }),
+		WithMetricFunc("panicking", "", func() float64 { panic("boom") }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	hs := srv.handshake()
+	if len(hs.Plots) != 2 || hs.Plots[0].Name != "queue" || len(hs.Plots[0].Series) != 1 || hs.Plots[0].Series[0].Unit != "items" {
+		t.Fatalf("got handshake plots %+v, want the queue plot", hs.Plots)
+	}
+
+	st := newStats()
+	smp := srv.newSampler()
+	for i := 1; i <= 3; i++ {
+		srv.collect(smp, &st)
+		if vals := st.UserPlots["queue"]; len(vals) != 1 || vals[0] != float64(i) {
+			t.Errorf("frame %d: got queue values %v, want [%d]", i, vals, i)
+		}
+		if vals := st.UserPlots["panicking"]; len(vals) != 1 || !math.IsNaN(vals[0]) {
+			t.Errorf("frame %d: got panicking values %v, want [NaN]", i, vals)
+		}
+	}
+
+	if _, err := NewServer(WithMetricFunc("queue", "", math.NaN), WithMetricFunc("queue", "", math.NaN)); err == nil {
+		t.Errorf("got nil error for a duplicate metric func, want non-nil")
+	}
+}
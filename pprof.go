@@ -0,0 +1,125 @@
+package statsviz
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithPprof serves the runtime profiles, like net/http/pprof does, under
+// <root>/pprof/, and links them from the user interface. Since they're served
+// under the statsviz root, they don't collide with net/http/pprof handlers
+// registered at /debug/pprof/, and they're protected by WithBasicAuth, if set.
+//
+// The profiles are:
+//   - <root>/pprof/: the list of profiles.
+//   - <root>/pprof/profile: a CPU profile, lasting for the number of seconds
+//     given by the seconds parameter, 30 by default.
+//   - <root>/pprof/<name>: the runtime/pprof profile of that name, such as heap
+//     or goroutine. The debug parameter selects the output format, as for
+//     pprof.Profile.WriteTo, and gc=1 runs a garbage collection before
+//     taking a heap profile.
+//
+// The execution trace, cmdline and symbol handlers of net/http/pprof are not
+// provided. Unlike net/http/pprof, statsviz doesn't register any handler on
+// http.DefaultServeMux.
+func WithPprof(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.pprof = enable
+		return nil
+	}
+}
+
+// pprofHandler returns the handler serving the runtime profiles under
+// <root>/pprof/.
+func (s *Server) pprofHandler() http.HandlerFunc {
+	prefix := s.root + "/pprof/"
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch name := strings.TrimPrefix(r.URL.Path, prefix); name {
+		case "":
+			pprofIndex(w)
+		case "profile":
+			cpuProfile(w, r)
+		default:
+			namedProfile(w, r, name)
+		}
+	}
+}
+
+var pprofIndexTmpl = template.Must(template.New("pprof").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Statsviz - profiles</title></head>
+<body>
+<p>Profiles:</p>
+<table>
+<tr><td></td><td><a href="profile">profile</a> (CPU, 30 seconds)</td></tr>
+{{range .}}<tr><td align="right">{{.Count}}</td><td><a href="{{.Name}}?debug=1">{{.Name}}</a></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func pprofIndex(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pprofIndexTmpl.Execute(w, pprof.Profiles())
+}
+
+// setProfileHeaders sets the headers of a profile response. Binary profiles
+// are downloaded as a file named after the profile.
+func setProfileHeaders(w http.ResponseWriter, name string, debug int) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if debug != 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+}
+
+func namedProfile(w http.ResponseWriter, r *http.Request, name string) {
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, fmt.Sprintf("unknown profile %q", name), http.StatusNotFound)
+		return
+	}
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	if name == "heap" && r.FormValue("gc") != "" {
+		runtime.GC()
+	}
+	setProfileHeaders(w, name, debug)
+	p.WriteTo(w, debug)
+}
+
+func cpuProfile(w http.ResponseWriter, r *http.Request) {
+	secs := int64(30)
+	if v := r.FormValue("seconds"); v != "" {
+		var err error
+		secs, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || secs <= 0 {
+			http.Error(w, fmt.Sprintf("invalid seconds %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	setProfileHeaders(w, "profile", 0)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		// The headers are not written yet, remove the profile ones.
+		w.Header().Del("Content-Disposition")
+		w.Header().Del("X-Content-Type-Options")
+		http.Error(w, fmt.Sprintf("can't enable CPU profiling: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer pprof.StopCPUProfile()
+
+	t := time.NewTimer(time.Duration(secs) * time.Second)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+}
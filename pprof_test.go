@@ -0,0 +1,109 @@
+package statsviz
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newPprofServer(t *testing.T, opts ...OptionFunc) *httptest.Server {
+	t.Helper()
+
+	srv, err := NewServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func get(t *testing.T, url string) (*http.Response, []byte) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestWithPprof(t *testing.T) {
+	t.Parallel()
+
+	ts := newPprofServer(t, WithPprof(true), Root("/foo"))
+
+	// Binary profile, gzip compressed protobuf.
+	resp, body := get(t, ts.URL+"/foo/pprof/heap")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET heap: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("GET heap: got Content-Type %q, want %q", ct, "application/octet-stream")
+	}
+	if !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		t.Errorf("GET heap: body isn't gzip compressed")
+	}
+
+	// Text profile.
+	resp, body = get(t, ts.URL+"/foo/pprof/goroutine?debug=1")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("GET goroutine: got Content-Type %q, want text/plain", ct)
+	}
+	if !strings.Contains(string(body), "goroutine profile:") {
+		t.Errorf("GET goroutine: got body %q, want a goroutine profile", body)
+	}
+
+	// Index.
+	resp, body = get(t, ts.URL+"/foo/pprof/")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `href="heap?debug=1"`) {
+		t.Errorf("GET index: got status %d and body %q, want a link to the heap profile", resp.StatusCode, body)
+	}
+
+	resp, _ = get(t, ts.URL+"/foo/pprof/unknown")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET unknown: got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	resp, _ = get(t, ts.URL+"/foo/pprof/profile?seconds=abc")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET profile with invalid seconds: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	// The user interface links to the profiles.
+	_, body = get(t, ts.URL+"/foo/handshake")
+	var hs handshake
+	if err := json.Unmarshal(body, &hs); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, l := range hs.Links {
+		found = found || l.URL == "/foo/pprof/"
+	}
+	if !found {
+		t.Errorf("got handshake links %+v, want a link to /foo/pprof/", hs.Links)
+	}
+}
+
+func TestWithoutPprof(t *testing.T) {
+	t.Parallel()
+
+	ts := newPprofServer(t)
+
+	resp, _ := get(t, ts.URL+"/debug/statsviz/pprof/heap")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET heap: got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,65 @@
+package statsviz
+
+import (
+	"math"
+	"strconv"
+)
+
+// WithFloatPrecision rounds the values of the runtime and user metrics of
+// JSON encoded stats to the given number of significant digits, which
+// shrinks the messages without visible loss on the plots. Integer values,
+// such as counters and byte counts, are never rounded. By default, values are
+// sent with full precision.
+func WithFloatPrecision(digits int) OptionFunc {
+	return func(s *Server) error {
+		if digits <= 0 || digits > 17 {
+			return configError("WithFloatPrecision", "float precision must be between 1 and 17 digits, got %d", digits)
+		}
+		s.precision = digits
+		return nil
+	}
+}
+
+// roundFloat rounds v to the given number of significant digits, unless it's
+// an integer.
+func roundFloat(v float64, digits int) float64 {
+	if v == math.Trunc(v) || math.IsNaN(v) {
+		return v
+	}
+	r, err := strconv.ParseFloat(strconv.FormatFloat(v, 'g', digits, 64), 64)
+	if err != nil {
+		return v
+	}
+	return r
+}
+
+// roundFloats stores in dst the values of src rounded to the given number of
+// significant digits, and returns dst, allocated if nil. It returns nil if src
+// is nil.
+func roundFloats(dst, src map[string]float64, digits int) map[string]float64 {
+	if src == nil {
+		return nil
+	}
+	if dst == nil {
+		dst = make(map[string]float64, len(src))
+	}
+	for name := range dst {
+		if _, ok := src[name]; !ok {
+			delete(dst, name)
+		}
+	}
+	for name, v := range src {
+		dst[name] = roundFloat(v, digits)
+	}
+	return dst
+}
+
+// roundStats returns a copy of st which metrics values are rounded with the
+// server float precision, stored in the metrics and user maps, which are
+// reused if not nil.
+func (s *Server) roundStats(st *stats, metrics, user map[string]float64) stats {
+	c := *st
+	c.Metrics = roundFloats(metrics, st.Metrics, s.precision)
+	c.UserMetrics = roundFloats(user, st.UserMetrics, s.precision)
+	return c
+}
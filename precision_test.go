@@ -0,0 +1,84 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestRoundFloat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		v    float64
+		want float64
+	}{
+		{1.2345678901234567e+08, 1.23e+08},
+		{123456789, 123456789},
+		{123456789.123, 123000000},
+		{0.000123456, 0.000123},
+		{-2.71828, -2.72},
+		{1 << 60, 1 << 60},
+		{math.Inf(1), math.Inf(1)},
+	}
+	for _, tt := range tests {
+		if got := roundFloat(tt.v, 3); got != tt.want {
+			t.Errorf("roundFloat(%v, 3) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+	if got := roundFloat(math.NaN(), 3); !math.IsNaN(got) {
+		t.Errorf("roundFloat(NaN, 3) = %v, want NaN", got)
+	}
+}
+
+func TestWithFloatPrecision(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithFloatPrecision(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	st := newStats()
+	st.Metrics = map[string]float64{
+		"/float:seconds": 0.123456789,
+		"/int:bytes":     123456789,
+	}
+	st.UserMetrics = map[string]float64{"ratio": 2.0 / 3}
+	buf, err := srv.marshalStats(&st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"/float:seconds":0.123`, `"/int:bytes":123456789`, `"ratio":0.667`} {
+		if !strings.Contains(string(buf), want) {
+			t.Errorf("got stats %s, want them to contain %s", buf, want)
+		}
+	}
+	if st.Metrics["/float:seconds"] != 0.123456789 {
+		t.Errorf("got metric %v after encoding, want it unchanged", st.Metrics["/float:seconds"])
+	}
+
+	// Frames sent to clients are rounded too.
+	h, smp := newTestHub(t, WithFloatPrecision(3))
+	h.tick(smp)
+	var frame struct{ Metrics map[string]float64 }
+	if err := json.Unmarshal(h.out, &frame); err != nil {
+		t.Fatal(err)
+	}
+	if len(frame.Metrics) == 0 {
+		t.Fatal("got a frame without metrics")
+	}
+	for name, v := range frame.Metrics {
+		if roundFloat(v, 3) != v {
+			t.Errorf("%s: got %v, want 3 significant digits", name, v)
+		}
+	}
+
+	for _, digits := range []int{0, 18} {
+		if _, err := NewServer(WithFloatPrecision(digits)); err == nil {
+			t.Errorf("WithFloatPrecision(%d): got nil error, want non-nil", digits)
+		}
+	}
+}
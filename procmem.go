@@ -0,0 +1,34 @@
+package statsviz
+
+// procMemPlot is the name of the plot added by WithProcessMemory.
+const procMemPlot = "process-memory"
+
+// WithProcessMemory adds a plot showing the resident set size (RSS) and the
+// virtual memory size of the process, alongside the total memory mapped by
+// the Go runtime. It helps understanding a memory growth that the Go heap
+// doesn't explain, such as memory allocated by C code. It's only supported on
+// Linux, where /proc/self/statm is read at each tick, which is why it's
+// disabled by default. On other platforms, the plot is not shown.
+func WithProcessMemory(enable bool) OptionFunc {
+	return func(s *Server) error {
+		for i := range s.runtimePlots {
+			if s.runtimePlots[i].name == procMemPlot {
+				s.runtimePlots = append(s.runtimePlots[:i:i], s.runtimePlots[i+1:]...)
+				break
+			}
+		}
+		if !enable || !procMemSupported {
+			return nil
+		}
+		s.runtimePlots = append(s.runtimePlots, runtimePlot{
+			name:  procMemPlot,
+			title: "Process memory (bytes)",
+			series: []runtimeSeries{
+				{name: "rss", read: processRSS, readUnit: "bytes"},
+				{name: "virtual", read: processVirtual, readUnit: "bytes"},
+				{name: "go total", metric: "/memory/classes/total:bytes"},
+			},
+		})
+		return nil
+	}
+}
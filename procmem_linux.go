@@ -0,0 +1,50 @@
+package statsviz
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const procMemSupported = true
+
+// statmPath is the file the process memory sizes are read from.
+var statmPath = "/proc/self/statm"
+
+var pageSize = float64(os.Getpagesize())
+
+// processRSS returns the resident set size of the process in bytes, or NaN if
+// it can't be read.
+func processRSS() float64 {
+	_, rss := readStatm(statmPath)
+	return rss
+}
+
+// processVirtual returns the virtual memory size of the process in bytes, or
+// NaN if it can't be read.
+func processVirtual() float64 {
+	virtual, _ := readStatm(statmPath)
+	return virtual
+}
+
+// readStatm reads the virtual memory size and resident set size, in bytes,
+// from the statm file at path, see proc(5). Sizes are NaN if they can't be
+// read.
+func readStatm(path string) (virtual, rss float64) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return math.NaN(), math.NaN()
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return math.NaN(), math.NaN()
+	}
+	size, err1 := strconv.ParseUint(fields[0], 10, 64)
+	resident, err2 := strconv.ParseUint(fields[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return math.NaN(), math.NaN()
+	}
+	return float64(size) * pageSize, float64(resident) * pageSize
+}
@@ -0,0 +1,77 @@
+package statsviz
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadStatm(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "statm")
+	if err := ioutil.WriteFile(path, []byte("1000 250 100 10 0 300 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	page := float64(os.Getpagesize())
+	if virtual, rss := readStatm(path); virtual != 1000*page || rss != 250*page {
+		t.Errorf("got virtual %v and rss %v, want %v and %v", virtual, rss, 1000*page, 250*page)
+	}
+
+	for _, content := range []string{"", "1000", "x 250"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if virtual, rss := readStatm(path); !math.IsNaN(virtual) || !math.IsNaN(rss) {
+			t.Errorf("statm %q: got virtual %v and rss %v, want NaN", content, virtual, rss)
+		}
+	}
+	if virtual, rss := readStatm(filepath.Join(dir, "missing")); !math.IsNaN(virtual) || !math.IsNaN(rss) {
+		t.Errorf("missing statm: got virtual %v and rss %v, want NaN", virtual, rss)
+	}
+
+	// The process statm.
+	if virtual, rss := readStatm(statmPath); !(rss > 0) || !(virtual >= rss) {
+		t.Errorf("got virtual %v and rss %v, want positive values", virtual, rss)
+	}
+}
+
+func TestWithProcessMemory(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithProcessMemory(true), WithProcessMemory(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	count := 0
+	for i := range srv.runtimePlots {
+		if p := &srv.runtimePlots[i]; p.name == procMemPlot {
+			count++
+			smp := srv.newSampler()
+			smp.read(srv.clock.Now())
+			vals := p.sample(smp, nil)
+			if len(vals) != 3 || !(vals[0] > 0) || !(vals[1] > 0) || !(vals[2] > 0) {
+				t.Errorf("got values %v, want rss, virtual and Go total memory", vals)
+			}
+		}
+	}
+	if count != 1 {
+		t.Errorf("got %d process memory plots, want 1", count)
+	}
+
+	srv, err = NewServer(WithProcessMemory(true), WithProcessMemory(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	for _, p := range srv.runtimePlots {
+		if p.name == procMemPlot {
+			t.Errorf("got a process memory plot after WithProcessMemory(false), want none")
+		}
+	}
+}
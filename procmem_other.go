@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package statsviz
+
+import "math"
+
+// Process memory is only read on Linux.
+const procMemSupported = false
+
+func processRSS() float64     { return math.NaN() }
+func processVirtual() float64 { return math.NaN() }
@@ -0,0 +1,79 @@
+package statsviz
+
+import (
+	"runtime"
+	"sync"
+)
+
+// WithProfileControls allows users to change the sampling rates of the memory,
+// block and mutex profiles from the user interface, in order to get more
+// detailed profiles while investigating, for example, allocation hotspots.
+// Use it with WithPprof to download the profiles right away.
+//
+// The changes apply to the whole program. Note that a heap profile shows the
+// allocations as of the last garbage collection, so that a new memory profile
+// rate is only fully reflected once a garbage collection has run: download
+// the heap profile with gc=1. It's disabled by default.
+func WithProfileControls(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.profileControls = enable
+		return nil
+	}
+}
+
+// profileRates are the sampling rates of the runtime profiles. Nil rates are
+// left unchanged.
+type profileRates struct {
+	MemProfileRate       *int `json:"memProfileRate,omitempty"`       // see runtime.MemProfileRate
+	BlockProfileRate     *int `json:"blockProfileRate,omitempty"`     // see runtime.SetBlockProfileRate
+	MutexProfileFraction *int `json:"mutexProfileFraction,omitempty"` // see runtime.SetMutexProfileFraction
+}
+
+var (
+	// profileMu serializes the changes of the profile rates, which are
+	// global to the program.
+	profileMu sync.Mutex
+
+	// blockProfileRate is the last rate set by statsviz, since the runtime
+	// doesn't report it. 0, the runtime default, until then.
+	blockProfileRate int
+)
+
+// setProfileRates sets the profile rates, if allowed, in response to a
+// setProfileRates control message. The reply carries the previous rates.
+func (s *Server) setProfileRates(rates *profileRates) controlMsg {
+	reply := controlMsg{Type: "setProfileRates"}
+	if !s.profileControls {
+		reply.Error = "profile controls are disabled"
+		return reply
+	}
+	if rates == nil {
+		rates = &profileRates{}
+	}
+	if (rates.MemProfileRate != nil && *rates.MemProfileRate < 0) ||
+		(rates.MutexProfileFraction != nil && *rates.MutexProfileFraction < 0) {
+		reply.Error = "profile rates must be positive or zero"
+		return reply
+	}
+
+	profileMu.Lock()
+	defer profileMu.Unlock()
+
+	mem, block, mutex := runtime.MemProfileRate, blockProfileRate, runtime.SetMutexProfileFraction(-1)
+	reply.Rates = &profileRates{MemProfileRate: &mem, BlockProfileRate: &block, MutexProfileFraction: &mutex}
+
+	if rates.MemProfileRate != nil {
+		runtime.MemProfileRate = *rates.MemProfileRate
+	}
+	if rates.BlockProfileRate != nil {
+		runtime.SetBlockProfileRate(*rates.BlockProfileRate)
+		blockProfileRate = *rates.BlockProfileRate
+		if blockProfileRate < 0 {
+			blockProfileRate = 0
+		}
+	}
+	if rates.MutexProfileFraction != nil {
+		runtime.SetMutexProfileFraction(*rates.MutexProfileFraction)
+	}
+	return reply
+}
@@ -0,0 +1,64 @@
+package statsviz
+
+import (
+	"runtime"
+	"testing"
+)
+
+// Not parallel, the profile rates are global.
+func TestWithProfileControls(t *testing.T) {
+	memRate, mutexFraction := runtime.MemProfileRate, runtime.SetMutexProfileFraction(-1)
+	defer func() {
+		runtime.MemProfileRate = memRate
+		runtime.SetBlockProfileRate(0)
+		runtime.SetMutexProfileFraction(mutexFraction)
+		blockProfileRate = 0
+	}()
+
+	mem, block, mutex := 4096, 10000, 100
+	reply := requestControl(t, controlMsg{Type: "setProfileRates", Rates: &profileRates{MemProfileRate: &mem, BlockProfileRate: &block, MutexProfileFraction: &mutex}}, WithProfileControls(true))
+	if reply.Type != "setProfileRates" || reply.Error != "" || reply.Rates == nil {
+		t.Fatalf("got reply %+v, want a setProfileRates reply with the previous rates", reply)
+	}
+	prev := reply.Rates
+	if *prev.MemProfileRate != memRate || *prev.BlockProfileRate != 0 || *prev.MutexProfileFraction != mutexFraction {
+		t.Errorf("got previous rates %d, %d, %d, want %d, 0, %d", *prev.MemProfileRate, *prev.BlockProfileRate, *prev.MutexProfileFraction, memRate, mutexFraction)
+	}
+	if runtime.MemProfileRate != mem {
+		t.Errorf("got memory profile rate %d, want %d", runtime.MemProfileRate, mem)
+	}
+	if got := runtime.SetMutexProfileFraction(-1); got != mutex {
+		t.Errorf("got mutex profile fraction %d, want %d", got, mutex)
+	}
+
+	// Unset rates are left unchanged, the previous ones are those just set.
+	mem = 1
+	reply = requestControl(t, controlMsg{Type: "setProfileRates", Rates: &profileRates{MemProfileRate: &mem}}, WithProfileControls(true))
+	if reply.Error != "" || reply.Rates == nil {
+		t.Fatalf("got reply %+v, want the previous rates", reply)
+	}
+	if prev := reply.Rates; *prev.MemProfileRate != 4096 || *prev.BlockProfileRate != block || *prev.MutexProfileFraction != mutex {
+		t.Errorf("got previous rates %d, %d, %d, want 4096, %d, %d", *prev.MemProfileRate, *prev.BlockProfileRate, *prev.MutexProfileFraction, block, mutex)
+	}
+	if runtime.MemProfileRate != 1 {
+		t.Errorf("got memory profile rate %d, want 1", runtime.MemProfileRate)
+	}
+	if got := runtime.SetMutexProfileFraction(-1); got != mutex {
+		t.Errorf("got mutex profile fraction %d, want %d", got, mutex)
+	}
+
+	mem = -1
+	if reply := requestControl(t, controlMsg{Type: "setProfileRates", Rates: &profileRates{MemProfileRate: &mem}}, WithProfileControls(true)); reply.Error == "" {
+		t.Errorf("got reply %+v, want an error for a negative rate", reply)
+	}
+}
+
+func TestProfileControlsDisabled(t *testing.T) {
+	t.Parallel()
+
+	mem := 1
+	reply := requestControl(t, controlMsg{Type: "setProfileRates", Rates: &profileRates{MemProfileRate: &mem}})
+	if reply.Type != "setProfileRates" || reply.Error == "" {
+		t.Errorf("got reply %+v, want a setProfileRates reply with an error", reply)
+	}
+}
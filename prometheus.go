@@ -0,0 +1,174 @@
+package statsviz
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrometheusHandler returns an handler that responds with the runtime metrics
+// sampled by statsviz, in the Prometheus text exposition format.
+//
+// Runtime metric names are translated into valid Prometheus metric names, for
+// example /gc/heap/allocs:bytes becomes go_gc_heap_allocs_bytes_total.
+// Runtime histograms are exposed as Prometheus histograms.
+func PrometheusHandler() http.Handler {
+	return prometheusHandler("", "")
+}
+
+// PrometheusHandler is like the PrometheusHandler function, but rather than
+// reading the runtime metrics on each scrape, it serves those most recently
+// collected by the server at its send frequency, which are the metrics of the
+// enabled plots and thresholds. Runtime histograms are left out, since the
+// server only keeps the values plotted from them. Metric names start with the
+// server metric prefix, see WithMetricPrefix, and metrics have the server
+// labels, see WithLabels. Like the other server endpoints, the handler checks
+// basic authentication and the allowed networks, see WithBasicAuth and
+// WithAllowedCIDRs.
+//
+// Nothing is served until the server collected its first stats, which it
+// starts doing when PrometheusHandler is called.
+func (s *Server) PrometheusHandler() http.Handler {
+	// Start tracking the latest stats right away.
+	s.latestStats()
+
+	prefix, labels := s.metricPrefix, promLabels(s.labels)
+	descs := metrics.All()
+
+	return s.wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		if st := s.latestStats(); st != nil {
+			for i := range descs {
+				if v, ok := st.Metrics[descs[i].Name]; ok {
+					writePromScalar(bw, prefix, labels, &descs[i], promFloat(v))
+				}
+			}
+		}
+		bw.Flush()
+	})
+}
+
+// prometheusHandler returns the Prometheus handler reading the runtime metrics
+// on each scrape, labels are formatted by promLabels.
+func prometheusHandler(prefix, labels string) http.Handler {
+	var mu sync.Mutex
+	smp := newSampler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		smp.read(time.Now())
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for i := range smp.samples {
+			writePromMetric(bw, prefix, labels, &smp.descs[i], &smp.samples[i])
+		}
+		bw.Flush()
+	})
+}
+
+// promName converts a runtime/metrics metric name into a valid Prometheus
+// metric name, starting with prefix if not empty.
+func promName(prefix string, d *metrics.Description) string {
+	name := MetricName(prefix, d.Name, '_')
+	if d.Cumulative && d.Kind != metrics.KindFloat64Histogram {
+		name += "_total"
+	}
+	return name
+}
+
+var promHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func promFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writePromMetric(w *bufio.Writer, prefix, labels string, d *metrics.Description, s *metrics.Sample) {
+	switch s.Value.Kind() {
+	case metrics.KindUint64:
+		writePromScalar(w, prefix, labels, d, strconv.FormatUint(s.Value.Uint64(), 10))
+	case metrics.KindFloat64:
+		writePromScalar(w, prefix, labels, d, promFloat(s.Value.Float64()))
+	case metrics.KindFloat64Histogram:
+		name := promName(prefix, d)
+		writePromHeader(w, name, "histogram", d)
+		writePromHistogram(w, name, labels, s.Value.Float64Histogram())
+	}
+}
+
+// writePromScalar writes the scalar metric described by d, with its formatted
+// value.
+func writePromScalar(w *bufio.Writer, prefix, labels string, d *metrics.Description, value string) {
+	name := promName(prefix, d)
+	typ := "gauge"
+	if d.Cumulative {
+		typ = "counter"
+	}
+	writePromHeader(w, name, typ, d)
+
+	series := name
+	if labels != "" {
+		series += "{" + labels + "}"
+	}
+	w.WriteString(series + " " + value + "\n")
+}
+
+func writePromHeader(w *bufio.Writer, name, typ string, d *metrics.Description) {
+	w.WriteString("# HELP " + name + " " + promHelpEscaper.Replace(d.Description) + "\n")
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// writePromHistogram writes h as a Prometheus histogram, with labels. Since
+// runtime histograms only provide bucket counts, the sum is approximated from
+// the bucket boundaries.
+func writePromHistogram(w *bufio.Writer, name, labels string, h *metrics.Float64Histogram) {
+	le, suffix := "{le=", " "
+	if labels != "" {
+		le, suffix = "{"+labels+",le=", "{"+labels+"} "
+	}
+	var count uint64
+	var sum float64
+	for i, n := range h.Counts {
+		count += n
+		if n != 0 {
+			sum += float64(n) * bucketValue(h.Buckets[i], h.Buckets[i+1])
+		}
+
+		upper := h.Buckets[i+1]
+		if math.IsInf(upper, +1) {
+			// The +Inf bucket is always written last, after the loop.
+			continue
+		}
+		w.WriteString(name + "_bucket" + le + `"` + promFloat(upper) + `"} ` + strconv.FormatUint(count, 10) + "\n")
+	}
+	w.WriteString(name + "_bucket" + le + `"+Inf"} ` + strconv.FormatUint(count, 10) + "\n")
+	w.WriteString(name + "_sum" + suffix + promFloat(sum) + "\n")
+	w.WriteString(name + "_count" + suffix + strconv.FormatUint(count, 10) + "\n")
+}
+
+// bucketValue returns a representative value of the [lo, hi) bucket.
+func bucketValue(lo, hi float64) float64 {
+	switch {
+	case math.IsInf(lo, -1):
+		return hi
+	case math.IsInf(hi, +1):
+		return lo
+	}
+	return (lo + hi) / 2
+}
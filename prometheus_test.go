@@ -0,0 +1,196 @@
+package statsviz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime/metrics"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest("GET", "http://example.com/metrics", nil)
+	w := httptest.NewRecorder()
+	PrometheusHandler().ServeHTTP(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("http status %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	body := w.Body.String()
+	var p expfmt.TextParser
+	families, err := p.TextToMetricFamilies(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("can't parse prometheus output: %v\n%s", err, body)
+	}
+
+	if _, ok := families["go_gc_heap_allocs_bytes_total"]; !ok {
+		t.Errorf("missing metric family %q", "go_gc_heap_allocs_bytes_total")
+	}
+
+	// /gc/pauses:seconds is a histogram available since go1.16.
+	const hist = "go_gc_pauses_seconds"
+	if _, ok := families[hist]; !ok {
+		t.Errorf("missing metric family %q", hist)
+	}
+	for _, suffix := range []string{"_bucket{", "_sum ", "_count "} {
+		if !strings.Contains(body, "\n"+hist+suffix) {
+			t.Errorf("histogram %s doesn't have a %q line", hist, suffix)
+		}
+	}
+}
+
+// scrapeServer returns the body served by the Prometheus handler of srv, once
+// srv collected its first stats.
+func scrapeServer(t *testing.T, srv *Server) string {
+	t.Helper()
+	h := srv.PrometheusHandler()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		if body := w.Body.String(); body != "" {
+			return body
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the first stats")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServerPrometheusHandler(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	srv, err := NewServer(withClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	h := srv.PrometheusHandler()
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if body := w.Body.String(); body != "" {
+		t.Errorf("got metrics %q before the first stats, want none", body)
+	}
+
+	// The clock doesn't move anymore, the served values are those of the
+	// latest stats.
+	clk.advance(srv.freq)
+	body := scrapeServer(t, srv)
+	st := srv.latestStats()
+
+	var p expfmt.TextParser
+	families, err := p.TextToMetricFamilies(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("can't parse prometheus output: %v\n%s", err, body)
+	}
+	if _, ok := families["go_gc_pauses_seconds"]; ok {
+		t.Errorf("got histogram go_gc_pauses_seconds, want histograms left out")
+	}
+	var n int
+	for _, d := range metrics.All() {
+		v, ok := st.Metrics[d.Name]
+		if !ok {
+			continue
+		}
+		n++
+		name := promName("", &d)
+		f, ok := families[name]
+		if !ok {
+			t.Errorf("missing metric family %q", name)
+			continue
+		}
+		m := f.Metric[0]
+		got := m.GetGauge().GetValue()
+		if m.Counter != nil {
+			got = m.GetCounter().GetValue()
+		}
+		if got != v {
+			t.Errorf("%s: got %v, want %v", name, got, v)
+		}
+	}
+	if n == 0 || len(families) != n {
+		t.Errorf("got %d metric families, want the %d runtime metrics of the latest stats", len(families), n)
+	}
+}
+
+func TestPromName(t *testing.T) {
+	tests := []struct {
+		desc metrics.Description
+		want string
+	}{
+		{
+			desc: metrics.Description{Name: "/gc/heap/allocs:bytes", Kind: metrics.KindUint64, Cumulative: true},
+			want: "go_gc_heap_allocs_bytes_total",
+		},
+		{
+			desc: metrics.Description{Name: "/gc/heap/goal:bytes", Kind: metrics.KindUint64},
+			want: "go_gc_heap_goal_bytes",
+		},
+		{
+			desc: metrics.Description{Name: "/gc/heap/allocs-by-size:bytes", Kind: metrics.KindFloat64Histogram, Cumulative: true},
+			want: "go_gc_heap_allocs_by_size_bytes",
+		},
+		{
+			desc: metrics.Description{Name: "/cpu/classes/gc/mark/assist:cpu-seconds", Kind: metrics.KindFloat64, Cumulative: true},
+			want: "go_cpu_classes_gc_mark_assist_cpu_seconds_total",
+		},
+	}
+	for _, tt := range tests {
+		if got := promName("", &tt.desc); got != tt.want {
+			t.Errorf("promName(%q) = %q, want %q", tt.desc.Name, got, tt.want)
+		}
+	}
+}
+
+func TestServerPrometheusHandlerAuth(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithBasicAuth("user", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	h := srv.PrometheusHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d without credentials, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if body := w.Body.String(); strings.Contains(body, "go_") {
+		t.Errorf("got metrics without credentials:\n%s", body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.SetBasicAuth("user", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d with credentials, want %d", w.Code, http.StatusOK)
+		}
+		if w.Body.Len() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the first stats")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,207 @@
+package statsviz
+
+import (
+	"math"
+	"sort"
+)
+
+// Protocol Buffers wire types.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireLen    = 2
+)
+
+// appendProtobuf appends the Protocol Buffers encoding of st to b, as a Frame
+// message described by statsviz.proto. Scalars hold the runtime and user
+// metrics, sorted by name, histograms hold the runtime heatmap plots and
+// series the values of the user plots and of the other runtime plots. If vm
+// isn't nil, the runtime metrics values are those of vm, see
+// WithVarintDeltas, and scalars only hold the user metrics.
+func (s *Server) appendProtobuf(b []byte, st *stats, vm *varintMetrics) []byte {
+	if !st.Time.IsZero() {
+		b = appendVarintField(b, 1, uint64(st.Time.UnixNano()))
+	}
+	if st.Seq != 0 {
+		b = appendVarintField(b, 2, st.Seq)
+	}
+	if st.GoVersion != "" {
+		b = appendBytesField(b, 3, st.GoVersion)
+	}
+	if st.Historical {
+		b = appendVarintField(b, 4, 1)
+	}
+
+	names := make([]string, 0, len(st.Metrics)+len(st.UserMetrics))
+	if vm == nil {
+		for name := range st.Metrics {
+			names = append(names, name)
+		}
+	}
+	for name := range st.UserMetrics {
+		if _, ok := st.Metrics[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v, ok := st.Metrics[name]
+		if !ok || vm != nil {
+			v = st.UserMetrics[name]
+		}
+		// message Scalar { string name = 1; double value = 2; }
+		b = appendTag(b, 5, wireLen)
+		b = appendVarint(b, uint64(bytesFieldSize(name)+1+8))
+		b = appendBytesField(b, 1, name)
+		b = appendDoubleField(b, 2, v)
+	}
+
+	for i := range s.runtimePlots {
+		p := &s.runtimePlots[i]
+		vals, ok := st.RuntimePlots[p.name]
+		if !ok || p.heatmap == nil {
+			continue
+		}
+		// message Histogram { string name = 1; repeated double bounds = 2;
+		// repeated double counts = 3; }
+		b = appendTag(b, 6, wireLen)
+		b = appendVarint(b, uint64(bytesFieldSize(p.name)+packedSize(p.heatmap.bounds)+packedSize(vals)))
+		b = appendBytesField(b, 1, p.name)
+		b = appendPacked(b, 2, p.heatmap.bounds)
+		b = appendPacked(b, 3, vals)
+	}
+
+	appendSeries := func(b []byte, name string, vals []float64) []byte {
+		// message Series { string name = 1; repeated double values = 2; }
+		b = appendTag(b, 7, wireLen)
+		b = appendVarint(b, uint64(bytesFieldSize(name)+packedSize(vals)))
+		b = appendBytesField(b, 1, name)
+		return appendPacked(b, 2, vals)
+	}
+	for _, p := range s.plots() {
+		if vals, ok := st.UserPlots[p.Name]; ok {
+			b = appendSeries(b, p.Name, vals)
+		}
+	}
+	for i := range s.runtimePlots {
+		p := &s.runtimePlots[i]
+		if vals, ok := st.RuntimePlots[p.name]; ok && p.heatmap == nil {
+			b = appendSeries(b, p.name, vals)
+		}
+	}
+
+	if st.Truncated {
+		b = appendVarintField(b, 8, 1)
+	}
+	if st.Uptime != 0 {
+		b = appendDoubleField(b, 9, st.Uptime)
+	}
+	if st.GC != nil {
+		// message GCEvent { uint64 count = 1; int64 time_unix_nano = 2;
+		// double gap_seconds = 3; }
+		count, t := uint64(st.GC.Count), uint64(st.GC.Time.UnixNano())
+		size := 2 + varintSize(count) + varintSize(t)
+		if st.GC.Gap != 0 {
+			size += 1 + 8
+		}
+		b = appendTag(b, 10, wireLen)
+		b = appendVarint(b, uint64(size))
+		b = appendVarintField(b, 1, count)
+		b = appendVarintField(b, 2, t)
+		if st.GC.Gap != 0 {
+			b = appendDoubleField(b, 3, st.GC.Gap)
+		}
+	}
+	if st.Interval != 0 {
+		b = appendDoubleField(b, 11, st.Interval)
+	}
+	for _, site := range st.TopAllocators {
+		// message AllocSite { string function = 1; string file = 2; int64 line
+		// = 3; int64 bytes = 4; int64 objects = 5; int64 inuse_bytes = 6; }
+		line, bytes, objects, inuse := uint64(site.Line), uint64(site.Bytes), uint64(site.Objects), uint64(site.InuseBytes)
+		size := bytesFieldSize(site.Function) + bytesFieldSize(site.File) +
+			4 + varintSize(line) + varintSize(bytes) + varintSize(objects) + varintSize(inuse)
+		b = appendTag(b, 14, wireLen)
+		b = appendVarint(b, uint64(size))
+		b = appendBytesField(b, 1, site.Function)
+		b = appendBytesField(b, 2, site.File)
+		b = appendVarintField(b, 3, line)
+		b = appendVarintField(b, 4, bytes)
+		b = appendVarintField(b, 5, objects)
+		b = appendVarintField(b, 6, inuse)
+	}
+	if vm != nil {
+		if vm.keyframe {
+			b = appendPacked(b, 12, vm.values)
+		} else {
+			b = appendVarint(appendTag(b, 13, wireLen), uint64(len(vm.deltas)))
+			b = append(b, vm.deltas...)
+		}
+	}
+	return b
+}
+
+func appendTag(b []byte, num, wire int) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(wire))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func varintSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	return appendVarint(appendTag(b, num, wireVarint), v)
+}
+
+func appendDoubleField(b []byte, num int, v float64) []byte {
+	return appendUint64LE(appendTag(b, num, wireI64), math.Float64bits(v))
+}
+
+func appendBytesField(b []byte, num int, s string) []byte {
+	b = appendVarint(appendTag(b, num, wireLen), uint64(len(s)))
+	return append(b, s...)
+}
+
+// bytesFieldSize returns the encoded size of a string field, with a 1 byte
+// tag.
+func bytesFieldSize(s string) int {
+	return 1 + varintSize(uint64(len(s))) + len(s)
+}
+
+// appendPacked appends a packed repeated double field.
+func appendPacked(b []byte, num int, vals []float64) []byte {
+	if len(vals) == 0 {
+		return b
+	}
+	b = appendVarint(appendTag(b, num, wireLen), uint64(8*len(vals)))
+	for _, v := range vals {
+		b = appendUint64LE(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// packedSize returns the encoded size of a packed repeated double field, with
+// a 1 byte tag.
+func packedSize(vals []float64) int {
+	if len(vals) == 0 {
+		return 0
+	}
+	return 1 + varintSize(uint64(8*len(vals))) + 8*len(vals)
+}
+
+func appendUint64LE(b []byte, u uint64) []byte {
+	return append(b, byte(u), byte(u>>8), byte(u>>16), byte(u>>24), byte(u>>32), byte(u>>40), byte(u>>48), byte(u>>56))
+}
@@ -0,0 +1,265 @@
+package statsviz
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A pbField is a decoded Protocol Buffers field.
+type pbField struct {
+	num   int
+	wire  int
+	u     uint64 // varint and i64 fields
+	bytes []byte // len fields
+}
+
+// decodeProtobuf decodes the fields of a Protocol Buffers message.
+func decodeProtobuf(b []byte) ([]pbField, error) {
+	var fields []pbField
+	for len(b) != 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("bad tag")
+		}
+		b = b[n:]
+		f := pbField{num: int(tag >> 3), wire: int(tag & 7)}
+		switch f.wire {
+		case wireVarint:
+			f.u, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("bad varint")
+			}
+			b = b[n:]
+		case wireI64:
+			if len(b) < 8 {
+				return nil, errors.New("short i64")
+			}
+			f.u, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireLen:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errors.New("bad length")
+			}
+			f.bytes, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return nil, errors.New("unsupported wire type")
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// pbDoubles decodes a packed repeated double field.
+func pbDoubles(b []byte) []float64 {
+	vals := make([]float64, len(b)/8)
+	for i := range vals {
+		vals[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+	}
+	return vals
+}
+
+// pbFrame is a decoded Frame message.
+type pbFrame struct {
+	time       time.Time
+	seq        uint64
+	goVersion  string
+	historical bool
+	uptime     float64
+	scalars    map[string]float64
+	histograms map[string][2][]float64 // bounds and counts
+	series     map[string][]float64
+}
+
+func decodeFrame(t *testing.T, b []byte) pbFrame {
+	t.Helper()
+
+	fields, err := decodeProtobuf(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := pbFrame{
+		scalars:    make(map[string]float64),
+		histograms: make(map[string][2][]float64),
+		series:     make(map[string][]float64),
+	}
+	var last string
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			fr.time = time.Unix(0, int64(f.u))
+		case 2:
+			fr.seq = f.u
+		case 3:
+			fr.goVersion = string(f.bytes)
+		case 4:
+			fr.historical = f.u != 0
+		case 9:
+			fr.uptime = math.Float64frombits(f.u)
+		case 5, 6, 7:
+			sub, err := decodeProtobuf(f.bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var name string
+			var vals [2][]float64
+			var value float64
+			for _, sf := range sub {
+				switch {
+				case sf.num == 1:
+					name = string(sf.bytes)
+				case f.num == 5 && sf.num == 2:
+					value = math.Float64frombits(sf.u)
+				case f.num == 6:
+					vals[sf.num-2] = pbDoubles(sf.bytes)
+				case f.num == 7 && sf.num == 2:
+					vals[0] = pbDoubles(sf.bytes)
+				}
+			}
+			switch f.num {
+			case 5:
+				if name < last {
+					t.Errorf("scalar %q after %q, want scalars sorted by name", name, last)
+				}
+				last = name
+				fr.scalars[name] = value
+			case 6:
+				fr.histograms[name] = vals
+			case 7:
+				fr.series[name] = vals[0]
+			}
+		}
+	}
+	return fr
+}
+
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && !(math.IsNaN(a[i]) && math.IsNaN(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(
+		WithEncoding(EncodingProtobuf),
+		WithPlot(TimeSeriesPlot{
+			Name: "user",
+			Series: []TimeSeries{
+				{Name: "one", Value: func() float64 { return 1 }},
+				{Name: "nan", Value: math.NaN},
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	st := newStats()
+	smp := srv.newSampler()
+	srv.collect(smp, &st)
+	srv.collect(smp, &st)
+	st.Seq = 42
+	st.Historical = true
+	st.UserMetrics = map[string]float64{"queue": 12}
+
+	buf, err := srv.marshalStats(&st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := decodeFrame(t, buf)
+
+	if !fr.time.Equal(st.Time) || fr.seq != 42 || fr.goVersion != st.GoVersion || !fr.historical {
+		t.Errorf("got frame %v, %d, %q, %t, want %v, 42, %q, true", fr.time, fr.seq, fr.goVersion, fr.historical, st.Time, st.GoVersion)
+	}
+
+	if fr.uptime != st.Uptime || fr.uptime <= 0 {
+		t.Errorf("got uptime %v, want %v", fr.uptime, st.Uptime)
+	}
+
+	if len(fr.scalars) != len(st.Metrics)+1 {
+		t.Errorf("got %d scalars, want %d", len(fr.scalars), len(st.Metrics)+1)
+	}
+	for name, v := range st.Metrics {
+		if got, ok := fr.scalars[name]; !ok || !equalFloats([]float64{got}, []float64{v}) {
+			t.Errorf("scalar %s: got %v, want %v", name, got, v)
+		}
+	}
+	if got := fr.scalars["queue"]; got != 12 {
+		t.Errorf("user metric: got %v, want 12", got)
+	}
+
+	if got, want := fr.series["user"], []float64(st.UserPlots["user"]); !equalFloats(got, want) {
+		t.Errorf("user plot: got %v, want %v", got, want)
+	}
+	nhist := 0
+	for i := range srv.runtimePlots {
+		p := &srv.runtimePlots[i]
+		want := []float64(st.RuntimePlots[p.name])
+		if p.heatmap == nil {
+			if got := fr.series[p.name]; !equalFloats(got, want) {
+				t.Errorf("runtime plot %s: got %v, want %v", p.name, got, want)
+			}
+			continue
+		}
+		nhist++
+		h := fr.histograms[p.name]
+		if !equalFloats(h[0], p.heatmap.bounds) || !equalFloats(h[1], want) {
+			t.Errorf("heatmap %s: got bounds %v and counts %v, want %v and %v", p.name, h[0], h[1], p.heatmap.bounds, want)
+		}
+	}
+	if len(fr.histograms) != nhist {
+		t.Errorf("got %d histograms, want %d", len(fr.histograms), nhist)
+	}
+}
+
+func TestWsProtobuf(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithEncoding(EncodingProtobuf), SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	typ, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != websocket.BinaryMessage {
+		t.Fatalf("got message type %d, want binary", typ)
+	}
+	if fr := decodeFrame(t, msg); fr.seq == 0 || len(fr.scalars) == 0 {
+		t.Errorf("got frame %+v, want stats", fr)
+	}
+
+	if hs := srv.handshake(); hs.Encoding != EncodingProtobuf {
+		t.Errorf("got handshake encoding %q, want %q", hs.Encoding, EncodingProtobuf)
+	}
+	if _, err := NewServer(WithEncoding(EncodingProtobuf), Transport(TransportSSE)); err == nil {
+		t.Errorf("got nil error for protobuf over SSE, want non-nil")
+	}
+}
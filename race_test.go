@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package statsviz
+
+func init() {
+	raceEnabled = true
+}
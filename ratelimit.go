@@ -0,0 +1,153 @@
+package statsviz
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithConnectionRateLimit limits the rate of websocket connection attempts
+// from a single IP address to perIP attempts per window. Attempts exceeding
+// the rate are refused with 429 Too Many Requests, they're counted too so
+// that a client reconnecting in a loop stays refused until it slows down.
+//
+// The client IP is the remote address of the connection. Behind a reverse
+// proxy, use WithTrustedProxies so that the client IP is read from the
+// X-Forwarded-For header set by the proxies.
+func WithConnectionRateLimit(perIP int, window time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if perIP <= 0 {
+			return configError("WithConnectionRateLimit", "connection rate limit must be positive")
+		}
+		if window <= 0 {
+			return configError("WithConnectionRateLimit", "connection rate limit window must be positive")
+		}
+		s.rateLimit = &rateLimiter{
+			limit:    perIP,
+			window:   window,
+			attempts: make(map[string][]time.Time),
+		}
+		return nil
+	}
+}
+
+// WithTrustedProxies sets the addresses of the reverse proxies in front of
+// statsviz, as IP addresses or CIDR ranges, for example "10.0.0.0/8". The IP
+// of clients connecting through them is read from the X-Forwarded-For header,
+// which is ignored for other connections since anyone can set it. It's only
+// used by WithConnectionRateLimit and WithAllowedCIDRs.
+func WithTrustedProxies(proxies ...string) OptionFunc {
+	return func(s *Server) error {
+		for _, p := range proxies {
+			if !strings.Contains(p, "/") {
+				ip := net.ParseIP(p)
+				if ip == nil {
+					return configError("WithTrustedProxies", "invalid trusted proxy %q", p)
+				}
+				bits := 8 * net.IPv6len
+				if ip4 := ip.To4(); ip4 != nil {
+					ip, bits = ip4, 8*net.IPv4len
+				}
+				s.trustedProxies = append(s.trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, ipnet, err := net.ParseCIDR(p)
+			if err != nil {
+				return configError("WithTrustedProxies", "invalid trusted proxy %q: %v", p, err)
+			}
+			s.trustedProxies = append(s.trustedProxies, ipnet)
+		}
+		return nil
+	}
+}
+
+// clientIP returns the IP of the client that sent r. If the request comes
+// from a trusted proxy, it's the rightmost address of X-Forwarded-For that
+// isn't a trusted proxy, since the leftmost ones may have been set by the
+// client itself.
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.trusted(host) {
+		return host
+	}
+
+	var fwd []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		fwd = append(fwd, strings.Split(h, ",")...)
+	}
+	for i := len(fwd) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(fwd[i])
+		if net.ParseIP(ip) == nil {
+			// Can't go further left than a malformed address.
+			break
+		}
+		host = ip
+		if !s.trusted(ip) {
+			break
+		}
+	}
+	return host
+}
+
+// trusted reports whether addr is the address of a trusted proxy.
+func (s *Server) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range s.trustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter limits the rate of connection attempts per IP, over a sliding
+// window.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	attempts  map[string][]time.Time // by IP, in the window, oldest first
+	lastSweep time.Time
+}
+
+// allow records a connection attempt from ip at now, and reports whether it
+// doesn't exceed the rate.
+func (rl *rateLimiter) allow(ip string, now time.Time) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Forget, once per window, the IPs which haven't attempted to connect
+	// for a whole window, so that the map doesn't grow without bounds.
+	if now.Sub(rl.lastSweep) >= rl.window {
+		for k, a := range rl.attempts {
+			if now.Sub(a[len(a)-1]) >= rl.window {
+				delete(rl.attempts, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	a := rl.attempts[ip]
+	i := 0
+	for i < len(a) && now.Sub(a[i]) >= rl.window {
+		i++
+	}
+	a = a[i:]
+	ok := len(a) < rl.limit
+	if !ok {
+		// Keep the number of recorded attempts bounded: refused attempts
+		// replace the oldest ones.
+		a = a[1:]
+	}
+	rl.attempts[ip] = append(a, now)
+	return ok
+}
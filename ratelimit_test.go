@@ -0,0 +1,143 @@
+package statsviz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWithConnectionRateLimit(t *testing.T) {
+	t.Parallel()
+
+	const limit = 3
+	srv, err := NewServer(WithConnectionRateLimit(limit, time.Hour), WithTrustedProxies("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http")
+	dial := func(ip string) (*http.Response, error) {
+		ws, resp, err := websocket.DefaultDialer.Dial(URL, http.Header{"X-Forwarded-For": {ip}})
+		if err == nil {
+			ws.Close()
+		}
+		return resp, err
+	}
+
+	for i := 0; i < limit; i++ {
+		if _, err := dial("10.0.0.1"); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	resp, err := dial("10.0.0.1")
+	if err == nil {
+		t.Fatalf("upgrade succeeded after %d attempts, want it refused", limit)
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got response %v, want status %d", resp, http.StatusTooManyRequests)
+	}
+
+	// Other IPs aren't limited.
+	if _, err := dial("10.0.0.2"); err != nil {
+		t.Errorf("another IP can't connect: %v", err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	rl := &rateLimiter{limit: 2, window: 10 * time.Second, attempts: make(map[string][]time.Time)}
+	t0 := time.Now()
+
+	tests := []struct {
+		ip    string
+		after time.Duration
+		want  bool
+	}{
+		{"a", 0, true},
+		{"a", 1 * time.Second, true},
+		{"a", 2 * time.Second, false},
+		{"b", 2 * time.Second, true},
+		// Refused attempts count too.
+		{"a", 10 * time.Second, false},
+		{"a", 12 * time.Second, true},
+	}
+	for _, tt := range tests {
+		if got := rl.allow(tt.ip, t0.Add(tt.after)); got != tt.want {
+			t.Errorf("allow(%q) after %v = %t, want %t", tt.ip, tt.after, got, tt.want)
+		}
+	}
+
+	// IPs which haven't attempted to connect for a whole window are
+	// forgotten.
+	rl.allow("c", t0.Add(21*time.Second))
+	if _, ok := rl.attempts["b"]; ok {
+		t.Errorf("inactive IP wasn't forgotten")
+	}
+	if len(rl.attempts) != 2 {
+		t.Errorf("got %d IPs, want 2", len(rl.attempts))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithTrustedProxies("10.0.0.0/8", "::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	tests := []struct {
+		remote string
+		fwd    []string
+		want   string
+	}{
+		{"192.168.1.1:1234", nil, "192.168.1.1"},
+		// X-Forwarded-For is ignored from untrusted addresses.
+		{"192.168.1.1:1234", []string{"1.2.3.4"}, "192.168.1.1"},
+		{"10.1.1.1:1234", nil, "10.1.1.1"},
+		{"10.1.1.1:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"[::1]:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		// Addresses set by the client, left of the first untrusted one, are
+		// ignored.
+		{"10.1.1.1:1234", []string{"6.6.6.6, 1.2.3.4, 10.2.2.2"}, "1.2.3.4"},
+		{"10.1.1.1:1234", []string{"6.6.6.6", "1.2.3.4, 10.2.2.2"}, "1.2.3.4"},
+		{"10.1.1.1:1234", []string{"10.3.3.3, 10.2.2.2"}, "10.3.3.3"},
+		{"10.1.1.1:1234", []string{"garbage, 1.2.3.4"}, "1.2.3.4"},
+		{"10.1.1.1:1234", []string{"1.2.3.4, garbage"}, "10.1.1.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, f := range tt.fwd {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		if got := srv.clientIP(r); got != tt.want {
+			t.Errorf("remote %s, X-Forwarded-For %q: got %q, want %q", tt.remote, tt.fwd, got, tt.want)
+		}
+	}
+}
+
+func TestWithConnectionRateLimitInvalid(t *testing.T) {
+	t.Parallel()
+
+	opts := []OptionFunc{
+		WithConnectionRateLimit(0, time.Second),
+		WithConnectionRateLimit(1, 0),
+		WithTrustedProxies("not an ip"),
+		WithTrustedProxies("10.0.0.0/33"),
+	}
+	for i, opt := range opts {
+		if _, err := NewServer(opt); err == nil {
+			t.Errorf("option %d: got nil error, want non-nil", i)
+		}
+	}
+}
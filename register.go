@@ -1,8 +1,17 @@
 package statsviz
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
+	"runtime/metrics"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,59 +23,791 @@ func RegisterDefault(opts ...OptionFunc) error {
 	return Register(http.DefaultServeMux, opts...)
 }
 
-// Root sets the root path of statsviz handlers.
+// Root sets the root path of statsviz handlers. The root is normalized to
+// have a single leading slash and no trailing slash, so "debug/statsviz" and
+// "/debug/statsviz/" are both the same as "/debug/statsviz", while "/" or ""
+// serves statsviz at the root of the mux. A root which isn't a valid URL path,
+// for example with spaces, a query or a fragment, is an error.
 func Root(root string) OptionFunc {
-	return func(s *server) error {
-		s.root = root
+	return func(s *Server) error {
+		norm, err := normalizeRoot(root)
+		if err != nil {
+			return &ConfigError{Option: "Root", Err: err}
+		}
+		s.root = norm
+		return nil
+	}
+}
+
+// WithWebSocketPath sets the path of the endpoint sending stats to the user
+// interface, <root>/ws by default, for example for a reverse proxy only
+// routing some paths to websockets. The user interface connects to it whatever
+// the root. path follows the same rules as Root, it can't be the path of
+// another statsviz endpoint. Note that the adapters of other routers, such as
+// statsvizgin, only route the paths under the root.
+func WithWebSocketPath(path string) OptionFunc {
+	return func(s *Server) error {
+		norm, err := normalizeRoot(path)
+		if err != nil {
+			return configError("WithWebSocketPath", "invalid websocket path %q: not a URL path", path)
+		}
+		if norm == "" {
+			return configError("WithWebSocketPath", "websocket path can't be the root of the mux")
+		}
+		s.wsPath = norm
 		return nil
 	}
 }
 
+// wsEndpoint returns the path of the websocket endpoint.
+func (s *Server) wsEndpoint() string {
+	if s.wsPath != "" {
+		return s.wsPath
+	}
+	return s.root + "/ws"
+}
+
+// normalizeRoot returns root with a single leading slash and no trailing
+// slash, or the empty string for the root of the mux.
+func normalizeRoot(root string) (string, error) {
+	// Escapes are refused too, since the root is used both as a mux pattern
+	// and in URLs.
+	u, err := url.Parse(root)
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.ContainsAny(root, "?#% \t") {
+		return "", fmt.Errorf("invalid root %q: not a URL path", root)
+	}
+	root = strings.Trim(root, "/")
+	if root == "" {
+		return "", nil
+	}
+	return "/" + root, nil
+}
+
 // SendFrequency defines the frequency at which statistics are sent from the
-// application to the HTML page.
+// application to the HTML page, 1 second by default. freq must be positive,
+// frequencies lower than 10ms are raised to 10ms, with a warning, since
+// collecting stats that often would mostly measure statsviz itself.
 func SendFrequency(freq time.Duration) OptionFunc {
-	return func(s *server) error {
+	return func(s *Server) error {
 		if freq <= 0 {
-			return fmt.Errorf("frequency must be a positive integer")
+			return configError("SendFrequency", "frequency must be positive, got %v", freq)
 		}
 		s.freq = freq
 		return nil
 	}
 }
 
+// WithHistorySize sets the number of most recent samples that are kept in
+// memory, in order to be sent to newly connected clients, so that plots don't
+// start empty. For example with the default send frequency of 1 second, 600
+// samples correspond to the last 10 minutes.
+//
+// By default, no history is kept.
+func WithHistorySize(n int) OptionFunc {
+	return func(s *Server) error {
+		if n < 0 {
+			return configError("WithHistorySize", "history size must be positive")
+		}
+		s.histSize = n
+		return nil
+	}
+}
+
+// WithHistoryBytes keeps a history, like WithHistorySize, sized by its
+// estimated memory use rather than by number of samples: the oldest samples
+// are evicted once the samples in history take more than maxBytes, whatever
+// the send frequency and the number of metrics and plots. The estimate counts
+// the metrics values, the plots series values and histograms buckets counts,
+// along with their names and the maps holding them.
+//
+// The minimum, maximum and average values of the metrics shown by the user
+// interface are computed over as many samples as fit in maxBytes when the
+// first one is recorded. WithHistoryBytes and WithHistorySize are mutually
+// exclusive.
+func WithHistoryBytes(maxBytes int) OptionFunc {
+	return func(s *Server) error {
+		if maxBytes <= 0 {
+			return configError("WithHistoryBytes", "history bytes must be positive")
+		}
+		s.histBytes = maxBytes
+		return nil
+	}
+}
+
+// WithCompression enables per-message compression (permessage-deflate) of
+// websocket messages, which reduces bandwidth usage on slow links. Compression
+// is only used if the client supports it.
+//
+// Compression comes at a CPU and memory cost: according to BenchmarkWsWrite,
+// sending a compressed stats message takes about twice as long and allocates
+// about 30% more memory than sending it uncompressed.
+func WithCompression(enable bool) OptionFunc {
+	return func(s *Server) error {
+		s.compression = enable
+		return nil
+	}
+}
+
+// WithCheckOrigin sets the function used to validate the Origin header of
+// websocket connection requests, which are refused with 403 Forbidden if
+// check returns false, check can't be nil. By default, only same-origin
+// requests are accepted.
+func WithCheckOrigin(check func(r *http.Request) bool) OptionFunc {
+	return func(s *Server) error {
+		if check == nil {
+			return configError("WithCheckOrigin", "nil origin check")
+		}
+		s.checkOrigin = check
+		return nil
+	}
+}
+
+// WithPingInterval sets the interval at which ping frames are sent on websocket
+// connections, in order to detect dead connections, for example dropped by a
+// load balancer after some idle time. A connection is closed if no pong is
+// received within the pong timeout, see WithPongTimeout. Zero disables pings.
+//
+// By default, pings are sent every 30 seconds.
+func WithPingInterval(d time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if d < 0 {
+			return configError("WithPingInterval", "ping interval must be positive or zero")
+		}
+		s.pingInterval = d
+		return nil
+	}
+}
+
+// WithPongTimeout sets how long, after the ping interval, a websocket
+// connection waits for a pong before being closed. It has no effect if pings
+// are disabled.
+//
+// By default, the pong timeout is 10 seconds.
+func WithPongTimeout(d time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if d <= 0 {
+			return configError("WithPongTimeout", "pong timeout must be positive")
+		}
+		s.pongTimeout = d
+		return nil
+	}
+}
+
+// WithWriteTimeout sets how long writing a stats message on a websocket
+// connection may take. A client whose connection doesn't accept the message
+// in time, for example because it silently went away, is disconnected. Zero
+// disables the timeout.
+//
+// By default, the write timeout is 10 seconds.
+func WithWriteTimeout(d time.Duration) OptionFunc {
+	return func(s *Server) error {
+		if d < 0 {
+			return configError("WithWriteTimeout", "write timeout must be positive or zero")
+		}
+		s.writeTimeout = d
+		return nil
+	}
+}
+
+// WithMaxFrameBytes caps the size, in bytes, of the JSON encoded stats sent to
+// clients at each tick, for clients with limited resources. When a frame
+// exceeds n bytes, user plots are dropped from it, last added first, until it
+// fits or there are no user plots left, and the frame is flagged as truncated.
+// Built-in plots are never dropped. Use FrameBytes to find a suitable value.
+//
+// By default, or if n is 0, frames aren't limited.
+func WithMaxFrameBytes(n int) OptionFunc {
+	return func(s *Server) error {
+		if n < 0 {
+			return configError("WithMaxFrameBytes", "max frame bytes must be positive or zero")
+		}
+		s.maxFrameBytes = n
+		return nil
+	}
+}
+
+// WithMaxClients limits the number of websocket clients connected at the same
+// time. Once n clients are connected, new websocket connection requests are
+// refused with 503 Service Unavailable, until a client disconnects.
+//
+// By default, or if n is 0, the number of clients isn't limited.
+func WithMaxClients(n int) OptionFunc {
+	return func(s *Server) error {
+		if n < 0 {
+			return configError("WithMaxClients", "max clients must be positive or zero")
+		}
+		s.maxClients = int32(n)
+		return nil
+	}
+}
+
+// Default websocket buffer sizes, see WithReadBufferSize and
+// WithWriteBufferSize.
+const (
+	defaultReadBufferSize  = 1024
+	defaultWriteBufferSize = 1024
+)
+
+// WithReadBufferSize sets the size, in bytes, of the buffer used to read
+// websocket messages from each client, 1024 by default. Clients only send
+// small control messages, so the default is in general enough.
+func WithReadBufferSize(n int) OptionFunc {
+	return func(s *Server) error {
+		if n <= 0 {
+			return configError("WithReadBufferSize", "read buffer size must be positive")
+		}
+		s.readBufferSize = n
+		return nil
+	}
+}
+
+// WithWriteBufferSize sets the size, in bytes, of the buffer used to write
+// websocket messages to clients, 1024 by default. Messages larger than the
+// buffer take several writes, so with large stats, for example with many user
+// plots or a high send frequency, a buffer the size of a message, such as 8KiB
+// or 16KiB, saves system calls.
+//
+// Write buffers are pooled between the connections of the server, they're
+// only held while a message is written, so a larger buffer doesn't cost memory
+// per connected client.
+func WithWriteBufferSize(n int) OptionFunc {
+	return func(s *Server) error {
+		if n <= 0 {
+			return configError("WithWriteBufferSize", "write buffer size must be positive")
+		}
+		s.writeBufferSize = n
+		return nil
+	}
+}
+
+// A TransportKind is a transport used to send statistics from the application
+// to the HTML page.
+type TransportKind string
+
+const (
+	// TransportWebSocket sends statistics over a websocket connection. This is
+	// the default.
+	TransportWebSocket TransportKind = "websocket"
+
+	// TransportSSE streams statistics as Server-Sent Events (text/event-stream),
+	// useful when websocket upgrades are blocked, by a proxy for example.
+	TransportSSE TransportKind = "sse"
+)
+
+// Transport defines the transport used to send statistics to the HTML page.
+func Transport(t TransportKind) OptionFunc {
+	return func(s *Server) error {
+		switch t {
+		case TransportWebSocket, TransportSSE:
+		default:
+			return configError("Transport", "unknown transport %q", t)
+		}
+		s.transport = t
+		return nil
+	}
+}
+
 // An OptionFunc is a server configuration option.
-type OptionFunc func(s *server) error
+type OptionFunc func(s *Server) error
 
 const (
 	defaultRoot          = "/debug/statsviz"
 	defaultSendFrequency = time.Second
+	minServerFrequency   = 10 * time.Millisecond // see SendFrequency
+	defaultPingInterval  = 30 * time.Second
+	defaultPongTimeout   = 10 * time.Second
+	defaultWriteTimeout  = 10 * time.Second
 )
 
-// Register registers statsviz HTTP handlers on the provided mux.
+// Register registers statsviz HTTP handlers on the provided mux. An invalid
+// configuration, including handlers already registered on mux at the same
+// root, for example if Register is called twice, is reported by a
+// *ConfigError rather than by a panic of mux.
 func Register(mux *http.ServeMux, opts ...OptionFunc) error {
-	s := &server{
-		mux:  mux,
-		root: defaultRoot,
-		freq: defaultSendFrequency,
+	s, err := NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	if err := s.checkRegistered(mux); err != nil {
+		s.Stop()
+		return err
+	}
+
+	s.Register(mux)
+	return nil
+}
+
+// RegisterContext registers statsviz HTTP handlers on the provided mux, as
+// Register does, and ties their lifetime to ctx: once ctx is cancelled, active
+// connections are closed, goroutines started by statsviz return and the
+// handlers respond with 503 Service Unavailable. See Server.Stop.
+func RegisterContext(ctx context.Context, mux *http.ServeMux, opts ...OptionFunc) error {
+	s, err := NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	if err := s.checkRegistered(mux); err != nil {
+		s.Stop()
+		return err
+	}
+
+	s.Register(mux)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-s.done:
+		}
+	}()
+	return nil
+}
+
+// A Server serves the statsviz user interface and sends it application
+// statistics. Use NewServer to create one, and either Register it on a
+// http.ServeMux or use its Index and Ws handlers directly.
+type Server struct {
+	// frameBytes, seq and the counters of stats are accessed atomically,
+	// they're kept first for 64-bit alignment on 32-bit platforms.
+	frameBytes int64  // size of the last frame, before truncation
+	seq        uint64 // sequence number of the last collected stats
+	counters   serverCounters
+
+	freq        time.Duration
+	root        string
+	wsPath      string // see WithWebSocketPath, <root>/ws if empty
+	transport   TransportKind
+	histSize    int
+	histBytes   int           // see WithHistoryBytes, 0 if the history is sized by histSize
+	idleHistory time.Duration // see WithIdleHistoryInterval, 0 for the send frequency
+	maxPoints   int           // historical stats sent to new clients, 0 means all
+	compression bool
+	gzipAssets  bool                       // see WithGzipAssets
+	checkOrigin func(r *http.Request) bool // nil means same-origin
+
+	pingInterval time.Duration // 0 means no keepalive
+	pongTimeout  time.Duration
+	writeTimeout time.Duration // of stats messages, 0 means no timeout
+	clock        clock         // schedules and timestamps stats collection
+
+	readBufferSize  int        // websocket read buffer size
+	writeBufferSize int        // websocket write buffer size
+	writeBuffers    *sync.Pool // shared by connections
+
+	maxFrameBytes   int    // 0 means no limit
+	logger          logger // server events, see WithLogger
+	tracer          Tracer // see WithTracer, nil for no spans
+	maxClients      int32  // 0 means no limit
+	clients         int32  // connected websocket clients, accessed atomically
+	clientBuffer    int    // frames buffered per client
+	pprof           bool   // serve runtime profiles
+	forceGC         bool   // clients can run a GC
+	profileControls bool   // clients can set the profile rates
+	freeze          bool   // see WithFreezeOnThreshold
+	page            page   // user interface customizations
+	assetsDir       fs.FS  // see WithAssetsDir, nil for the embedded assets
+	dataOnly        bool   // see WithDataOnly
+	slowClients     SlowClientPolicy
+	encoding        EncodingKind
+	compact         bool               // see WithCompactMetrics
+	metricNames     []string           // index the values of compact stats
+	keyframes       int                // see WithVarintDeltas, 0 if disabled
+	precision       int                // see WithFloatPrecision, 0 for full precision
+	histInterval    time.Duration      // see WithHistogramInterval
+	delta           bool               // see WithDeltaFrames
+	batchSize       int                // see WithBatchSize, 0 if frames aren't batched
+	metricPrefix    string             // see WithMetricPrefix
+	labels          map[string]string  // see WithLabels
+	pinnedSampler   bool               // see WithPinnedSampler
+	adaptive        *adaptiveFrequency // nil if the frequency is fixed
+
+	history     *history            // nil if no history is kept
+	goroutines  *goroutineBreakdown // nil if not enabled
+	topAllocs   *topAllocators      // nil if not enabled
+	replay      []stats             // replayed stats, see ServeReplay
+	replaySpeed float64             // see WithReplaySpeed
+	thresholds  []*threshold        // see WithThreshold
+	expvars     []string            // see WithExpvar
+	// clientsChanged is signaled on client connections and disconnections,
+	// nil if the history frequency doesn't depend on them.
+	clientsChanged chan struct{}
+	sinks          []io.Writer // see WithSink
+	// expvarSkipped holds the non-numeric expvars, which have been warned
+	// about.
+	expvarSkipped sync.Map
+
+	plotsMu   sync.RWMutex
+	userPlots []*userPlot // replaced, never modified, once the server runs
+
+	// runtimePlots holds the enabled built-in runtime plots supported by the
+	// current Go runtime.
+	runtimePlots   []runtimePlot
+	missingMetrics []string      // used by built-in plots but not supported
+	disabled       map[Plot]bool // disabled built-in plots
+	auth           *basicAuth    // nil if there's no authentication
+	cors           *cors         // nil if cross-origin requests aren't allowed
+	rateLimit      *rateLimiter  // nil if connection attempts aren't limited
+	trustedProxies []*net.IPNet  // see WithTrustedProxies
+	allowedNets    []*net.IPNet  // nil if any client IP is allowed
+
+	hubsMu sync.Mutex
+	hubs   map[time.Duration]*hub // hub by send frequency
+
+	latestOnce sync.Once
+	latest     latestFrame // see latestStats
+
+	collector collector // see Collect
+
+	mu      sync.Mutex
+	stopped bool
+	done    chan struct{}  // closed on Stop
+	wg      sync.WaitGroup // goroutines started by the server
+}
+
+// newServer returns a Server with the default configuration.
+func newServer() *Server {
+	s := &Server{
+		root:      defaultRoot,
+		freq:      defaultSendFrequency,
+		transport: TransportWebSocket,
+		encoding:  EncodingJSON,
+		done:      make(chan struct{}),
+
+		readBufferSize:  defaultReadBufferSize,
+		writeBufferSize: defaultWriteBufferSize,
+		writeBuffers:    &sync.Pool{},
+
+		page:         page{Title: defaultPageTitle},
+		clientBuffer: subscriberBufferSize,
+		gzipAssets:   true,
+		slowClients:  DropNewest,
+
+		pingInterval: defaultPingInterval,
+		pongTimeout:  defaultPongTimeout,
+		writeTimeout: defaultWriteTimeout,
+		clock:        realClock{},
+		logger:       nopLogger{},
+
+		disabled: make(map[Plot]bool),
+		hubs:     make(map[time.Duration]*hub),
 	}
+	s.runtimePlots, s.missingMetrics = supportedRuntimePlots(runtimePlots, metrics.All())
+	return s
+}
 
-	for _, opt := range opts {
+// NewServer creates a statsviz Server configured with the provided options.
+// An invalid option value, or conflicting options, are reported by a
+// *ConfigError naming the offending option.
+func NewServer(opts ...OptionFunc) (*Server, error) {
+	s := newServer()
+	for i, opt := range opts {
+		if opt == nil {
+			return nil, configError("option", "option %d is nil", i)
+		}
 		if err := opt(s); err != nil {
-			return err
+			var cerr *ConfigError
+			if errors.As(err, &cerr) {
+				return nil, err
+			}
+			return nil, &ConfigError{Option: "option", Err: err}
+		}
+	}
+
+	if s.freq < minServerFrequency {
+		s.logger.Warn("statsviz: send frequency too low, using the minimum", "frequency", s.freq, "minimum", minServerFrequency)
+		s.freq = minServerFrequency
+	}
+	if len(s.missingMetrics) != 0 {
+		s.logger.Warn("statsviz: runtime metrics not supported by this Go version, some plots won't be shown", "metrics", s.missingMetrics)
+	}
+	for _, p := range s.userPlots {
+		p.logger = s.logger
+	}
+
+	if s.encoding.binary() && s.transport != TransportWebSocket {
+		return nil, configError("WithEncoding", "%s encoding requires the websocket transport", s.encoding)
+	}
+	if s.batchSize > 1 && (s.transport != TransportWebSocket || s.encoding != EncodingJSON) {
+		return nil, configError("WithBatchSize", "batches require the websocket transport and the JSON encoding")
+	}
+	if len(s.sinks) != 0 && s.encoding != EncodingJSON {
+		return nil, configError("WithSink", "sinks require the JSON encoding")
+	}
+	if s.delta && s.compact {
+		return nil, configError("WithDeltaFrames", "delta frames and compact metrics are mutually exclusive")
+	}
+	if s.keyframes > 0 && s.encoding != EncodingProtobuf {
+		return nil, configError("WithVarintDeltas", "varint deltas require the protobuf encoding")
+	}
+	if s.histSize > 0 && s.histBytes > 0 {
+		return nil, configError("WithHistoryBytes", "history size and history bytes are mutually exclusive")
+	}
+	if s.keyframes > 0 && s.delta {
+		return nil, configError("WithVarintDeltas", "delta frames and varint deltas are mutually exclusive")
+	}
+	for _, e := range s.endpoints() {
+		if e.name != "ws" && e.pattern == s.wsEndpoint() {
+			return nil, configError("WithWebSocketPath", "websocket path %q is the path of the %s endpoint", s.wsPath, e.name)
 		}
 	}
 
-	s.register()
+	var rtplots []runtimePlot
+	for _, p := range s.runtimePlots {
+		if s.enabled(Plot(p.name)) {
+			rtplots = append(rtplots, p)
+		}
+	}
+	s.runtimePlots = rtplots
+	if s.compact || s.keyframes > 0 {
+		s.metricNames = s.compactMetricNames()
+	}
+
+	if s.cors != nil && s.checkOrigin == nil {
+		s.checkOrigin = s.cors.checkOrigin
+	}
+
+	if s.goroutines != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.goroutines.run(s.clock, s.done)
+		}()
+	}
+
+	if s.topAllocs != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.topAllocs.run(s.clock, s.done)
+		}()
+	}
+
+	if len(s.thresholds) != 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.watchThresholds()
+		}()
+	}
+
+	if s.histSize > 0 || s.histBytes > 0 {
+		if s.histBytes > 0 {
+			s.history = newHistoryBytes(s.histBytes)
+		} else {
+			s.history = newHistory(s.histSize)
+		}
+		if s.idleHistory > 0 && s.idleHistory != s.freq {
+			s.clientsChanged = make(chan struct{}, 1)
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.recordHistory()
+		}()
+	}
+
+	for _, w := range s.sinks {
+		s.wg.Add(1)
+		go func(w io.Writer) {
+			defer s.wg.Done()
+			s.runSink(w)
+		}(w)
+	}
+
+	return s, nil
+}
+
+func (s *Server) handshake() handshake {
+	userPlots := s.plots()
+	plots := make([]TimeSeriesPlot, len(userPlots))
+	for i, p := range userPlots {
+		plots[i] = p.TimeSeriesPlot
+	}
+	rtplots := make([]PlotConfig, len(s.runtimePlots))
+	for i := range s.runtimePlots {
+		rtplots[i] = s.runtimePlots[i].config()
+	}
+	return handshake{
+		Transport:       s.transport,
+		Millis:          s.freq.Milliseconds(),
+		Plots:           plots,
+		BuiltinPlots:    s.builtinPlots(),
+		MemoryLimit:     memoryLimit(),
+		RuntimePlots:    rtplots,
+		Links:           s.links(),
+		ForceGC:         s.forceGC,
+		ProfileControls: s.profileControls,
+		Encoding:        s.encoding,
+		Build:           readBuildInfo(),
+		MetricNames:     s.metricNames,
+		DeltaFrames:     s.delta,
+		Keyframes:       s.keyframes,
+		Metrics:         s.metricInfos(),
+		Labels:          s.labels,
+	}
+}
+
+// links returns the links to the optional endpoints, to be shown by the user
+// interface.
+func (s *Server) links() []link {
+	links := []link{
+		{Title: "Goroutines dump", URL: s.root + "/goroutines.txt", Icon: "download"},
+	}
+	if s.pprof {
+		prefix := s.root + "/pprof/"
+		heap := prefix + "heap"
+		if s.profileControls {
+			// Reflect a new memory profile rate.
+			heap += "?gc=1"
+		}
+		links = append(links,
+			link{Title: "Profiles", URL: prefix, Icon: "tachometer alternate"},
+			link{Title: "CPU profile", URL: prefix + "profile?seconds=30", Icon: "microchip"},
+			link{Title: "Heap profile", URL: heap, Icon: "database"},
+		)
+	}
+	return links
+}
+
+// Root returns the root path of the server handlers.
+func (s *Server) Root() string {
+	return s.root
+}
+
+// An endpoint is a statsviz handler and the mux pattern it's registered at.
+type endpoint struct {
+	name    string
+	pattern string
+	handler func() http.Handler // built on registration
+}
+
+// endpoints returns the endpoints registered by Register, according to the
+// options of s.
+func (s *Server) endpoints() []endpoint {
+	var eps []endpoint
+	if !s.dataOnly {
+		eps = append(eps, endpoint{"index", s.root + "/", func() http.Handler { return s.Index() }})
+	}
+	eps = append(eps,
+		endpoint{"handshake", s.root + "/handshake", func() http.Handler {
+			return s.wrap(s.unlessStopped(handshakeHandler(s.handshake)))
+		}},
+		endpoint{"ws", s.wsEndpoint(), func() http.Handler { return s.Ws() }},
+		endpoint{"history.csv", s.root + "/history.csv", s.CSVHandler},
+		endpoint{"stream.ndjson", s.root + "/stream.ndjson", s.NDJSONHandler},
+		endpoint{"plots.json", s.root + "/plots.json", s.PlotConfigHandler},
+		endpoint{"grafana", s.root + "/grafana/", func() http.Handler {
+			return http.StripPrefix(s.root+"/grafana", s.GrafanaHandler())
+		}},
+		endpoint{"goroutines.txt", s.root + "/goroutines.txt", func() http.Handler {
+			return s.wrap(s.unlessStopped(GoroutineDumpHandler().ServeHTTP))
+		}},
+		endpoint{"metrics.json", s.root + "/metrics.json", func() http.Handler {
+			return s.wrap(s.unlessStopped(MetricsCatalogHandler().ServeHTTP))
+		}},
+	)
+	if s.pprof {
+		eps = append(eps, endpoint{"pprof", s.root + "/pprof/", func() http.Handler {
+			return s.wrap(s.unlessStopped(s.pprofHandler()))
+		}})
+	}
+	return eps
+}
+
+// Register registers the statsviz HTTP handlers on the provided mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	for _, e := range s.endpoints() {
+		mux.Handle(e.pattern, e.handler())
+	}
+}
+
+// Index returns the handler serving the statsviz user interface.
+func (s *Server) Index() http.HandlerFunc {
+	if s.dataOnly {
+		return http.NotFound
+	}
+	p := s.page
+	p.ws = s.wsEndpoint()
+	return s.wrap(s.unlessStopped(indexAtRoot(s.root, p, s.assetsDir, s.gzipAssets)))
+}
+
+// Ws returns the handler sending statistics to the user interface, either via
+// websocket or Server-Sent Events, depending on the Transport option.
+func (s *Server) Ws() http.HandlerFunc {
+	if s.transport == TransportSSE {
+		return s.wrap(s.sse())
+	}
+	return s.wrap(s.ws())
+}
+
+// wrap wraps h with the handlers common to all statsviz endpoints, such as
+// authentication or IP filtering.
+func (s *Server) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if s.auth != nil {
+		h = s.auth.wrap(h)
+	}
+	// Preflight requests don't carry credentials, CORS goes first.
+	if s.cors != nil {
+		h = s.cors.wrap(h, s.auth != nil)
+	}
+	// Clients which aren't allowed don't get any further, not even CORS
+	// headers.
+	if s.allowedNets != nil {
+		h = s.allowIPs(h)
+	}
+	return h
+}
+
+// Stop stops the server: active connections are closed and all goroutines
+// started by the server return before Stop does. Once stopped, the server
+// handlers respond with 503 Service Unavailable.
+//
+// Clients are sent the stats collected when stopping, then websocket clients
+// a close frame with a normal closure code and the "server shutting down"
+// reason. Those not receiving them within a second are disconnected anyway.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	close(s.done)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// Close stops the server, see Stop. It always returns nil, and exists so that
+// Server implements io.Closer.
+func (s *Server) Close() error {
+	s.Stop()
 	return nil
 }
 
-type server struct {
-	mux  *http.ServeMux
-	freq time.Duration
-	root string
+// track records a new active connection. It returns false if the server is
+// stopped, in which case the connection must not be served. The returned
+// function must be called once the connection is closed.
+func (s *Server) track() (untrack func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return nil, false
+	}
+	s.wg.Add(1)
+	return s.wg.Done, true
 }
 
-func (s *server) register() {
-	s.mux.Handle(s.root+"/", IndexAtRoot(s.root))
-	s.mux.HandleFunc(s.root+"/ws", NewWsHandler(s.freq))
+func (s *Server) unlessStopped(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		stopped := s.stopped
+		s.mu.Unlock()
+
+		if stopped {
+			http.Error(w, "statsviz server stopped", http.StatusServiceUnavailable)
+			return
+		}
+		h(w, r)
+	}
 }
@@ -0,0 +1,375 @@
+package statsviz
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// waitGoroutines waits for the number of goroutines to be at most n, and
+// returns the number of goroutines.
+func waitGoroutines(n int) int {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := runtime.NumGoroutine()
+		if got <= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitHubs waits for srv to have n hubs, that is for its clients to be
+// subscribed.
+func waitHubs(t *testing.T, srv *Server, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		srv.hubsMu.Lock()
+		got := len(srv.hubs)
+		srv.hubsMu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d hubs, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServerStop(t *testing.T) {
+	// Not parallel since we're counting goroutines.
+
+	before := runtime.NumGoroutine()
+
+	srv, err := NewServer(SendFrequency(10*time.Millisecond), WithHistorySize(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/debug/statsviz/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	var st stats
+	if err := ws.ReadJSON(&st); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Stop()
+
+	// The active connection must have been closed by the server.
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				t.Fatalf("websocket connection still open after Stop")
+			}
+			break
+		}
+	}
+
+	for _, path := range []string{"/debug/statsviz/", "/debug/statsviz/ws", "/debug/statsviz/handshake"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("GET %s after Stop: got status %d, want %d", path, resp.StatusCode, http.StatusServiceUnavailable)
+		}
+	}
+
+	ws.Close()
+	ts.CloseClientConnections()
+	ts.Close()
+	http.DefaultClient.CloseIdleConnections()
+
+	if after := waitGoroutines(before); after > before {
+		t.Errorf("got %d goroutines after Stop, want at most %d", after, before)
+	}
+
+	// Stop is idempotent.
+	srv.Stop()
+}
+
+func TestServerStopDrain(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/debug/statsviz/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// No stats are collected before Stop, at this frequency, but the last
+	// frame.
+	errc := make(chan error, 1)
+	frames := make(chan stats, 1)
+	go func() {
+		for {
+			var st stats
+			if err := ws.ReadJSON(&st); err != nil {
+				errc <- err
+				return
+			}
+			if st.Seq != 0 {
+				frames <- st
+			}
+		}
+	}()
+	waitHubs(t, srv, 1)
+
+	start := time.Now()
+	srv.Stop()
+	if d := time.Since(start); d >= drainTimeout {
+		t.Errorf("Stop took %v, want less than %v with a reading client", d, drainTimeout)
+	}
+
+	select {
+	case st := <-frames:
+		if len(st.Metrics) == 0 {
+			t.Errorf("got last frame %+v, want stats", st)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame received after Stop")
+	}
+	err = <-errc
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) || err.(*websocket.CloseError).Text != shutdownReason {
+		t.Errorf("got error %v, want a normal closure with reason %q", err, shutdownReason)
+	}
+}
+
+func TestServerStopStuckClient(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	srv.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// The client never reads, so it doesn't answer the close frame.
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/debug/statsviz/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	waitHubs(t, srv, 1)
+
+	start := time.Now()
+	srv.Stop()
+	if d := time.Since(start); d > drainTimeout+time.Second {
+		t.Errorf("Stop took %v, want about %v with a stuck client", d, drainTimeout)
+	}
+}
+
+func TestRegisterContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mux := http.NewServeMux()
+	if err := RegisterContext(ctx, mux, SendFrequency(10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/debug/statsviz/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	var st stats
+	if err := ws.ReadJSON(&st); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	// The streaming connection must be terminated.
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				t.Fatalf("websocket connection still open after context cancellation")
+			}
+			break
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/debug/statsviz/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d after context cancellation, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestRegisterMultipleRoots(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	if err := Register(mux, Root("/debug/statsviz-a")); err != nil {
+		t.Fatal(err)
+	}
+	plot := TimeSeriesPlot{
+		Name:   "plot-b",
+		Series: []TimeSeries{{Name: "one", Value: func() float64 { return 1 }}},
+	}
+	if err := Register(mux, Root("/debug/statsviz-b"), WithPlot(plot)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, root := range []string{"/debug/statsviz-a", "/debug/statsviz-b"} {
+		resp, err := http.Get(ts.URL + root + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		want := `<meta name="statsviz-ws" content="` + root + `/ws" />`
+		if !strings.Contains(string(body), want) {
+			t.Errorf("%s index page doesn't contain %q", root, want)
+		}
+
+		URL := "ws" + strings.TrimPrefix(ts.URL, "http") + root + "/ws"
+		ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", root, err)
+		}
+		var st stats
+		err = ws.ReadJSON(&st)
+		ws.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", root, err)
+		}
+
+		// Only the second instance has a user plot.
+		_, hasPlot := st.UserPlots["plot-b"]
+		if wantPlot := root == "/debug/statsviz-b"; hasPlot != wantPlot {
+			t.Errorf("%s: stats has plot-b = %t, want %t", root, hasPlot, wantPlot)
+		}
+	}
+}
+
+func TestRootNormalization(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		root    string
+		want    string // normalized root
+		baseURL string
+	}{
+		{"statsviz", "/statsviz", "http://example.com/statsviz/"},
+		{"/statsviz/", "/statsviz", "http://example.com/statsviz/"},
+		{"debug/statsviz/", "/debug/statsviz", "http://example.com/debug/statsviz/"},
+		{"/", "", "http://example.com/"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.root, func(t *testing.T) {
+			t.Parallel()
+
+			srv, err := NewServer(Root(tt.root), SendFrequency(100*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Stop()
+			if got := srv.Root(); got != tt.want {
+				t.Errorf("got root %q, want %q", got, tt.want)
+			}
+
+			mux := http.NewServeMux()
+			srv.Register(mux)
+			testRegister(t, mux, tt.baseURL)
+		})
+	}
+
+	for _, root := range []string{"/debug statsviz", "/debug?statsviz", "/debug#statsviz", "/debug%20statsviz", "http://host/statsviz", "/debug\x7f"} {
+		if err := Register(http.NewServeMux(), Root(root)); err == nil {
+			t.Errorf("Root(%q): got nil error, want non-nil", root)
+		}
+	}
+}
+
+func TestSendFrequency(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		freq    time.Duration
+		want    time.Duration
+		wantErr bool
+		warn    bool
+	}{
+		{freq: 0, wantErr: true},
+		{freq: -time.Second, wantErr: true},
+		{freq: time.Millisecond, want: minServerFrequency, warn: true},
+		{freq: 250 * time.Millisecond, want: 250 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		log := &warnLogger{}
+		withLog := func(s *Server) error { s.logger = log; return nil }
+		srv, err := NewServer(withLog, SendFrequency(tt.freq))
+		if tt.wantErr {
+			if err == nil {
+				srv.Stop()
+				t.Errorf("SendFrequency(%v): got nil error, want non-nil", tt.freq)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("SendFrequency(%v): %v", tt.freq, err)
+		}
+		srv.Stop()
+		if srv.freq != tt.want {
+			t.Errorf("SendFrequency(%v): got frequency %v, want %v", tt.freq, srv.freq, tt.want)
+		}
+		warned := false
+		for _, w := range log.warns {
+			warned = warned || strings.Contains(w, "send frequency too low")
+		}
+		if warned != tt.warn {
+			t.Errorf("SendFrequency(%v): got warning %t, want %t", tt.freq, warned, tt.warn)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package statsviz
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ServeReplay returns a Server replaying the stats recorded in r, as
+// newline-delimited JSON written by the NDJSON handler or by WithSink, rather
+// than collecting the stats of the process. It allows to reproduce a scenario
+// in the user interface, for example for a demo. r is read until EOF, and
+// isn't closed.
+//
+// Each client receives the recorded stats from the start, at their original
+// cadence, given by their timestamps, optionally sped up, see
+// WithReplaySpeed. The stats are timestamped with the time they're sent, as
+// if they were live. Once all stats have been sent, the connection stays open
+// until the client disconnects or the server is stopped. Clients can't change
+// the send frequency.
+//
+// The server is configured with opts, which should enable the same plots as
+// the recording server, see NewServer. Register it on a mux to serve the
+// replay.
+func ServeReplay(r io.Reader, opts ...OptionFunc) (*Server, error) {
+	frames, err := readReplay(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(append([]OptionFunc{withReplay(frames)}, opts...)...)
+}
+
+// WithReplaySpeed sets the speed at which ServeReplay sends the recorded
+// stats, 1 by default. For example, with a speed of 2, stats recorded one
+// second apart are sent half a second apart.
+func WithReplaySpeed(speed float64) OptionFunc {
+	return func(s *Server) error {
+		if !(speed > 0) || speed > 1000 {
+			return configError("WithReplaySpeed", "replay speed must be in (0, 1000], got %v", speed)
+		}
+		s.replaySpeed = speed
+		return nil
+	}
+}
+
+func withReplay(frames []stats) OptionFunc {
+	return func(s *Server) error {
+		s.replay = frames
+		return nil
+	}
+}
+
+// maxReplayLine is the size of the longest line of a recording.
+const maxReplayLine = 16 << 20
+
+// readReplay reads the stats of a newline-delimited JSON recording, skipping
+// the control messages.
+func readReplay(r io.Reader) ([]stats, error) {
+	var frames []stats
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxReplayLine)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var st struct {
+			Type *string `json:"type"` // set on control messages
+			stats
+		}
+		if err := json.Unmarshal(line, &st); err != nil {
+			return nil, fmt.Errorf("replay: line %d: %v", n, err)
+		}
+		if st.Type != nil {
+			continue
+		}
+		if st.Time.IsZero() {
+			return nil, fmt.Errorf("replay: line %d: stats without time", n)
+		}
+		if len(frames) != 0 && st.Time.Before(frames[len(frames)-1].Time) {
+			return nil, fmt.Errorf("replay: line %d: stats out of order", n)
+		}
+		st.Summary = nil // refers to the history of the recording server
+		frames = append(frames, st.stats)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("replay: %v", err)
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("replay: no stats recorded")
+	}
+	return frames, nil
+}
+
+// sendReplay sends the replayed stats with send, see ServeReplay, until done
+// is closed or the server is stopped. Frequency requests received on freqc
+// are refused.
+func (s *Server) sendReplay(done <-chan struct{}, freqc <-chan time.Duration, send func(msg []byte, binary bool) error) error {
+	speed := s.replaySpeed
+	if speed == 0 {
+		speed = 1
+	}
+
+	var tm timer
+	defer func() {
+		if tm != nil {
+			tm.Stop()
+		}
+	}()
+	// wait waits for tick, or forever if tick is nil, and reports whether the
+	// replay goes on.
+	wait := func(tick <-chan time.Time) (bool, error) {
+		for {
+			select {
+			case <-done:
+				return false, nil
+			case <-s.done:
+				return false, nil
+			case <-freqc:
+				buf, err := json.Marshal(controlMsg{
+					Type:   "setFrequency",
+					Millis: s.freq.Milliseconds(),
+					Error:  "the frequency of a replay can't be changed",
+				})
+				if err != nil {
+					return false, err
+				}
+				if err := send(buf, false); err != nil {
+					return false, err
+				}
+			case <-tick:
+				return true, nil
+			}
+		}
+	}
+
+	for i := range s.replay {
+		if i > 0 {
+			d := time.Duration(float64(s.replay[i].Time.Sub(s.replay[i-1].Time)) / speed)
+			if d > 0 {
+				if tm == nil {
+					tm = s.clock.NewTimer(d)
+				} else {
+					tm.Reset(d)
+				}
+				if ok, err := wait(tm.C()); !ok {
+					return err
+				}
+			}
+		}
+
+		// Maps are shared with the recording, which marshaling doesn't
+		// modify.
+		st := s.replay[i]
+		st.Time = s.clock.Now()
+		st.Seq = uint64(i + 1)
+		st.Historical = false
+		buf, err := s.marshalStats(&st)
+		if err != nil {
+			return err
+		}
+		if err := send(buf, s.encoding.binary()); err != nil {
+			return err
+		}
+		s.counters.sent(len(buf))
+	}
+	_, err := wait(nil)
+	return err
+}
@@ -0,0 +1,117 @@
+package statsviz
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// replayRecording holds two stats recorded 2 seconds apart, and a control
+// message in between.
+const replayRecording = `{"GoVersion":"go1.22.0","Seq":7,"Time":"2024-03-01T10:00:00Z","NumGoroutine":10,"UserPlots":{"queue":[1,null]}}
+{"type":"reset"}
+
+{"GoVersion":"go1.22.0","Seq":8,"Time":"2024-03-01T10:00:02Z","NumGoroutine":12,"UserPlots":{"queue":[2,3]}}
+`
+
+func TestServeReplay(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		speed float64
+		gap   time.Duration // between the replayed stats
+	}{
+		{1, 2 * time.Second},
+		{4, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t0 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		clk := newFakeClock(t0)
+		srv, err := ServeReplay(strings.NewReader(replayRecording), withClock(clk), WithReplaySpeed(tt.speed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Stop()
+		ts := httptest.NewServer(srv.Ws())
+		defer ts.Close()
+
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		var st stats
+		if err := ws.ReadJSON(&st); err != nil {
+			t.Fatal(err)
+		}
+		if st.NumGoroutine != 10 || st.Seq != 1 || !st.Time.Equal(t0) {
+			t.Errorf("speed %v: got first stats %d goroutines, seq %d at %v, want 10, 1 at %v", tt.speed, st.NumGoroutine, st.Seq, st.Time, t0)
+		}
+		if q := st.UserPlots["queue"]; len(q) != 2 || q[0] != 1 || !math.IsNaN(q[1]) {
+			t.Errorf("speed %v: got first queue values %v, want [1 NaN]", tt.speed, q)
+		}
+
+		// The second stats are sent once the recorded gap, sped up, elapsed,
+		// not before.
+		next := make(chan stats, 1)
+		errc := make(chan error, 1)
+		go func() {
+			var st stats
+			if err := ws.ReadJSON(&st); err != nil {
+				errc <- err
+				return
+			}
+			next <- st
+		}()
+		select {
+		case <-clk.added:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the replay timer")
+		}
+		clk.advance(tt.gap - time.Millisecond)
+		select {
+		case st := <-next:
+			t.Errorf("speed %v: got stats seq %d before the recorded gap elapsed", tt.speed, st.Seq)
+		case err := <-errc:
+			t.Fatal(err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		clk.advance(time.Millisecond)
+
+		select {
+		case st = <-next:
+		case err := <-errc:
+			t.Fatal(err)
+		}
+		if want := t0.Add(tt.gap); st.NumGoroutine != 12 || st.Seq != 2 || !st.Time.Equal(want) {
+			t.Errorf("speed %v: got second stats %d goroutines, seq %d at %v, want 12, 2 at %v", tt.speed, st.NumGoroutine, st.Seq, st.Time, want)
+		}
+	}
+}
+
+func TestServeReplayInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"empty":        "",
+		"control only": `{"type":"reset"}`,
+		"bad json":     `{"Time":`,
+		"no time":      `{"NumGoroutine":1}`,
+		"out of order": `{"Time":"2024-03-01T10:00:02Z"}` + "\n" + `{"Time":"2024-03-01T10:00:00Z"}`,
+	}
+	for name, rec := range tests {
+		if srv, err := ServeReplay(strings.NewReader(rec)); err == nil {
+			srv.Stop()
+			t.Errorf("%s: got nil error, want non-nil", name)
+		}
+	}
+	if _, err := ServeReplay(strings.NewReader(replayRecording), WithReplaySpeed(0)); err == nil {
+		t.Errorf("zero speed: got nil error, want non-nil")
+	}
+}
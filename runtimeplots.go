@@ -0,0 +1,687 @@
+package statsviz
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A runtimePlot is a built-in plot whose series are read from runtime/metrics.
+// It's either a time series plot, or a heatmap showing the evolution of an
+// histogram metric.
+type runtimePlot struct {
+	name    string
+	title   string
+	series  []runtimeSeries
+	stacked bool // series are drawn as stacked areas
+	stepped bool // series are drawn as steps, for values changing rarely
+
+	// partial indicates the plot is shown with the supported series only, if
+	// some of its metrics are missing, rather than not at all.
+	partial bool
+
+	// heatmap, if not nil, describes the histogram shown by a heatmap plot,
+	// in which case series is empty.
+	heatmap *runtimeHeatmap
+}
+
+// A runtimeSeries is a single time series of a runtimePlot.
+type runtimeSeries struct {
+	name   string
+	metric string // runtime/metrics name, empty if read is set
+
+	// read, if not nil, reads the series value from another source than
+	// runtime/metrics, in readUnit.
+	read     func() float64
+	readUnit string
+
+	// key identifies the transform state of a series with a read function,
+	// it's only needed if transform isn't Raw.
+	key string
+
+	// value computes the series value from the metric value. If nil, the
+	// metric must be a scalar, which value is used as is.
+	value func(metrics.Value) float64
+
+	// fallback, if not nil, reads the series value, in the metric unit, if
+	// the metric isn't supported by the current Go runtime.
+	fallback func() float64
+
+	// transform is applied to the metric value, see Transform.
+	transform Transform
+
+	// percentOf, if set, is the name of another cumulative metric. The series
+	// then shows the increase of metric, over each interval, as a percentage
+	// of the increase of percentOf.
+	percentOf string
+
+	// minus, if set, is the name of another scalar metric, which value is
+	// subtracted from the metric value.
+	minus string
+
+	// rightAxis draws the series against a second y axis, on the right, for
+	// series which values aren't comparable with the others.
+	rightAxis bool
+}
+
+// A runtimeHeatmap is a heatmap of a Float64Histogram runtime metric, showing
+// the number of values recorded in each bucket over each interval. Since
+// runtime histograms have a lot of buckets, adjacent buckets are merged so that
+// the heatmap has at most maxBuckets buckets.
+type runtimeHeatmap struct {
+	metric     string // runtime/metrics name
+	maxBuckets int    // maxHeatmapBuckets if 0
+
+	// minus, if set, is another histogram metric with the same buckets,
+	// which counts are subtracted from those of metric.
+	minus string
+
+	// user, if not nil, is the histogram shown rather than metric, see
+	// WithHistogramPlot.
+	user *UserHistogram
+
+	// label formats a bucket upper bound.
+	label func(float64) string
+
+	factor  int       // number of merged buckets
+	buckets []string  // merged buckets labels
+	bounds  []float64 // merged buckets boundaries, len(buckets)+1 values
+}
+
+const maxHeatmapBuckets = 30
+
+// runtimePlots holds all built-in plots backed by runtime/metrics. Depending
+// on the Go version, some of them may not be supported, see
+// supportedRuntimePlots.
+var runtimePlots = []runtimePlot{
+	{
+		name:  string(PlotSchedLatencies),
+		title: "Scheduling latencies",
+		heatmap: &runtimeHeatmap{
+			metric: "/sched/latencies:seconds",
+			label:  secondsLabel,
+		},
+	},
+	{
+		name:  string(PlotMutexWait),
+		title: "Mutex wait (seconds per second)",
+		series: []runtimeSeries{
+			{
+				name:      "wait",
+				metric:    "/sync/mutex/wait/total:seconds",
+				transform: Rate,
+			},
+		},
+	},
+	{
+		name:  string(PlotGCCPU),
+		title: "GC CPU usage (% per interval)",
+		series: []runtimeSeries{
+			{
+				name:      "gc",
+				metric:    "/cpu/classes/gc/total:cpu-seconds",
+				percentOf: "/cpu/classes/total:cpu-seconds",
+			},
+		},
+	},
+	{
+		name:  string(PlotThreads),
+		title: "Goroutines and OS threads",
+		series: []runtimeSeries{
+			{
+				name:   "goroutines",
+				metric: "/sched/goroutines:goroutines",
+			},
+			{
+				// The runtime doesn't export the number of OS threads as a
+				// metric, but counts the created ones for this profile.
+				name: "threads",
+				read: threadCount,
+			},
+		},
+	},
+	{
+		// How the heap memory is used: by objects, unused in spans of
+		// objects, free or released to the OS, so that fragmentation is
+		// visible at a glance.
+		name:    string(PlotHeapClasses),
+		title:   "Heap memory classes (bytes)",
+		stacked: true,
+		series: []runtimeSeries{
+			{name: "objects", metric: "/memory/classes/heap/objects:bytes"},
+			{name: "unused", metric: "/memory/classes/heap/unused:bytes"},
+			{name: "free", metric: "/memory/classes/heap/free:bytes"},
+			{name: "released", metric: "/memory/classes/heap/released:bytes"},
+		},
+	},
+	{
+		// Objects allocated minus objects freed over each interval, by size
+		// class, showing which size classes churn. The runtime/metrics
+		// counterpart of the size classes plot, which shows live objects.
+		name:  string(PlotSizeClassChurn),
+		title: "Size classes churn (allocated minus freed objects)",
+		heatmap: &runtimeHeatmap{
+			metric: "/gc/heap/allocs-by-size:bytes",
+			minus:  "/gc/heap/frees-by-size:bytes",
+			label:  sizeClassLabel,
+		},
+	},
+	{
+		// The rate of heap allocations, rather than the cumulative counters.
+		// There's no value for the first interval.
+		name:  string(PlotAllocRate),
+		title: "Heap allocations (per second)",
+		series: []runtimeSeries{
+			{name: "bytes", metric: "/gc/heap/allocs:bytes", transform: Rate},
+			{name: "objects", metric: "/gc/heap/allocs:objects", transform: Rate},
+		},
+	},
+	{
+		// The live heap against the heap size at which the next GC starts,
+		// the gap being the headroom left. Live heap bytes are only exported
+		// by Go 1.21 and later.
+		name:    string(PlotLiveHeap),
+		title:   "Live heap and next GC goal (bytes)",
+		partial: true,
+		series: []runtimeSeries{
+			{name: "live", metric: "/gc/heap/live:bytes"},
+			{name: "goal", metric: "/gc/heap/goal:bytes"},
+		},
+	},
+	{
+		// The CPU time spent by the GC over each interval, by dedicated
+		// workers, by goroutines assisting the GC while allocating, which is
+		// time taken from the application, by idle workers and during
+		// pauses.
+		name:    string(PlotGCCPUClasses),
+		title:   "GC CPU time (cpu-seconds per interval)",
+		stacked: true,
+		series: []runtimeSeries{
+			{name: "dedicated", metric: "/cpu/classes/gc/mark/dedicated:cpu-seconds", transform: Delta},
+			{name: "assist", metric: "/cpu/classes/gc/mark/assist:cpu-seconds", transform: Delta},
+			{name: "idle", metric: "/cpu/classes/gc/mark/idle:cpu-seconds", transform: Delta},
+			{name: "pause", metric: "/cpu/classes/gc/pause:cpu-seconds", transform: Delta},
+		},
+	},
+	{
+		// The memory used by goroutine stacks, allocated from the heap, and
+		// by the stacks allocated by the OS, along with the number of
+		// goroutines which stacks grow.
+		name:    string(PlotStacks),
+		title:   "Stack memory (bytes) and goroutines",
+		partial: true,
+		series: []runtimeSeries{
+			{name: "heap stacks", metric: "/memory/classes/heap/stacks:bytes"},
+			{name: "os stacks", metric: "/memory/classes/os-stacks:bytes"},
+			{name: "goroutines", metric: "/sched/goroutines:goroutines"},
+		},
+	},
+	{
+		// Where the CPU time available to the Go runtime went over each
+		// interval: user code, GC, returning memory to the OS or idle, as
+		// percentages of the total, which sum to 100%.
+		name:    string(PlotCPUClasses),
+		title:   "CPU time breakdown (% per interval)",
+		stacked: true,
+		series: []runtimeSeries{
+			{name: "user", metric: "/cpu/classes/user:cpu-seconds", percentOf: "/cpu/classes/total:cpu-seconds"},
+			{name: "gc", metric: "/cpu/classes/gc/total:cpu-seconds", percentOf: "/cpu/classes/total:cpu-seconds"},
+			{name: "scavenge", metric: "/cpu/classes/scavenge/total:cpu-seconds", percentOf: "/cpu/classes/total:cpu-seconds"},
+			{name: "idle", metric: "/cpu/classes/idle:cpu-seconds", percentOf: "/cpu/classes/total:cpu-seconds"},
+		},
+	},
+	{
+		// How many times the program relied on a non-default behavior
+		// enabled by a GODEBUG setting, which often keeps a deprecated
+		// behavior. The settings depend on the Go version, the plot isn't
+		// shown without any.
+		name:    string(PlotGoDebug),
+		title:   "GODEBUG non-default behaviors (events)",
+		partial: true,
+		series:  godebugSeries(metrics.All()),
+	},
+	{
+		// Whether runtime/trace is currently tracing the program.
+		name:  string(PlotTracing),
+		title: "Execution tracing (1 if enabled)",
+		series: []runtimeSeries{
+			{name: "enabled", read: tracingEnabled},
+		},
+	},
+	{
+		// Memory returned to the OS by the scavenger, against the CPU time it
+		// costs. The released memory decreases as the heap reuses it, which
+		// the rate counts as zero: it's the rate at which the scavenger
+		// releases memory beyond what's reused.
+		name:    string(PlotScavenger),
+		title:   "Scavenger",
+		partial: true,
+		series: []runtimeSeries{
+			{name: "released to the OS", metric: "/memory/classes/heap/released:bytes", transform: Rate},
+			{name: "scavenge CPU", metric: "/cpu/classes/scavenge/total:cpu-seconds", transform: Rate, rightAxis: true},
+		},
+	},
+	{
+		// Finalizers and cleanups queued but not executed yet, a backlog
+		// retains memory. The metrics are approximate, and only exist since
+		// Go 1.25, the plot isn't shown before.
+		name:    string(PlotFinalizers),
+		title:   "Finalizer and cleanup queues",
+		partial: true,
+		series: []runtimeSeries{
+			{name: "finalizers", metric: "/gc/finalizers/queued:finalizers", minus: "/gc/finalizers/executed:finalizers"},
+			{name: "cleanups", metric: "/gc/cleanups/queued:cleanups", minus: "/gc/cleanups/executed:cleanups"},
+		},
+	},
+	{
+		// GOMAXPROCS, which the runtime may update when the CPU limit of
+		// the container changes, as well as runtime.GOMAXPROCS calls. Drawn
+		// as steps, to compare with the CPU plots.
+		name:    string(PlotGOMAXPROCS),
+		title:   "GOMAXPROCS",
+		stepped: true,
+		series: []runtimeSeries{
+			{name: "gomaxprocs", metric: "/sched/gomaxprocs:threads", fallback: gomaxprocs},
+		},
+	},
+}
+
+// godebugPrefix prefixes the metrics counting the uses of non-default
+// behaviors enabled by GODEBUG settings, named after the settings.
+const godebugPrefix = "/godebug/non-default-behavior/"
+
+// godebugSeries returns the series counting the non-default behaviors
+// described in descs.
+func godebugSeries(descs []metrics.Description) []runtimeSeries {
+	var series []runtimeSeries
+	for _, d := range descs {
+		if !strings.HasPrefix(d.Name, godebugPrefix) || d.Kind == metrics.KindBad {
+			continue
+		}
+		name := strings.TrimPrefix(d.Name, godebugPrefix)
+		name = name[:strings.LastIndexByte(name, ':')]
+		series = append(series, runtimeSeries{name: name, metric: d.Name})
+	}
+	return series
+}
+
+func gomaxprocs() float64 {
+	return float64(runtime.GOMAXPROCS(0))
+}
+
+func tracingEnabled() float64 {
+	if trace.IsEnabled() {
+		return 1
+	}
+	return 0
+}
+
+var threadCreateProfile = pprof.Lookup("threadcreate")
+
+// threadCount returns the number of OS threads created by the Go program.
+func threadCount() float64 {
+	return float64(threadCreateProfile.Count())
+}
+
+// withFallbacks returns series, in which the series of unknown metrics with
+// a fallback read it instead. series isn't modified.
+func withFallbacks(series []runtimeSeries, known map[string]bool) []runtimeSeries {
+	var replaced []runtimeSeries
+	for i, ts := range series {
+		if ts.fallback == nil || ts.metric == "" || known[ts.metric] {
+			continue
+		}
+		if replaced == nil {
+			replaced = append([]runtimeSeries(nil), series...)
+		}
+		replaced[i].read, replaced[i].readUnit = ts.fallback, ts.unit()
+		replaced[i].metric = ""
+	}
+	if replaced == nil {
+		return series
+	}
+	return replaced
+}
+
+// metrics returns the names of the runtime metrics the plot reads.
+func (p *runtimePlot) metrics() []string {
+	if p.heatmap != nil {
+		if p.heatmap.user != nil {
+			return nil
+		}
+		if p.heatmap.minus != "" {
+			return []string{p.heatmap.metric, p.heatmap.minus}
+		}
+		return []string{p.heatmap.metric}
+	}
+	var names []string
+	for _, ts := range p.series {
+		if ts.metric == "" {
+			continue
+		}
+		names = append(names, ts.metric)
+		if ts.percentOf != "" {
+			names = append(names, ts.percentOf)
+		}
+		if ts.minus != "" {
+			names = append(names, ts.minus)
+		}
+	}
+	return names
+}
+
+// supportedRuntimePlots returns the plots, among plots, for which all metrics
+// are described in descs, and the sorted list of the missing metrics of the
+// other plots, which are dropped. Partial plots are kept with their supported
+// series, unless they have none.
+func supportedRuntimePlots(plots []runtimePlot, descs []metrics.Description) (supported []runtimePlot, missing []string) {
+	known := make(map[string]bool, len(descs))
+	for _, d := range descs {
+		if d.Kind != metrics.KindBad {
+			known[d.Name] = true
+		}
+	}
+
+	for _, p := range plots {
+		p.series = withFallbacks(p.series, known)
+		if p.partial {
+			var series []runtimeSeries
+			for _, ts := range p.series {
+				if ts.metric != "" && !known[ts.metric] {
+					missing = append(missing, ts.metric)
+					continue
+				}
+				if ts.minus != "" && !known[ts.minus] {
+					missing = append(missing, ts.minus)
+					continue
+				}
+				series = append(series, ts)
+			}
+			if p.series = series; len(series) != 0 {
+				supported = append(supported, p)
+			}
+			continue
+		}
+
+		ok := true
+		for _, name := range p.metrics() {
+			if !known[name] {
+				missing = append(missing, name)
+				ok = false
+			}
+		}
+		if !ok {
+			continue
+		}
+		if p.heatmap != nil {
+			hm := *p.heatmap
+			hm.init()
+			p.heatmap = &hm
+		}
+		supported = append(supported, p)
+	}
+
+	sort.Strings(missing)
+	return supported, missing
+}
+
+// init reads the histogram once in order to compute the heatmap buckets, the
+// buckets of a runtime histogram never change. Those of a user histogram are
+// its bounds.
+func (hm *runtimeHeatmap) init() {
+	var h *metrics.Float64Histogram
+	if hm.user != nil {
+		bounds := append([]float64{math.Inf(-1)}, hm.user.bounds...)
+		h = &metrics.Float64Histogram{
+			Buckets: append(bounds, math.Inf(1)),
+			Counts:  make([]uint64, len(hm.user.counts)),
+		}
+	} else {
+		s := []metrics.Sample{{Name: hm.metric}}
+		metrics.Read(s)
+		h = s[0].Value.Float64Histogram()
+	}
+
+	maxBuckets := hm.maxBuckets
+	if maxBuckets <= 0 {
+		maxBuckets = maxHeatmapBuckets
+	}
+
+	// h.Buckets holds len(h.Counts)+1 boundaries, the first and last ones may
+	// be -Inf and +Inf.
+	n := len(h.Counts)
+	hm.factor = (n + maxBuckets - 1) / maxBuckets
+	hm.buckets = nil
+	hm.bounds = []float64{h.Buckets[0]}
+	for i := 0; i < n; i += hm.factor {
+		upper := h.Buckets[min(i+hm.factor, n)]
+		hm.buckets = append(hm.buckets, hm.label(upper))
+		hm.bounds = append(hm.bounds, upper)
+	}
+}
+
+// counts stores the merged buckets counts of the histogram metric, read from
+// smp, into vals, which is allocated if it doesn't have the right length, and
+// returns it. Counts are the number of values recorded since the previous call
+// with the same key, minus those of the minus metric if set, all counts are NaN
+// the first time.
+func (hm *runtimeHeatmap) counts(smp *sampler, key string, vals plotValues) plotValues {
+	if len(vals) != len(hm.buckets) {
+		vals = make(plotValues, len(hm.buckets))
+	}
+	for i := range vals {
+		vals[i] = 0
+	}
+	var ok bool
+	if hm.user != nil {
+		ok = hm.addUser(smp, key, vals)
+	} else {
+		ok = hm.add(smp, key, hm.metric, 1, vals)
+	}
+	if hm.minus != "" {
+		// The previous counts of metric are keyed by the plot name, those of
+		// minus by its name.
+		okMinus := hm.add(smp, hm.minus, hm.minus, -1, vals)
+		ok = ok && okMinus
+	}
+	if !ok {
+		for i := range vals {
+			vals[i] = math.NaN()
+		}
+	}
+	return vals
+}
+
+// add adds to vals the merged buckets counts of the histogram metric recorded
+// since the previous call with the same key, multiplied by sign. It reports
+// false if the metric couldn't be read, or if it's the first call.
+func (hm *runtimeHeatmap) add(smp *sampler, key, metric string, sign float64, vals plotValues) bool {
+	v := smp.value(metric)
+	if v.Kind() != metrics.KindFloat64Histogram {
+		return false
+	}
+
+	h := v.Float64Histogram()
+	prev, ok := smp.hists[key]
+	if !ok || len(prev) != len(h.Counts) {
+		prev = make([]uint64, len(h.Counts))
+		smp.hists[key] = prev
+		ok = false
+	}
+	for i, c := range h.Counts {
+		if j := i / hm.factor; j < len(vals) && c > prev[i] {
+			vals[j] += sign * float64(c-prev[i])
+		}
+		prev[i] = c
+	}
+	return ok
+}
+
+// secondsLabel formats a bucket boundary expressed in seconds.
+func secondsLabel(secs float64) string {
+	if math.IsInf(secs, 1) {
+		return "+Inf"
+	}
+	return time.Duration(secs * float64(time.Second)).String()
+}
+
+// sizeClassLabel formats the upper bound of a bucket of the allocs-by-size and
+// frees-by-size histograms. Buckets hold objects which size is lower than the
+// bound, which is one more than the largest size of the bucket.
+func sizeClassLabel(upper float64) string {
+	if math.IsInf(upper, 1) {
+		return "+Inf"
+	}
+	size := upper - 1
+	if size < 1024 {
+		return strconv.FormatFloat(size, 'f', -1, 64) + " B"
+	}
+	return strconv.FormatFloat(size/1024, 'g', 4, 64) + " KiB"
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// sample stores the values of the plot series, or the heatmap buckets counts,
+// read from smp, into vals, which is allocated if it doesn't have the right
+// length, and returns it.
+func (p *runtimePlot) sample(smp *sampler, vals plotValues) plotValues {
+	if p.heatmap != nil {
+		return p.heatmap.counts(smp, p.name, vals)
+	}
+
+	if len(vals) != len(p.series) {
+		vals = make(plotValues, len(p.series))
+	}
+	for i, ts := range p.series {
+		if ts.read != nil {
+			vals[i] = smp.transform(ts.key, ts.transform, ts.read())
+			continue
+		}
+		v := smp.value(ts.metric)
+		switch {
+		case ts.value != nil:
+			vals[i] = ts.value(v)
+		case ts.percentOf != "":
+			vals[i] = smp.percent(ts.metric, scalar(v), ts.percentOf, scalar(smp.value(ts.percentOf)))
+		case ts.minus != "":
+			vals[i] = scalar(v) - scalar(smp.value(ts.minus))
+		default:
+			vals[i] = smp.transform(ts.metric, ts.transform, scalar(v))
+		}
+	}
+	return vals
+}
+
+// newSampler returns a sampler reading the runtime metrics needed by the
+// server enabled runtime plots and thresholds.
+func (s *Server) newSampler() *sampler {
+	names := []string{}
+	for i := range s.runtimePlots {
+		names = append(names, s.runtimePlots[i].metrics()...)
+	}
+	names = append(names, s.thresholdMetrics()...)
+	smp := newSamplerOf(names)
+	smp.logger = s.logger
+	return smp
+}
+
+// A PlotConfig is the configuration of a plot, as drawn by the user
+// interface. See Server.PlotConfigs.
+type PlotConfig struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	Type  string `json:"type"` // "scatter" or "heatmap"
+
+	// Stacked, for scatter plots, indicates series are drawn as stacked
+	// areas.
+	Stacked bool `json:"stacked,omitempty"`
+
+	// Stepped, for scatter plots, indicates series are drawn as steps.
+	Stepped bool `json:"stepped,omitempty"`
+
+	// RightAxis holds the names of the series drawn against a second y
+	// axis, on the right.
+	RightAxis []string `json:"rightAxis,omitempty"`
+
+	// YAxis is the type of the y axis, linear if empty.
+	YAxis Axis `json:"yaxis,omitempty"`
+
+	// Series holds the plot series. For heatmaps, there's one series per
+	// bucket, named after the bucket upper bound.
+	Series []TimeSeries `json:"series"`
+
+	// Metrics holds the names of the runtime metrics the plot is drawn
+	// from, described in the handshake Metrics.
+	Metrics []string `json:"metrics,omitempty"`
+}
+
+// config returns the plot configuration, as sent in the handshake.
+func (p *runtimePlot) config() PlotConfig {
+	cfg := PlotConfig{Name: p.name, Title: p.title, Type: "scatter", Stacked: p.stacked, Stepped: p.stepped, Metrics: p.metrics()}
+	if p.heatmap != nil {
+		cfg.Type = "heatmap"
+		for _, b := range p.heatmap.buckets {
+			cfg.Series = append(cfg.Series, TimeSeries{Name: b})
+		}
+		return cfg
+	}
+	for _, ts := range p.series {
+		cfg.Series = append(cfg.Series, TimeSeries{Name: ts.name, Unit: ts.unit()})
+		if ts.rightAxis {
+			cfg.RightAxis = append(cfg.RightAxis, ts.name)
+		}
+	}
+	return cfg
+}
+
+// A metricInfo describes a runtime metric, as sent in the handshake.
+type metricInfo struct {
+	Description string `json:"description"`
+
+	// Cumulative indicates the metric only increases, its rate is generally
+	// more meaningful than its value.
+	Cumulative bool `json:"cumulative,omitempty"`
+}
+
+// metricInfos returns the descriptions of the runtime metrics sampled by the
+// server, by name.
+func (s *Server) metricInfos() map[string]metricInfo {
+	smp := s.newSampler()
+	if len(smp.descs) == 0 {
+		return nil
+	}
+	infos := make(map[string]metricInfo, len(smp.descs))
+	for _, d := range smp.descs {
+		infos[d.Name] = metricInfo{Description: d.Description, Cumulative: d.Cumulative}
+	}
+	return infos
+}
+
+// unit returns the unit of the series values, derived from the unit of the
+// runtime metric. It's empty if the series isn't read from runtime/metrics.
+func (ts *runtimeSeries) unit() string {
+	switch {
+	case ts.read != nil:
+		return ts.readUnit
+	case ts.metric == "" || ts.value != nil:
+		return ""
+	case ts.percentOf != "":
+		return "percent"
+	}
+	unit := ts.metric[strings.LastIndexByte(ts.metric, ':')+1:]
+	if ts.transform == Rate {
+		unit += "/s"
+	}
+	return unit
+}
@@ -0,0 +1,894 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"runtime/trace"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupportedRuntimePlots(t *testing.T) {
+	t.Parallel()
+
+	plots := []runtimePlot{
+		{
+			name: "supported",
+			series: []runtimeSeries{
+				{name: "goroutines", metric: "/sched/goroutines:goroutines"},
+			},
+		},
+		{
+			name: "unsupported",
+			series: []runtimeSeries{
+				{name: "goroutines", metric: "/sched/goroutines:goroutines"},
+				{name: "missing", metric: "/does/not/exist:units"},
+			},
+		},
+	}
+
+	got, missing := supportedRuntimePlots(plots, metrics.All())
+	if len(got) != 1 || got[0].name != "supported" {
+		t.Fatalf("got plots %+v, want only the 'supported' plot", got)
+	}
+	if len(missing) != 1 || missing[0] != "/does/not/exist:units" {
+		t.Errorf("got missing metrics %q, want [/does/not/exist:units]", missing)
+	}
+
+	s := newServer()
+	s.runtimePlots = got
+
+	st := newStats()
+	s.collect(newSampler(), &st)
+
+	if _, ok := st.RuntimePlots["unsupported"]; ok {
+		t.Errorf("frame contains the unsupported plot")
+	}
+	vals, ok := st.RuntimePlots["supported"]
+	if !ok {
+		t.Fatalf("frame doesn't contain the supported plot")
+	}
+	if len(vals) != 1 || !(vals[0] > 0) {
+		t.Errorf("got supported plot values %v, want 1 positive value", vals)
+	}
+
+	hs := s.handshake()
+	if len(hs.RuntimePlots) != 1 || hs.RuntimePlots[0].Name != "supported" {
+		t.Errorf("got handshake runtime plots %+v, want only the 'supported' plot", hs.RuntimePlots)
+	}
+}
+
+func TestRuntimePlotSampleMissingMetric(t *testing.T) {
+	t.Parallel()
+
+	// Sampling a metric that doesn't exist must not fail.
+	p := runtimePlot{
+		name: "plot",
+		series: []runtimeSeries{
+			{name: "missing", metric: "/does/not/exist:units"},
+		},
+	}
+
+	smp := newSampler()
+	smp.read(time.Now())
+	vals := p.sample(smp, nil)
+	if len(vals) != 1 || !math.IsNaN(vals[0]) {
+		t.Errorf("got %v, want [NaN]", vals)
+	}
+}
+
+func TestSchedLatenciesPlot(t *testing.T) {
+	t.Parallel()
+
+	const metric = "/sched/latencies:seconds"
+
+	available := false
+	for _, d := range metrics.All() {
+		if d.Name == metric {
+			available = true
+		}
+	}
+	if !available {
+		t.Skipf("%s not supported by this Go version", metric)
+	}
+
+	s := newServer()
+
+	var plot *runtimePlot
+	for i := range s.runtimePlots {
+		for _, name := range s.runtimePlots[i].metrics() {
+			if name == metric {
+				plot = &s.runtimePlots[i]
+			}
+		}
+	}
+	if plot == nil {
+		t.Fatalf("%s is not sampled", metric)
+	}
+
+	nbuckets := len(plot.heatmap.buckets)
+	if nbuckets == 0 || nbuckets > maxHeatmapBuckets {
+		t.Errorf("got %d heatmap buckets, want between 1 and %d", nbuckets, maxHeatmapBuckets)
+	}
+
+	// Counts are per interval, so there are none the first time, then
+	// schedule some goroutines before sampling again.
+	st := newStats()
+	smp := newSampler()
+	s.collect(smp, &st)
+	for _, v := range st.RuntimePlots[plot.name] {
+		if !math.IsNaN(v) {
+			t.Fatalf("got first sample counts %v, want only NaN", st.RuntimePlots[plot.name])
+		}
+	}
+	for i := 0; i < 100; i++ {
+		done := make(chan struct{})
+		go close(done)
+		<-done
+	}
+	s.collect(smp, &st)
+
+	vals := st.RuntimePlots[plot.name]
+	if len(vals) != nbuckets {
+		t.Fatalf("got %d bucket counts, want %d", len(vals), nbuckets)
+	}
+	total := 0.0
+	for _, v := range vals {
+		total += v
+	}
+	if total == 0 {
+		t.Errorf("got no scheduling latency samples, want some")
+	}
+
+	for _, cfg := range s.handshake().RuntimePlots {
+		if cfg.Name == plot.name {
+			if cfg.Type != "heatmap" {
+				t.Errorf("got plot type %q, want %q", cfg.Type, "heatmap")
+			}
+			return
+		}
+	}
+	t.Errorf("%s plot is not in the handshake", plot.name)
+}
+
+func TestThreadsPlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotThreads))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) == 0 {
+		t.Skip("/sched/goroutines:goroutines not supported by this Go version")
+	}
+
+	st := newStats()
+	s.collect(s.newSampler(), &st)
+
+	vals, ok := st.RuntimePlots[string(PlotThreads)]
+	if !ok {
+		t.Fatalf("frame doesn't contain the %s plot", PlotThreads)
+	}
+	if len(vals) != 2 {
+		t.Fatalf("got %d series values, want 2 (goroutines and threads)", len(vals))
+	}
+	for i, name := range []string{"goroutines", "threads"} {
+		if !(vals[i] > 0) {
+			t.Errorf("got %s = %v, want a positive value", name, vals[i])
+		}
+	}
+}
+
+func TestHeapClassesPlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotHeapClasses))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) == 0 {
+		t.Skip("heap memory classes metrics not supported by this Go version")
+	}
+
+	st := newStats()
+	s.collect(s.newSampler(), &st)
+
+	vals := st.RuntimePlots[string(PlotHeapClasses)]
+	series := []string{"objects", "unused", "free", "released"}
+	if len(vals) != len(series) {
+		t.Fatalf("got %d series values, want %d", len(vals), len(series))
+	}
+	for i, name := range series {
+		if math.IsNaN(vals[i]) {
+			t.Errorf("got %s = NaN, want a value", name)
+		}
+	}
+	if vals[0] <= 0 {
+		t.Errorf("got objects = %v, want a positive value", vals[0])
+	}
+
+	cfgs := s.handshake().RuntimePlots
+	if len(cfgs) != 1 || !cfgs[0].Stacked {
+		t.Errorf("got handshake runtime plots %+v, want a single stacked plot", cfgs)
+	}
+}
+
+func TestRuntimeSeriesUnit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		series runtimeSeries
+		want   string
+	}{
+		{runtimeSeries{metric: "/memory/classes/total:bytes"}, "bytes"},
+		{runtimeSeries{metric: "/sched/goroutines:goroutines"}, "goroutines"},
+		{runtimeSeries{metric: "/sync/mutex/wait/total:seconds", transform: Rate}, "seconds/s"},
+		{runtimeSeries{metric: "/cpu/classes/gc/total:cpu-seconds", percentOf: "/cpu/classes/total:cpu-seconds"}, "percent"},
+		{runtimeSeries{read: threadCount}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.series.unit(); got != tt.want {
+			t.Errorf("unit of %+v = %q, want %q", tt.series, got, tt.want)
+		}
+	}
+
+	// The unit is sent in the plot config.
+	p := runtimePlot{name: "total", series: []runtimeSeries{{name: "total", metric: "/memory/classes/total:bytes"}}}
+	buf, err := json.Marshal(p.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"series":[{"name":"total","unit":"bytes"}]`; !strings.Contains(string(buf), want) {
+		t.Errorf("got plot config %s, want it to contain %s", buf, want)
+	}
+}
+
+func TestAllocRatePlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotAllocRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) != 1 {
+		t.Skip("heap allocations metrics not supported by this Go version")
+	}
+	p := &s.runtimePlots[0]
+
+	cfg := p.config()
+	if len(cfg.Series) != 2 || cfg.Series[0].Unit != "bytes/s" || cfg.Series[1].Unit != "objects/s" {
+		t.Errorf("got series %+v, want bytes/s and objects/s", cfg.Series)
+	}
+
+	// Two synthetic samples of the cumulative counters, 2s apart.
+	smp := s.newSampler()
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	rates := func(t time.Time, bytes, objects float64) [2]float64 {
+		smp.t = t
+		return [2]float64{
+			smp.transform(p.series[0].metric, p.series[0].transform, bytes),
+			smp.transform(p.series[1].metric, p.series[1].transform, objects),
+		}
+	}
+	if got := rates(t0, 1<<20, 100); !math.IsNaN(got[0]) || !math.IsNaN(got[1]) {
+		t.Errorf("first sample: got rates %v, want NaN", got)
+	}
+	if got := rates(t0.Add(2*time.Second), 3<<20, 300); got != [2]float64{1 << 20, 100} {
+		t.Errorf("second sample: got rates %v, want [%v 100]", got, 1<<20)
+	}
+
+	// Rates of the actual counters.
+	st := newStats()
+	s.collect(smp, &st)
+	s.collect(smp, &st)
+	if vals := st.RuntimePlots[string(PlotAllocRate)]; len(vals) != 2 || math.IsNaN(vals[0]) || vals[0] < 0 {
+		t.Errorf("got values %v, want allocation rates", vals)
+	}
+}
+
+var churnSink [][]byte
+
+func TestSizeClassChurnPlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotSizeClassChurn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) != 1 {
+		t.Skip("size classes metrics not supported by this Go version")
+	}
+	p := &s.runtimePlots[0]
+
+	smp := s.newSampler()
+	for _, name := range []string{"/gc/heap/allocs-by-size:bytes", "/gc/heap/frees-by-size:bytes"} {
+		if _, ok := smp.idx[name]; !ok {
+			t.Errorf("%s is not sampled", name)
+		}
+	}
+
+	// Adjacent size classes are merged, the bounds are the sizes bounds.
+	samples := []metrics.Sample{{Name: p.heatmap.metric}}
+	metrics.Read(samples)
+	h := samples[0].Value.Float64Histogram()
+	factor := (len(h.Counts) + maxHeatmapBuckets - 1) / maxHeatmapBuckets
+	nbuckets := (len(h.Counts) + factor - 1) / factor
+	if len(p.heatmap.buckets) != nbuckets || len(p.heatmap.bounds) != nbuckets+1 {
+		t.Fatalf("got %d buckets and %d bounds, want %d and %d", len(p.heatmap.buckets), len(p.heatmap.bounds), nbuckets, nbuckets+1)
+	}
+	if got, want := p.heatmap.buckets[0], sizeClassLabel(h.Buckets[factor]); got != want {
+		t.Errorf("got first bucket %q, want %q", got, want)
+	}
+
+	st := newStats()
+	s.collect(smp, &st)
+	for i := 0; i < 100; i++ {
+		churnSink = append(churnSink, make([]byte, 1024))
+	}
+	s.collect(smp, &st)
+	vals := st.RuntimePlots[p.name]
+	if len(vals) != nbuckets {
+		t.Fatalf("got %d bucket counts, want %d", len(vals), nbuckets)
+	}
+	for i, v := range vals {
+		if math.IsNaN(v) {
+			t.Errorf("bucket %s: got NaN, want a count", p.heatmap.buckets[i])
+		}
+	}
+}
+
+func TestSizeClassLabel(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		upper float64
+		want  string
+	}{
+		{9, "8 B"},
+		{1025, "1 KiB"},
+		{2689, "2.625 KiB"},
+		{math.Inf(1), "+Inf"},
+	}
+	for _, tt := range tests {
+		if got := sizeClassLabel(tt.upper); got != tt.want {
+			t.Errorf("sizeClassLabel(%v) = %q, want %q", tt.upper, got, tt.want)
+		}
+	}
+}
+
+func TestLiveHeapPlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotLiveHeap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) != 1 {
+		t.Skip("heap goal metric not supported by this Go version")
+	}
+	p := &s.runtimePlots[0]
+	if len(p.series) != 2 {
+		t.Skip("live heap metric not supported by this Go version")
+	}
+
+	// The live heap is only known once a GC cycle completed.
+	runtime.GC()
+	st := newStats()
+	s.collect(s.newSampler(), &st)
+	vals := st.RuntimePlots[p.name]
+	if len(vals) != 2 {
+		t.Fatalf("got values %v, want live and goal values", vals)
+	}
+	if !(vals[0] > 0) || !(vals[1] > 0) {
+		t.Errorf("got live heap %v and goal %v, want positive values", vals[0], vals[1])
+	}
+
+	// A partial plot is drawn with its supported series.
+	plots := []runtimePlot{{
+		name:    "partial",
+		partial: true,
+		series: []runtimeSeries{
+			{name: "missing", metric: "/does/not/exist:bytes"},
+			{name: "goal", metric: "/gc/heap/goal:bytes"},
+		},
+	}}
+	got, missing := supportedRuntimePlots(plots, metrics.All())
+	if len(got) != 1 || len(got[0].series) != 1 || got[0].series[0].name != "goal" {
+		t.Errorf("got plots %+v, want the partial plot with the goal series only", got)
+	}
+	if len(missing) != 1 || missing[0] != "/does/not/exist:bytes" {
+		t.Errorf("got missing metrics %q, want [/does/not/exist:bytes]", missing)
+	}
+	plots[0].series = plots[0].series[:1]
+	if got, _ := supportedRuntimePlots(plots, metrics.All()); len(got) != 0 {
+		t.Errorf("got plots %+v, want none without supported series", got)
+	}
+}
+
+func TestGCCPUClassesPlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotGCCPUClasses))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) != 1 {
+		t.Skip("GC CPU classes metrics not supported by this Go version")
+	}
+	p := &s.runtimePlots[0]
+
+	cfg := p.config()
+	series := []string{"dedicated", "assist", "idle", "pause"}
+	if !cfg.Stacked || len(cfg.Series) != len(series) {
+		t.Fatalf("got plot %+v, want a stacked plot with %d series", cfg, len(series))
+	}
+	for i, name := range series {
+		if cfg.Series[i].Name != name || cfg.Series[i].Unit != "cpu-seconds" {
+			t.Errorf("got series %+v, want %s in cpu-seconds", cfg.Series[i], name)
+		}
+	}
+
+	// Synthetic samples of the cumulative CPU times.
+	smp := s.newSampler()
+	deltas := func(cumulative [4]float64) [4]float64 {
+		var vals [4]float64
+		for i, ts := range p.series {
+			vals[i] = smp.transform(ts.metric, ts.transform, cumulative[i])
+		}
+		return vals
+	}
+	for _, v := range deltas([4]float64{1, 2, 3, 4}) {
+		if !math.IsNaN(v) {
+			t.Errorf("first sample: got delta %v, want NaN", v)
+		}
+	}
+	if got, want := deltas([4]float64{1.5, 2, 4, 4.25}), [4]float64{0.5, 0, 1, 0.25}; got != want {
+		t.Errorf("second sample: got deltas %v, want %v", got, want)
+	}
+	if got, want := deltas([4]float64{2.5, 3, 4, 4.5}), [4]float64{1, 1, 0, 0.25}; got != want {
+		t.Errorf("third sample: got deltas %v, want %v", got, want)
+	}
+
+	// Deltas of the actual CPU times.
+	runtime.GC()
+	st := newStats()
+	s.collect(smp, &st)
+	runtime.GC()
+	s.collect(smp, &st)
+	vals := st.RuntimePlots[p.name]
+	if len(vals) != len(series) {
+		t.Fatalf("got values %v, want %d series", vals, len(series))
+	}
+	for i, v := range vals {
+		if math.IsNaN(v) || v < 0 {
+			t.Errorf("%s: got %v, want a non-negative delta", series[i], v)
+		}
+	}
+}
+
+func TestStacksPlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotStacks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) != 1 {
+		t.Skip("stack memory metrics not supported by this Go version")
+	}
+	p := &s.runtimePlots[0]
+
+	smp := s.newSampler()
+	for _, ts := range p.series {
+		if _, ok := smp.idx[ts.metric]; !ok {
+			t.Errorf("%s is not sampled", ts.metric)
+		}
+	}
+
+	st := newStats()
+	s.collect(smp, &st)
+	vals := st.RuntimePlots[p.name]
+	if len(vals) != len(p.series) {
+		t.Fatalf("got values %v, want %d series", vals, len(p.series))
+	}
+	for i, ts := range p.series {
+		// OS stacks are only allocated for some threads, there may be none.
+		if math.IsNaN(vals[i]) || vals[i] < 0 || ts.name != "os stacks" && vals[i] == 0 {
+			t.Errorf("%s: got %v, want a positive value", ts.name, vals[i])
+		}
+	}
+}
+
+func TestHandshakeMetrics(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotAllocRate, PlotThreads))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) != 2 {
+		t.Skip("runtime metrics not supported by this Go version")
+	}
+
+	b, err := json.Marshal(s.handshake())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hs handshake
+	if err := json.Unmarshal(b, &hs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cfg := range hs.RuntimePlots {
+		if len(cfg.Metrics) == 0 {
+			t.Errorf("plot %s: got no metrics, want the metrics it's drawn from", cfg.Name)
+		}
+	}
+	for name := range s.newSampler().idx {
+		if info, ok := hs.Metrics[name]; !ok || info.Description == "" {
+			t.Errorf("metric %s: got %+v, want a description", name, info)
+		}
+	}
+	tests := []struct {
+		metric     string
+		cumulative bool
+	}{
+		{"/gc/heap/allocs:bytes", true},
+		{"/sched/goroutines:goroutines", false},
+	}
+	for _, tt := range tests {
+		if got := hs.Metrics[tt.metric].Cumulative; got != tt.cumulative {
+			t.Errorf("metric %s: got cumulative %t, want %t", tt.metric, got, tt.cumulative)
+		}
+	}
+	if len(hs.Metrics) != len(s.newSampler().idx) {
+		t.Errorf("got %d described metrics, want %d", len(hs.Metrics), len(s.newSampler().idx))
+	}
+}
+
+func TestCPUClassesPlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotCPUClasses))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) != 1 {
+		t.Skip("CPU classes metrics not supported by this Go version")
+	}
+	p := &s.runtimePlots[0]
+	if cfg := p.config(); !cfg.Stacked || len(cfg.Series) != 4 || cfg.Series[0].Unit != "percent" {
+		t.Fatalf("got plot %+v, want a stacked plot with 4 series in percent", cfg)
+	}
+
+	// Synthetic samples of the cumulative CPU times, by class, and of the
+	// total.
+	smp := s.newSampler()
+	percents := func(cumulative [4]float64, total float64) (vals [4]float64, sum float64) {
+		for i, ts := range p.series {
+			vals[i] = smp.percent(ts.metric, cumulative[i], ts.percentOf, total)
+			sum += vals[i]
+		}
+		return vals, sum
+	}
+	if _, sum := percents([4]float64{1, 0.5, 0.1, 2.4}, 4); !math.IsNaN(sum) {
+		t.Errorf("first sample: got sum %v, want NaN", sum)
+	}
+	vals, sum := percents([4]float64{2, 1, 0.1, 2.9}, 6)
+	if want := [4]float64{50, 25, 0, 25}; vals != want {
+		t.Errorf("second sample: got %v, want %v", vals, want)
+	}
+	if math.Abs(sum-100) > 1e-9 {
+		t.Errorf("second sample: got sum %v, want 100", sum)
+	}
+
+	// Percentages of the actual CPU times.
+	smp = s.newSampler()
+	st := newStats()
+	s.collect(smp, &st)
+	runtime.GC()
+	time.Sleep(50 * time.Millisecond)
+	s.collect(smp, &st)
+	sum = 0
+	for _, v := range st.RuntimePlots[p.name] {
+		sum += v
+	}
+	if math.IsNaN(sum) {
+		t.Skip("runtime CPU times didn't increase")
+	}
+	// The runtime CPU times are estimates, which may be slightly off when
+	// read while the runtime updates them.
+	if math.Abs(sum-100) > 1 {
+		t.Errorf("got percentages %v summing to %v, want 100", st.RuntimePlots[p.name], sum)
+	}
+}
+
+func TestGoDebugPlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotGoDebug))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	want := godebugSeries(metrics.All())
+	if len(want) == 0 {
+		if len(s.runtimePlots) != 0 {
+			t.Errorf("got plots %+v, want none without GODEBUG metrics", s.runtimePlots)
+		}
+		t.Skip("GODEBUG metrics not supported by this Go version")
+	}
+	if len(s.runtimePlots) != 1 {
+		t.Fatalf("got %d plots, want 1", len(s.runtimePlots))
+	}
+	p := &s.runtimePlots[0]
+
+	cfg := p.config()
+	if len(cfg.Series) != len(want) {
+		t.Fatalf("got %d series, want %d", len(cfg.Series), len(want))
+	}
+	for i, ts := range want {
+		if !strings.HasPrefix(ts.metric, godebugPrefix+ts.name+":") {
+			t.Errorf("got series %q for metric %s", ts.name, ts.metric)
+		}
+		if cfg.Series[i].Name != ts.name || cfg.Metrics[i] != ts.metric {
+			t.Errorf("got series %q of %s, want %q of %s", cfg.Series[i].Name, cfg.Metrics[i], ts.name, ts.metric)
+		}
+	}
+
+	smp := s.newSampler()
+	for _, ts := range want {
+		if _, ok := smp.idx[ts.metric]; !ok {
+			t.Errorf("%s is not sampled", ts.metric)
+		}
+	}
+	st := newStats()
+	s.collect(smp, &st)
+	vals := st.RuntimePlots[string(PlotGoDebug)]
+	if len(vals) != len(want) {
+		t.Fatalf("got values %v, want %d series", vals, len(want))
+	}
+	for i, v := range vals {
+		if math.IsNaN(v) || v < 0 {
+			t.Errorf("%s: got %v, want a count", want[i].name, v)
+		}
+	}
+}
+
+func TestTracingPlot(t *testing.T) {
+	// Not parallel since it starts tracing.
+
+	s, err := NewServer(WithPlots(PlotTracing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	smp := s.newSampler()
+	st := newStats()
+	enabled := func() float64 {
+		s.collect(smp, &st)
+		return st.RuntimePlots[string(PlotTracing)][0]
+	}
+	if got := enabled(); got != 0 {
+		t.Errorf("got %v, want 0 without tracing", got)
+	}
+	if err := trace.Start(ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	got := enabled()
+	trace.Stop()
+	if got != 1 {
+		t.Errorf("got %v, want 1 while tracing", got)
+	}
+}
+
+func TestGOMAXPROCSPlot(t *testing.T) {
+	// Not parallel since it changes GOMAXPROCS.
+
+	s, err := NewServer(WithPlots(PlotGOMAXPROCS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	var cfg *PlotConfig
+	for _, c := range s.handshake().RuntimePlots {
+		if c.Name == string(PlotGOMAXPROCS) {
+			c := c
+			cfg = &c
+		}
+	}
+	if cfg == nil || !cfg.Stepped || len(cfg.Series) != 1 || cfg.Series[0].Unit != "threads" {
+		t.Fatalf("got config %+v, want a stepped plot of threads", cfg)
+	}
+
+	smp := s.newSampler()
+	st := newStats()
+	procs := func() float64 {
+		s.collect(smp, &st)
+		return st.RuntimePlots[string(PlotGOMAXPROCS)][0]
+	}
+	prev := runtime.GOMAXPROCS(0)
+	defer runtime.GOMAXPROCS(prev)
+	if got := procs(); got != float64(prev) {
+		t.Errorf("got %v, want %d", got, prev)
+	}
+	runtime.GOMAXPROCS(prev + 1)
+	if got := procs(); got != float64(prev+1) {
+		t.Errorf("got %v after changing GOMAXPROCS, want %d", got, prev+1)
+	}
+
+	// Without the metric, GOMAXPROCS is read from the runtime package.
+	var descs []metrics.Description
+	for _, d := range metrics.All() {
+		if d.Name != "/sched/gomaxprocs:threads" {
+			descs = append(descs, d)
+		}
+	}
+	supported, _ := supportedRuntimePlots(runtimePlots, descs)
+	for _, p := range supported {
+		if p.name != string(PlotGOMAXPROCS) {
+			continue
+		}
+		ts := p.series[0]
+		if ts.metric != "" || ts.read == nil || ts.unit() != "threads" {
+			t.Errorf("got series %+v, want the fallback in threads", ts)
+		} else if got := ts.read(); got != float64(prev+1) {
+			t.Errorf("fallback: got %v, want %d", got, prev+1)
+		}
+		for _, p := range runtimePlots {
+			if p.name == string(PlotGOMAXPROCS) && p.series[0].read != nil {
+				t.Errorf("runtimePlots has been modified")
+			}
+		}
+		return
+	}
+	t.Errorf("plot %s not supported without its metric", PlotGOMAXPROCS)
+}
+
+func TestFinalizersPlot(t *testing.T) {
+	// Not parallel since it blocks the finalizer goroutine for a while.
+
+	known := make(map[string]bool)
+	for _, d := range metrics.All() {
+		known[d.Name] = true
+	}
+	var want []string
+	if known["/gc/finalizers/queued:finalizers"] && known["/gc/finalizers/executed:finalizers"] {
+		want = append(want, "finalizers")
+	}
+	if known["/gc/cleanups/queued:cleanups"] && known["/gc/cleanups/executed:cleanups"] {
+		want = append(want, "cleanups")
+	}
+
+	s, err := NewServer(WithPlots(PlotFinalizers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(want) == 0 {
+		if len(s.runtimePlots) != 0 {
+			t.Errorf("got plots %+v, want none without finalizer metrics", s.runtimePlots)
+		}
+		t.Skip("finalizer metrics not supported by this Go version")
+	}
+	if len(s.runtimePlots) != 1 {
+		t.Fatalf("got %d plots, want 1", len(s.runtimePlots))
+	}
+	p := &s.runtimePlots[0]
+	var got []string
+	for _, ts := range p.series {
+		got = append(got, ts.name)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got series %q, want %q", got, want)
+	}
+	smp := s.newSampler()
+	for _, name := range p.metrics() {
+		if _, ok := smp.idx[name]; !ok {
+			t.Errorf("%s is not sampled", name)
+		}
+	}
+	if want[0] != "finalizers" {
+		return
+	}
+
+	// A blocked finalizer holds up the queue.
+	release := make(chan struct{})
+	running := make(chan struct{})
+	blocking, queued := new([16]byte), new([16]byte)
+	runtime.SetFinalizer(blocking, func(*[16]byte) {
+		close(running)
+		<-release
+	})
+	runtime.SetFinalizer(queued, func(*[16]byte) {})
+	blocking, queued = nil, nil
+	defer close(release)
+
+	st := newStats()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.GC()
+		s.collect(smp, &st)
+		if v := st.RuntimePlots[string(PlotFinalizers)][0]; v >= 1 {
+			break
+		} else if v < 0 {
+			t.Fatalf("got finalizer queue %v, want a non-negative value", v)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the finalizer queue to grow")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	<-running
+}
+
+func TestScavengerPlot(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(WithPlots(PlotScavenger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if len(s.runtimePlots) != 1 {
+		t.Fatalf("got %d plots, want 1", len(s.runtimePlots))
+	}
+	p := &s.runtimePlots[0]
+	cfg := p.config()
+	var released *runtimeSeries
+	for i, ts := range p.series {
+		if ts.metric == "/memory/classes/heap/released:bytes" {
+			released = &p.series[i]
+			if cfg.Series[i].Unit != "bytes/s" {
+				t.Errorf("got unit %q, want bytes/s", cfg.Series[i].Unit)
+			}
+		}
+	}
+	if released == nil {
+		t.Fatalf("got series %+v, want the released memory", p.series)
+	}
+	if len(p.series) == 2 && (len(cfg.RightAxis) != 1 || cfg.RightAxis[0] != "scavenge CPU") {
+		t.Errorf("got right axis %q, want the scavenge CPU", cfg.RightAxis)
+	}
+
+	// The release rate of synthetic released memory values.
+	smp := s.newSampler()
+	t0 := time.Now()
+	rate := func(at time.Duration, v float64) float64 {
+		smp.t = t0.Add(at)
+		return smp.transform(released.metric, released.transform, v)
+	}
+	if got := rate(0, 1<<20); !math.IsNaN(got) {
+		t.Errorf("first tick: got %v, want NaN", got)
+	}
+	if got := rate(2*time.Second, 5<<20); got != 2<<20 {
+		t.Errorf("second tick: got %v, want %v", got, 2<<20)
+	}
+	// Released memory reused by the heap isn't a negative release.
+	if got := rate(3*time.Second, 4<<20); got != 0 {
+		t.Errorf("third tick: got %v, want 0", got)
+	}
+
+	st := newStats()
+	s.collect(smp, &st)
+	s.collect(smp, &st)
+	if vals := st.RuntimePlots[p.name]; len(vals) != len(p.series) || vals[0] < 0 {
+		t.Errorf("got values %v, want %d non-negative values", vals, len(p.series))
+	}
+}
@@ -0,0 +1,70 @@
+package statsviz
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ServerStats are statistics about a Server and its clients, returned by
+// Server.Stats.
+type ServerStats struct {
+	// Clients is the number of clients currently receiving stats, via
+	// websocket, Server-Sent Events, the NDJSON handler or StreamFrames.
+	// Sinks, see WithSink, aren't clients.
+	Clients int
+
+	// TotalClients is the number of clients served since the server was
+	// created, including the current ones.
+	TotalClients uint64
+
+	// FramesSent and BytesSent are the number of stats frames sent to
+	// clients and sinks, including historical stats, and their total size,
+	// before compression. Control messages aren't counted.
+	FramesSent uint64
+	BytesSent  uint64
+
+	// LastSample is the time stats were last collected for clients, zero if
+	// they never were.
+	LastSample time.Time
+}
+
+// serverCounters are the counters of ServerStats, updated atomically. It must
+// be kept 64-bit aligned.
+type serverCounters struct {
+	total      uint64
+	frames     uint64
+	bytes      uint64
+	lastSample int64 // in nanoseconds since the Unix epoch, 0 if none
+	clients    int32
+}
+
+func (c *serverCounters) connect() {
+	atomic.AddInt32(&c.clients, 1)
+	atomic.AddUint64(&c.total, 1)
+}
+
+func (c *serverCounters) disconnect() {
+	atomic.AddInt32(&c.clients, -1)
+}
+
+// sent counts a stats frame of n bytes sent to a client.
+func (c *serverCounters) sent(n int) {
+	atomic.AddUint64(&c.frames, 1)
+	atomic.AddUint64(&c.bytes, uint64(n))
+}
+
+// Stats returns the current statistics of the server, for example to check
+// whether clients are connected. It's safe for concurrent use.
+func (s *Server) Stats() ServerStats {
+	c := &s.counters
+	st := ServerStats{
+		Clients:      int(atomic.LoadInt32(&c.clients)),
+		TotalClients: atomic.LoadUint64(&c.total),
+		FramesSent:   atomic.LoadUint64(&c.frames),
+		BytesSent:    atomic.LoadUint64(&c.bytes),
+	}
+	if t := atomic.LoadInt64(&c.lastSample); t != 0 {
+		st.LastSample = time.Unix(0, t)
+	}
+	return st
+}
@@ -0,0 +1,59 @@
+package statsviz
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServerStats(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	if st := srv.Stats(); st != (ServerStats{}) {
+		t.Errorf("got stats %+v before any client, want zero stats", st)
+	}
+
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := srv.Stats()
+	if st.Clients != 1 || st.TotalClients != 1 {
+		t.Errorf("got %d clients and %d total clients, want 1 and 1", st.Clients, st.TotalClients)
+	}
+	if st.FramesSent < 1 || st.BytesSent < uint64(len(msg)) {
+		t.Errorf("got %d frames and %d bytes sent, want at least 1 frame and %d bytes", st.FramesSent, st.BytesSent, len(msg))
+	}
+	if st.LastSample.IsZero() || time.Since(st.LastSample) > time.Minute {
+		t.Errorf("got last sample at %v, want a recent time", st.LastSample)
+	}
+
+	// The client leaves.
+	ws.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.Stats().Clients != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("client still counted after disconnecting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := srv.Stats(); st.TotalClients != 1 {
+		t.Errorf("got %d total clients, want 1", st.TotalClients)
+	}
+}
@@ -0,0 +1,39 @@
+package statsviz
+
+import (
+	"io"
+)
+
+// WithSink writes the stats to w, in addition to sending them to the clients,
+// as newline-delimited JSON: one JSON encoded stats per line, in the format of
+// the websocket handler. It allows to forward the stats to another transport,
+// such as a message queue or a log aggregator, the server doesn't need to be
+// registered on a mux then. Stats are written at the server send frequency,
+// until the server is stopped.
+//
+// The first write error stops the sink, and is logged. The sink is subject to
+// the slow client policy, see WithSlowClientPolicy. WithSink can be set more
+// than once, it requires the JSON encoding. w isn't closed.
+func WithSink(w io.Writer) OptionFunc {
+	return func(s *Server) error {
+		if w == nil {
+			return configError("WithSink", "nil sink")
+		}
+		s.sinks = append(s.sinks, w)
+		return nil
+	}
+}
+
+// runSink writes the stats to w until the server is stopped, the last frame
+// included, or a write fails.
+func (s *Server) runSink(w io.Writer) {
+	line := []byte{}
+	err := s.sendStats(nil, s.freq, 1, nil, nil, func(msg []byte, _ bool) error {
+		line = append(append(line[:0], msg...), '\n')
+		_, err := w.Write(line)
+		return err
+	})
+	if err != nil {
+		s.logger.Warn("statsviz: sink stopped", "error", err)
+	}
+}
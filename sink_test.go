@@ -0,0 +1,102 @@
+package statsviz
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineWriter is a concurrency safe buffer, notifying written lines.
+type lineWriter struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	lines chan struct{}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case w.lines <- struct{}{}:
+	default:
+	}
+	return w.buf.Write(p)
+}
+
+func TestWithSink(t *testing.T) {
+	t.Parallel()
+
+	w := &lineWriter{lines: make(chan struct{}, 10)}
+	srv, err := NewServer(SendFrequency(10*time.Millisecond), WithSink(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-w.lines:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the sink to be written")
+		}
+	}
+	srv.Stop()
+
+	var last uint64
+	n := 0
+	sc := bufio.NewScanner(&w.buf)
+	sc.Buffer(nil, 1<<24)
+	for sc.Scan() {
+		var st stats
+		if err := json.Unmarshal(sc.Bytes(), &st); err != nil {
+			t.Fatalf("line %d: %v", n+1, err)
+		}
+		if st.Seq <= last || len(st.Metrics) == 0 {
+			t.Errorf("line %d: got frame %d after %d, want increasing frames with stats", n+1, st.Seq, last)
+		}
+		last = st.Seq
+		n++
+	}
+	if n < 3 {
+		t.Errorf("got %d frames, want at least 3", n)
+	}
+}
+
+type errWriter struct {
+	mu     sync.Mutex
+	writes int
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	return 0, errors.New("broken sink")
+}
+
+func TestWithSinkError(t *testing.T) {
+	t.Parallel()
+
+	w := &errWriter{}
+	srv, err := NewServer(SendFrequency(10*time.Millisecond), WithSink(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	srv.Stop()
+
+	if w.writes != 1 {
+		t.Errorf("got %d writes, want 1, the sink should stop on error", w.writes)
+	}
+	for _, opts := range [][]OptionFunc{
+		{WithSink(nil)},
+		{WithSink(w), WithEncoding(EncodingMsgpack)},
+	} {
+		if srv, err := NewServer(opts...); err == nil {
+			srv.Stop()
+			t.Errorf("got nil error, want non-nil")
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build go1.21
+// +build go1.21
+
+package statsviz
+
+import "log/slog"
+
+// WithLogger sets the logger to which the server reports events: websocket
+// clients connecting and disconnecting, failed websocket upgrades and writes,
+// panicking user plots, and runtime metrics not supported by the Go version.
+// Events have the remote_addr, root and error attributes, where relevant.
+//
+// By default, or if l is nil, events are discarded.
+func WithLogger(l *slog.Logger) OptionFunc {
+	return func(s *Server) error {
+		if l == nil {
+			s.logger = nopLogger{}
+			return nil
+		}
+		s.logger = l
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+//go:build go1.21
+// +build go1.21
+
+package statsviz
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// logRecorder is an io.Writer recording the JSON records of a slog handler.
+type logRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *logRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+// records returns the records logged so far.
+func (r *logRecorder) records(t *testing.T) []map[string]interface{} {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var recs []map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(r.buf.Bytes()))
+	for dec.More() {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// waitRecord waits for a record with the given message to be logged.
+func (r *logRecorder) waitRecord(t *testing.T, msg string) map[string]interface{} {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, rec := range r.records(t) {
+			if rec["msg"] == msg {
+				return rec
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no %q record logged", msg)
+	return nil
+}
+
+func newRecordedServer(t *testing.T) (*logRecorder, *httptest.Server) {
+	t.Helper()
+
+	rec := &logRecorder{}
+	srv, err := NewServer(WithLogger(slog.New(slog.NewJSONHandler(rec, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+
+	ts := httptest.NewServer(srv.Ws())
+	t.Cleanup(ts.Close)
+	return rec, ts
+}
+
+func TestWithLoggerConnection(t *testing.T) {
+	t.Parallel()
+
+	rec, ts := newRecordedServer(t)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := ws.LocalAddr().String()
+
+	connected := rec.waitRecord(t, "statsviz: websocket client connected")
+	if connected["level"] != "INFO" || connected["remote_addr"] != local || connected["root"] != defaultRoot {
+		t.Errorf("got connect record %v, want level INFO, remote_addr %s and root %s", connected, local, defaultRoot)
+	}
+
+	ws.Close()
+	disconnected := rec.waitRecord(t, "statsviz: websocket client disconnected")
+	if disconnected["remote_addr"] != local {
+		t.Errorf("got disconnect record %v, want remote_addr %s", disconnected, local)
+	}
+}
+
+func TestWithLoggerUpgradeFailure(t *testing.T) {
+	t.Parallel()
+
+	rec, ts := newRecordedServer(t)
+
+	// Not a websocket request.
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	failed := rec.waitRecord(t, "statsviz: websocket upgrade failed")
+	if failed["level"] != "WARN" || failed["error"] == nil {
+		t.Errorf("got upgrade failure record %v, want level WARN and an error", failed)
+	}
+}
+
+func TestWithLoggerNil(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	if _, ok := srv.logger.(nopLogger); !ok {
+		t.Errorf("got logger %T, want nopLogger", srv.logger)
+	}
+}
@@ -0,0 +1,95 @@
+package statsviz
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// SlowClientPolicy defines what happens to the frames sent to a client which
+// doesn't receive them as fast as they're collected, once its buffer, sized
+// by WithClientBuffer, is full.
+type SlowClientPolicy string
+
+const (
+	// DropNewest skips the new frame, the client receives the frames already
+	// buffered. This is the default.
+	DropNewest SlowClientPolicy = "drop-newest"
+
+	// DropOldest evicts the oldest buffered frame to make room for the new
+	// one, so that the client receives the latest stats first.
+	DropOldest SlowClientPolicy = "drop-oldest"
+
+	// Disconnect closes the connection of the slow client. The user interface
+	// reconnects, receiving the stats it missed if the server keeps history.
+	Disconnect SlowClientPolicy = "disconnect"
+)
+
+// errSlowClient is returned by sendStats when the client is disconnected by
+// the Disconnect policy.
+var errSlowClient = errors.New("statsviz: slow client disconnected")
+
+// WithSlowClientPolicy sets the policy applied to clients too slow to receive
+// the frames as fast as they're collected. Whatever the policy, a slow client
+// never delays the collection of stats, nor the other clients.
+func WithSlowClientPolicy(policy SlowClientPolicy) OptionFunc {
+	return func(s *Server) error {
+		switch policy {
+		case DropNewest, DropOldest, Disconnect:
+		default:
+			return configError("WithSlowClientPolicy", "unknown slow client policy %q", policy)
+		}
+		s.slowClients = policy
+		return nil
+	}
+}
+
+// WithClientBuffer sets the number of frames buffered for each client, 4 by
+// default. A larger buffer absorbs longer client stalls, at the cost of
+// memory, before the slow client policy applies.
+func WithClientBuffer(n int) OptionFunc {
+	return func(s *Server) error {
+		if n <= 0 {
+			return configError("WithClientBuffer", "client buffer must be positive, got %d", n)
+		}
+		s.clientBuffer = n
+		return nil
+	}
+}
+
+// offer sends f to sub, applying the subscriber slow client policy if its
+// buffer is full. Must be called with s.hubsMu held.
+func (h *hub) offer(sub *subscriber, f *frame) {
+	atomic.AddInt32(&f.refs, 1)
+	select {
+	case sub.ch <- f:
+		return
+	default:
+	}
+
+	switch sub.policy {
+	case DropOldest:
+		// Only the subscriber concurrently receives from its channel, so
+		// there's room for f once a frame has been evicted, unless the
+		// subscriber received it in the meantime, in which case there's room
+		// too.
+		select {
+		case old := <-sub.ch:
+			old.release()
+			h.sendFull()
+		default:
+		}
+		select {
+		case sub.ch <- f:
+			return
+		default:
+		}
+	case Disconnect:
+		h.s.logger.Warn("statsviz: disconnecting slow client", "buffered", len(sub.ch))
+		h.remove(sub)
+		close(sub.ch)
+	}
+	atomic.AddInt32(&f.refs, -1)
+	// The subscriber missed a frame, which may have held changed metrics
+	// values, see WithDeltaFrames.
+	h.sendFull()
+}
@@ -0,0 +1,141 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// slowSubscriber returns a test hub with a subscriber that never drains its
+// frames, with the server slow client policy and buffer.
+func slowSubscriber(t *testing.T, opts ...OptionFunc) (*hub, *sampler, *subscriber) {
+	t.Helper()
+
+	h, smp := newTestHub(t, opts...)
+	h.quit = make(chan struct{})
+	h.s.hubs[h.freq] = h
+	sub := &subscriber{ch: make(chan *frame, h.s.clientBuffer), policy: h.s.slowClients}
+	h.s.hubsMu.Lock()
+	h.subs[sub] = struct{}{}
+	h.s.hubsMu.Unlock()
+	return h, smp, sub
+}
+
+// bufferedSeqs drains ch and returns the sequence numbers of its frames.
+func bufferedSeqs(t *testing.T, ch chan *frame) []uint64 {
+	t.Helper()
+
+	var seqs []uint64
+	for {
+		select {
+		case f, ok := <-ch:
+			if !ok {
+				return seqs
+			}
+			var st struct{ Seq uint64 }
+			if err := json.Unmarshal(f.bytes(), &st); err != nil {
+				t.Fatal(err)
+			}
+			f.release()
+			seqs = append(seqs, st.Seq)
+		default:
+			return seqs
+		}
+	}
+}
+
+func TestSlowClientPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		policy SlowClientPolicy
+		want   []uint64 // buffered seqs after 5 ticks, relative to the first
+	}{
+		{DropNewest, []uint64{0, 1, 2}},
+		{DropOldest, []uint64{2, 3, 4}},
+		{Disconnect, []uint64{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.policy), func(t *testing.T) {
+			t.Parallel()
+
+			h, smp, sub := slowSubscriber(t, WithSlowClientPolicy(tt.policy), WithClientBuffer(3))
+			for i := 0; i < 5; i++ {
+				h.tick(smp)
+			}
+
+			h.s.hubsMu.Lock()
+			_, subscribed := h.subs[sub]
+			h.s.hubsMu.Unlock()
+			if want := tt.policy != Disconnect; subscribed != want {
+				t.Errorf("got subscribed %t, want %t", subscribed, want)
+			}
+
+			seqs := bufferedSeqs(t, sub.ch)
+			if len(seqs) != len(tt.want) {
+				t.Fatalf("got buffered frames %v, want %d frames", seqs, len(tt.want))
+			}
+			for i := range seqs {
+				if got := seqs[i] - seqs[0] + tt.want[0]; got != tt.want[i] {
+					t.Errorf("got buffered frames %v, want %v relative to the first", seqs, tt.want)
+					break
+				}
+			}
+			if tt.policy == Disconnect {
+				if _, ok := <-sub.ch; ok {
+					t.Errorf("got open channel, want the slow client channel closed")
+				}
+				select {
+				case <-h.quit:
+				default:
+					t.Errorf("hub still running without subscribers")
+				}
+			}
+		})
+	}
+}
+
+func TestSlowClientDisconnect(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(time.Millisecond), WithSlowClientPolicy(Disconnect), WithClientBuffer(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// The first send blocks until the client buffer overflows, which closes
+	// the client channel.
+	blocked := true
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.sendStats(nil, srv.freq, 1, nil, nil, func([]byte, bool) error {
+			if blocked {
+				blocked = false
+				time.Sleep(50 * time.Millisecond)
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-errc:
+		if err != errSlowClient {
+			t.Errorf("got error %v, want %v", err, errSlowClient)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the slow client to be disconnected")
+	}
+}
+
+func TestSlowClientOptions(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithSlowClientPolicy("block")); err == nil {
+		t.Errorf("got nil error for an unknown policy, want non-nil")
+	}
+	if _, err := NewServer(WithClientBuffer(0)); err == nil {
+		t.Errorf("got nil error for an empty client buffer, want non-nil")
+	}
+}
@@ -0,0 +1,77 @@
+package statsviz
+
+import (
+	"fmt"
+	"math"
+)
+
+// A Smoothing smooths the plotted values of a time series, which is useful
+// for jittery values, such as rates. The zero Smoothing plots the values as
+// they are. See MovingAverage.
+type Smoothing struct {
+	window int // number of averaged samples, 0 or 1 for none
+}
+
+// MovingAverage returns a Smoothing plotting the average of the last window
+// values of a series, once transformed, rather than the last value only, see
+// TimeSeries.Transform. Until window values have been collected, the
+// available ones are averaged. NaN values, such as the first one of a Rate,
+// are left out of the average.
+//
+// The average is computed by the server, over the stats it collects. To also
+// plot the raw values, add another series with the same Value and no
+// Smoothing.
+func MovingAverage(window int) Smoothing {
+	return Smoothing{window: window}
+}
+
+func (sm Smoothing) check() error {
+	if sm.window < 0 {
+		return fmt.Errorf("moving average window must be positive, got %d", sm.window)
+	}
+	return nil
+}
+
+// A movingAverage holds the last values of a series, see MovingAverage.
+type movingAverage struct {
+	vals []float64 // ring of the last values
+	next int       // index of the next value in vals
+	n    int       // number of values in vals
+}
+
+// update records v and returns the average of the last values.
+func (ma *movingAverage) update(v float64) float64 {
+	ma.vals[ma.next] = v
+	ma.next = (ma.next + 1) % len(ma.vals)
+	if ma.n < len(ma.vals) {
+		ma.n++
+	}
+
+	sum, n := 0.0, 0
+	for _, v := range ma.vals[:ma.n] {
+		if !math.IsNaN(v) {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return sum / float64(n)
+}
+
+// smooth applies sm to v, the last value of the series identified by key.
+func (s *sampler) smooth(key string, sm Smoothing, v float64) float64 {
+	if sm.window <= 1 {
+		return v
+	}
+	if s.averages == nil {
+		s.averages = make(map[string]*movingAverage)
+	}
+	ma, ok := s.averages[key]
+	if !ok {
+		ma = &movingAverage{vals: make([]float64, sm.window)}
+		s.averages[key] = ma
+	}
+	return ma.update(v)
+}
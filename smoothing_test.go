@@ -0,0 +1,61 @@
+package statsviz
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMovingAverage(t *testing.T) {
+	t.Parallel()
+
+	noisy := []float64{10, 2, 9, 1, 8, 3}
+	i := 0
+	up := newUserPlot(TimeSeriesPlot{
+		Name: "noisy",
+		Series: []TimeSeries{
+			{Name: "smoothed", Value: func() float64 { return noisy[i] }, Smoothing: MovingAverage(3)},
+			{Name: "raw", Value: func() float64 { return noisy[i] }},
+		},
+	})
+	// Warm-up averages the available values, then the last 3.
+	want := []float64{10, 6, 7, 4, 6, 4}
+
+	smp := newSamplerOf([]string{})
+	var vals plotValues
+	for i = range noisy {
+		vals = up.sample(smp, vals)
+		if math.Abs(vals[0]-want[i]) > 1e-9 {
+			t.Errorf("sample %d: got %v, want %v", i, vals[0], want[i])
+		}
+		if vals[1] != noisy[i] {
+			t.Errorf("sample %d: got raw %v, want %v", i, vals[1], noisy[i])
+		}
+	}
+}
+
+func TestMovingAverageNaN(t *testing.T) {
+	t.Parallel()
+
+	ma := &movingAverage{vals: make([]float64, 2)}
+	if got := ma.update(math.NaN()); !math.IsNaN(got) {
+		t.Errorf("got %v, want NaN without values", got)
+	}
+	if got := ma.update(4); got != 4 {
+		t.Errorf("got %v, want NaN left out of the average", got)
+	}
+	if got := ma.update(2); got != 3 {
+		t.Errorf("got %v, want 3", got)
+	}
+}
+
+func TestMovingAverageInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewServer(WithPlot(TimeSeriesPlot{
+		Name:   "plot",
+		Series: []TimeSeries{{Name: "s", Value: func() float64 { return 0 }, Smoothing: MovingAverage(-1)}},
+	}))
+	if err == nil {
+		t.Errorf("got nil error for a negative window, want non-nil")
+	}
+}
@@ -1,7 +1,13 @@
 package statsviz
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -9,23 +15,577 @@ import (
 
 type stats struct {
 	GoVersion    string
-	Mem          runtime.MemStats
-	NumGoroutine int
+	Seq          uint64 // increases with each collected stats, see sendMissed
+	Time         time.Time
+	Historical   bool                  `json:",omitempty"`
+	Truncated    bool                  `json:",omitempty"` // user plots dropped, see WithMaxFrameBytes
+	Mem          *runtime.MemStats     `json:",omitempty"`
+	NumGoroutine int                   `json:",omitempty"`
+	MemoryLimit  int64                 `json:",omitempty"` // 0 if there's no limit
+	SinceLastGC  float64               `json:",omitempty"` // in seconds, 0 before the first GC
+	GC           *gcEvent              `json:",omitempty"` // collections since the previous stats
+	Uptime       float64               `json:",omitempty"` // in seconds, since the process started
+	Interval     float64               `json:",omitempty"` // in seconds, see WithAdaptiveFrequency
+	Metrics      metricValues          `json:",omitempty"`
+	MetricValues compactValues         `json:",omitempty"` // replaces Metrics, see WithCompactMetrics
+	UserMetrics  metricValues          `json:",omitempty"`
+	UserPlots    map[string]plotValues `json:",omitempty"`
+	RuntimePlots map[string]plotValues `json:",omitempty"`
+
+	// Summary holds the summaries of the values of Metrics and UserMetrics
+	// in history, indexed by metric name. It's only set on live stats.
+	Summary map[string]summary `json:",omitempty"`
+
+	// TopAllocators holds the last top allocators snapshot, see
+	// WithTopAllocators. It's only set on live stats.
+	TopAllocators []allocSite `json:",omitempty"`
+}
+
+func newStats() stats {
+	return stats{GoVersion: runtime.Version()}
+}
+
+// clone returns a deep copy of stats.
+func (st *stats) clone() stats {
+	c := *st
+	if st.Mem != nil {
+		mem := *st.Mem
+		c.Mem = &mem
+	}
+	c.Metrics = cloneFloats(st.Metrics)
+	c.UserMetrics = cloneFloats(st.UserMetrics)
+	c.UserPlots = clonePlotValues(st.UserPlots)
+	c.RuntimePlots = clonePlotValues(st.RuntimePlots)
+	if st.Summary != nil {
+		c.Summary = make(map[string]summary, len(st.Summary))
+		for k, v := range st.Summary {
+			c.Summary[k] = v
+		}
+	}
+	return c
+}
+
+// metricValues holds the values of metrics, indexed by metric name.
+type metricValues map[string]float64
+
+// MarshalJSON encodes the values into a JSON object, NaN and infinite values,
+// which have no JSON representation, being encoded as null.
+func (vals metricValues) MarshalJSON() ([]byte, error) {
+	for _, v := range vals {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return json.Marshal(vals.nullable())
+		}
+	}
+	return json.Marshal(map[string]float64(vals))
+}
+
+// nullable returns the values as pointers, nil for NaN and infinite values.
+func (vals metricValues) nullable() map[string]*float64 {
+	ptrs := make(map[string]*float64, len(vals))
+	for name, v := range vals {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			ptrs[name] = nil
+			continue
+		}
+		v := v
+		ptrs[name] = &v
+	}
+	return ptrs
 }
 
-// sendStats indefinitely send runtime statistics on the websocket connection.
-func sendStats(conn *websocket.Conn, frequency time.Duration) error {
-	tick := time.NewTicker(frequency)
-	defer tick.Stop()
+// UnmarshalJSON decodes a JSON object into vals, null values being decoded as
+// NaN.
+func (vals *metricValues) UnmarshalJSON(buf []byte) error {
+	var ptrs map[string]*float64
+	if err := json.Unmarshal(buf, &ptrs); err != nil {
+		return err
+	}
+	if ptrs == nil {
+		*vals = nil
+		return nil
+	}
+	*vals = make(metricValues, len(ptrs))
+	for name, p := range ptrs {
+		if p == nil {
+			(*vals)[name] = math.NaN()
+			continue
+		}
+		(*vals)[name] = *p
+	}
+	return nil
+}
 
-	stats := stats{GoVersion: runtime.Version()}
-	for range tick.C {
-		runtime.ReadMemStats(&stats.Mem)
+func cloneFloats(m map[string]float64) map[string]float64 {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]float64, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func clonePlotValues(m map[string]plotValues) map[string]plotValues {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]plotValues, len(m))
+	for k, v := range m {
+		c[k] = append(plotValues(nil), v...)
+	}
+	return c
+}
+
+// collect reads the runtime statistics needed by the enabled plots, user
+// metrics, user plots and runtime plots values into stats, using smp to read
+// runtime metrics.
+func (s *Server) collect(smp *sampler, stats *stats) {
+	stats.Time = s.clock.Now()
+	if s.needsMemStats() {
+		if stats.Mem == nil {
+			stats.Mem = new(runtime.MemStats)
+		}
+		runtime.ReadMemStats(stats.Mem)
+	}
+	if s.enabled(PlotGoroutines) {
 		stats.NumGoroutine = runtime.NumGoroutine()
-		if err := conn.WriteJSON(stats); err != nil {
+	}
+	if s.enabled(PlotHeap) {
+		stats.MemoryLimit = memoryLimit()
+	}
+	smp.read(stats.Time)
+	stats.SinceLastGC = smp.sinceLastGC(stats.Time)
+	stats.GC = smp.gcEvent(stats.Time, smp.gcCycles())
+	stats.Uptime = time.Since(processStart).Seconds()
+
+	// Maps and slices are reused if stats is, to limit allocations.
+	stats.Metrics = smp.scalars(stats.Metrics)
+	stats.UserMetrics = readUserMetrics(stats.UserMetrics)
+	if len(s.expvars) != 0 {
+		stats.UserMetrics = s.readExpvars(stats.UserMetrics)
+	}
+
+	if plots := s.plots(); len(plots) != 0 || stats.UserPlots != nil {
+		if stats.UserPlots == nil {
+			stats.UserPlots = make(map[string]plotValues, len(plots))
+		}
+		for _, p := range plots {
+			stats.UserPlots[p.Name] = p.sample(smp, stats.UserPlots[p.Name])
+		}
+		// Drop the values of removed plots.
+		if len(stats.UserPlots) != len(plots) {
+			for name := range stats.UserPlots {
+				if !hasPlot(plots, name) {
+					delete(stats.UserPlots, name)
+				}
+			}
+		}
+	}
+
+	if len(s.runtimePlots) != 0 {
+		if stats.RuntimePlots == nil {
+			stats.RuntimePlots = make(map[string]plotValues, len(s.runtimePlots))
+		}
+		histograms := s.sampleHistograms(smp, stats.Time)
+		for i := range s.runtimePlots {
+			p := &s.runtimePlots[i]
+			if p.heatmap != nil && !histograms {
+				delete(stats.RuntimePlots, p.name)
+				continue
+			}
+			stats.RuntimePlots[p.name] = p.sample(smp, stats.RuntimePlots[p.name])
+		}
+	}
+}
+
+// hasPlot reports whether one of plots is named name.
+func hasPlot(plots []*userPlot, name string) bool {
+	for _, p := range plots {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalStats encodes stats with the server encoding.
+func (s *Server) marshalStats(st *stats) ([]byte, error) {
+	if s.encoding == EncodingProtobuf {
+		return s.appendProtobuf(nil, st, nil), nil
+	}
+	if s.precision > 0 && s.encoding == EncodingJSON {
+		c := s.roundStats(st, nil, nil)
+		st = &c
+	}
+	if s.compact {
+		c := s.compactStats(st, nil)
+		st = &c
+	}
+	if s.encoding == EncodingMsgpack {
+		return appendMsgpack(nil, st)
+	}
+	return json.Marshal(st)
+}
+
+// minSendFrequency is the lowest frequency a client can request.
+const minSendFrequency = 50 * time.Millisecond
+
+// A controlMsg is a control message exchanged between the user interface and
+// the server, as opposed to stats messages.
+type controlMsg struct {
+	Type   string `json:"type"`
+	Millis int64  `json:"millis,omitempty"`
+	Freed  uint64 `json:"freed,omitempty"` // heap bytes freed by a forced GC
+	Since  uint64 `json:"since,omitempty"` // sequence number to resume after
+
+	Added   []TimeSeriesPlot `json:"added,omitempty"`   // user plots added
+	Removed []string         `json:"removed,omitempty"` // user plots removed
+	Rates   *profileRates    `json:"rates,omitempty"`   // see WithProfileControls
+	Reason  string           `json:"reason,omitempty"`  // why plots are frozen
+	Error   string           `json:"error,omitempty"`
+}
+
+// sendStats first sends the stats kept in history, if any, then sends the
+// stats periodically collected by the server, until send returns an error,
+// done is closed or the server is stopped, in which case the last frame,
+// collected once stopped, is sent before returning. Messages are passed to send
+// encoded, binary is set for MessagePack encoded stats, other messages are
+// JSON encoded.
+//
+// If resumec is not nil, the client is reconnecting: rather than the whole
+// history, sendStats first waits for the sequence number of the last stats
+// the client received on resumec, and only sends the stats it missed, see
+// sendMissed.
+//
+// Stats are first sent at freq. Frequency change requests received on freqc
+// are acknowledged by sending a controlMsg, carrying the frequency in use,
+// which is also sent first if freq isn't the server frequency.
+//
+// If batch is more than 1, stats messages are sent by batches of batch
+// messages, see WithBatchSize. The stats of a replay are sent instead, if the
+// server replays a recording, see ServeReplay.
+func (s *Server) sendStats(done <-chan struct{}, freq time.Duration, batch int, freqc <-chan time.Duration, resumec <-chan uint64, send func(msg []byte, binary bool) error) error {
+	if s.replay != nil {
+		return s.sendReplay(done, freqc, send)
+	}
+
+	b := &batcher{send: send, size: batch}
+	// Control messages are sent right away, after the pending batch.
+	sendControl := func(msg controlMsg) error {
+		buf, err := json.Marshal(msg)
+		if err != nil {
 			return err
 		}
+		if err := b.flush(); err != nil {
+			return err
+		}
+		return send(buf, false)
 	}
 
-	panic("unreachable")
+	if resumec == nil {
+		if s.history != nil {
+			if err := s.sendHistory(s.history.snapshot(), b.add); err != nil {
+				return err
+			}
+		}
+	} else {
+		select {
+		case <-done:
+			return nil
+		case <-s.done:
+			return nil
+		case seq := <-resumec:
+			if err := s.sendMissed(seq, b.add); err != nil {
+				return err
+			}
+		}
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	if freq != s.freq {
+		if err := sendControl(controlMsg{Type: "setFrequency", Millis: freq.Milliseconds()}); err != nil {
+			return err
+		}
+	}
+	frames, unsubscribe := s.subscribe(freq)
+	defer func() { unsubscribe() }()
+
+	for {
+		select {
+		case <-done:
+			// Try to send the pending batch, the client may still receive
+			// it.
+			b.flush()
+			return nil
+		case <-s.done:
+			if err := s.sendLast(frames, b.add); err != nil {
+				return err
+			}
+			return b.flush()
+		case req := <-freqc:
+			ack := controlMsg{Type: "setFrequency"}
+			if req < minSendFrequency {
+				ack.Error = fmt.Sprintf("frequency must be at least %v", minSendFrequency)
+			} else if req != freq {
+				unsubscribe()
+				freq = req
+				frames, unsubscribe = s.subscribe(freq)
+			}
+			ack.Millis = freq.Milliseconds()
+			if err := sendControl(ack); err != nil {
+				return err
+			}
+		case f, ok := <-frames:
+			if !ok {
+				if s.isStopped() {
+					// The last frame has been received.
+					return b.flush()
+				}
+				b.flush()
+				return errSlowClient
+			}
+			err := b.add(f.bytes(), f.binary)
+			if err == nil {
+				s.counters.sent(len(f.bytes()))
+			}
+			f.release()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendLast sends the frames collected until the server stopped, the last one
+// being collected once stopped, see hub.stop.
+func (s *Server) sendLast(frames <-chan *frame, send func(msg []byte, binary bool) error) error {
+	var err error
+	for f := range frames {
+		if err == nil {
+			err = send(f.bytes(), f.binary)
+		}
+		f.release()
+	}
+	return err
+}
+
+func (s *Server) isStopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendHistory sends historical stats, decimated if WithDecimation is set.
+func (s *Server) sendHistory(all []stats, send func(msg []byte, binary bool) error) error {
+	if s.maxPoints > 0 {
+		all = decimate(all, s.maxPoints)
+	}
+	for _, st := range all {
+		st.Historical = true
+		buf, err := s.marshalStats(&st)
+		if err != nil {
+			return err
+		}
+		if err := send(buf, s.encoding.binary()); err != nil {
+			return err
+		}
+		s.counters.sent(len(buf))
+	}
+	return nil
+}
+
+// sendMissed sends the stats kept in history collected after the stats with
+// sequence number seq, which a reconnecting client received last. If some of
+// them are not in history anymore, or seq is unknown, for example because the
+// server restarted, the client is told to reset its plots, and the whole
+// history is sent. Without history, nothing is sent, the client plots just
+// have a gap.
+func (s *Server) sendMissed(seq uint64, send func(msg []byte, binary bool) error) error {
+	if s.history == nil {
+		return nil
+	}
+	if seq <= atomic.LoadUint64(&s.seq) {
+		if missed, ok := s.history.after(seq); ok {
+			return s.sendHistory(missed, send)
+		}
+	}
+
+	buf, err := json.Marshal(controlMsg{Type: "reset"})
+	if err != nil {
+		return err
+	}
+	if err := send(buf, false); err != nil {
+		return err
+	}
+	return s.sendHistory(s.history.snapshot(), send)
+}
+
+// readControls reads control messages sent by the client on the websocket
+// connection, until the connection is closed, in which case closed is closed.
+// Frequency change requests are forwarded to freqc, resume requests to
+// resumec, if the client hasn't already sent one, reset requests call reset
+// and forceGC requests are answered with the reply of forceGC. Pings are
+// answered with pongs written by w, the connection writer.
+//
+// If pongWait is not zero, the connection is considered dead, and
+// readControls returns, if no pong is received within pongWait.
+func readControls(conn *websocket.Conn, w *wsWriter, pongWait time.Duration, freqc chan<- time.Duration, resumec chan<- uint64, reset func(), request func(controlMsg) controlMsg, stop <-chan struct{}, closed chan<- struct{}) {
+	defer close(closed)
+
+	if pongWait > 0 {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+	}
+
+	conn.SetPingHandler(func(data string) error {
+		// Like the default ping handler, ignore write errors, which are
+		// reported to the stats sender anyway.
+		_ = w.write(websocket.PongMessage, []byte(data))
+		return nil
+	})
+
+	for {
+		var msg controlMsg
+		if err := conn.ReadJSON(&msg); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				continue
+			}
+			return
+		}
+
+		switch msg.Type {
+		case "setFrequency":
+			select {
+			case freqc <- time.Duration(msg.Millis) * time.Millisecond:
+			case <-stop:
+				return
+			}
+		case "resume":
+			// resumec is buffered, only the first request is used.
+			select {
+			case resumec <- msg.Since:
+			default:
+			}
+		case "reset":
+			reset()
+		case "forceGC", "setProfileRates":
+			buf, err := json.Marshal(request(msg))
+			if err != nil {
+				return
+			}
+			// As for pongs, write errors are reported to the stats sender.
+			_ = w.write(websocket.TextMessage, buf)
+		}
+	}
+}
+
+// handleRequest handles the control messages expecting a reply, and returns
+// the reply.
+func (s *Server) handleRequest(msg controlMsg) controlMsg {
+	if msg.Type == "setProfileRates" {
+		return s.setProfileRates(msg.Rates)
+	}
+	return s.runGC()
+}
+
+// drainTimeout is the time given to websocket clients, once the server is
+// stopped, to receive the last frame and the close frame.
+const drainTimeout = time.Second
+
+// shutdownReason is the reason of the close frame sent to websocket clients
+// once the server is stopped.
+const shutdownReason = "server shutting down"
+
+// sendStatsWs indefinitely send runtime statistics on the websocket
+// connection, while handling control messages sent by the client. All writes
+// go through a single wsWriter. If keepalive is enabled, the connection is
+// closed if the client stops answering pings. If resume is set, the client is
+// reconnecting and sends a resume request first. Stats are first sent at
+// freq, see sendStats.
+func (s *Server) sendStatsWs(conn *websocket.Conn, freq time.Duration, resume bool) error {
+	s.connected()
+	defer s.disconnected()
+
+	stop := make(chan struct{})
+	closed := make(chan struct{})
+	freqc := make(chan time.Duration)
+	resumec := make(chan uint64, 1)
+
+	var pongWait time.Duration
+	if s.pingInterval > 0 {
+		pongWait = s.pingInterval + s.pongTimeout
+	}
+	w := newWsWriter(conn, s.pingInterval, s.writeTimeout)
+	go readControls(conn, w, pongWait, freqc, resumec, s.ResetHistory, s.handleRequest, stop, closed)
+
+	// Once the server is stopped, the client has drainTimeout to receive the
+	// last frame and the close frame, after which the connection is closed
+	// anyway so that a stuck client doesn't block Stop.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-finished:
+			return
+		case <-s.done:
+		}
+		t := time.NewTimer(drainTimeout)
+		defer t.Stop()
+		select {
+		case <-finished:
+		case <-t.C:
+			conn.Close()
+		}
+	}()
+
+	var waitResume <-chan uint64
+	if resume {
+		waitResume = resumec
+	}
+	err := s.sendStats(closed, freq, s.batchSize, freqc, waitResume, func(msg []byte, binary bool) error {
+		if binary {
+			return w.write(websocket.BinaryMessage, msg)
+		}
+		return w.write(websocket.TextMessage, msg)
+	})
+
+	close(stop)
+	if err == nil && s.isStopped() {
+		// Tell the client why it's disconnected, and wait for it to answer
+		// the close frame, which ends readControls.
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, shutdownReason)
+		if w.write(websocket.CloseMessage, msg) == nil {
+			<-closed
+		}
+	}
+
+	// Closing the connection unblocks both the writer, if it's writing, and
+	// the reader. Then wait for them to return.
+	conn.Close()
+	w.close()
+	<-closed
+	return err
+}
+
+// sendStatsSSE sends runtime statistics as server-sent events until done is
+// closed or a write fails.
+func (s *Server) sendStatsSSE(done <-chan struct{}, w io.Writer, flusher http.Flusher) error {
+	s.connected()
+	defer s.disconnected()
+
+	return s.sendStats(done, s.freq, 1, nil, nil, func(msg []byte, _ bool) error {
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", msg); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
 }
@@ -0,0 +1,11 @@
+module github.com/arl/statsviz/statsvizchi
+
+go 1.16
+
+require (
+	github.com/arl/statsviz v0.4.1
+	github.com/go-chi/chi v1.5.4
+	github.com/gorilla/websocket v1.4.2
+)
+
+replace github.com/arl/statsviz => ../
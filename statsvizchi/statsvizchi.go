@@ -0,0 +1,44 @@
+// Package statsvizchi mounts statsviz on a chi router.
+//
+//	r := chi.NewRouter()
+//	if err := statsvizchi.Mount(r); err != nil {
+//	    log.Fatal(err)
+//	}
+//
+// The statsviz user interface is then served at /debug/statsviz/, unless the
+// statsviz.Root option says otherwise.
+package statsvizchi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"github.com/arl/statsviz"
+)
+
+// Mount registers the statsviz HTTP handlers on the chi router r, under the
+// root path set by the statsviz.Root option, /debug/statsviz by default.
+//
+// Middlewares installed with r.Use also apply to statsviz handlers.
+func Mount(r chi.Router, opts ...statsviz.OptionFunc) error {
+	s, err := statsviz.NewServer(opts...)
+	if err != nil {
+		return err
+	}
+
+	// The statsviz mux dispatches the requests matching the root, which
+	// redirects to the index, and the chi wildcard, which catches the index,
+	// assets, and other endpoints. chi passes the standard http.ResponseWriter
+	// through, which implements http.Hijacker, so that the websocket upgrade
+	// can take place.
+	mux := http.NewServeMux()
+	s.Register(mux)
+
+	root := s.Root()
+	if root != "" {
+		r.Handle(root, mux)
+	}
+	r.Handle(root+"/*", mux)
+	return nil
+}
@@ -0,0 +1,11 @@
+module github.com/arl/statsviz/statsvizecho
+
+go 1.16
+
+require (
+	github.com/arl/statsviz v0.4.1
+	github.com/gorilla/websocket v1.4.2
+	github.com/labstack/echo/v4 v4.6.3
+)
+
+replace github.com/arl/statsviz => ../
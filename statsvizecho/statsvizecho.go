@@ -0,0 +1,40 @@
+// Package statsvizecho mounts statsviz on an echo router.
+//
+//	e := echo.New()
+//	if err := statsvizecho.Register(e); err != nil {
+//	    log.Fatal(err)
+//	}
+//
+// The statsviz user interface is then served at /debug/statsviz/, unless the
+// statsviz.Root option says otherwise.
+package statsvizecho
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/arl/statsviz"
+)
+
+// Register registers the statsviz HTTP handlers on the echo router e, under
+// the root path set by the statsviz.Root option, /debug/statsviz by default.
+//
+// Middlewares installed with e.Use also apply to statsviz handlers.
+func Register(e *echo.Echo, opts ...statsviz.OptionFunc) error {
+	s, err := statsviz.NewServer(opts...)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	s.Register(mux)
+
+	// echo.WrapHandler passes the underlying http.ResponseWriter, which
+	// implements http.Hijacker, and http.Request to mux, so that the websocket
+	// upgrade can take place.
+	root := strings.TrimRight(s.Root(), "/")
+	e.Any(root+"/*", echo.WrapHandler(mux))
+	return nil
+}
@@ -0,0 +1,107 @@
+package statsvizecho
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"github.com/arl/statsviz"
+)
+
+func newEcho(t *testing.T, opts ...statsviz.OptionFunc) *echo.Echo {
+	t.Helper()
+
+	e := echo.New()
+	e.Logger.SetOutput(ioutil.Discard)
+
+	// Check statsviz works with common middlewares installed.
+	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{Output: ioutil.Discard}))
+	e.Use(middleware.Recover())
+	if err := Register(e, opts...); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	e := newEcho(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/statsviz/", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "statsviz") {
+		t.Errorf("body doesn't contain 'statsviz'")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	t.Parallel()
+
+	e := newEcho(t, statsviz.Root("/foo/bar"), statsviz.SendFrequency(50*time.Millisecond))
+
+	req := httptest.NewRequest(http.MethodGet, "/foo/bar/handshake", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var hs struct {
+		Transport string
+		Millis    int64
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&hs); err != nil {
+		t.Fatal(err)
+	}
+	if hs.Transport != "websocket" {
+		t.Errorf("transport: got %q, want %q", hs.Transport, "websocket")
+	}
+	if hs.Millis != 50 {
+		t.Errorf("millis: got %d, want %d", hs.Millis, 50)
+	}
+}
+
+func TestWs(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(newEcho(t, statsviz.SendFrequency(50*time.Millisecond)))
+	defer ts.Close()
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/debug/statsviz/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+	if err != nil {
+		t.Fatalf("websocket handshake: %v", err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var stats map[string]interface{}
+	if err := ws.ReadJSON(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["Mem"]; !ok {
+		t.Errorf("stats message doesn't contain 'Mem'")
+	}
+}
+
+func TestRegisterError(t *testing.T) {
+	t.Parallel()
+
+	if err := Register(echo.New(), statsviz.SendFrequency(-1)); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+}
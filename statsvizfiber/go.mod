@@ -0,0 +1,12 @@
+module github.com/arl/statsviz/statsvizfiber
+
+go 1.16
+
+require (
+	github.com/arl/statsviz v0.4.1
+	github.com/gofiber/adaptor/v2 v2.1.18
+	github.com/gofiber/fiber/v2 v2.27.0
+	github.com/gorilla/websocket v1.4.2
+)
+
+replace github.com/arl/statsviz => ../
@@ -0,0 +1,153 @@
+// Package statsvizfiber mounts statsviz on a fiber application.
+//
+//	app := fiber.New()
+//	if err := statsvizfiber.Register(app); err != nil {
+//	    log.Fatal(err)
+//	}
+//
+// The statsviz user interface is then served at /debug/statsviz/, unless the
+// statsviz.Root option says otherwise.
+//
+// Fiber is built on fasthttp, not net/http. Statsviz HTTP handlers are bridged
+// with the fiber adaptor package, but that can't be done for the websocket
+// endpoint, which needs to take over the connection. Like fiber's websocket
+// middleware, Register hijacks the fasthttp connection of websocket upgrade
+// requests, which are then handled by statsviz. Fiber middlewares run before
+// the upgrade, but they don't see the upgrade response.
+package statsvizfiber
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/adaptor/v2"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/arl/statsviz"
+)
+
+// Register registers the statsviz HTTP handlers on the fiber application app,
+// under the root path set by the statsviz.Root option, /debug/statsviz by
+// default.
+//
+// Middlewares installed with app.Use also apply to statsviz handlers.
+func Register(app *fiber.App, opts ...statsviz.OptionFunc) error {
+	s, err := statsviz.NewServer(opts...)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	s.Register(mux)
+	handler := adaptor.HTTPHandler(mux)
+
+	root := strings.TrimRight(s.Root(), "/")
+	app.All(root+"/*", func(c *fiber.Ctx) error {
+		if !isUpgrade(c) {
+			return handler(c)
+		}
+		return hijack(c, mux)
+	})
+	return nil
+}
+
+// isUpgrade reports whether c holds a websocket upgrade request.
+func isUpgrade(c *fiber.Ctx) bool {
+	return strings.EqualFold(c.Get(fiber.HeaderUpgrade), "websocket")
+}
+
+// hijack takes over the connection of c once fiber is done with it, and
+// serves the request with h on it, so that h can upgrade the connection to
+// the websocket protocol.
+func hijack(c *fiber.Ctx, h http.Handler) error {
+	// The fasthttp request can't be used once the connection is hijacked.
+	r, err := newRequest(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Context()
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(conn net.Conn) {
+		// Clear the deadline set by fasthttp to read the request, statsviz
+		// sets its own.
+		conn.SetDeadline(time.Time{})
+
+		w := &hijackWriter{conn: conn, header: make(http.Header)}
+		h.ServeHTTP(w, r)
+		if !w.hijacked {
+			// The connection wasn't upgraded, for example because the request
+			// isn't authorized, write the response.
+			w.writeResponse()
+		}
+	})
+	return nil
+}
+
+// newRequest returns a net/http request with a copy of the fasthttp request of
+// c, which has no body.
+func newRequest(c *fiber.Ctx) (*http.Request, error) {
+	req := c.Request()
+	r, err := http.NewRequest(string(req.Header.Method()), string(req.RequestURI()), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.VisitAll(func(k, v []byte) {
+		r.Header.Add(string(k), string(v))
+	})
+	r.Host = string(req.Host())
+	r.RequestURI = string(req.RequestURI())
+	r.RemoteAddr = c.Context().RemoteAddr().String()
+	return r, nil
+}
+
+// hijackWriter is the http.ResponseWriter of a request served on a hijacked
+// connection. It implements http.Hijacker, for websocket upgrades. Otherwise,
+// the response is buffered until writeResponse is called.
+type hijackWriter struct {
+	conn     net.Conn
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	hijacked bool
+}
+
+func (w *hijackWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *hijackWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *hijackWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// writeResponse writes the buffered response to the connection.
+func (w *hijackWriter) writeResponse() {
+	w.WriteHeader(http.StatusOK)
+	resp := &http.Response{
+		StatusCode:    w.status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          ioutil.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+		Close:         true,
+	}
+	resp.Write(w.conn)
+}
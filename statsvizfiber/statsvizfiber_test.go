@@ -0,0 +1,98 @@
+package statsvizfiber
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gorilla/websocket"
+
+	"github.com/arl/statsviz"
+)
+
+// startFiber serves a fiber application, with statsviz registered, and returns
+// its base URL.
+func startFiber(t *testing.T, opts ...statsviz.OptionFunc) string {
+	t.Helper()
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+
+	// Check statsviz works with common middlewares installed.
+	app.Use(recover.New())
+	if err := Register(app, opts...); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(l)
+	t.Cleanup(func() { app.Shutdown() })
+	return "http://" + l.Addr().String()
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	url := startFiber(t)
+
+	resp, err := http.Get(url + "/debug/statsviz/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if !strings.Contains(string(body), "statsviz") {
+		t.Errorf("body doesn't contain 'statsviz'")
+	}
+}
+
+func TestWs(t *testing.T) {
+	t.Parallel()
+
+	url := startFiber(t, statsviz.Root("/foo/bar"), statsviz.SendFrequency(50*time.Millisecond))
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/foo/bar/ws", nil)
+	if err != nil {
+		t.Fatalf("websocket handshake: %v", err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var stats map[string]interface{}
+	if err := ws.ReadJSON(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["Mem"]; !ok {
+		t.Errorf("stats message doesn't contain 'Mem'")
+	}
+}
+
+func TestWsUnauthorized(t *testing.T) {
+	t.Parallel()
+
+	url := startFiber(t, statsviz.WithBasicAuth("user", "pass"))
+
+	// The response of a rejected upgrade is written on the hijacked
+	// connection.
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/debug/statsviz/ws", nil)
+	if err == nil {
+		t.Fatal("websocket handshake succeeded without credentials")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got response %v, want status %d", resp, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,39 @@
+// Package statsvizgin mounts statsviz on a gin router.
+//
+//	r := gin.Default()
+//	if err := statsvizgin.Register(r); err != nil {
+//	    log.Fatal(err)
+//	}
+//
+// The statsviz user interface is then served at /debug/statsviz/, unless the
+// statsviz.Root option says otherwise.
+package statsvizgin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arl/statsviz"
+)
+
+// Register registers the statsviz HTTP handlers on the gin router r, under the
+// root path set by the statsviz.Root option, /debug/statsviz by default.
+func Register(r *gin.Engine, opts ...statsviz.OptionFunc) error {
+	s, err := statsviz.NewServer(opts...)
+	if err != nil {
+		return err
+	}
+
+	// gin doesn't allow other routes to coexist with a catch-all route on the
+	// same path segment, so a single route dispatches all requests to the
+	// statsviz handlers. gin.Context.Writer implements http.Hijacker, which
+	// the websocket upgrade requires.
+	mux := http.NewServeMux()
+	s.Register(mux)
+
+	root := strings.TrimRight(s.Root(), "/")
+	r.Any(root+"/*filepath", gin.WrapH(mux))
+	return nil
+}
@@ -0,0 +1,91 @@
+package statsvizgin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/arl/statsviz"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func testRegister(t *testing.T, root string, opts ...statsviz.OptionFunc) {
+	t.Helper()
+
+	r := gin.New()
+	if err := Register(r, opts...); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + root + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("index: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if !strings.Contains(string(body), "statsviz") {
+		t.Errorf("index: body doesn't contain 'statsviz'")
+	}
+
+	URL := "ws" + strings.TrimPrefix(ts.URL, "http") + root + "/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(URL, nil)
+	if err != nil {
+		t.Fatalf("websocket handshake: %v", err)
+	}
+	defer ws.Close()
+
+	// Check the first message is a stats message.
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var stats map[string]interface{}
+	if err := ws.ReadJSON(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["Mem"]; !ok {
+		t.Errorf("stats message doesn't contain 'Mem'")
+	}
+
+	resp, err = http.Get(ts.URL + root + "/handshake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var hs struct{ Transport string }
+	if err := json.NewDecoder(resp.Body).Decode(&hs); err != nil {
+		t.Fatal(err)
+	}
+	if hs.Transport != "websocket" {
+		t.Errorf("handshake transport: got %q, want %q", hs.Transport, "websocket")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		testRegister(t, "/debug/statsviz", statsviz.SendFrequency(50*time.Millisecond))
+	})
+	t.Run("root", func(t *testing.T) {
+		testRegister(t, "/foo/bar", statsviz.Root("/foo/bar"), statsviz.SendFrequency(50*time.Millisecond))
+	})
+}
+
+func TestRegisterError(t *testing.T) {
+	r := gin.New()
+	if err := Register(r, statsviz.SendFrequency(-1)); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+}
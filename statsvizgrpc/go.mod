@@ -0,0 +1,10 @@
+module github.com/arl/statsviz/statsvizgrpc
+
+go 1.16
+
+require (
+	github.com/arl/statsviz v0.4.1
+	google.golang.org/grpc v1.31.0
+)
+
+replace github.com/arl/statsviz => ../
@@ -0,0 +1,219 @@
+// Package statsvizgrpc streams the statsviz frames over gRPC.
+//
+//	gs := grpc.NewServer()
+//	srv, err := statsvizgrpc.RegisterGRPC(gs)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer srv.Stop()
+//
+// RegisterGRPC registers the Statsviz service, described by
+// statsvizgrpc.proto, which StreamMetrics server-streaming RPC sends the
+// stats as Frame messages of statsviz.proto, the schema of the statsviz
+// protobuf encoding. Clients generated from the .proto files receive them as
+// any other message, Go clients may also call StreamMetrics.
+package statsvizgrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/arl/statsviz"
+)
+
+// RegisterGRPC registers the Statsviz service on the gRPC server gs. The
+// frames are collected by a statsviz server configured with opts, for example
+// to set its send frequency, the default frequency of the streams, or its
+// history. The protobuf encoding is always used.
+//
+// RegisterGRPC returns the statsviz server, which also serves the streams.
+// Stop it once gs is stopped, to release its resources.
+func RegisterGRPC(gs *grpc.Server, opts ...statsviz.OptionFunc) (*statsviz.Server, error) {
+	s, err := statsviz.NewServer(append(opts, statsviz.WithEncoding(statsviz.EncodingProtobuf))...)
+	if err != nil {
+		return nil, fmt.Errorf("statsvizgrpc: %v", err)
+	}
+	gs.RegisterService(&serviceDesc, &service{s: s})
+	return s, nil
+}
+
+// A Frame holds the stats collected at a given time. As SubscribeRequest, it
+// implements the Marshal and Unmarshal methods the gRPC protobuf codec uses, so
+// that the frames, already encoded by statsviz, are sent as is.
+type Frame struct {
+	// Data is the Protocol Buffers encoding of the Frame message of
+	// statsviz.proto.
+	Data []byte
+}
+
+func (f *Frame) Reset()         { *f = Frame{} }
+func (f *Frame) String() string { return fmt.Sprintf("statsviz.Frame(%d bytes)", len(f.Data)) }
+func (*Frame) ProtoMessage()    {}
+
+// Marshal returns the encoding of f, its Data.
+func (f *Frame) Marshal() ([]byte, error) { return f.Data, nil }
+
+// Unmarshal sets the Data of f to a copy of b.
+func (f *Frame) Unmarshal(b []byte) error {
+	f.Data = append([]byte(nil), b...)
+	return nil
+}
+
+// A SubscribeRequest starts a stream of frames.
+type SubscribeRequest struct {
+	// FrequencyMillis is the interval between frames, in milliseconds, at
+	// least 50. The frames are sent at the statsviz server frequency if 0.
+	FrequencyMillis uint32
+}
+
+func (r *SubscribeRequest) Reset() { *r = SubscribeRequest{} }
+func (r *SubscribeRequest) String() string {
+	return fmt.Sprintf("frequency_millis:%d", r.FrequencyMillis)
+}
+func (*SubscribeRequest) ProtoMessage() {}
+
+// Marshal returns the Protocol Buffers encoding of r.
+func (r *SubscribeRequest) Marshal() ([]byte, error) {
+	if r.FrequencyMillis == 0 {
+		return nil, nil
+	}
+	// Field 1, varint.
+	b := make([]byte, 1+binary.MaxVarintLen32)
+	b[0] = 1 << 3
+	n := binary.PutUvarint(b[1:], uint64(r.FrequencyMillis))
+	return b[:1+n], nil
+}
+
+var errBadRequest = errors.New("statsvizgrpc: malformed SubscribeRequest")
+
+// Unmarshal decodes the Protocol Buffers encoding of a SubscribeRequest,
+// skipping unknown fields.
+func (r *SubscribeRequest) Unmarshal(b []byte) error {
+	*r = SubscribeRequest{}
+	for len(b) != 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadRequest
+		}
+		b = b[n:]
+		switch tag & 7 {
+		case 0: // varint
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errBadRequest
+			}
+			b = b[n:]
+			if tag>>3 == 1 {
+				r.FrequencyMillis = uint32(v)
+			}
+		case 1: // i64
+			if len(b) < 8 {
+				return errBadRequest
+			}
+			b = b[8:]
+		case 2: // len
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errBadRequest
+			}
+			b = b[n+int(l):]
+		case 5: // i32
+			if len(b) < 4 {
+				return errBadRequest
+			}
+			b = b[4:]
+		default:
+			return errBadRequest
+		}
+	}
+	return nil
+}
+
+// service implements the Statsviz service.
+type service struct {
+	s *statsviz.Server
+}
+
+func (svc *service) streamMetrics(req *SubscribeRequest, stream grpc.ServerStream) error {
+	freq := time.Duration(req.FrequencyMillis) * time.Millisecond
+	err := svc.s.StreamFrames(stream.Context(), freq, func(b []byte) error {
+		// The transport may hold the message after SendMsg returns, while b
+		// belongs to statsviz.
+		return stream.SendMsg(&Frame{Data: append([]byte(nil), b...)})
+	})
+	return streamStatus(err)
+}
+
+// streamStatus converts the errors of statsviz.Server.StreamFrames into gRPC
+// status errors. Errors of SendMsg already are.
+func streamStatus(err error) error {
+	var cerr *statsviz.ConfigError
+	switch {
+	case errors.Is(err, statsviz.ErrInvalidFrequency), errors.As(err, &cerr):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, statsviz.ErrServerStopped):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
+
+func streamMetricsHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(SubscribeRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(*service).streamMetrics(req, stream)
+}
+
+// streamMetricsMethod is the full name of the StreamMetrics method.
+const streamMetricsMethod = "/statsviz.Statsviz/StreamMetrics"
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "statsviz.Statsviz",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamMetrics",
+			Handler:       streamMetricsHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "statsvizgrpc.proto",
+}
+
+// StreamMetrics calls the StreamMetrics RPC of the Statsviz service on cc,
+// and returns the stream of frames.
+func StreamMetrics(ctx context.Context, cc grpc.ClientConnInterface, req *SubscribeRequest, opts ...grpc.CallOption) (*FrameStream, error) {
+	stream, err := cc.NewStream(ctx, &serviceDesc.Streams[0], streamMetricsMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &FrameStream{stream}, nil
+}
+
+// A FrameStream receives the frames streamed by StreamMetrics.
+type FrameStream struct {
+	grpc.ClientStream
+}
+
+// Recv returns the next frame, or io.EOF once the stream has ended.
+func (fs *FrameStream) Recv() (*Frame, error) {
+	f := new(Frame)
+	if err := fs.RecvMsg(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,160 @@
+package statsvizgrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/arl/statsviz"
+)
+
+// frameFields decodes the seq, field 2, and counts the scalars, field 5, of
+// an encoded Frame message.
+func frameFields(t *testing.T, b []byte) (seq uint64, scalars int) {
+	t.Helper()
+
+	for len(b) != 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad tag")
+		}
+		b = b[n:]
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("bad varint")
+			}
+			b = b[n:]
+			if tag>>3 == 2 {
+				seq = v
+			}
+		case 1:
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				t.Fatal("bad length")
+			}
+			b = b[n+int(l):]
+			if tag>>3 == 5 {
+				scalars++
+			}
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+	return seq, scalars
+}
+
+func TestRegisterGRPC(t *testing.T) {
+	gs := grpc.NewServer()
+	srv, err := RegisterGRPC(gs, statsviz.SendFrequency(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gs.Serve(ln)
+	defer gs.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, ln.Addr().String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	// The requested frequency overrides the server one.
+	stream, err := StreamMetrics(ctx, cc, &SubscribeRequest{FrequencyMillis: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prev uint64
+	for i := 0; i < 2; i++ {
+		f, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq, scalars := frameFields(t, f.Data)
+		if seq <= prev || scalars == 0 {
+			t.Errorf("frame %d: got seq %d and %d scalars, want seq above %d and scalars", i, seq, scalars, prev)
+		}
+		prev = seq
+	}
+}
+
+func TestStreamMetricsStatus(t *testing.T) {
+	gs := grpc.NewServer()
+	srv, err := RegisterGRPC(gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gs.Serve(ln)
+	defer gs.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, ln.Addr().String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	// recvCode returns the code of the error ending a stream at freq.
+	recvCode := func(freq uint32) codes.Code {
+		t.Helper()
+		stream, err := StreamMetrics(ctx, cc, &SubscribeRequest{FrequencyMillis: freq})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = stream.Recv()
+		return status.Code(err)
+	}
+
+	if got := recvCode(10); got != codes.InvalidArgument {
+		t.Errorf("10ms frequency: got code %v, want %v", got, codes.InvalidArgument)
+	}
+	srv.Stop()
+	if got := recvCode(0); got != codes.Unavailable {
+		t.Errorf("stopped server: got code %v, want %v", got, codes.Unavailable)
+	}
+}
+
+func TestRegisterGRPCInvalid(t *testing.T) {
+	if _, err := RegisterGRPC(grpc.NewServer(), statsviz.SendFrequency(0)); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+}
+
+func TestSubscribeRequest(t *testing.T) {
+	for _, freq := range []uint32{0, 50, 1000, 1 << 31} {
+		b, err := (&SubscribeRequest{FrequencyMillis: freq}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// An unknown field is skipped.
+		b = append(b, 2<<3|2, 1, 'x')
+		var req SubscribeRequest
+		if err := req.Unmarshal(b); err != nil || req.FrequencyMillis != freq {
+			t.Errorf("got %d, %v, want %d", req.FrequencyMillis, err, freq)
+		}
+	}
+	if err := new(SubscribeRequest).Unmarshal([]byte{1 << 3}); err == nil {
+		t.Errorf("truncated request: got nil error, want non-nil")
+	}
+}
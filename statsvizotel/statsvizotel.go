@@ -0,0 +1,133 @@
+// Package statsvizotel exports the runtime metrics plotted by statsviz as
+// OpenTelemetry metrics.
+//
+//	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+//	if err := statsvizotel.Register(provider.Meter("statsviz")); err != nil {
+//	    log.Fatal(err)
+//	}
+//
+// Each scalar runtime metric is exported as an observable instrument:
+// cumulative metrics as counters, the others as gauges. Runtime histograms
+// are not exported.
+//
+// WithTracer also traces the statsviz overhead, with OpenTelemetry spans.
+package statsvizotel
+
+import (
+	"context"
+	"fmt"
+	"runtime/metrics"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/arl/statsviz"
+)
+
+// Register creates an observable instrument on meter for each scalar runtime
+// metric supported by the current Go runtime, and registers a callback that
+// reads runtime metrics and reports their values each time metrics are
+// collected.
+//
+// Instrument names are derived from runtime/metrics names, for example
+// /gc/heap/allocs:bytes becomes go.gc.heap.allocs.bytes, with the By unit.
+// Among opts, only statsviz.WithMetricPrefix and statsviz.WithLabels are used,
+// to prefix instrument names and to attach attributes to the observations.
+func Register(meter metric.Meter, opts ...statsviz.OptionFunc) error {
+	s, err := statsviz.NewServer(opts...)
+	if err != nil {
+		return fmt.Errorf("statsvizotel: %v", err)
+	}
+	prefix := s.MetricPrefix()
+	attrs := labelAttributes(s.Labels())
+	s.Stop()
+
+	var (
+		samples []metrics.Sample
+		insts   []metric.Observable
+	)
+	for _, d := range metrics.All() {
+		var (
+			inst metric.Observable
+			err  error
+		)
+		name, unit := instrumentName(prefix, d.Name)
+		desc := metric.WithDescription(d.Description)
+		switch {
+		case d.Kind == metrics.KindUint64 && d.Cumulative:
+			inst, err = meter.Int64ObservableCounter(name, desc, metric.WithUnit(unit))
+		case d.Kind == metrics.KindUint64:
+			inst, err = meter.Int64ObservableGauge(name, desc, metric.WithUnit(unit))
+		case d.Kind == metrics.KindFloat64 && d.Cumulative:
+			inst, err = meter.Float64ObservableCounter(name, desc, metric.WithUnit(unit))
+		case d.Kind == metrics.KindFloat64:
+			inst, err = meter.Float64ObservableGauge(name, desc, metric.WithUnit(unit))
+		default:
+			continue // histograms
+		}
+		if err != nil {
+			return fmt.Errorf("statsvizotel: can't create instrument for %s: %v", d.Name, err)
+		}
+		samples = append(samples, metrics.Sample{Name: d.Name})
+		insts = append(insts, inst)
+	}
+
+	// Callbacks may be called concurrently, samples must not.
+	var mu sync.Mutex
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		metrics.Read(samples)
+		for i, s := range samples {
+			switch s.Value.Kind() {
+			case metrics.KindUint64:
+				o.ObserveInt64(insts[i].(metric.Int64Observable), int64(s.Value.Uint64()), attrs)
+			case metrics.KindFloat64:
+				o.ObserveFloat64(insts[i].(metric.Float64Observable), s.Value.Float64(), attrs)
+			}
+		}
+		return nil
+	}, insts...)
+	if err != nil {
+		return fmt.Errorf("statsvizotel: can't register callback: %v", err)
+	}
+	return nil
+}
+
+// labelAttributes returns the option attaching labels to observations, as
+// string attributes.
+func labelAttributes(labels map[string]string) metric.MeasurementOption {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for k, v := range labels {
+		kvs = append(kvs, attribute.String(k, v))
+	}
+	return metric.WithAttributes(kvs...)
+}
+
+// units maps runtime/metrics units to UCUM units, as recommended by
+// OpenTelemetry. Other units are used as annotations, e.g. {objects}.
+var units = map[string]string{
+	"bytes":       "By",
+	"seconds":     "s",
+	"cpu-seconds": "s",
+	"percent":     "%",
+}
+
+// instrumentName converts a runtime/metrics metric name into a valid
+// OpenTelemetry instrument name, starting with prefix if not empty, and
+// returns it along with its unit.
+func instrumentName(prefix, name string) (string, string) {
+	unit := ""
+	if i := strings.IndexByte(name, ':'); i != -1 {
+		unit = name[i+1:]
+	}
+
+	otelUnit, ok := units[unit]
+	if !ok && unit != "" {
+		otelUnit = "{" + unit + "}"
+	}
+	return statsviz.MetricName(prefix, name, '.'), otelUnit
+}
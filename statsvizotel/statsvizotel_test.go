@@ -0,0 +1,125 @@
+package statsvizotel
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/arl/statsviz"
+)
+
+func TestRegister(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+
+	if err := Register(provider.Meter("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm.ScopeMetrics) != 1 {
+		t.Fatalf("got %d scopes, want 1", len(rm.ScopeMetrics))
+	}
+
+	found := make(map[string]metricdata.Metrics)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		found[m.Name] = m
+	}
+
+	// A gauge.
+	m, ok := found["go.sched.goroutines.goroutines"]
+	if !ok {
+		t.Fatalf("goroutines metric not reported, got %d metrics", len(found))
+	}
+	g, ok := m.Data.(metricdata.Gauge[int64])
+	if !ok {
+		t.Fatalf("got goroutines data %T, want an int64 gauge", m.Data)
+	}
+	if len(g.DataPoints) != 1 || g.DataPoints[0].Value <= 0 {
+		t.Errorf("got goroutines data points %+v, want 1 positive value", g.DataPoints)
+	}
+	if m.Unit != "{goroutines}" {
+		t.Errorf("got goroutines unit %q, want %q", m.Unit, "{goroutines}")
+	}
+
+	// A counter.
+	m, ok = found["go.gc.heap.allocs.bytes"]
+	if !ok {
+		t.Fatal("heap allocs metric not reported")
+	}
+	sum, ok := m.Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("got heap allocs data %T, want an int64 sum", m.Data)
+	}
+	if !sum.IsMonotonic || len(sum.DataPoints) != 1 || sum.DataPoints[0].Value <= 0 {
+		t.Errorf("got heap allocs %+v, want a monotonic sum with 1 positive value", sum)
+	}
+	if m.Unit != "By" {
+		t.Errorf("got heap allocs unit %q, want %q", m.Unit, "By")
+	}
+
+	// Histograms are not exported.
+	if _, ok := found["go.sched.latencies.seconds"]; ok {
+		t.Errorf("histogram metric /sched/latencies:seconds is reported")
+	}
+}
+
+func TestInstrumentName(t *testing.T) {
+	tests := []struct {
+		metric, name, unit string
+	}{
+		{"/gc/heap/allocs:bytes", "go.gc.heap.allocs.bytes", "By"},
+		{"/gc/heap/allocs:objects", "go.gc.heap.allocs.objects", "{objects}"},
+		{"/cpu/classes/gc/total:cpu-seconds", "go.cpu.classes.gc.total.cpu_seconds", "s"},
+		{"/godebug/non-default-behavior/x509sha1:events", "go.godebug.non_default_behavior.x509sha1.events", "{events}"},
+		{"/weird name*:units", "go.weird_name_.units", "{units}"},
+	}
+	for _, tt := range tests {
+		name, unit := instrumentName("", tt.metric)
+		if name != tt.name || unit != tt.unit {
+			t.Errorf("instrumentName(%q) = %q, %q, want %q, %q", tt.metric, name, unit, tt.name, tt.unit)
+		}
+	}
+	if name, _ := instrumentName("my.app", "/gc/heap/allocs:bytes"); name != "my.app.go.gc.heap.allocs.bytes" {
+		t.Errorf("got prefixed name %q, want %q", name, "my.app.go.gc.heap.allocs.bytes")
+	}
+}
+
+func TestRegisterLabels(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+
+	labels := map[string]string{"region": "eu-west-1", "instance": "i-42"}
+	if err := Register(provider.Meter("test"), statsviz.WithLabels(labels)); err != nil {
+		t.Fatal(err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != "go.sched.goroutines.goroutines" {
+			continue
+		}
+		dps := m.Data.(metricdata.Gauge[int64]).DataPoints
+		if len(dps) != 1 {
+			t.Fatalf("got %d data points, want 1", len(dps))
+		}
+		for k, want := range labels {
+			if v, ok := dps[0].Attributes.Value(attribute.Key(k)); !ok || v.AsString() != want {
+				t.Errorf("attribute %s: got %v, want %q", k, v.AsString(), want)
+			}
+		}
+		return
+	}
+	t.Fatal("goroutines metric not reported")
+}
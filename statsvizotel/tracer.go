@@ -0,0 +1,41 @@
+package statsvizotel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/arl/statsviz"
+)
+
+// WithTracer returns a statsviz option tracing each collection of stats and
+// each encoding of a frame with a span of tracer, see statsviz.WithTracer.
+//
+//	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+//	srv, err := statsviz.NewServer(statsvizotel.WithTracer(provider.Tracer("statsviz")))
+func WithTracer(tracer trace.Tracer) statsviz.OptionFunc {
+	return statsviz.WithTracer(otelTracer{tracer})
+}
+
+// otelTracer adapts an OpenTelemetry tracer to statsviz.Tracer.
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t otelTracer) Start(name string) statsviz.Span {
+	_, span := t.tracer.Start(context.Background(), name)
+	return otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetInt(key string, value int64) {
+	s.span.SetAttributes(attribute.Int64(key, value))
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
@@ -0,0 +1,52 @@
+package statsvizotel
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/arl/statsviz"
+)
+
+func TestWithTracer(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+
+	srv, err := statsviz.NewServer(WithTracer(provider.Tracer("statsviz")), statsviz.SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	attrs := make(map[string]map[string]int64)
+	for _, span := range rec.Ended() {
+		m := make(map[string]int64)
+		for _, kv := range span.Attributes() {
+			m[string(kv.Key)] = kv.Value.AsInt64()
+		}
+		attrs[span.Name()] = m
+	}
+	if got := attrs["statsviz.collect"]["statsviz.metrics"]; got <= 0 {
+		t.Errorf("got collect span attributes %v, want a positive number of metrics", attrs["statsviz.collect"])
+	}
+	if got := attrs["statsviz.encode"]["statsviz.frame_bytes"]; got <= 0 {
+		t.Errorf("got encode span attributes %v, want a positive frame size", attrs["statsviz.encode"])
+	}
+}
@@ -0,0 +1,46 @@
+package statsviz
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StreamFrames sends the stats to send, each one encoded as a Frame message
+// described by statsviz.proto, until ctx is done, the server is stopped, or
+// send fails, in which case its error is returned. It allows to stream the
+// stats over another transport, such as gRPC, see the statsvizgrpc package.
+//
+// Stats are sent at freq, or at the server frequency if freq is 0, starting
+// with the history, if any. Control messages, such as "reset", aren't sent.
+// StreamFrames requires the protobuf encoding, see EncodingProtobuf, and is
+// subject to the slow client policy, see WithSlowClientPolicy.
+//
+// StreamFrames returns a *ConfigError if the encoding isn't protobuf, an
+// error wrapping ErrInvalidFrequency if freq is below 50ms, and
+// ErrServerStopped if the server is stopped.
+func (s *Server) StreamFrames(ctx context.Context, freq time.Duration, send func(frame []byte) error) error {
+	if s.encoding != EncodingProtobuf {
+		return configError("WithEncoding", "streaming frames requires the protobuf encoding")
+	}
+	if freq == 0 {
+		freq = s.freq
+	} else if freq < minSendFrequency {
+		return fmt.Errorf("%w: must be at least %v, got %v", ErrInvalidFrequency, minSendFrequency, freq)
+	}
+
+	untrack, ok := s.track()
+	if !ok {
+		return ErrServerStopped
+	}
+	defer untrack()
+	s.connected()
+	defer s.disconnected()
+
+	return s.sendStats(ctx.Done(), freq, 1, nil, nil, func(msg []byte, binary bool) error {
+		if !binary {
+			return nil
+		}
+		return send(msg)
+	})
+}
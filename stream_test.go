@@ -0,0 +1,76 @@
+package statsviz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStreamFrames(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithEncoding(EncodingProtobuf), SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var frames []pbFrame
+	errEnough := errors.New("enough frames")
+	err = srv.StreamFrames(ctx, 0, func(b []byte) error {
+		frames = append(frames, decodeFrame(t, b))
+		if len(frames) == 2 {
+			return errEnough
+		}
+		return nil
+	})
+	if err != errEnough {
+		t.Fatalf("got error %v, want %v", err, errEnough)
+	}
+	for i, fr := range frames {
+		if fr.seq == 0 || len(fr.scalars) == 0 {
+			t.Errorf("frame %d: got %+v, want stats", i, fr)
+		}
+	}
+	if frames[1].seq <= frames[0].seq {
+		t.Errorf("got seqs %d then %d, want increasing seqs", frames[0].seq, frames[1].seq)
+	}
+
+	// The stream ends with the context.
+	cancel()
+	if err := srv.StreamFrames(ctx, 0, func([]byte) error { return nil }); err != nil {
+		t.Errorf("canceled context: got error %v, want nil", err)
+	}
+}
+
+func TestStreamFramesInvalid(t *testing.T) {
+	t.Parallel()
+
+	send := func([]byte) error { return nil }
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cerr *ConfigError
+	if err := srv.StreamFrames(context.Background(), 0, send); !errors.As(err, &cerr) {
+		t.Errorf("JSON encoding: got error %v, want a *ConfigError", err)
+	}
+	srv.Stop()
+
+	srv, err = NewServer(WithEncoding(EncodingProtobuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.StreamFrames(context.Background(), time.Millisecond, send); !errors.Is(err, ErrInvalidFrequency) {
+		t.Errorf("1ms frequency: got error %v, want ErrInvalidFrequency", err)
+	}
+	srv.Stop()
+	if err := srv.StreamFrames(context.Background(), 0, send); err != ErrServerStopped {
+		t.Errorf("stopped server: got error %v, want ErrServerStopped", err)
+	}
+}
@@ -0,0 +1,94 @@
+package statsviz
+
+import "math"
+
+// A summary holds the minimum, maximum and average values of a metric over
+// the stats kept in history. It's sent with each stats, see WithHistorySize.
+type summary struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+}
+
+// A window computes the summary of the last values of a series, over a sliding
+// window, in amortized constant time per value. NaN values, of missing
+// samples, are part of the window but ignored by the summary.
+type window struct {
+	vals  []float64 // ring buffer of the values in the window
+	seq   int       // number of values pushed so far
+	sum   float64   // of the non-NaN values in the window
+	count int       // number of non-NaN values in the window
+
+	// mins and maxs are monotonic queues of the values which may become
+	// the minimum or maximum of the window, as older values are evicted.
+	mins, maxs []windowValue
+}
+
+type windowValue struct {
+	seq int
+	v   float64
+}
+
+func newWindow(size int) *window {
+	return &window{vals: make([]float64, size)}
+}
+
+// push adds v to the window, evicting the oldest value if the window is full.
+func (w *window) push(v float64) {
+	size := len(w.vals)
+	i := w.seq % size
+	if w.seq >= size {
+		if old := w.vals[i]; !math.IsNaN(old) {
+			w.sum -= old
+			w.count--
+		}
+	}
+	w.vals[i] = v
+	w.seq++
+
+	oldest := w.seq - size
+	for len(w.mins) > 0 && w.mins[0].seq < oldest {
+		w.mins = w.mins[1:]
+	}
+	for len(w.maxs) > 0 && w.maxs[0].seq < oldest {
+		w.maxs = w.maxs[1:]
+	}
+	if math.IsNaN(v) {
+		return
+	}
+
+	w.sum += v
+	w.count++
+	// Recompute the sum once per window revolution, so that rounding errors
+	// of the subtractions don't accumulate.
+	if w.seq%size == 0 {
+		w.sum = 0
+		for _, v := range w.vals {
+			if !math.IsNaN(v) {
+				w.sum += v
+			}
+		}
+	}
+
+	for len(w.mins) > 0 && w.mins[len(w.mins)-1].v >= v {
+		w.mins = w.mins[:len(w.mins)-1]
+	}
+	w.mins = append(w.mins, windowValue{w.seq - 1, v})
+	for len(w.maxs) > 0 && w.maxs[len(w.maxs)-1].v <= v {
+		w.maxs = w.maxs[:len(w.maxs)-1]
+	}
+	w.maxs = append(w.maxs, windowValue{w.seq - 1, v})
+}
+
+// summary returns the summary of the values in the window. ok is false if
+// there's none.
+func (w *window) summary() (s summary, ok bool) {
+	if w.count == 0 {
+		return summary{}, false
+	}
+	return summary{
+		Min: w.mins[0].v,
+		Max: w.maxs[0].v,
+		Avg: w.sum / float64(w.count),
+	}, true
+}
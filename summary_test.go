@@ -0,0 +1,86 @@
+package statsviz
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestWindow(t *testing.T) {
+	t.Parallel()
+
+	nan := math.NaN()
+	w := newWindow(3)
+	if _, ok := w.summary(); ok {
+		t.Fatalf("got a summary of an empty window")
+	}
+
+	tests := []struct {
+		push float64
+		want summary
+	}{
+		{5, summary{Min: 5, Max: 5, Avg: 5}},
+		{1, summary{Min: 1, Max: 5, Avg: 3}},
+		{3, summary{Min: 1, Max: 5, Avg: 3}},
+		{4, summary{Min: 1, Max: 4, Avg: 8.0 / 3}}, // 5 evicted
+		{nan, summary{Min: 3, Max: 4, Avg: 3.5}},   // 1 evicted, NaN ignored
+		{2, summary{Min: 2, Max: 4, Avg: 3}},       // 3 evicted
+		{2, summary{Min: 2, Max: 2, Avg: 2}},       // 4 evicted
+	}
+	for i, tt := range tests {
+		w.push(tt.push)
+		got, ok := w.summary()
+		if !ok || got.Min != tt.want.Min || got.Max != tt.want.Max || math.Abs(got.Avg-tt.want.Avg) > 1e-9 {
+			t.Errorf("push #%d (%v): got summary %+v (ok=%t), want %+v", i, tt.push, got, ok, tt.want)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		w.push(nan)
+	}
+	if s, ok := w.summary(); ok {
+		t.Errorf("got summary %+v of a window of NaN values, want none", s)
+	}
+}
+
+func TestWindowRandom(t *testing.T) {
+	t.Parallel()
+
+	const size = 16
+	rng := rand.New(rand.NewSource(1))
+	w := newWindow(size)
+	var vals []float64
+	for i := 0; i < 1000; i++ {
+		v := rng.Float64() * 100
+		if rng.Intn(10) == 0 {
+			v = math.NaN()
+		}
+		w.push(v)
+		vals = append(vals, v)
+		if len(vals) > size {
+			vals = vals[1:]
+		}
+
+		want := summary{Min: math.Inf(1), Max: math.Inf(-1)}
+		n := 0
+		for _, v := range vals {
+			if math.IsNaN(v) {
+				continue
+			}
+			want.Min, want.Max = math.Min(want.Min, v), math.Max(want.Max, v)
+			want.Avg += v
+			n++
+		}
+		got, ok := w.summary()
+		if n == 0 {
+			if ok {
+				t.Fatalf("push #%d: got summary %+v, want none", i, got)
+			}
+			continue
+		}
+		want.Avg /= float64(n)
+		if got.Min != want.Min || got.Max != want.Max || math.Abs(got.Avg-want.Avg) > 1e-9 {
+			t.Fatalf("push #%d: got summary %+v, want %+v", i, got, want)
+		}
+	}
+}
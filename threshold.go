@@ -0,0 +1,118 @@
+package statsviz
+
+import (
+	"math"
+	"runtime/metrics"
+	"strings"
+)
+
+// WithThreshold arranges for fn to be called when the value of the named
+// metric rises above the given threshold, for example to raise an alert.
+// metricName is either the name of a scalar runtime/metrics metric, such as
+// /sched/goroutines:goroutines, or the name of a user metric (see NewCounter
+// and NewGauge).
+//
+// The metric is checked each time stats are collected, at the server send
+// frequency. fn is only called when the value crosses the threshold: it's
+// called again once the value has fallen back to, or below, the threshold,
+// and then exceeds it again. fn is called from a new goroutine, a panic in fn
+// is recovered and logged.
+func WithThreshold(metricName string, above float64, fn func(name string, value float64)) OptionFunc {
+	return func(s *Server) error {
+		if metricName == "" {
+			return configError("WithThreshold", "threshold metric name can't be empty")
+		}
+		if strings.HasPrefix(metricName, "/") && !isScalar(metricName) {
+			return configError("WithThreshold", "threshold on %q: not a scalar runtime metric supported by this Go version", metricName)
+		}
+		if fn == nil {
+			return configError("WithThreshold", "threshold on %q: nil callback", metricName)
+		}
+		if math.IsNaN(above) {
+			return configError("WithThreshold", "threshold on %q: threshold can't be NaN", metricName)
+		}
+		s.thresholds = append(s.thresholds, &threshold{metric: metricName, above: above, fn: fn})
+		return nil
+	}
+}
+
+// isScalar reports whether name is a scalar runtime metric.
+func isScalar(name string) bool {
+	for _, d := range metrics.All() {
+		if d.Name == name {
+			return d.Kind == metrics.KindUint64 || d.Kind == metrics.KindFloat64
+		}
+	}
+	return false
+}
+
+// A threshold calls fn when a metric rises above a value.
+type threshold struct {
+	metric string
+	above  float64
+	fn     func(name string, value float64)
+
+	exceeded bool // the last value was above the threshold
+}
+
+// cross records the last value of the metric and reports whether it just
+// rose above the threshold. NaN values, of metrics not sampled yet, are
+// ignored.
+func (t *threshold) cross(v float64) bool {
+	if math.IsNaN(v) {
+		return false
+	}
+	was := t.exceeded
+	t.exceeded = v > t.above
+	return t.exceeded && !was
+}
+
+// thresholdMetrics returns the names of the runtime metrics thresholds are
+// set on.
+func (s *Server) thresholdMetrics() []string {
+	var names []string
+	for _, t := range s.thresholds {
+		if strings.HasPrefix(t.metric, "/") {
+			names = append(names, t.metric)
+		}
+	}
+	return names
+}
+
+// checkThresholds calls the callbacks of the thresholds crossed by st values,
+// and freezes the clients plots if WithFreezeOnThreshold is set. It's called
+// from a single goroutine, the one of the hub stats are collected by, with
+// s.hubsMu held.
+func (s *Server) checkThresholds(st *stats) {
+	for _, t := range s.thresholds {
+		v, ok := st.Metrics[t.metric]
+		if !ok {
+			v, ok = st.UserMetrics[t.metric]
+		}
+		if !ok || !t.cross(v) {
+			continue
+		}
+		if s.freeze {
+			s.freezeClients(t, v)
+		}
+		go s.callThreshold(t, v)
+	}
+}
+
+func (s *Server) callThreshold(t *threshold, v float64) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("statsviz: threshold callback panicked", "metric", t.metric, "error", r)
+		}
+	}()
+	t.fn(t.metric, v)
+}
+
+// watchThresholds checks thresholds on the stats collected at the server send
+// frequency, until the server is stopped.
+func (s *Server) watchThresholds() {
+	unsubscribe := s.subscribeFunc(s.freq, s.checkThresholds)
+	defer unsubscribe()
+
+	<-s.done
+}
@@ -0,0 +1,119 @@
+package statsviz
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestThresholdRisingEdges(t *testing.T) {
+	t.Parallel()
+
+	type call struct {
+		name  string
+		value float64
+	}
+	calls := make(chan call, 16)
+	srv, err := NewServer(WithThreshold("queue", 10, func(name string, v float64) {
+		calls <- call{name, v}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// Rises above 10 at 11 and 13, NaN and missing values are ignored.
+	samples := []float64{5, 10, 11, 12, math.NaN(), 15, 10, 3, 13, 13, 9}
+	for i, v := range samples {
+		st := stats{UserMetrics: map[string]float64{"queue": v}}
+		if i == 5 {
+			st.UserMetrics = nil
+		}
+		srv.checkThresholds(&st)
+	}
+
+	// Callbacks are called from their own goroutine, in no particular order.
+	want := []float64{11, 13}
+	var got []float64
+	for range want {
+		select {
+		case c := <-calls:
+			if c.name != "queue" {
+				t.Errorf("got callback for %q, want %q", c.name, "queue")
+			}
+			got = append(got, c.value)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for callbacks, got %v, want %v", got, want)
+		}
+	}
+	sort.Float64s(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got callbacks with %v, want %v", got, want)
+	}
+	select {
+	case c := <-calls:
+		t.Errorf("got unexpected callback %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestThresholdRuntimeMetric(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	values := make(chan float64, 1)
+	srv, err := NewServer(
+		withClock(clk),
+		WithThreshold("/sched/goroutines:goroutines", 0, func(_ string, v float64) {
+			values <- v
+		}),
+		WithThreshold("/sched/goroutines:goroutines", 1, func(string, float64) {
+			panic("boom")
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	select {
+	case <-clk.added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the hub ticker")
+	}
+	clk.advance(time.Second)
+
+	select {
+	case v := <-values:
+		if v < 1 {
+			t.Errorf("got %v goroutines, want at least 1", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the callback")
+	}
+}
+
+func TestWithThresholdErrors(t *testing.T) {
+	t.Parallel()
+
+	fn := func(string, float64) {}
+	tests := []struct {
+		name   string
+		metric string
+		above  float64
+		fn     func(string, float64)
+	}{
+		{"empty name", "", 1, fn},
+		{"unknown runtime metric", "/not/a/metric:bytes", 1, fn},
+		{"histogram metric", "/gc/pauses:seconds", 1, fn},
+		{"nil callback", "queue", 1, nil},
+		{"NaN threshold", "queue", math.NaN(), fn},
+	}
+	for _, tt := range tests {
+		if _, err := NewServer(WithThreshold(tt.metric, tt.above, tt.fn)); err == nil {
+			t.Errorf("%s: got nil error, want non-nil", tt.name)
+		}
+	}
+}
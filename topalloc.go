@@ -0,0 +1,227 @@
+package statsviz
+
+import (
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minTopAllocatorsInterval is the shortest interval between the top
+// allocators snapshots, see WithTopAllocators.
+const minTopAllocatorsInterval = time.Second
+
+// maxTopAllocators is the largest number of allocation sites of a snapshot.
+const maxTopAllocators = 100
+
+// WithTopAllocators shows a table of the n allocation sites which allocated
+// the most bytes during the last interval, such as main.(*Decoder).Decode at
+// decode.go:42. The sites are extracted from the heap profile every interval,
+// which is independent of the send frequency and must be at least 1 second,
+// since reading the profile grows with the number of sites, and are sent with
+// each stats, in their TopAllocators section. Sites are the first frames of
+// the allocation stacks outside of the runtime.
+//
+// Like the heap profile, the snapshots are sampled, at the rate set by
+// runtime.MemProfileRate, and are estimates. They reflect the allocations as
+// of the last completed garbage collection.
+func WithTopAllocators(interval time.Duration, n int) OptionFunc {
+	return func(s *Server) error {
+		if interval < minTopAllocatorsInterval {
+			return configError("WithTopAllocators", "top allocators interval must be at least %v, got %v", minTopAllocatorsInterval, interval)
+		}
+		if n <= 0 || n > maxTopAllocators {
+			return configError("WithTopAllocators", "number of top allocators must be in [1, %d], got %d", maxTopAllocators, n)
+		}
+		if s.topAllocs != nil {
+			return configError("WithTopAllocators", "top allocators already enabled")
+		}
+		s.topAllocs = newTopAllocators(interval, n)
+		return nil
+	}
+}
+
+// An allocSite is a line of the top allocators table.
+type allocSite struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+
+	// Bytes and Objects are allocated during the last interval, since the
+	// process started for the first snapshot.
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+
+	// InuseBytes are allocated and not yet freed.
+	InuseBytes int64 `json:"inuseBytes"`
+}
+
+// allocTotals are the cumulative allocations of a site.
+type allocTotals struct {
+	bytes, objects, inuse int64
+}
+
+// topAllocators periodically extracts the top allocation sites from the heap
+// profile.
+type topAllocators struct {
+	interval time.Duration
+	n        int
+
+	recs []runtime.MemProfileRecord // reused profile records
+	prev map[allocSiteKey]allocTotals
+
+	mu     sync.Mutex
+	latest []allocSite // last snapshot, never modified once stored
+}
+
+// allocSiteKey identifies an allocation site.
+type allocSiteKey struct {
+	function, file string
+	line           int
+}
+
+func newTopAllocators(interval time.Duration, n int) *topAllocators {
+	return &topAllocators{interval: interval, n: n}
+}
+
+// run takes a snapshot every interval until done is closed.
+func (ta *topAllocators) run(clk clock, done <-chan struct{}) {
+	ta.update()
+	tick := clk.NewTicker(ta.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C():
+		}
+		ta.update()
+	}
+}
+
+// load returns the last snapshot, nil until the first one is taken.
+func (ta *topAllocators) load() []allocSite {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	return ta.latest
+}
+
+// update reads the heap profile and stores the sites which allocated the most
+// bytes since the previous update.
+func (ta *topAllocators) update() {
+	ta.recs = readMemProfile(ta.recs)
+	totals := allocSiteTotals(ta.recs, int64(runtime.MemProfileRate))
+
+	sites := make([]allocSite, 0, len(totals))
+	for k, cur := range totals {
+		prev := ta.prev[k]
+		site := allocSite{
+			Function:   k.function,
+			File:       k.file,
+			Line:       k.line,
+			Bytes:      cur.bytes - prev.bytes,
+			Objects:    cur.objects - prev.objects,
+			InuseBytes: cur.inuse,
+		}
+		// Estimates decrease if the profile rate is raised.
+		if site.Bytes <= 0 {
+			continue
+		}
+		if site.Objects < 0 {
+			site.Objects = 0
+		}
+		sites = append(sites, site)
+	}
+	ta.prev = totals
+
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].Bytes != sites[j].Bytes {
+			return sites[i].Bytes > sites[j].Bytes
+		}
+		return sites[i].Function < sites[j].Function
+	})
+	if len(sites) > ta.n {
+		sites = sites[:ta.n]
+	}
+
+	ta.mu.Lock()
+	ta.latest = sites
+	ta.mu.Unlock()
+}
+
+// readMemProfile returns the records of the heap profile, including those of
+// the sites which objects have all been freed, in recs which is grown as
+// needed.
+func readMemProfile(recs []runtime.MemProfileRecord) []runtime.MemProfileRecord {
+	n, _ := runtime.MemProfile(nil, true)
+	for {
+		// Leave room for the records added in the meantime.
+		if cap(recs) < n+50 {
+			recs = make([]runtime.MemProfileRecord, n+50)
+		}
+		recs = recs[:cap(recs)]
+		var ok bool
+		if n, ok = runtime.MemProfile(recs, true); ok {
+			return recs[:n]
+		}
+	}
+}
+
+// allocSiteTotals sums the allocations of the records by site, scaled as the
+// heap profile does according to the sampling rate.
+func allocSiteTotals(recs []runtime.MemProfileRecord, rate int64) map[allocSiteKey]allocTotals {
+	totals := make(map[allocSiteKey]allocTotals)
+	for i := range recs {
+		r := &recs[i]
+		k, ok := allocSiteOf(r.Stack())
+		if !ok {
+			continue
+		}
+		objects, bytes := scaleHeapSample(r.AllocObjects, r.AllocBytes, rate)
+		_, inuse := scaleHeapSample(r.InUseObjects(), r.InUseBytes(), rate)
+		t := totals[k]
+		t.bytes += bytes
+		t.objects += objects
+		t.inuse += inuse
+		totals[k] = t
+	}
+	return totals
+}
+
+// allocSiteOf returns the site of an allocation stack, its first frame
+// outside of the runtime, or its first frame if all are in the runtime.
+func allocSiteOf(stk []uintptr) (allocSiteKey, bool) {
+	frames := runtime.CallersFrames(stk)
+	var first allocSiteKey
+	for i := 0; ; i++ {
+		f, more := frames.Next()
+		k := allocSiteKey{function: f.Function, file: f.File, line: f.Line}
+		if i == 0 {
+			first = k
+		}
+		if f.Function != "" && !strings.HasPrefix(f.Function, "runtime.") {
+			return k, true
+		}
+		if !more {
+			return first, first.function != ""
+		}
+	}
+}
+
+// scaleHeapSample estimates the number of objects and bytes actually
+// allocated from the sampled ones, like runtime/pprof: an allocation of size
+// bytes is sampled with probability 1-exp(-size/rate).
+func scaleHeapSample(count, size, rate int64) (int64, int64) {
+	if count == 0 || size == 0 {
+		return 0, 0
+	}
+	if rate <= 1 {
+		return count, size
+	}
+	avg := float64(size) / float64(count)
+	scale := 1 / (1 - math.Exp(-avg/float64(rate)))
+	return int64(float64(count) * scale), int64(float64(size) * scale)
+}
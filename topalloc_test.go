@@ -0,0 +1,179 @@
+package statsviz
+
+import (
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var topAllocSink [][]byte
+
+// allocateForTopAllocators allocates 64MB, by 1MB slices.
+//
+//go:noinline
+func allocateForTopAllocators() {
+	for i := 0; i < 64; i++ {
+		topAllocSink = append(topAllocSink, make([]byte, 1<<20))
+	}
+}
+
+func TestTopAllocators(t *testing.T) {
+	ta := newTopAllocators(time.Second, 20)
+	ta.update()
+
+	allocateForTopAllocators()
+	topAllocSink = nil
+	// The profile reflects the allocations as of the last completed GC.
+	runtime.GC()
+	runtime.GC()
+	ta.update()
+
+	const fn = "github.com/arl/statsviz.allocateForTopAllocators"
+	sites := ta.load()
+	for i, site := range sites {
+		if i > 0 && site.Bytes > sites[i-1].Bytes {
+			t.Errorf("site %d: got %d bytes after %d, want sites sorted by bytes", i, site.Bytes, sites[i-1].Bytes)
+		}
+		if site.Function != fn {
+			continue
+		}
+		if site.Bytes < 32<<20 || site.Objects == 0 {
+			t.Errorf("got %d bytes and %d objects, want about 64MB", site.Bytes, site.Objects)
+		}
+		if !strings.HasSuffix(site.File, "topalloc_test.go") || site.Line == 0 {
+			t.Errorf("got site at %s:%d, want topalloc_test.go", site.File, site.Line)
+		}
+		return
+	}
+	t.Errorf("%s not in the top allocators %+v", fn, sites)
+}
+
+func TestTopAllocatorsFrames(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithTopAllocators(time.Second, 5), SendFrequency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// The first snapshot holds the allocations since the process started.
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var st struct {
+			TopAllocators []allocSite
+		}
+		if err := ws.ReadJSON(&st); err != nil {
+			t.Fatal(err)
+		}
+		if len(st.TopAllocators) == 0 {
+			continue
+		}
+		if len(st.TopAllocators) > 5 {
+			t.Errorf("got %d sites, want at most 5", len(st.TopAllocators))
+		}
+		for _, site := range st.TopAllocators {
+			if site.Function == "" || site.Bytes <= 0 {
+				t.Errorf("got site %+v, want a function and bytes", site)
+			}
+		}
+		break
+	}
+}
+
+func TestWithTopAllocatorsInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		interval time.Duration
+		n        int
+	}{
+		{100 * time.Millisecond, 10},
+		{time.Second, 0},
+		{time.Second, maxTopAllocators + 1},
+	}
+	for _, tt := range tests {
+		if _, err := NewServer(WithTopAllocators(tt.interval, tt.n)); err == nil {
+			t.Errorf("WithTopAllocators(%v, %d): got nil error, want non-nil", tt.interval, tt.n)
+		}
+	}
+	if _, err := NewServer(WithTopAllocators(time.Second, 1), WithTopAllocators(time.Second, 1)); err == nil {
+		t.Errorf("WithTopAllocators twice: got nil error, want non-nil")
+	}
+}
+
+func TestScaleHeapSample(t *testing.T) {
+	tests := []struct {
+		count, size, rate   int64
+		wantCount, wantSize int64
+	}{
+		{0, 0, 512 << 10, 0, 0},
+		{10, 1000, 1, 10, 1000},
+		// Large allocations are always sampled.
+		{1, 64 << 20, 512 << 10, 1, 64 << 20},
+		// Half of the allocations of rate*ln(2) bytes are sampled.
+		{1, 363409, 512 << 10, 1, 726818},
+	}
+	for _, tt := range tests {
+		count, size := scaleHeapSample(tt.count, tt.size, tt.rate)
+		if count != tt.wantCount || size < tt.wantSize-1 || size > tt.wantSize+1 {
+			t.Errorf("scaleHeapSample(%d, %d, %d) = %d, %d, want %d, %d", tt.count, tt.size, tt.rate, count, size, tt.wantCount, tt.wantSize)
+		}
+	}
+}
+
+func TestTopAllocatorsProtobuf(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(WithEncoding(EncodingProtobuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	site := allocSite{Function: "main.decode", File: "/src/main.go", Line: 42, Bytes: 1 << 20, Objects: 3, InuseBytes: 512}
+	st := stats{TopAllocators: []allocSite{site}}
+	fields, err := decodeProtobuf(srv.appendProtobuf(nil, &st, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 || fields[0].num != 14 {
+		t.Fatalf("got fields %+v, want a top allocators field", fields)
+	}
+	sub, err := decodeProtobuf(fields[0].bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got allocSite
+	for _, f := range sub {
+		switch f.num {
+		case 1:
+			got.Function = string(f.bytes)
+		case 2:
+			got.File = string(f.bytes)
+		case 3:
+			got.Line = int(f.u)
+		case 4:
+			got.Bytes = int64(f.u)
+		case 5:
+			got.Objects = int64(f.u)
+		case 6:
+			got.InuseBytes = int64(f.u)
+		}
+	}
+	if got != site {
+		t.Errorf("got %+v, want %+v", got, site)
+	}
+}
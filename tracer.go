@@ -0,0 +1,68 @@
+package statsviz
+
+// A Tracer traces the work done by the server to collect and encode stats,
+// to correlate its overhead with the application latency, see WithTracer.
+// statsvizotel.WithTracer reports the spans to an OpenTelemetry tracer.
+type Tracer interface {
+	// Start starts a span named name.
+	Start(name string) Span
+}
+
+// A Span is a unit of work traced by a Tracer.
+type Span interface {
+	// SetInt sets an integer attribute of the span.
+	SetInt(key string, value int64)
+
+	// End ends the span.
+	End()
+}
+
+// Spans and their attributes.
+const (
+	// spanCollect spans the collection of stats, spanMetrics is the number of
+	// runtime metrics read.
+	spanCollect = "statsviz.collect"
+	spanMetrics = "statsviz.metrics"
+
+	// spanEncode spans the encoding of a frame, spanFrameBytes is the frame
+	// size and spanEncodeNanos the encoding duration, in nanoseconds.
+	spanEncode      = "statsviz.encode"
+	spanFrameBytes  = "statsviz.frame_bytes"
+	spanEncodeNanos = "statsviz.encode_ns"
+)
+
+// WithTracer traces each collection of stats and each encoding of a frame
+// with a span of tracer. There are no spans by default.
+func WithTracer(tracer Tracer) OptionFunc {
+	return func(s *Server) error {
+		s.tracer = tracer
+		return nil
+	}
+}
+
+// collect collects the stats of the hub, in a span if the server has a
+// tracer.
+func (h *hub) collect(smp *sampler) {
+	if h.s.tracer == nil {
+		h.s.collect(smp, &h.stats)
+		return
+	}
+	span := h.s.tracer.Start(spanCollect)
+	defer span.End()
+	h.s.collect(smp, &h.stats)
+	span.SetInt(spanMetrics, int64(len(smp.samples)))
+}
+
+// tracedEncode is like encode, in a span if the server has a tracer.
+func (h *hub) tracedEncode() error {
+	if h.s.tracer == nil {
+		return h.encode()
+	}
+	span := h.s.tracer.Start(spanEncode)
+	defer span.End()
+	start := h.s.clock.Now()
+	err := h.encode()
+	span.SetInt(spanFrameBytes, int64(len(h.out)))
+	span.SetInt(spanEncodeNanos, int64(h.s.clock.Now().Sub(start)))
+	return err
+}
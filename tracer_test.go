@@ -0,0 +1,93 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedSpan is a span recorded by spanRecorder.
+type recordedSpan struct {
+	name  string
+	attrs map[string]int64
+	ended bool
+}
+
+// spanRecorder is a Tracer recording spans in memory.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *spanRecorder) Start(name string) Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sp := &recordedSpan{name: name, attrs: make(map[string]int64)}
+	r.spans = append(r.spans, sp)
+	return &recorderSpan{r: r, sp: sp}
+}
+
+type recorderSpan struct {
+	r  *spanRecorder
+	sp *recordedSpan
+}
+
+func (s *recorderSpan) SetInt(key string, value int64) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.sp.attrs[key] = value
+}
+
+func (s *recorderSpan) End() {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.sp.ended = true
+}
+
+func TestWithTracer(t *testing.T) {
+	t.Parallel()
+
+	rec := &spanRecorder{}
+	srv, err := NewServer(WithTracer(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	h := &hub{s: srv, freq: time.Second, stats: newStats()}
+	h.enc = json.NewEncoder(&h.buf)
+	smp := srv.newSampler()
+	var frameBytes []int
+	const ticks = 3
+	for i := 0; i < ticks; i++ {
+		h.tick(smp)
+		frameBytes = append(frameBytes, len(h.out))
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.spans) != 2*ticks {
+		t.Fatalf("got %d spans, want %d", len(rec.spans), 2*ticks)
+	}
+	for i, sp := range rec.spans {
+		if !sp.ended {
+			t.Errorf("span %d %s not ended", i, sp.name)
+		}
+		switch want := []string{spanCollect, spanEncode}[i%2]; {
+		case sp.name != want:
+			t.Errorf("span %d: got %s, want %s", i, sp.name, want)
+		case sp.name == spanCollect:
+			if got := sp.attrs[spanMetrics]; got != int64(len(smp.samples)) || got == 0 {
+				t.Errorf("collect span: got %d metrics, want %d", got, len(smp.samples))
+			}
+		case sp.name == spanEncode:
+			if got, want := sp.attrs[spanFrameBytes], int64(frameBytes[i/2]); got != want {
+				t.Errorf("encode span %d: got %d frame bytes, want %d", i, got, want)
+			}
+			if _, ok := sp.attrs[spanEncodeNanos]; !ok {
+				t.Errorf("encode span: got attributes %v, want the encoding duration", sp.attrs)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package statsviz
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Transform derives the plotted value of a time series from its sampled
+// value. Transforms are useful for cumulative counters, such as the number of
+// allocations, which are hard to read when plotted as is.
+type Transform int
+
+const (
+	// Raw plots the sampled value as is. This is the default.
+	Raw Transform = iota
+
+	// Rate plots the per-second rate of change of a cumulative counter,
+	// computed over each sampling interval.
+	Rate
+
+	// Delta plots the increase of a cumulative counter over each sampling
+	// interval.
+	Delta
+)
+
+func (tr Transform) check() error {
+	switch tr {
+	case Raw, Rate, Delta:
+		return nil
+	}
+	return fmt.Errorf("unknown transform %d", tr)
+}
+
+// A transformState holds the previous sample of a time series, needed to
+// apply Rate and Delta transforms.
+//
+// On the first sample, there's no previous one, so Rate and Delta are NaN. If
+// the counter decreases, for example because it's been reset, its increase is
+// considered to be zero.
+type transformState struct {
+	rate  counterRate
+	delta counterDelta
+}
+
+// apply records v, sampled at t, and returns the transformed value.
+func (st *transformState) apply(tr Transform, v float64, t time.Time) float64 {
+	switch tr {
+	case Rate:
+		return st.rate.update(v, t)
+	case Delta:
+		return st.delta.update(v)
+	}
+	return v
+}
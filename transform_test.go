@@ -0,0 +1,73 @@
+package statsviz
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTransform(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		tr   Transform
+		want []float64 // NaN for the first sample
+	}{
+		{tr: Raw, want: []float64{10, 14, 20, 5, 8}},
+		{tr: Delta, want: []float64{math.NaN(), 4, 6, 0, 3}},
+		{tr: Rate, want: []float64{math.NaN(), 2, 3, 0, 1.5}},
+	}
+
+	// A counter sampled every 2 seconds, reset between the 3rd and 4th sample.
+	values := []float64{10, 14, 20, 5, 8}
+
+	for _, tt := range tests {
+		var st transformState
+		for i, v := range values {
+			got := st.apply(tt.tr, v, t0.Add(time.Duration(i)*2*time.Second))
+			want := tt.want[i]
+			if math.IsNaN(want) {
+				if !math.IsNaN(got) {
+					t.Errorf("transform %d, sample %d: got %v, want NaN", tt.tr, i, got)
+				}
+				continue
+			}
+			if got != want {
+				t.Errorf("transform %d, sample %d: got %v, want %v", tt.tr, i, got, want)
+			}
+		}
+	}
+}
+
+func TestUserPlotTransform(t *testing.T) {
+	t.Parallel()
+
+	var count float64
+	p := newUserPlot(TimeSeriesPlot{
+		Name: "requests",
+		Series: []TimeSeries{
+			{Name: "total", Value: func() float64 { return count }},
+			{Name: "per interval", Value: func() float64 { return count }, Transform: Delta},
+		},
+	})
+
+	smp := newSampler()
+	var vals plotValues
+	for i, want := range []float64{math.NaN(), 5, 0} {
+		switch i {
+		case 1:
+			count = 5
+		case 2:
+			count = 1 // counter reset
+		}
+		vals = p.sample(smp, vals)
+		if vals[0] != count {
+			t.Errorf("sample %d: got raw value %v, want %v", i, vals[0], count)
+		}
+		if got := vals[1]; got != want && !(math.IsNaN(got) && math.IsNaN(want)) {
+			t.Errorf("sample %d: got delta %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package statsviz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// ListenAndServeUnix serves the statsviz handlers, configured with the
+// provided options, on the Unix domain socket at socketPath, so that statsviz
+// isn't reachable over TCP. The socket can then be forwarded, for example with
+// 'ssh -L 8080:/path/to/statsviz.sock host'.
+//
+// A stale socket file, left by a previous process that didn't clean up, is
+// removed. ListenAndServeUnix returns an error if another process is still
+// serving on socketPath, or if socketPath exists and is not a socket.
+//
+// ListenAndServeUnix always returns a non-nil error. The socket file is
+// removed before it returns.
+func ListenAndServeUnix(socketPath string, opts ...OptionFunc) error {
+	return ListenAndServeUnixContext(context.Background(), socketPath, opts...)
+}
+
+// ListenAndServeUnixContext is like ListenAndServeUnix but stops serving once
+// ctx is cancelled: the HTTP server is shut down, the statsviz server is
+// stopped and the socket file is removed. It then returns ctx.Err().
+func ListenAndServeUnixContext(ctx context.Context, socketPath string, opts ...OptionFunc) error {
+	s, err := NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	defer s.Stop()
+
+	if err := removeStaleSocket(socketPath); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	// serve closes the listener, which removes the socket file.
+	return serve(ctx, s, l, "", "")
+}
+
+// removeStaleSocket removes the socket file at path if nothing listens on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
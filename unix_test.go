@@ -0,0 +1,98 @@
+package statsviz
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unixClient returns an HTTP client connecting to the unix socket at path.
+func unixClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+}
+
+func TestListenAndServeUnix(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "statsviz.sock")
+
+	// A stale socket file, as left by a crashed process, is not in use.
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("stale socket file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- ListenAndServeUnixContext(ctx, path) }()
+
+	// Wait for the server to listen.
+	client := unixClient(path)
+	var resp *http.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err = client.Get("http://unix/debug/statsviz/")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "<title>Statsviz</title>") {
+		t.Errorf("got status %d and body %q, want the index page", resp.StatusCode, body)
+	}
+
+	// Another server can't steal a socket in use.
+	if err := ListenAndServeUnix(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("got error %v, want socket in use", err)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file not removed after shutdown: %v", err)
+	}
+}
+
+func TestListenAndServeUnixNotASocket(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ListenAndServeUnix(path); err == nil {
+		t.Errorf("got nil error, want non-nil")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file was removed: %v", err)
+	}
+}
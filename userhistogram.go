@@ -0,0 +1,116 @@
+package statsviz
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// A UserHistogram counts application values, such as request latencies, in
+// buckets. It's shown as a heatmap with WithHistogramPlot, the number of
+// values observed in each bucket over each interval, like the built-in
+// scheduling latencies plot. A UserHistogram is safe for concurrent use.
+type UserHistogram struct {
+	bounds []float64
+	counts []uint64 // cumulative, accessed atomically
+}
+
+// NewUserHistogram returns a histogram which buckets are delimited by bounds,
+// which must be sorted in increasing order. Values lower than bounds[0] are
+// counted in a first bucket, and those greater than or equal to the last bound
+// in a last one, so there are len(bounds)+1 buckets.
+func NewUserHistogram(bounds ...float64) (*UserHistogram, error) {
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("histogram requires at least one bucket bound")
+	}
+	for i, b := range bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return nil, fmt.Errorf("invalid histogram bucket bound %v", b)
+		}
+		if i > 0 && b <= bounds[i-1] {
+			return nil, fmt.Errorf("histogram bucket bounds must be increasing, got %v after %v", b, bounds[i-1])
+		}
+	}
+	return &UserHistogram{
+		bounds: append([]float64(nil), bounds...),
+		counts: make([]uint64, len(bounds)+1),
+	}, nil
+}
+
+// Observe counts v in its bucket. NaN values are ignored.
+func (h *UserHistogram) Observe(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	i := sort.Search(len(h.bounds), func(i int) bool { return v < h.bounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+}
+
+// A HistogramPlot is a user-defined heatmap plot, showing the evolution of a
+// UserHistogram. It's added to the user interface with WithHistogramPlot.
+type HistogramPlot struct {
+	// Name identifies the plot, it must be unique among user and built-in
+	// plots.
+	Name string
+
+	// Title is shown on top of the plot.
+	Title string
+
+	// Histogram holds the plotted values.
+	Histogram *UserHistogram
+}
+
+// WithHistogramPlot adds a user-defined heatmap plot to the user interface.
+func WithHistogramPlot(p HistogramPlot) OptionFunc {
+	return func(s *Server) error {
+		if p.Name == "" {
+			return configError("WithHistogramPlot", "plot name can't be empty")
+		}
+		if p.Histogram == nil {
+			return configError("WithHistogramPlot", "plot %q has a nil histogram", p.Name)
+		}
+		for _, up := range s.userPlots {
+			if up.Name == p.Name {
+				return configError("WithHistogramPlot", "duplicate plot name %q", p.Name)
+			}
+		}
+		for i := range s.runtimePlots {
+			if s.runtimePlots[i].name == p.Name {
+				return configError("WithHistogramPlot", "duplicate plot name %q", p.Name)
+			}
+		}
+
+		hm := &runtimeHeatmap{user: p.Histogram, label: boundLabel}
+		hm.init()
+		s.runtimePlots = append(s.runtimePlots, runtimePlot{name: p.Name, title: p.Title, heatmap: hm})
+		return nil
+	}
+}
+
+// boundLabel formats a UserHistogram bucket bound.
+func boundLabel(b float64) string {
+	if math.IsInf(b, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(b, 'g', -1, 64)
+}
+
+// addUser is like add for the histogram of a user heatmap.
+func (hm *runtimeHeatmap) addUser(smp *sampler, key string, vals plotValues) bool {
+	counts := hm.user.counts
+	prev, ok := smp.hists[key]
+	if !ok {
+		prev = make([]uint64, len(counts))
+		smp.hists[key] = prev
+	}
+	for i := range counts {
+		c := atomic.LoadUint64(&counts[i])
+		if j := i / hm.factor; j < len(vals) && c > prev[i] {
+			vals[j] += float64(c - prev[i])
+		}
+		prev[i] = c
+	}
+	return ok
+}
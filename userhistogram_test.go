@@ -0,0 +1,84 @@
+package statsviz
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestUserHistogramPlot(t *testing.T) {
+	t.Parallel()
+
+	h, err := NewUserHistogram(0.01, 0.1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(WithPlots(), WithHistogramPlot(HistogramPlot{Name: "latency", Title: "Latency", Histogram: h}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	cfg := srv.handshake().RuntimePlots
+	if len(cfg) != 1 || cfg[0].Type != "heatmap" || len(cfg[0].Series) != 4 {
+		t.Fatalf("got runtime plots %+v, want the latency heatmap with 4 buckets", cfg)
+	}
+	for i, want := range []string{"0.01", "0.1", "1", "+Inf"} {
+		if got := cfg[0].Series[i].Name; got != want {
+			t.Errorf("bucket %d: got %q, want %q", i, got, want)
+		}
+	}
+
+	st := newStats()
+	smp := srv.newSampler()
+	h.Observe(0.5) // counted before the first collection, not plotted
+	srv.collect(smp, &st)
+	for _, v := range st.RuntimePlots["latency"] {
+		if !math.IsNaN(v) {
+			t.Fatalf("got first counts %v, want only NaN", st.RuntimePlots["latency"])
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, v := range []float64{0.001, 0.01, 0.05, 0.05, 0.5, 2, 10, math.NaN()} {
+		wg.Add(1)
+		go func(v float64) {
+			defer wg.Done()
+			h.Observe(v)
+		}(v)
+	}
+	wg.Wait()
+	srv.collect(smp, &st)
+	if got, want := []float64(st.RuntimePlots["latency"]), []float64{1, 3, 1, 2}; !equalFloats(got, want) {
+		t.Errorf("got counts %v, want %v", got, want)
+	}
+
+	// Counts are per interval.
+	h.Observe(0)
+	srv.collect(smp, &st)
+	if got, want := []float64(st.RuntimePlots["latency"]), []float64{1, 0, 0, 0}; !equalFloats(got, want) {
+		t.Errorf("got counts %v, want %v", got, want)
+	}
+}
+
+func TestUserHistogramInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, bounds := range [][]float64{nil, {1, 1}, {2, 1}, {math.NaN()}, {math.Inf(1)}} {
+		if _, err := NewUserHistogram(bounds...); err == nil {
+			t.Errorf("NewUserHistogram(%v): got nil error, want non-nil", bounds)
+		}
+	}
+
+	h, _ := NewUserHistogram(1)
+	tests := []HistogramPlot{
+		{Name: "", Histogram: h},
+		{Name: "nil"},
+		{Name: string(PlotSchedLatencies), Histogram: h},
+	}
+	for _, p := range tests {
+		if _, err := NewServer(WithHistogramPlot(p)); err == nil {
+			t.Errorf("WithHistogramPlot(%+v): got nil error, want non-nil", p)
+		}
+	}
+}
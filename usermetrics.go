@@ -0,0 +1,133 @@
+package statsviz
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// userMetric is implemented by the metrics that users can create and feed
+// with their own application values.
+type userMetric interface {
+	Value() float64
+}
+
+// userMetrics holds all user metrics, indexed by name.
+var userMetrics = struct {
+	sync.RWMutex
+	m map[string]userMetric
+}{m: make(map[string]userMetric)}
+
+func publishUserMetric(name string, m userMetric) {
+	userMetrics.Lock()
+	defer userMetrics.Unlock()
+
+	if _, dup := userMetrics.m[name]; dup {
+		panic(fmt.Sprintf("statsviz: reuse of metric name %q", name))
+	}
+	userMetrics.m[name] = m
+}
+
+// readUserMetrics stores the current values of all user metrics into vals,
+// which is allocated if nil, and returns it. It returns nil if there are no
+// user metrics.
+func readUserMetrics(vals map[string]float64) map[string]float64 {
+	userMetrics.RLock()
+	defer userMetrics.RUnlock()
+
+	if len(userMetrics.m) == 0 {
+		return nil
+	}
+	if vals == nil {
+		vals = make(map[string]float64, len(userMetrics.m))
+	}
+	for name, m := range userMetrics.m {
+		vals[name] = m.Value()
+	}
+	return vals
+}
+
+// A Counter is a user metric representing a value that only goes up, such as
+// the number of processed requests. A Counter is safe for concurrent use.
+//
+// The value of each Counter is sampled and sent alongside runtime statistics,
+// and shown in the 'User metrics' plot.
+type Counter struct {
+	v atomicFloat64
+}
+
+// NewCounter creates a new Counter and publishes it under the given name.
+//
+// NewCounter panics if a user metric with the same name already exists.
+func NewCounter(name string) *Counter {
+	c := &Counter{}
+	publishUserMetric(name, c)
+	return c
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add adds delta to the counter. Add panics if delta is negative, NaN or
+// infinite.
+func (c *Counter) Add(delta float64) {
+	if !(delta >= 0) || math.IsInf(delta, 1) {
+		panic(fmt.Sprintf("statsviz: invalid counter delta %v", delta))
+	}
+	c.v.Add(delta)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	return c.v.Load()
+}
+
+// MarshalJSON encodes the current value of the counter into JSON.
+func (c *Counter) MarshalJSON() ([]byte, error) {
+	return c.v.MarshalJSON()
+}
+
+// A Gauge is a user metric representing a value that can arbitrarily go up
+// and down, such as a queue depth. A Gauge is safe for concurrent use.
+//
+// The value of each Gauge is sampled and sent alongside runtime statistics,
+// and shown in the 'User metrics' plot.
+type Gauge struct {
+	v atomicFloat64
+}
+
+// NewGauge creates a new Gauge and publishes it under the given name.
+//
+// NewGauge panics if a user metric with the same name already exists.
+func NewGauge(name string) *Gauge {
+	g := &Gauge{}
+	publishUserMetric(name, g)
+	return g
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+// Add adds delta, which may be negative, to the gauge.
+func (g *Gauge) Add(delta float64) {
+	g.v.Add(delta)
+}
+
+// Set sets the gauge to val.
+func (g *Gauge) Set(val float64) {
+	g.v.Store(val)
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return g.v.Load()
+}
+
+// MarshalJSON encodes the current value of the gauge into JSON.
+func (g *Gauge) MarshalJSON() ([]byte, error) {
+	return g.v.MarshalJSON()
+}
@@ -0,0 +1,189 @@
+package statsviz
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCounter(t *testing.T) {
+	t.Parallel()
+
+	const n = 100
+
+	c := NewCounter("test-counter")
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			c.Inc()
+			c.Add(0.5)
+		}()
+	}
+	wg.Wait()
+
+	if got := c.Value(); got != 150 {
+		t.Errorf("Value() = %v, want %v", got, 150)
+	}
+
+	buf, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "150" {
+		t.Errorf("json.Marshal(counter) = %s, want %s", buf, "150")
+	}
+
+	if got := readUserMetrics(nil)["test-counter"]; got != 150 {
+		t.Errorf("sampled counter value = %v, want %v", got, 150)
+	}
+}
+
+func TestCounterInvalidAdd(t *testing.T) {
+	t.Parallel()
+
+	c := NewCounter("test-counter-invalid")
+	for _, delta := range []float64{-1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Add(%v) didn't panic", delta)
+				}
+			}()
+			c.Add(delta)
+		}()
+	}
+	if got := c.Value(); got != 0 {
+		t.Errorf("got value %v after invalid deltas, want 0", got)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	t.Parallel()
+
+	const n = 100
+
+	g := NewGauge("test-gauge")
+	g.Set(10)
+
+	var wg sync.WaitGroup
+	wg.Add(2 * n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			g.Inc()
+		}()
+		go func() {
+			defer wg.Done()
+			g.Add(-2)
+		}()
+	}
+	wg.Wait()
+
+	if got := g.Value(); got != 10-n {
+		t.Errorf("Value() = %v, want %v", got, 10-n)
+	}
+
+	buf, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "-90" {
+		t.Errorf("json.Marshal(gauge) = %s, want %s", buf, "-90")
+	}
+
+	if got := readUserMetrics(nil)["test-gauge"]; got != 10-n {
+		t.Errorf("sampled gauge value = %v, want %v", got, 10-n)
+	}
+}
+
+func TestUserMetricDuplicateName(t *testing.T) {
+	t.Parallel()
+
+	NewGauge("test-duplicate")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a duplicate metric name should panic")
+		}
+	}()
+	NewCounter("test-duplicate")
+}
+
+// TestNonFiniteGauge isn't parallel since the gauge is seen by all the
+// servers, it's unpublished once done.
+func TestNonFiniteGauge(t *testing.T) {
+	g := NewGauge("test-gauge-nan")
+	g.Set(math.NaN())
+	defer func() {
+		userMetrics.Lock()
+		delete(userMetrics.m, "test-gauge-nan")
+		userMetrics.Unlock()
+	}()
+
+	srv, err := NewServer(SendFrequency(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), `"test-gauge-nan":null`) {
+		t.Errorf("got frame %s, want the NaN gauge encoded as null", msg)
+	}
+	var st stats
+	if err := json.Unmarshal(msg, &st); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := st.UserMetrics["test-gauge-nan"]; !ok || !math.IsNaN(v) {
+		t.Errorf("got gauge value %v, want it decoded as NaN", v)
+	}
+}
+
+func TestMetricValuesJSON(t *testing.T) {
+	t.Parallel()
+
+	vals := metricValues{"b": 1e21, "a": 0.5, "c": math.Inf(1), "d": 1e-7, "e": 123456789}
+	buf, err := json.Marshal(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":0.5,"b":1e+21,"c":null,"d":1e-7,"e":123456789}`
+	if string(buf) != want {
+		t.Errorf("json.Marshal(%v) = %s, want %s", vals, buf, want)
+	}
+
+	// Finite values are encoded as in a plain map.
+	vals = metricValues{"<q\">": 1, "é\u2028": -2.5e-9, "tab\t": 0}
+	buf, err = json.Marshal(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := json.Marshal(map[string]float64(vals))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(plain) {
+		t.Errorf("json.Marshal(%v) = %s, want %s", vals, buf, plain)
+	}
+}
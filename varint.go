@@ -0,0 +1,74 @@
+package statsviz
+
+import (
+	"math"
+)
+
+// WithVarintDeltas, with the Protocol Buffers encoding, sends the values of
+// the scalar runtime metrics in their most compact form: in the order of the
+// handshake MetricNames, as the zig-zag varint encoded differences between
+// the IEEE 754 bits of each value and of its value in the previous frame, so
+// that an unchanged value takes a single byte, and values are exact.
+//
+// Every keyframes frames, and whenever a client connects or misses frames,
+// a keyframe holds the values themselves, from which the following frames
+// are decoded. See the metric_values and metric_deltas fields of the Frame
+// message of statsviz.proto. User metrics are still sent as scalars. It's not
+// compatible with WithDeltaFrames.
+func WithVarintDeltas(keyframes int) OptionFunc {
+	return func(s *Server) error {
+		if keyframes <= 0 {
+			return configError("WithVarintDeltas", "keyframes interval must be positive, got %d", keyframes)
+		}
+		s.keyframes = keyframes
+		return nil
+	}
+}
+
+// varintMetrics holds the metrics values of a frame, with varint deltas.
+type varintMetrics struct {
+	keyframe bool
+	values   []float64 // keyframe values
+	deltas   []byte    // varint deltas, if not a keyframe
+}
+
+// A varintDelta tracks the metrics values of the frames of a hub, from one
+// frame to the next, to encode them as varint deltas.
+type varintDelta struct {
+	prev []uint64 // bits of the values of the previous frame
+	n    int      // frames since the last keyframe
+	vm   varintMetrics
+}
+
+// next returns the encoding of vals, which are the values of the metrics of
+// the next frame, a keyframe if full is set or after keyframes frames. The
+// returned varintMetrics are reused by the next call.
+func (d *varintDelta) next(vals []float64, keyframes int, full bool) *varintMetrics {
+	d.vm.values = vals
+	d.vm.deltas = d.vm.deltas[:0]
+	d.vm.keyframe = full || d.n == 0 || d.n >= keyframes || len(d.prev) != len(vals)
+	if d.vm.keyframe {
+		d.n = 1
+		d.prev = d.prev[:0]
+		for _, v := range vals {
+			d.prev = append(d.prev, math.Float64bits(v))
+		}
+		return &d.vm
+	}
+
+	d.n++
+	d.vm.deltas = appendVarintDeltas(d.vm.deltas, d.prev, vals)
+	return &d.vm
+}
+
+// appendVarintDeltas appends to b the zig-zag varint encoded differences
+// between the bits of vals and prev, and stores the bits of vals in prev.
+func appendVarintDeltas(b []byte, prev []uint64, vals []float64) []byte {
+	for i, v := range vals {
+		bits := math.Float64bits(v)
+		delta := int64(bits - prev[i])
+		b = appendVarint(b, uint64(delta<<1)^uint64(delta>>63))
+		prev[i] = bits
+	}
+	return b
+}
@@ -0,0 +1,135 @@
+package statsviz
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// decodeVarintDeltas decodes the metrics values of a frame sent with varint
+// deltas, from the values of the previous frame.
+func decodeVarintDeltas(t *testing.T, b []byte, prev []float64) []float64 {
+	t.Helper()
+
+	vals := make([]float64, len(prev))
+	for i := range prev {
+		u, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad varint for value %d", i)
+		}
+		b = b[n:]
+		delta := int64(u>>1) ^ -int64(u&1)
+		vals[i] = math.Float64frombits(math.Float64bits(prev[i]) + uint64(delta))
+	}
+	if len(b) != 0 {
+		t.Fatalf("%d trailing bytes", len(b))
+	}
+	return vals
+}
+
+func TestAppendVarintDeltas(t *testing.T) {
+	t.Parallel()
+
+	frames := [][]float64{
+		{0, 1, 1e9, -3, math.NaN()},
+		{0, 2, 1e9 + 1024, -2.5, 4},
+		{0, 1.5, 1e9, math.Inf(1), math.NaN()},
+		{math.MaxFloat64, 1.5, 0, -3, math.Inf(-1)},
+	}
+
+	prev := make([]uint64, len(frames[0]))
+	vals := make([]float64, len(frames[0]))
+	for i, frame := range frames {
+		b := appendVarintDeltas(nil, prev, frame)
+		vals = decodeVarintDeltas(t, b, vals)
+		if !equalFloats(vals, frame) {
+			t.Errorf("frame %d: got %v, want %v", i, vals, frame)
+		}
+	}
+
+	// Unchanged values take a byte each.
+	if b := appendVarintDeltas(nil, prev, frames[len(frames)-1]); len(b) != len(frames[0]) {
+		t.Errorf("got %d bytes for unchanged values, want %d", len(b), len(frames[0]))
+	}
+}
+
+func TestVarintDeltaFrames(t *testing.T) {
+	t.Parallel()
+
+	const keyframes = 3
+	h, smp := newTestHub(t, WithEncoding(EncodingProtobuf), WithVarintDeltas(keyframes))
+	if got := h.s.handshake().Keyframes; got != keyframes {
+		t.Errorf("got handshake keyframes %d, want %d", got, keyframes)
+	}
+	names := h.s.handshake().MetricNames
+	if len(names) == 0 {
+		t.Fatal("got no metric names in the handshake")
+	}
+
+	// decode decodes the next frame of the hub, with the values of the
+	// previous frame, and reports whether it's a keyframe.
+	decode := func(prev []float64) ([]float64, bool) {
+		t.Helper()
+
+		h.tick(smp)
+		fields, err := decodeProtobuf(h.out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vals []float64
+		keyframe := false
+		for _, f := range fields {
+			switch f.num {
+			case 5:
+				t.Errorf("got a scalar, want metrics values only")
+			case 12:
+				vals, keyframe = pbDoubles(f.bytes), true
+			case 13:
+				if prev == nil {
+					t.Fatal("got a delta frame, want a keyframe first")
+				}
+				vals = decodeVarintDeltas(t, f.bytes, prev)
+			}
+		}
+		if len(vals) != len(names) {
+			t.Fatalf("got %d values, want %d", len(vals), len(names))
+		}
+		if !equalFloats(vals, h.vals) {
+			t.Errorf("got values %v, want %v", vals, h.vals)
+		}
+		return vals, keyframe
+	}
+
+	var vals []float64
+	var keyframe bool
+	for i := 0; i < 2*keyframes; i++ {
+		vals, keyframe = decode(vals)
+		if want := i%keyframes == 0; keyframe != want {
+			t.Errorf("frame %d: got keyframe %t, want %t", i, keyframe, want)
+		}
+	}
+
+	// A client connecting, or missing frames, resyncs on a keyframe.
+	h.sendFull()
+	if vals, keyframe = decode(vals); !keyframe {
+		t.Errorf("got a delta frame after a resync, want a keyframe")
+	}
+	if _, keyframe = decode(vals); keyframe {
+		t.Errorf("got a keyframe after a resync keyframe, want a delta frame")
+	}
+}
+
+func TestWithVarintDeltasInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := [][]OptionFunc{
+		{WithEncoding(EncodingProtobuf), WithVarintDeltas(0)},
+		{WithVarintDeltas(10)},
+		{WithEncoding(EncodingProtobuf), WithVarintDeltas(10), WithDeltaFrames(true)},
+	}
+	for i, opts := range tests {
+		if _, err := NewServer(opts...); err == nil {
+			t.Errorf("test %d: got nil error, want non-nil", i)
+		}
+	}
+}
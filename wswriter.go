@@ -0,0 +1,112 @@
+package statsviz
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// controlWriteTimeout is the time allowed to write a websocket control frame.
+const controlWriteTimeout = time.Second
+
+var errWriterClosed = errors.New("statsviz: websocket writer closed")
+
+// A wsWriter is the only writer of a websocket connection, since websocket
+// connections don't support concurrent writers. Data messages and control
+// frames (ping, pong, close) written by the various goroutines of a
+// connection are all serialized through the wsWriter goroutine.
+type wsWriter struct {
+	conn         *websocket.Conn
+	reqs         chan wsWrite
+	pingInterval time.Duration // 0 means no pings
+	writeTimeout time.Duration // for data messages, 0 means no timeout
+
+	stopOnce sync.Once
+	stop     chan struct{} // closed to stop the writer goroutine
+	done     chan struct{} // closed once the writer goroutine has returned
+}
+
+// A wsWrite is a write request, which result is sent on errc.
+type wsWrite struct {
+	typ  int // websocket message type
+	data []byte
+	errc chan error
+}
+
+// newWsWriter starts the writer goroutine of conn, which also sends a ping
+// every pingInterval, if not zero. Writing a data message fails if it takes
+// longer than writeTimeout, if not zero, after which the connection is
+// unusable. close must be called to stop it.
+func newWsWriter(conn *websocket.Conn, pingInterval, writeTimeout time.Duration) *wsWriter {
+	w := &wsWriter{
+		conn:         conn,
+		reqs:         make(chan wsWrite),
+		pingInterval: pingInterval,
+		writeTimeout: writeTimeout,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *wsWriter) run() {
+	defer close(w.done)
+
+	// A nil channel never receives, so no pings are sent without interval.
+	var pings <-chan time.Time
+	if w.pingInterval > 0 {
+		ticker := time.NewTicker(w.pingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-pings:
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(controlWriteTimeout)); err != nil {
+				return
+			}
+		case req := <-w.reqs:
+			var err error
+			switch req.typ {
+			case websocket.TextMessage, websocket.BinaryMessage:
+				if w.writeTimeout > 0 {
+					w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+				}
+				err = w.conn.WriteMessage(req.typ, req.data)
+			default:
+				err = w.conn.WriteControl(req.typ, req.data, time.Now().Add(controlWriteTimeout))
+			}
+			req.errc <- err
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// write writes a message of the given type on the connection, and waits for
+// it to be written. data is not retained after write returns. write returns
+// errWriterClosed if the writer is closed, or has stopped after a previous
+// write failed.
+func (w *wsWriter) write(typ int, data []byte) error {
+	errc := make(chan error, 1)
+	select {
+	case w.reqs <- wsWrite{typ: typ, data: data, errc: errc}:
+	case <-w.done:
+		return errWriterClosed
+	}
+	return <-errc
+}
+
+// close stops the writer goroutine and waits for it to return. Pending and
+// subsequent writes fail with errWriterClosed.
+func (w *wsWriter) close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
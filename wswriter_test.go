@@ -0,0 +1,295 @@
+package statsviz
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialTestWs starts an http server upgrading connections and calling handle
+// with them, and returns a client connection to it.
+func dialTestWs(t *testing.T, handle func(conn *websocket.Conn)) *websocket.Conn {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var upgrader websocket.Upgrader
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}))
+	t.Cleanup(ts.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func TestWsWriterConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	const n = 50
+
+	var pings sync.WaitGroup
+	pings.Add(n)
+
+	ws := dialTestWs(t, func(conn *websocket.Conn) {
+		w := newWsWriter(conn, 0, 0)
+		defer w.close()
+
+		// Concurrently write data messages and pings, gorilla/websocket
+		// panics on concurrent writes.
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if err := w.write(websocket.TextMessage, []byte(`{}`)); err != nil {
+					t.Errorf("data message: %v", err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if err := w.write(websocket.PingMessage, nil); err != nil {
+					t.Errorf("ping: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		pings.Wait()
+	})
+
+	ws.SetPingHandler(func(string) error {
+		pings.Done()
+		return nil
+	})
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < n; i++ {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	// Pings are handled while reading, keep reading until they all arrived
+	// and the server closes the connection.
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+func TestWsWriterClosed(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	dialTestWs(t, func(conn *websocket.Conn) {
+		defer close(done)
+
+		w := newWsWriter(conn, 0, 0)
+		w.close()
+		w.close() // no-op
+		if err := w.write(websocket.TextMessage, []byte(`{}`)); err != errWriterClosed {
+			t.Errorf("got error %v, want %v", err, errWriterClosed)
+		}
+	})
+	<-done
+}
+
+func TestWsPongsWhileStreaming(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(SendFrequency(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// The server answers pings from its reading goroutine while stats are
+	// written from another one.
+	const n = 20
+	pongs := make(chan struct{}, n)
+	ws.SetPongHandler(func(string) error {
+		pongs <- struct{}{}
+		return nil
+	})
+	for i := 0; i < n; i++ {
+		if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for received := 0; received < n; {
+		select {
+		case <-pongs:
+			received++
+			continue
+		default:
+		}
+		if _, _, err := ws.ReadMessage(); err != nil {
+			t.Fatalf("got %d pongs, want %d: %v", received, n, err)
+		}
+	}
+}
+
+func TestWsMissedPong(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewServer(
+		SendFrequency(time.Hour),
+		WithPingInterval(20*time.Millisecond),
+		WithPongTimeout(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// Never answer pings, the server must then close the connection.
+	pinged := make(chan struct{}, 1)
+	ws.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := ws.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			t.Fatalf("connection still open after missing pongs")
+		}
+		break
+	}
+	select {
+	case <-pinged:
+	default:
+		t.Errorf("got no ping, want some")
+	}
+
+	// The streaming goroutine has unsubscribed from the hub.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		srv.hubsMu.Lock()
+		nhubs := len(srv.hubs)
+		srv.hubsMu.Unlock()
+		if nhubs == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d hubs after the connection was closed, want 0", nhubs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWithPongTimeoutInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithPongTimeout(0)); err == nil {
+		t.Errorf("WithPongTimeout(0): got nil error, want non-nil")
+	}
+	if _, err := NewServer(WithPingInterval(-time.Second)); err == nil {
+		t.Errorf("WithPingInterval(-1s): got nil error, want non-nil")
+	}
+}
+
+func TestWsWriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	// Large frames, to quickly fill the connection buffers.
+	plot := TimeSeriesPlot{Name: "large"}
+	for i := 0; i < 5000; i++ {
+		plot.Series = append(plot.Series, TimeSeries{Name: strconv.Itoa(i), Value: func() float64 { return 1 }})
+	}
+	srv, err := NewServer(
+		SendFrequency(10*time.Millisecond),
+		WithPingInterval(0),
+		WithWriteTimeout(50*time.Millisecond),
+		WithPlot(plot),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	ts := httptest.NewServer(srv.Ws())
+	defer ts.Close()
+
+	// A client which never reads, with a small receive buffer.
+	dialer := websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			conn.(*net.TCPConn).SetReadBuffer(1024)
+			return conn, nil
+		},
+	}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// The server gives up writing, closes the connection and the streaming
+	// goroutine returns, unsubscribing from the hub.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		srv.hubsMu.Lock()
+		nhubs := len(srv.hubs)
+		srv.hubsMu.Unlock()
+		if nhubs == 0 && srv.Stats().Clients == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d hubs and %d clients with a stuck client, want 0", nhubs, srv.Stats().Clients)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+}
+
+func TestWithWriteTimeoutInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewServer(WithWriteTimeout(-time.Second)); err == nil {
+		t.Errorf("WithWriteTimeout(-1s): got nil error, want non-nil")
+	}
+}